@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	pb "control_grpc/gen/proto"
+	"fyne.io/fyne/v2/widget"
+)
+
+const allDisplaysLabel = "All displays"
+
+var (
+	// displaySelect picks which of the host's displays the video shows.
+	displaySelect  *widget.Select
+	displayIndices = map[string]int32{}
+	// followingHost is set while the picker is updated from the host's stream, so the
+	// change is not sent back as a new selection.
+	followingHost bool
+	displayMu     sync.Mutex
+)
+
+// fetchDisplays lists the host's displays. Hosts without multi-monitor support return
+// an error, and the picker is then left out.
+func fetchDisplays(client pb.RemoteControlServiceClient) []*pb.DisplayInfo {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	resp, err := client.ListDisplays(ctx, &pb.ListDisplaysRequest{})
+	if err != nil {
+		log.Printf("WARN: Failed to list the host's displays: %v", err)
+		return nil
+	}
+	for _, d := range resp.GetDisplays() {
+		log.Printf("INFO: Host display %s", displayLabel(d))
+	}
+	return resp.GetDisplays()
+}
+
+func displayLabel(d *pb.DisplayInfo) string {
+	if d.GetIndex() < 0 {
+		return allDisplaysLabel
+	}
+	label := fmt.Sprintf("%d: %dx%d", d.GetIndex()+1, d.GetWidth(), d.GetHeight())
+	if d.GetPrimary() {
+		return label + " (primary)"
+	}
+	return fmt.Sprintf("%s at %d,%d", label, d.GetX(), d.GetY())
+}
+
+// newDisplaySelect returns a picker that switches the video to another display
+// mid-session, or nil when the host has a single display.
+func newDisplaySelect(displays []*pb.DisplayInfo, events chan<- *pb.FeedRequest) *widget.Select {
+	if len(displays) < 2 {
+		return nil
+	}
+	var options []string
+	for _, d := range displays {
+		label := displayLabel(d)
+		options = append(options, label)
+		displayIndices[label] = d.GetIndex()
+	}
+	options = append(options, allDisplaysLabel)
+	displayIndices[allDisplaysLabel] = -1
+
+	// The feed starts on the first display, selected before OnChanged is set so that
+	// it is not requested again.
+	displaySelect = widget.NewSelect(options, nil)
+	displaySelect.SetSelected(options[0])
+	displaySelect.OnChanged = func(label string) {
+		displayMu.Lock()
+		following := followingHost
+		displayMu.Unlock()
+		index, ok := displayIndices[label]
+		if following || !ok {
+			return
+		}
+		log.Printf("INFO: Switching to host display %q", label)
+		req := &pb.FeedRequest{Message: "select_display", DisplayIndex: index, Timestamp: time.Now().UnixNano()}
+		select {
+		case events <- req:
+		default:
+			log.Println("Display selection dropped (inputEvents channel full)")
+		}
+	}
+	return displaySelect
+}
+
+// showSelectedDisplay updates the picker to the display the host reports streaming,
+// which differs from the requested one when that display no longer exists.
+func showSelectedDisplay(info *pb.DisplayInfo) {
+	if info == nil || displaySelect == nil {
+		return
+	}
+	label := displayLabel(info)
+	if info.GetIndex() >= 0 {
+		// Match by index, the size in the label may have changed since it was listed.
+		for l, index := range displayIndices {
+			if index == info.GetIndex() {
+				label = l
+			}
+		}
+	}
+	if displaySelect.Selected == label {
+		return
+	}
+	displayMu.Lock()
+	followingHost = true
+	displayMu.Unlock()
+	displaySelect.SetSelected(label)
+	displayMu.Lock()
+	followingHost = false
+	displayMu.Unlock()
+}
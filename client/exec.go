@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+
+	pb "control_grpc/gen/proto"
+)
+
+// Exit codes of -exec when the remote program did not report its own, following
+// timeout(1) and ssh.
+const (
+	execExitTimedOut = 124
+	execExitFailed   = 255
+)
+
+// execOptions are the -exec* flags.
+type execOptions struct {
+	dir     string
+	env     execEnvFlag
+	timeout time.Duration
+	stdin   bool
+}
+
+// execEnvFlag collects repeated -execEnv KEY=VALUE flags.
+type execEnvFlag map[string]string
+
+func (f *execEnvFlag) String() string {
+	if f == nil {
+		return ""
+	}
+	pairs := make([]string, 0, len(*f))
+	for key, value := range *f {
+		pairs = append(pairs, key+"="+value)
+	}
+	return strings.Join(pairs, ",")
+}
+
+func (f *execEnvFlag) Set(value string) error {
+	key, val, ok := strings.Cut(value, "=")
+	if !ok || key == "" {
+		return fmt.Errorf("expected KEY=VALUE, got %q", value)
+	}
+	if *f == nil {
+		*f = make(execEnvFlag)
+	}
+	(*f)[key] = val
+	return nil
+}
+
+// runExecCommand runs argv on the host through the Exec RPC, copying its output to this
+// process's stdout and stderr, and returns the exit code to exit with. Client logging is
+// turned off so the output is only the remote program's.
+func runExecCommand(allowLocalInsecure bool, argv []string, opts execOptions) int {
+	if len(argv) == 0 {
+		fmt.Fprintln(os.Stderr, "exec: no command given, e.g. -exec -- hostname")
+		return execExitFailed
+	}
+	log.SetOutput(io.Discard)
+
+	req := &pb.ExecRequest{
+		Argv:             argv,
+		WorkingDirectory: opts.dir,
+		Environment:      opts.env,
+		TimeoutMs:        opts.timeout.Milliseconds(),
+	}
+	if opts.stdin {
+		stdin, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "exec: reading standard input: %v\n", err)
+			return execExitFailed
+		}
+		req.Stdin = stdin
+	}
+
+	conn, err := dialServer(allowLocalInsecure)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "exec: could not connect to %s: %v\n", *serverAddrActual, err)
+		return execExitFailed
+	}
+	defer conn.Close()
+
+	// Ctrl+C cancels the call, which kills the program on the host.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	stream, err := pb.NewTerminalServiceClient(conn).Exec(ctx, req)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "exec: %v\n", err)
+		return execExitFailed
+	}
+	for {
+		resp, err := stream.Recv()
+		if err != nil {
+			if err == io.EOF {
+				err = fmt.Errorf("the host ended the call without an exit status")
+			}
+			fmt.Fprintf(os.Stderr, "exec: %v\n", err)
+			return execExitFailed
+		}
+		if resp.GetExited() {
+			if msg := resp.GetErrorMessage(); msg != "" {
+				fmt.Fprintf(os.Stderr, "exec: %s\n", msg)
+			}
+			switch {
+			case resp.GetTimedOut():
+				return execExitTimedOut
+			case resp.GetExitCode() < 0:
+				return execExitFailed
+			}
+			return int(resp.GetExitCode())
+		}
+		if resp.GetOutputType() == pb.TerminalResponse_STDERR {
+			os.Stderr.Write(resp.GetData())
+		} else {
+			os.Stdout.Write(resp.GetData())
+		}
+	}
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	pb "control_grpc/gen/proto"
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/widget"
+)
+
+// treeNodeRow wraps the contents of a file tree row so that a secondary tap
+// (right click / long press) can open the context menu for the node it shows.
+// Primary taps fall through to the tree so selection keeps working.
+type treeNodeRow struct {
+	widget.BaseWidget
+	content *fyne.Container
+	nodeID  widget.TreeNodeID
+}
+
+func newTreeNodeRow(content *fyne.Container) *treeNodeRow {
+	row := &treeNodeRow{content: content}
+	row.ExtendBaseWidget(row)
+	return row
+}
+
+func (r *treeNodeRow) CreateRenderer() fyne.WidgetRenderer {
+	return widget.NewSimpleRenderer(r.content)
+}
+
+func (r *treeNodeRow) TappedSecondary(e *fyne.PointEvent) {
+	if r.nodeID == "" {
+		return
+	}
+	canvas := fyne.CurrentApp().Driver().CanvasForObject(r)
+	if canvas == nil {
+		log.Printf("Context menu: no canvas found for tree row '%s'", r.nodeID)
+		return
+	}
+	menu := buildNodeContextMenu(r.nodeID)
+	if menu == nil {
+		return
+	}
+	widget.ShowPopUpMenuAtPosition(menu, canvas, e.AbsolutePosition)
+}
+
+func buildNodeContextMenu(id string) *fyne.Menu {
+	treeDataMutex.RLock()
+	node, ok := nodesMap[id]
+	parentPath, hasParent := parentMap[id]
+	treeDataMutex.RUnlock()
+	if !ok || isErrorNodeName(node.Name) {
+		return nil
+	}
+
+	var items []*fyne.MenuItem
+	if node.Type == pb.FSNode_FOLDER {
+		if !node.ReadOnly {
+			items = append(items,
+				fyne.NewMenuItem("New Folder...", func() { promptMakeDirectory(node.Path) }),
+				fyne.NewMenuItem("Upload Here...", func() { startUpload(AppInstance, node.Path) }),
+			)
+		}
+		items = append(items,
+			fyne.NewMenuItem("Download as Archive...", func() { go startDownload(AppInstance, node.Path, true) }),
+			fyne.NewMenuItem("Sync with Local Folder...", func() { openSyncWindow(node.Path) }),
+		)
+	} else {
+		items = append(items,
+			fyne.NewMenuItem("Download", func() { go startDownload(AppInstance, node.Path, false) }),
+		)
+	}
+
+	// Shared roots cannot be renamed, moved or deleted, and read-only nodes can only be copied.
+	if hasParent && parentPath != "" {
+		items = append(items, fyne.NewMenuItemSeparator())
+		if node.ReadOnly {
+			items = append(items, fyne.NewMenuItem("Copy To...", func() { promptTransferPath(node, parentPath, false) }))
+		} else {
+			items = append(items,
+				fyne.NewMenuItem("Rename...", func() { promptRenamePath(node, parentPath) }),
+				fyne.NewMenuItem("Copy To...", func() { promptTransferPath(node, parentPath, false) }),
+				fyne.NewMenuItem("Move To...", func() { promptTransferPath(node, parentPath, true) }),
+				fyne.NewMenuItemSeparator(),
+				fyne.NewMenuItem("Delete", func() { confirmDeletePath(node, parentPath) }),
+			)
+		}
+	} else {
+		items = append(items, fyne.NewMenuItemSeparator(), fyne.NewMenuItem("Refresh", func() { go fetchChildren(node.Path) }))
+	}
+	return fyne.NewMenu("", items...)
+}
+
+func isErrorNodeName(name string) bool {
+	return strings.HasPrefix(name, "[Error") || strings.HasPrefix(name, "[Server Error:")
+}
+
+func promptMakeDirectory(parentPath string) {
+	entry := widget.NewEntry()
+	entry.SetPlaceHolder("Folder name")
+	dialog.ShowForm("New Folder", "Create", "Cancel", []*widget.FormItem{
+		widget.NewFormItem("Name", entry),
+	}, func(confirmed bool) {
+		if !confirmed || strings.TrimSpace(entry.Text) == "" {
+			return
+		}
+		newPath := joinRemotePath(parentPath, strings.TrimSpace(entry.Text))
+		go runFileOperation("Create folder", func(ctx context.Context) (*pb.FileOperationResponse, error) {
+			return filesClient.MakeDirectory(ctx, &pb.MakeDirectoryRequest{Path: newPath})
+		}, parentPath)
+	}, mainWindow)
+}
+
+func promptRenamePath(node *pb.FSNode, parentPath string) {
+	entry := widget.NewEntry()
+	entry.SetText(node.Name)
+	dialog.ShowForm(fmt.Sprintf("Rename %s", node.Name), "Rename", "Cancel", []*widget.FormItem{
+		widget.NewFormItem("New name", entry),
+	}, func(confirmed bool) {
+		newName := strings.TrimSpace(entry.Text)
+		if !confirmed || newName == "" || newName == node.Name {
+			return
+		}
+		go runFileOperation("Rename", func(ctx context.Context) (*pb.FileOperationResponse, error) {
+			return filesClient.RenamePath(ctx, &pb.RenamePathRequest{Path: node.Path, NewName: newName})
+		}, parentPath)
+	}, mainWindow)
+}
+
+func promptTransferPath(node *pb.FSNode, parentPath string, move bool) {
+	title, action := "Copy", "Copy"
+	if move {
+		title, action = "Move", "Move"
+	}
+	destEntry := widget.NewEntry()
+	destEntry.SetText(parentPath)
+	overwriteCheck := widget.NewCheck("Replace existing items", nil)
+	dialog.ShowForm(fmt.Sprintf("%s %s", title, node.Name), action, "Cancel", []*widget.FormItem{
+		widget.NewFormItem("Destination folder", destEntry),
+		widget.NewFormItem("", overwriteCheck),
+	}, func(confirmed bool) {
+		destDir := strings.TrimSpace(destEntry.Text)
+		if !confirmed || destDir == "" {
+			return
+		}
+		req := &pb.TransferPathsRequest{
+			SourcePaths:    []string{node.Path},
+			DestinationDir: destDir,
+			Overwrite:      overwriteCheck.Checked,
+		}
+		refresh := []string{destDir}
+		if move {
+			refresh = append(refresh, parentPath)
+		}
+		go runFileOperation(title, func(ctx context.Context) (*pb.FileOperationResponse, error) {
+			if move {
+				return filesClient.MovePaths(ctx, req)
+			}
+			return filesClient.CopyPaths(ctx, req)
+		}, refresh...)
+	}, mainWindow)
+}
+
+func confirmDeletePath(node *pb.FSNode, parentPath string) {
+	message := fmt.Sprintf("Delete '%s' from the remote machine?", node.Path)
+	if node.Type == pb.FSNode_FOLDER {
+		message = fmt.Sprintf("Delete folder '%s' and everything inside it from the remote machine?", node.Path)
+	}
+	dialog.ShowConfirm("Delete", message, func(confirmed bool) {
+		if !confirmed {
+			return
+		}
+		go runFileOperation("Delete", func(ctx context.Context) (*pb.FileOperationResponse, error) {
+			return filesClient.DeletePaths(ctx, &pb.DeletePathsRequest{
+				Paths:     []string{node.Path},
+				Recursive: node.Type == pb.FSNode_FOLDER,
+			})
+		}, parentPath)
+	}, mainWindow)
+}
+
+// runFileOperation performs a file operation RPC, reports any per-path failures
+// and reloads the listed folders in the tree afterwards.
+func runFileOperation(title string, call func(ctx context.Context) (*pb.FileOperationResponse, error), refreshPaths ...string) {
+	if filesClient == nil {
+		log.Printf("%s: filesClient is nil.", title)
+		if mainWindow != nil {
+			dialog.ShowError(fmt.Errorf("file transfer client not initialized"), mainWindow)
+		}
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	res, err := call(ctx)
+	if err != nil {
+		log.Printf("%s failed: %v", title, err)
+		showDownloadError(fmt.Sprintf("%s failed", title), err, mainWindow)
+		return
+	}
+
+	var failures []string
+	for _, result := range res.GetResults() {
+		if result.GetSuccess() {
+			log.Printf("%s succeeded for '%s' (new path: '%s')", title, result.GetPath(), result.GetNewPath())
+			continue
+		}
+		log.Printf("%s failed for '%s': %s", title, result.GetPath(), result.GetErrorMessage())
+		failures = append(failures, fmt.Sprintf("%s: %s", result.GetPath(), result.GetErrorMessage()))
+	}
+	if len(failures) > 0 && mainWindow != nil {
+		dialog.ShowError(fmt.Errorf("%s failed:\n%s", title, strings.Join(failures, "\n")), mainWindow)
+	}
+
+	refreshed := make(map[string]bool)
+	for _, p := range refreshPaths {
+		if refreshed[p] {
+			continue
+		}
+		refreshed[p] = true
+		treeDataMutex.RLock()
+		_, loaded := childrenMap[p]
+		treeDataMutex.RUnlock()
+		if loaded {
+			fetchChildren(p)
+		}
+	}
+}
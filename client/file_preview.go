@@ -0,0 +1,416 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf16"
+
+	pb "control_grpc/gen/proto"
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/canvas"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/driver/desktop"
+	"fyne.io/fyne/v2/theme"
+	"fyne.io/fyne/v2/widget"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// previewReadLength is how much of a text file the preview pane shows.
+	previewReadLength = 256 * 1024
+	// maxInlineFileSize bounds images shown in the preview and files opened in the
+	// editor; it matches the server's per-call ReadFile limit.
+	maxInlineFileSize = 2 * 1024 * 1024
+)
+
+var encodingNames = map[pb.TextEncoding]string{
+	pb.TextEncoding_ENCODING_BINARY:   "Binary",
+	pb.TextEncoding_ENCODING_UTF8:     "UTF-8",
+	pb.TextEncoding_ENCODING_UTF8_BOM: "UTF-8 with BOM",
+	pb.TextEncoding_ENCODING_UTF16_LE: "UTF-16 LE",
+	pb.TextEncoding_ENCODING_UTF16_BE: "UTF-16 BE",
+	pb.TextEncoding_ENCODING_LATIN1:   "Latin-1",
+}
+
+// filePreviewPane shows the file selected in the tree next to it.
+type filePreviewPane struct {
+	container      *fyne.Container
+	titleLabel     *widget.Label
+	infoLabel      *widget.Label
+	body           *fyne.Container
+	editButton     *widget.Button
+	downloadButton *widget.Button
+
+	mu   sync.Mutex
+	path string
+	// loadID identifies the current load so a slow response cannot replace a newer file.
+	loadID int
+}
+
+var (
+	activePreview   *filePreviewPane
+	activePreviewMu sync.Mutex
+)
+
+func newFilePreviewPane() *filePreviewPane {
+	p := &filePreviewPane{
+		titleLabel: widget.NewLabelWithStyle("", fyne.TextAlignLeading, fyne.TextStyle{Bold: true}),
+		infoLabel:  widget.NewLabel(""),
+		body:       container.NewStack(),
+	}
+	p.titleLabel.Truncation = fyne.TextTruncateEllipsis
+	p.infoLabel.Importance = widget.LowImportance
+	p.editButton = widget.NewButtonWithIcon("Edit", theme.DocumentCreateIcon(), func() {
+		if path := p.currentPath(); path != "" {
+			go openFileEditor(path)
+		}
+	})
+	p.downloadButton = widget.NewButtonWithIcon("Download", theme.DownloadIcon(), func() {
+		if path := p.currentPath(); path != "" {
+			go startDownload(AppInstance, path, false)
+		}
+	})
+	p.editButton.Disable()
+	p.downloadButton.Disable()
+	p.body.Add(container.NewCenter(widget.NewLabel("Select a file to preview it.")))
+
+	header := container.NewBorder(nil, nil, nil, container.NewHBox(p.editButton, p.downloadButton),
+		container.NewVBox(p.titleLabel, p.infoLabel))
+	p.container = container.NewBorder(header, nil, nil, nil, p.body)
+	return p
+}
+
+func setActivePreview(p *filePreviewPane) {
+	activePreviewMu.Lock()
+	activePreview = p
+	activePreviewMu.Unlock()
+}
+
+func getActivePreview() *filePreviewPane {
+	activePreviewMu.Lock()
+	defer activePreviewMu.Unlock()
+	return activePreview
+}
+
+func (p *filePreviewPane) currentPath() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.path
+}
+
+// showFilePreview previews a file in the open file browser, or downloads it when no
+// preview pane is showing.
+func showFilePreview(node *pb.FSNode) {
+	p := getActivePreview()
+	if p == nil {
+		log.Printf("No preview pane open for '%s'. Initiating download.", node.Path)
+		go startDownload(AppInstance, node.Path, false)
+		return
+	}
+	p.mu.Lock()
+	p.loadID++
+	loadID := p.loadID
+	p.path = node.Path
+	p.mu.Unlock()
+
+	p.titleLabel.SetText(filepath.Base(node.Path))
+	p.infoLabel.SetText(formatNodeDetails(node))
+	p.editButton.Disable()
+	p.downloadButton.Enable()
+	p.setBody(widget.NewProgressBarInfinite())
+	go p.load(loadID, node)
+}
+
+func (p *filePreviewPane) setBody(obj fyne.CanvasObject) {
+	p.body.Objects = []fyne.CanvasObject{obj}
+	p.body.Refresh()
+}
+
+func (p *filePreviewPane) load(loadID int, node *pb.FSNode) {
+	length := int64(previewReadLength)
+	if node.Size > previewReadLength && node.Size <= maxInlineFileSize && looksLikeImage(node.Path) {
+		length = maxInlineFileSize
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	res, err := filesClient.ReadFile(ctx, &pb.ReadFileRequest{Path: node.Path, Length: length})
+	cancel()
+
+	p.mu.Lock()
+	stale := p.loadID != loadID
+	p.mu.Unlock()
+	if stale {
+		return
+	}
+	if err != nil {
+		log.Printf("Error previewing '%s': %v", node.Path, err)
+		s, _ := status.FromError(err)
+		p.setBody(container.NewCenter(widget.NewLabel(fmt.Sprintf("Preview unavailable: %s", s.Message()))))
+		return
+	}
+
+	info := fmt.Sprintf("%s, %s", formatBytes(res.TotalSize), encodingNames[res.Encoding])
+	switch {
+	case strings.HasPrefix(res.MimeType, "image/") && res.Eof:
+		img := canvas.NewImageFromReader(bytes.NewReader(res.Data), filepath.Base(node.Path))
+		img.FillMode = canvas.ImageFillContain
+		p.infoLabel.SetText(fmt.Sprintf("%s, %s", formatBytes(res.TotalSize), res.MimeType))
+		p.setBody(img)
+	case res.Encoding == pb.TextEncoding_ENCODING_BINARY:
+		p.infoLabel.SetText(fmt.Sprintf("%s, %s", formatBytes(res.TotalSize), res.MimeType))
+		p.setBody(container.NewCenter(widget.NewLabel("Binary file. Download it to open locally.")))
+	default:
+		text, err := decodeText(res.Data, res.Encoding)
+		if err != nil {
+			p.setBody(container.NewCenter(widget.NewLabel(fmt.Sprintf("Preview unavailable: %v", err))))
+			return
+		}
+		if !res.Eof {
+			info += fmt.Sprintf(" (showing first %s)", formatBytes(int64(len(res.Data))))
+		}
+		p.infoLabel.SetText(info)
+		grid := widget.NewTextGridFromString(strings.ReplaceAll(text, "\r\n", "\n"))
+		p.setBody(container.NewScroll(grid))
+		if res.TotalSize <= maxInlineFileSize && !node.ReadOnly {
+			p.editButton.Enable()
+		}
+	}
+}
+
+func looksLikeImage(path string) bool {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".png", ".jpg", ".jpeg", ".gif", ".bmp", ".svg", ".webp":
+		return true
+	}
+	return false
+}
+
+// decodeText converts file content in a detected encoding to a string.
+func decodeText(data []byte, encoding pb.TextEncoding) (string, error) {
+	switch encoding {
+	case pb.TextEncoding_ENCODING_UTF8:
+		return string(data), nil
+	case pb.TextEncoding_ENCODING_UTF8_BOM:
+		return string(bytes.TrimPrefix(data, []byte{0xEF, 0xBB, 0xBF})), nil
+	case pb.TextEncoding_ENCODING_UTF16_LE, pb.TextEncoding_ENCODING_UTF16_BE:
+		data = data[min(2, len(data)):]
+		units := make([]uint16, len(data)/2)
+		for i := range units {
+			if encoding == pb.TextEncoding_ENCODING_UTF16_LE {
+				units[i] = uint16(data[2*i]) | uint16(data[2*i+1])<<8
+			} else {
+				units[i] = uint16(data[2*i])<<8 | uint16(data[2*i+1])
+			}
+		}
+		return string(utf16.Decode(units)), nil
+	case pb.TextEncoding_ENCODING_LATIN1:
+		runes := make([]rune, len(data))
+		for i, b := range data {
+			runes[i] = rune(b)
+		}
+		return string(runes), nil
+	default:
+		return "", errors.New("file is not text")
+	}
+}
+
+// encodeText is the inverse of decodeText, restoring any byte order mark.
+func encodeText(text string, encoding pb.TextEncoding) ([]byte, error) {
+	switch encoding {
+	case pb.TextEncoding_ENCODING_UTF8:
+		return []byte(text), nil
+	case pb.TextEncoding_ENCODING_UTF8_BOM:
+		return append([]byte{0xEF, 0xBB, 0xBF}, text...), nil
+	case pb.TextEncoding_ENCODING_UTF16_LE, pb.TextEncoding_ENCODING_UTF16_BE:
+		units := utf16.Encode([]rune(text))
+		out := make([]byte, 0, 2+2*len(units))
+		if encoding == pb.TextEncoding_ENCODING_UTF16_LE {
+			out = append(out, 0xFF, 0xFE)
+			for _, u := range units {
+				out = append(out, byte(u), byte(u>>8))
+			}
+		} else {
+			out = append(out, 0xFE, 0xFF)
+			for _, u := range units {
+				out = append(out, byte(u>>8), byte(u))
+			}
+		}
+		return out, nil
+	case pb.TextEncoding_ENCODING_LATIN1:
+		out := make([]byte, 0, len(text))
+		for _, r := range text {
+			if r > 0xFF {
+				return nil, fmt.Errorf("character %q cannot be saved in Latin-1", r)
+			}
+			out = append(out, byte(r))
+		}
+		return out, nil
+	default:
+		return nil, errors.New("file is not text")
+	}
+}
+
+// remoteFileEditor is a window editing a text file on the server. Saves are rejected
+// by the server if the file changed since it was loaded, so concurrent edits are not
+// silently overwritten.
+type remoteFileEditor struct {
+	path        string
+	window      fyne.Window
+	entry       *widget.Entry
+	statusLabel *widget.Label
+
+	modifiedTime int64
+	sha256       []byte
+	encoding     pb.TextEncoding
+	crlf         bool
+	dirty        bool
+	loading      bool
+}
+
+func openFileEditor(path string) {
+	if filesClient == nil || AppInstance == nil {
+		log.Println("openFileEditor: client not initialized.")
+		return
+	}
+	e := &remoteFileEditor{path: path}
+	e.window = AppInstance.NewWindow("Edit " + filepath.Base(path))
+	e.entry = widget.NewMultiLineEntry()
+	e.entry.TextStyle = fyne.TextStyle{Monospace: true}
+	e.entry.Wrapping = fyne.TextWrapOff
+	e.entry.OnChanged = func(string) {
+		if !e.loading && !e.dirty {
+			e.dirty = true
+			e.window.SetTitle("*Edit " + filepath.Base(path))
+		}
+	}
+	e.statusLabel = widget.NewLabel("Loading...")
+
+	saveButton := widget.NewButtonWithIcon("Save", theme.DocumentSaveIcon(), func() { go e.save(false) })
+	reloadButton := widget.NewButtonWithIcon("Reload", theme.ViewRefreshIcon(), func() {
+		if !e.dirty {
+			go e.load()
+			return
+		}
+		dialog.ShowConfirm("Discard changes?", "Reloading discards your unsaved changes.", func(ok bool) {
+			if ok {
+				go e.load()
+			}
+		}, e.window)
+	})
+	toolbar := container.NewBorder(nil, nil, nil, container.NewHBox(reloadButton, saveButton), e.statusLabel)
+	e.window.SetContent(container.NewBorder(toolbar, nil, nil, nil, e.entry))
+	e.window.Canvas().AddShortcut(&desktop.CustomShortcut{KeyName: fyne.KeyS, Modifier: fyne.KeyModifierShortcutDefault},
+		func(fyne.Shortcut) { go e.save(false) })
+	e.window.SetCloseIntercept(func() {
+		if !e.dirty {
+			e.window.Close()
+			return
+		}
+		dialog.ShowConfirm("Discard changes?", fmt.Sprintf("%s has unsaved changes. Close anyway?", filepath.Base(path)), func(ok bool) {
+			if ok {
+				e.window.Close()
+			}
+		}, e.window)
+	})
+	e.window.Resize(fyne.NewSize(800, 600))
+	e.window.Show()
+	e.load()
+}
+
+func (e *remoteFileEditor) load() {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	res, err := filesClient.ReadFile(ctx, &pb.ReadFileRequest{Path: e.path, Length: maxInlineFileSize})
+	cancel()
+	if err != nil {
+		log.Printf("Editor: error reading '%s': %v", e.path, err)
+		e.statusLabel.SetText("Failed to load file.")
+		showDownloadError("Failed to open file", err, e.window)
+		return
+	}
+	if !res.Eof {
+		e.statusLabel.SetText("File is too large to edit.")
+		dialog.ShowError(fmt.Errorf("%s is %s; only files up to %s can be edited", filepath.Base(e.path), formatBytes(res.TotalSize), formatBytes(maxInlineFileSize)), e.window)
+		return
+	}
+	text, err := decodeText(res.Data, res.Encoding)
+	if err != nil {
+		e.statusLabel.SetText("File is not text.")
+		dialog.ShowError(fmt.Errorf("%s cannot be edited: %v", filepath.Base(e.path), err), e.window)
+		return
+	}
+
+	e.modifiedTime = res.ModifiedTime
+	e.sha256 = res.Sha256
+	e.encoding = res.Encoding
+	e.crlf = strings.Contains(text, "\r\n")
+	if e.crlf {
+		text = strings.ReplaceAll(text, "\r\n", "\n")
+	}
+	e.loading = true
+	e.entry.SetText(text)
+	e.loading = false
+	e.dirty = false
+	e.window.SetTitle("Edit " + filepath.Base(e.path))
+	e.statusLabel.SetText(fmt.Sprintf("%s, %s", encodingNames[e.encoding], formatBytes(res.TotalSize)))
+}
+
+// save writes the editor content back. Unless force is set, the server rejects the
+// write if the file changed since it was loaded and the user is asked what to do.
+func (e *remoteFileEditor) save(force bool) {
+	text := e.entry.Text
+	if e.crlf {
+		text = strings.ReplaceAll(text, "\n", "\r\n")
+	}
+	data, err := encodeText(text, e.encoding)
+	if err != nil {
+		dialog.ShowError(fmt.Errorf("Cannot save %s: %v", filepath.Base(e.path), err), e.window)
+		return
+	}
+	req := &pb.WriteFileRequest{Path: e.path, Data: data}
+	if !force {
+		req.ExpectedModifiedTime = e.modifiedTime
+		req.ExpectedSha256 = e.sha256
+	}
+	e.statusLabel.SetText("Saving...")
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	res, err := filesClient.WriteFile(ctx, req)
+	cancel()
+	if err != nil {
+		log.Printf("Editor: error saving '%s': %v", e.path, err)
+		if status.Code(err) == codes.FailedPrecondition {
+			e.statusLabel.SetText("File changed on the server.")
+			s, _ := status.FromError(err)
+			dialog.ShowConfirm("File changed on the server", s.Message()+"\nOverwrite it with your version?", func(overwrite bool) {
+				if overwrite {
+					go e.save(true)
+				}
+			}, e.window)
+			return
+		}
+		e.statusLabel.SetText("Save failed.")
+		showDownloadError("Save failed", err, e.window)
+		return
+	}
+
+	e.modifiedTime = res.ModifiedTime
+	e.sha256 = res.Sha256
+	e.dirty = false
+	e.window.SetTitle("Edit " + filepath.Base(e.path))
+	e.statusLabel.SetText(fmt.Sprintf("Saved %s at %s", formatBytes(res.Size), time.Now().Format("15:04:05")))
+	log.Printf("Editor: saved '%s' (%d bytes)", e.path, res.Size)
+
+	treeDataMutex.RLock()
+	parentPath, known := parentMap[e.path]
+	treeDataMutex.RUnlock()
+	if known {
+		scheduleFolderRefresh(parentPath)
+	}
+}
@@ -0,0 +1,352 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	pb "control_grpc/gen/proto"
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/theme"
+	"fyne.io/fyne/v2/widget"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// lastSelectedFolder is the folder most recently selected in the tree (or the parent
+// of the selected file). It is the default root for searches.
+var (
+	lastSelectedFolder   string
+	lastSelectedFolderMu sync.Mutex
+)
+
+func setLastSelectedFolder(path string) {
+	lastSelectedFolderMu.Lock()
+	lastSelectedFolder = path
+	lastSelectedFolderMu.Unlock()
+}
+
+func getLastSelectedFolder() string {
+	lastSelectedFolderMu.Lock()
+	defer lastSelectedFolderMu.Unlock()
+	return lastSelectedFolder
+}
+
+// fileSearchPanel is the search box and result list shown in the file browser window.
+type fileSearchPanel struct {
+	window fyne.Window
+
+	rootEntry    *widget.Entry
+	nameEntry    *widget.Entry
+	contentEntry *widget.Entry
+	regexCheck   *widget.Check
+	caseCheck    *widget.Check
+	hiddenCheck  *widget.Check
+	minSizeEntry *widget.Entry
+	maxSizeEntry *widget.Entry
+	daysEntry    *widget.Entry
+	depthEntry   *widget.Entry
+
+	statusLabel  *widget.Label
+	stopButton   *widget.Button
+	resultsList  *widget.List
+	resultsPanel *fyne.Container
+	treeView     fyne.CanvasObject
+
+	mu      sync.Mutex
+	results []*pb.SearchMatch
+	cancel  context.CancelFunc
+	// searchID identifies the current search so a stopped one cannot add stale results.
+	searchID int
+}
+
+// newFileBrowserContent lays out the file browser window: a search bar on top of
+// the tree, with search results replacing the tree while a search is shown, and a
+// preview of the selected file beside it.
+func newFileBrowserContent(treeView fyne.CanvasObject, win fyne.Window) fyne.CanvasObject {
+	p := &fileSearchPanel{window: win, treeView: treeView}
+	// The tree is shared between file browser windows; a previous window may have hidden it.
+	treeView.Show()
+
+	p.rootEntry = widget.NewEntry()
+	p.rootEntry.SetPlaceHolder("Search in (defaults to selected folder)")
+	p.nameEntry = widget.NewEntry()
+	p.nameEntry.SetPlaceHolder("Name, e.g. *.log")
+	p.nameEntry.OnSubmitted = func(string) { p.startSearch() }
+
+	p.contentEntry = widget.NewEntry()
+	p.contentEntry.SetPlaceHolder("Text inside files")
+	p.regexCheck = widget.NewCheck("Regular expressions", nil)
+	p.caseCheck = widget.NewCheck("Case sensitive", nil)
+	p.hiddenCheck = widget.NewCheck("Include hidden", nil)
+	p.minSizeEntry = widget.NewEntry()
+	p.minSizeEntry.SetPlaceHolder("KB")
+	p.maxSizeEntry = widget.NewEntry()
+	p.maxSizeEntry.SetPlaceHolder("KB")
+	p.daysEntry = widget.NewEntry()
+	p.daysEntry.SetPlaceHolder("days")
+	p.depthEntry = widget.NewEntry()
+	p.depthEntry.SetPlaceHolder("unlimited")
+
+	searchButton := widget.NewButtonWithIcon("", theme.SearchIcon(), p.startSearch)
+	optionsButton := widget.NewButtonWithIcon("", theme.SettingsIcon(), p.showOptions)
+	searchBar := container.NewBorder(nil, nil, nil, container.NewHBox(optionsButton, searchButton),
+		container.NewGridWithColumns(2, p.rootEntry, p.nameEntry))
+
+	p.statusLabel = widget.NewLabel("")
+	p.stopButton = widget.NewButtonWithIcon("Stop", theme.MediaStopIcon(), p.stopSearch)
+	closeButton := widget.NewButtonWithIcon("Back to tree", theme.NavigateBackIcon(), p.closeResults)
+	p.resultsList = widget.NewList(p.resultCount, p.createResultItem, p.updateResultItem)
+	p.resultsList.OnSelected = p.onResultSelected
+	p.resultsPanel = container.NewBorder(
+		container.NewBorder(nil, nil, nil, container.NewHBox(p.stopButton, closeButton), p.statusLabel),
+		nil, nil, nil, p.resultsList)
+	p.resultsPanel.Hide()
+
+	preview := newFilePreviewPane()
+	setActivePreview(preview)
+	win.SetOnClosed(func() {
+		p.stopSearch()
+		if getActivePreview() == preview {
+			setActivePreview(nil)
+		}
+	})
+	split := container.NewHSplit(container.NewStack(treeView, p.resultsPanel), preview.container)
+	split.Offset = 0.45
+	return container.NewBorder(searchBar, nil, nil, nil, split)
+}
+
+func (p *fileSearchPanel) showOptions() {
+	dialog.ShowForm("Search Options", "OK", "Cancel", []*widget.FormItem{
+		widget.NewFormItem("Containing", p.contentEntry),
+		widget.NewFormItem("", p.regexCheck),
+		widget.NewFormItem("", p.caseCheck),
+		widget.NewFormItem("", p.hiddenCheck),
+		widget.NewFormItem("Min size", p.minSizeEntry),
+		widget.NewFormItem("Max size", p.maxSizeEntry),
+		widget.NewFormItem("Modified within", p.daysEntry),
+		widget.NewFormItem("Max depth", p.depthEntry),
+	}, func(bool) {}, p.window)
+}
+
+func (p *fileSearchPanel) buildRequest() (*pb.SearchFilesRequest, error) {
+	root := strings.TrimSpace(p.rootEntry.Text)
+	if root == "" {
+		root = getLastSelectedFolder()
+	}
+	if root == "" {
+		return nil, fmt.Errorf("select a folder in the tree or enter a folder to search in")
+	}
+
+	req := &pb.SearchFilesRequest{
+		RootPath:       root,
+		NamePattern:    strings.TrimSpace(p.nameEntry.Text),
+		ContentPattern: p.contentEntry.Text,
+		UseRegex:       p.regexCheck.Checked,
+		CaseSensitive:  p.caseCheck.Checked,
+		IncludeHidden:  p.hiddenCheck.Checked,
+	}
+	if req.NamePattern == "" && req.ContentPattern == "" {
+		return nil, fmt.Errorf("enter a name pattern or text to search for")
+	}
+
+	parse := func(entry *widget.Entry, what string) (int64, error) {
+		text := strings.TrimSpace(entry.Text)
+		if text == "" {
+			return 0, nil
+		}
+		v, err := strconv.ParseInt(text, 10, 64)
+		if err != nil || v < 0 {
+			return 0, fmt.Errorf("invalid %s: %q", what, text)
+		}
+		return v, nil
+	}
+	minKB, err := parse(p.minSizeEntry, "minimum size")
+	if err != nil {
+		return nil, err
+	}
+	maxKB, err := parse(p.maxSizeEntry, "maximum size")
+	if err != nil {
+		return nil, err
+	}
+	days, err := parse(p.daysEntry, "modified within days")
+	if err != nil {
+		return nil, err
+	}
+	depth, err := parse(p.depthEntry, "max depth")
+	if err != nil {
+		return nil, err
+	}
+	req.MinSize = minKB * 1024
+	req.MaxSize = maxKB * 1024
+	if days > 0 {
+		req.ModifiedAfter = time.Now().Add(-time.Duration(days) * 24 * time.Hour).UnixNano()
+	}
+	req.MaxDepth = int32(depth)
+	return req, nil
+}
+
+func (p *fileSearchPanel) startSearch() {
+	if filesClient == nil {
+		dialog.ShowError(fmt.Errorf("file transfer client not initialized"), p.window)
+		return
+	}
+	req, err := p.buildRequest()
+	if err != nil {
+		dialog.ShowError(err, p.window)
+		return
+	}
+
+	p.stopSearch()
+	ctx, cancel := context.WithCancel(context.Background())
+	p.mu.Lock()
+	p.results = nil
+	p.cancel = cancel
+	p.searchID++
+	searchID := p.searchID
+	p.mu.Unlock()
+
+	p.resultsList.UnselectAll()
+	p.resultsList.Refresh()
+	p.statusLabel.SetText(fmt.Sprintf("Searching %s...", req.RootPath))
+	p.stopButton.Enable()
+	p.treeView.Hide()
+	p.resultsPanel.Show()
+
+	go p.runSearch(ctx, searchID, req)
+}
+
+func (p *fileSearchPanel) runSearch(ctx context.Context, searchID int, req *pb.SearchFilesRequest) {
+	log.Printf("Starting search under '%s' for name '%s', content '%s'", req.RootPath, req.NamePattern, req.ContentPattern)
+	stream, err := filesClient.SearchFiles(ctx, req)
+	if err == nil {
+		lastRefresh := time.Now()
+		for {
+			var match *pb.SearchMatch
+			match, err = stream.Recv()
+			if err != nil {
+				break
+			}
+			p.mu.Lock()
+			if p.searchID != searchID {
+				p.mu.Unlock()
+				return
+			}
+			p.results = append(p.results, match)
+			count := len(p.results)
+			p.mu.Unlock()
+			// Batch UI updates; a search can return thousands of matches quickly.
+			if time.Since(lastRefresh) > 200*time.Millisecond {
+				p.statusLabel.SetText(fmt.Sprintf("Searching %s... %d found", req.RootPath, count))
+				p.resultsList.Refresh()
+				lastRefresh = time.Now()
+			}
+		}
+	}
+
+	p.mu.Lock()
+	superseded := p.searchID != searchID
+	p.mu.Unlock()
+	if superseded {
+		return
+	}
+	count := p.resultCount()
+	switch {
+	case err == io.EOF:
+		log.Printf("Search under '%s' finished with %d matches", req.RootPath, count)
+		p.statusLabel.SetText(fmt.Sprintf("%d found in %s", count, req.RootPath))
+	case status.Code(err) == codes.Canceled || ctx.Err() != nil:
+		log.Printf("Search under '%s' stopped with %d matches", req.RootPath, count)
+		p.statusLabel.SetText(fmt.Sprintf("Stopped. %d found in %s", count, req.RootPath))
+	default:
+		log.Printf("Search under '%s' failed: %v", req.RootPath, err)
+		p.statusLabel.SetText(fmt.Sprintf("Search failed after %d matches", count))
+		showDownloadError("Search failed", err, p.window)
+	}
+	p.stopButton.Disable()
+	p.resultsList.Refresh()
+}
+
+func (p *fileSearchPanel) stopSearch() {
+	p.mu.Lock()
+	cancel := p.cancel
+	p.cancel = nil
+	p.mu.Unlock()
+	if cancel != nil {
+		cancel()
+	}
+}
+
+func (p *fileSearchPanel) closeResults() {
+	p.stopSearch()
+	p.resultsPanel.Hide()
+	p.treeView.Show()
+}
+
+func (p *fileSearchPanel) resultCount() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.results)
+}
+
+func (p *fileSearchPanel) resultAt(i int) *pb.SearchMatch {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if i < 0 || i >= len(p.results) {
+		return nil
+	}
+	return p.results[i]
+}
+
+func (p *fileSearchPanel) createResultItem() fyne.CanvasObject {
+	detailLabel := widget.NewLabel("")
+	detailLabel.Importance = widget.LowImportance
+	return container.NewHBox(widget.NewIcon(nil), widget.NewLabel("Template"), detailLabel)
+}
+
+func (p *fileSearchPanel) updateResultItem(id widget.ListItemID, item fyne.CanvasObject) {
+	match := p.resultAt(id)
+	if match == nil || match.GetNode() == nil {
+		return
+	}
+	row := item.(*fyne.Container)
+	icon := row.Objects[0].(*widget.Icon)
+	label := row.Objects[1].(*widget.Label)
+	detailLabel := row.Objects[2].(*widget.Label)
+
+	node := match.GetNode()
+	if node.Type == pb.FSNode_FOLDER {
+		icon.SetResource(theme.FolderIcon())
+	} else {
+		icon.SetResource(theme.FileIcon())
+	}
+	label.SetText(node.Path)
+	if match.GetLineNumber() > 0 {
+		detailLabel.SetText(fmt.Sprintf("line %d: %s", match.GetLineNumber(), match.GetLine()))
+	} else {
+		detailLabel.SetText(formatNodeDetails(node))
+	}
+}
+
+func (p *fileSearchPanel) onResultSelected(id widget.ListItemID) {
+	match := p.resultAt(id)
+	p.resultsList.Unselect(id)
+	if match == nil || match.GetNode() == nil {
+		return
+	}
+	node := match.GetNode()
+	if node.Type == pb.FSNode_FOLDER {
+		log.Printf("Search result folder selected: %s. Using it as search root.", node.Path)
+		p.rootEntry.SetText(node.Path)
+		return
+	}
+	log.Printf("Search result file selected: %s. Showing preview.", node.Path)
+	showFilePreview(node)
+}
@@ -0,0 +1,736 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	pb "control_grpc/gen/proto"
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/theme"
+	"fyne.io/fyne/v2/widget"
+)
+
+type syncDirection int
+
+const (
+	syncLocalToRemote syncDirection = iota
+	syncRemoteToLocal
+	syncBothWays
+)
+
+type syncActionKind int
+
+const (
+	syncMkdirRemote syncActionKind = iota
+	syncMkdirLocal
+	syncUpload
+	syncDownload
+	syncDeleteRemote
+	syncDeleteLocal
+	syncConflict
+	syncSkipUnreadable
+	syncSkipNotSynced
+)
+
+var syncActionLabels = map[syncActionKind]string{
+	syncMkdirRemote:    "Create remote folder",
+	syncMkdirLocal:     "Create local folder",
+	syncUpload:         "Upload",
+	syncDownload:       "Download",
+	syncDeleteRemote:   "Delete remote",
+	syncDeleteLocal:    "Delete local",
+	syncConflict:       "Skip (conflict)",
+	syncSkipUnreadable: "Skip (unreadable)",
+	syncSkipNotSynced:  "Skip (not synced)",
+}
+
+// syncAction is one step of a sync plan, applied to RelPath below both roots.
+type syncAction struct {
+	Kind    syncActionKind
+	RelPath string // slash-separated, relative to the synced folders
+	Size    int64
+	ModTime int64 // Unix nanoseconds of the source side, applied to the copy
+	Reason  string
+}
+
+// syncOptions describes what to compare and how to reconcile differences.
+type syncOptions struct {
+	LocalRoot        string
+	RemoteRoot       string
+	Direction        syncDirection
+	DeleteExtraneous bool // one-way modes only: remove files missing on the source side
+	CompareHashes    bool
+	Exclude          []string
+}
+
+// Modification times within this window are treated as equal, so file systems with
+// coarse timestamps (FAT, some network shares) do not cause endless re-syncs.
+const syncMtimeTolerance = 2 * time.Second
+
+func matchSyncExclude(patterns []string, relPath string) bool {
+	for _, p := range patterns {
+		name := relPath
+		if !strings.Contains(p, "/") {
+			name = path.Base(relPath)
+		}
+		if matched, _ := path.Match(p, name); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// buildLocalManifest lists regular files and folders below root, keyed by slash-separated
+// relative path, in the same shape as the server's GetManifest. Other files are listed
+// with a skip reason, so that a sync does not take them as missing.
+func buildLocalManifest(ctx context.Context, root string, exclude []string, withHashes bool) (map[string]*pb.ManifestEntry, error) {
+	manifest := make(map[string]*pb.ManifestEntry)
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		if err != nil && p == root {
+			return err
+		}
+		if p == root {
+			return nil
+		}
+		rel, relErr := filepath.Rel(root, p)
+		if relErr != nil {
+			return nil
+		}
+		rel = filepath.ToSlash(rel)
+		// skip keeps an entry that could not be read, so that planSync does not take what
+		// is missing below it as deleted.
+		skip := func(isDir bool) error {
+			manifest[rel] = &pb.ManifestEntry{RelativePath: rel, IsDir: isDir, Unreadable: true}
+			if isDir {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if err != nil {
+			log.Printf("Sync: skipping local '%s': %v", p, err)
+			return skip(d != nil && d.IsDir())
+		}
+		if matchSyncExclude(exclude, rel) {
+			if d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			log.Printf("Sync: could not stat '%s': %v. Skipping.", p, err)
+			return skip(d.IsDir())
+		}
+		if !d.IsDir() && !info.Mode().IsRegular() {
+			manifest[rel] = &pb.ManifestEntry{RelativePath: rel, SkipReason: describeUnsyncedFile(info.Mode()) + " locally"}
+			return nil
+		}
+		entry := &pb.ManifestEntry{RelativePath: rel, IsDir: d.IsDir(), ModifiedTime: info.ModTime().UnixNano()}
+		if !d.IsDir() {
+			entry.Size = info.Size()
+			if withHashes {
+				digest, err := hashLocalFile(p)
+				if err != nil {
+					log.Printf("Sync: could not hash '%s': %v. Skipping.", p, err)
+					return skip(false)
+				}
+				entry.Sha256 = digest
+			}
+		}
+		manifest[rel] = entry
+		return nil
+	})
+	return manifest, err
+}
+
+// describeUnsyncedFile names the kind of a file that is not regular.
+func describeUnsyncedFile(mode fs.FileMode) string {
+	switch {
+	case mode&fs.ModeSymlink != 0:
+		return "a symlink"
+	case mode&fs.ModeNamedPipe != 0:
+		return "a named pipe"
+	case mode&fs.ModeSocket != 0:
+		return "a socket"
+	case mode&fs.ModeDevice != 0:
+		return "a device"
+	}
+	return "not a regular file"
+}
+
+func hashLocalFile(p string) ([]byte, error) {
+	file, err := os.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, file); err != nil {
+		return nil, err
+	}
+	return hasher.Sum(nil), nil
+}
+
+func fetchRemoteManifest(ctx context.Context, opts *syncOptions) (map[string]*pb.ManifestEntry, error) {
+	stream, err := filesClient.GetManifest(ctx, &pb.ManifestRequest{
+		RootPath:        opts.RemoteRoot,
+		IncludeHashes:   opts.CompareHashes,
+		ExcludePatterns: opts.Exclude,
+	})
+	if err != nil {
+		return nil, err
+	}
+	manifest := make(map[string]*pb.ManifestEntry)
+	for {
+		batch, err := stream.Recv()
+		if err == io.EOF {
+			return manifest, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range batch.GetEntries() {
+			// A folder the host could not read is listed again after its own entry.
+			if prev, ok := manifest[entry.GetRelativePath()]; ok && prev.GetUnreadable() {
+				continue
+			}
+			manifest[entry.GetRelativePath()] = entry
+		}
+	}
+}
+
+func sameSyncContent(local, remote *pb.ManifestEntry, compareHashes bool) bool {
+	if local.GetSize() != remote.GetSize() {
+		return false
+	}
+	if compareHashes && len(local.GetSha256()) > 0 && len(remote.GetSha256()) > 0 {
+		return bytes.Equal(local.GetSha256(), remote.GetSha256())
+	}
+	diff := time.Duration(local.GetModifiedTime() - remote.GetModifiedTime())
+	return diff.Abs() <= syncMtimeTolerance
+}
+
+// planSync compares two manifests and returns the actions that reconcile them:
+// folder creation first (parents before children), then transfers, then deletions.
+// In two-way mode a file that differs is copied from the side that changed it since
+// base, the last sync; one changed on both sides, or with no base to tell, is a
+// conflict. Entries that could not be read or are not synced on either side are
+// skipped along with everything below them, as what a manifest leaves out there is
+// unknown rather than deleted.
+func planSync(local, remote map[string]*pb.ManifestEntry, base *syncBase, opts *syncOptions) []syncAction {
+	paths := make([]string, 0, len(local)+len(remote))
+	for p := range local {
+		paths = append(paths, p)
+	}
+	for p := range remote {
+		if _, ok := local[p]; !ok {
+			paths = append(paths, p)
+		}
+	}
+	sort.Strings(paths)
+
+	var skipped []string
+	for _, p := range paths {
+		if _, _, skip := syncSkip(local[p], remote[p]); skip {
+			skipped = append(skipped, p)
+		}
+	}
+	belowSkipped := func(p string) bool {
+		for _, dir := range skipped {
+			if strings.HasPrefix(p, dir+"/") {
+				return true
+			}
+		}
+		return false
+	}
+
+	var mkdirs, transfers, deletes []syncAction
+	var deletedDirs []string
+	underDeletedDir := func(p string) bool {
+		for _, dir := range deletedDirs {
+			if strings.HasPrefix(p, dir+"/") {
+				return true
+			}
+		}
+		return false
+	}
+	upload := opts.Direction != syncRemoteToLocal
+	download := opts.Direction != syncLocalToRemote
+
+	for _, p := range paths {
+		l, inLocal := local[p]
+		r, inRemote := remote[p]
+		if kind, reason, skip := syncSkip(l, r); skip {
+			transfers = append(transfers, syncAction{Kind: kind, RelPath: p, Reason: reason})
+			continue
+		}
+		if belowSkipped(p) {
+			continue
+		}
+		switch {
+		case inLocal && inRemote:
+			if l.GetIsDir() != r.GetIsDir() {
+				transfers = append(transfers, syncAction{Kind: syncConflict, RelPath: p, Reason: "file on one side, folder on the other"})
+				continue
+			}
+			if l.GetIsDir() || sameSyncContent(l, r, opts.CompareHashes) {
+				continue
+			}
+			uploadChange := opts.Direction == syncLocalToRemote
+			if opts.Direction == syncBothWays {
+				localChanged := !base.unchanged(false, l, opts.CompareHashes)
+				remoteChanged := !base.unchanged(true, r, opts.CompareHashes)
+				if localChanged == remoteChanged {
+					transfers = append(transfers, syncAction{Kind: syncConflict, RelPath: p, Reason: describeSyncConflict(base, p, localChanged)})
+					continue
+				}
+				uploadChange = localChanged
+			}
+			if uploadChange {
+				transfers = append(transfers, syncAction{Kind: syncUpload, RelPath: p, Size: l.GetSize(), ModTime: l.GetModifiedTime(), Reason: describeSyncDifference(l, r)})
+			} else {
+				transfers = append(transfers, syncAction{Kind: syncDownload, RelPath: p, Size: r.GetSize(), ModTime: r.GetModifiedTime(), Reason: describeSyncDifference(r, l)})
+			}
+		case inLocal:
+			if underDeletedDir(p) {
+				continue
+			}
+			if upload {
+				if l.GetIsDir() {
+					mkdirs = append(mkdirs, syncAction{Kind: syncMkdirRemote, RelPath: p, Reason: "missing on host"})
+				} else {
+					transfers = append(transfers, syncAction{Kind: syncUpload, RelPath: p, Size: l.GetSize(), ModTime: l.GetModifiedTime(), Reason: "missing on host"})
+				}
+			} else if opts.DeleteExtraneous {
+				deletes = append(deletes, syncAction{Kind: syncDeleteLocal, RelPath: p, Reason: "not on host"})
+				if l.GetIsDir() {
+					deletedDirs = append(deletedDirs, p)
+				}
+			}
+		case inRemote:
+			if underDeletedDir(p) {
+				continue
+			}
+			if download {
+				if r.GetIsDir() {
+					mkdirs = append(mkdirs, syncAction{Kind: syncMkdirLocal, RelPath: p, Reason: "missing locally"})
+				} else {
+					transfers = append(transfers, syncAction{Kind: syncDownload, RelPath: p, Size: r.GetSize(), ModTime: r.GetModifiedTime(), Reason: "missing locally"})
+				}
+			} else if opts.DeleteExtraneous {
+				deletes = append(deletes, syncAction{Kind: syncDeleteRemote, RelPath: p, Reason: "not in local folder"})
+				if r.GetIsDir() {
+					deletedDirs = append(deletedDirs, p)
+				}
+			}
+		}
+	}
+
+	plan := append(mkdirs, transfers...)
+	return append(plan, deletes...)
+}
+
+// syncSkip reports whether a path is left out of the sync because either side could
+// not read it or does not sync it, and which skip action and reason to plan for it.
+func syncSkip(local, remote *pb.ManifestEntry) (syncActionKind, string, bool) {
+	switch {
+	case local.GetUnreadable():
+		return syncSkipUnreadable, "could not be read locally", true
+	case remote.GetUnreadable():
+		return syncSkipUnreadable, "could not be read on the host", true
+	case local.GetSkipReason() != "":
+		return syncSkipNotSynced, local.GetSkipReason(), true
+	case remote.GetSkipReason() != "":
+		return syncSkipNotSynced, remote.GetSkipReason(), true
+	}
+	return 0, "", false
+}
+
+func describeSyncConflict(base *syncBase, p string, changed bool) string {
+	switch {
+	case !base.agreedOn(p):
+		return "differs, and was not synced before; sync one way first"
+	case changed:
+		return "changed on both sides since the last sync"
+	}
+	return "differs, though neither side changed since the last sync"
+}
+
+func describeSyncDifference(newer, older *pb.ManifestEntry) string {
+	if newer.GetSize() != older.GetSize() {
+		return fmt.Sprintf("size %s vs %s", formatBytes(newer.GetSize()), formatBytes(older.GetSize()))
+	}
+	if newer.GetModifiedTime() > older.GetModifiedTime() {
+		return "newer"
+	}
+	if len(newer.GetSha256()) > 0 && len(older.GetSha256()) > 0 {
+		return "content differs"
+	}
+	return "modified"
+}
+
+// remoteSyncPath joins a slash-separated relative path onto a remote root using the
+// server's separator.
+func remoteSyncPath(root, relPath string) string {
+	separator := "/"
+	if strings.Contains(root, `\`) {
+		separator = `\`
+	}
+	return joinRemotePath(root, strings.ReplaceAll(relPath, "/", separator))
+}
+
+// applySyncAction performs one planned step.
+func applySyncAction(ctx context.Context, opts *syncOptions, action syncAction) error {
+	localPath := filepath.Join(opts.LocalRoot, filepath.FromSlash(action.RelPath))
+	remotePath := remoteSyncPath(opts.RemoteRoot, action.RelPath)
+
+	switch action.Kind {
+	case syncMkdirRemote:
+		res, err := filesClient.MakeDirectory(ctx, &pb.MakeDirectoryRequest{Path: remotePath, Parents: true})
+		return firstFileOperationError(res, err)
+	case syncMkdirLocal:
+		return os.MkdirAll(localPath, 0755)
+	case syncUpload:
+		return syncUploadFile(ctx, localPath, remotePath, action.ModTime)
+	case syncDownload:
+		return syncDownloadFile(ctx, remotePath, localPath)
+	case syncDeleteRemote:
+		res, err := filesClient.DeletePaths(ctx, &pb.DeletePathsRequest{Paths: []string{remotePath}, Recursive: true})
+		return firstFileOperationError(res, err)
+	case syncDeleteLocal:
+		return os.RemoveAll(localPath)
+	default:
+		return nil
+	}
+}
+
+func firstFileOperationError(res *pb.FileOperationResponse, err error) error {
+	if err != nil {
+		return err
+	}
+	for _, result := range res.GetResults() {
+		if !result.GetSuccess() {
+			return fmt.Errorf("%s", result.GetErrorMessage())
+		}
+	}
+	return nil
+}
+
+// syncUploadFile uploads a local file, asking the server to keep its modification time
+// so the next comparison sees both sides as equal.
+func syncUploadFile(ctx context.Context, localPath, remotePath string, modTime int64) error {
+	file, err := os.Open(localPath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	stream, err := filesClient.UploadFile(ctx)
+	if err != nil {
+		return err
+	}
+	buffer := make([]byte, 1024*64)
+	firstChunk := true
+	for {
+		n, readErr := file.Read(buffer)
+		if n > 0 || (firstChunk && readErr == io.EOF) {
+			chunk := &pb.FileChunk{Content: buffer[:n]}
+			if firstChunk {
+				chunk.Metadata = &pb.FileChunkMetadata{TotalSize: info.Size(), Path: remotePath, ModifiedTime: modTime}
+				firstChunk = false
+			}
+			if sendErr := stream.Send(chunk); sendErr != nil {
+				if _, closeErr := stream.CloseAndRecv(); closeErr != nil {
+					return closeErr
+				}
+				return sendErr
+			}
+		}
+		if readErr == io.EOF {
+			break
+		}
+		if readErr != nil {
+			return readErr
+		}
+	}
+	_, err = stream.CloseAndRecv()
+	return err
+}
+
+// syncDownloadFile downloads a remote file into a temporary file next to localPath,
+// checks the SHA-256 reported by the host and then moves it into place with the
+// remote modification time.
+func syncDownloadFile(ctx context.Context, remotePath, localPath string) error {
+	stream, err := filesClient.DownloadFile(ctx, &pb.FileRequest{Path: remotePath})
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(localPath), 0755); err != nil {
+		return err
+	}
+	tmpFile, err := os.CreateTemp(filepath.Dir(localPath), "."+filepath.Base(localPath)+".sync-*")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmpFile.Name()
+	committed := false
+	defer func() {
+		if !committed {
+			tmpFile.Close()
+			os.Remove(tmpPath)
+		}
+	}()
+
+	hasher := sha256.New()
+	var modTime int64
+	var digest []byte
+	for {
+		chunk, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if metadata := chunk.GetMetadata(); metadata != nil {
+			if metadata.GetModifiedTime() != 0 {
+				modTime = metadata.GetModifiedTime()
+			}
+			if len(metadata.GetSha256()) > 0 {
+				digest = metadata.GetSha256()
+			}
+		}
+		if _, err := tmpFile.Write(chunk.GetContent()); err != nil {
+			return err
+		}
+		hasher.Write(chunk.GetContent())
+	}
+	if len(digest) > 0 && !bytes.Equal(digest, hasher.Sum(nil)) {
+		return errDigestMismatch
+	}
+	if err := tmpFile.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpPath, localPath); err != nil {
+		return err
+	}
+	committed = true
+	if modTime != 0 {
+		t := time.Unix(0, modTime)
+		if err := os.Chtimes(localPath, t, t); err != nil {
+			log.Printf("Sync: could not set modification time on '%s': %v", localPath, err)
+		}
+	}
+	return nil
+}
+
+// openSyncWindow shows the sync dialog for a remote folder: choose a local folder and
+// direction, compare to get a dry-run list of changes, then apply them.
+func openSyncWindow(remoteRoot string) {
+	if AppInstance == nil || filesClient == nil {
+		log.Println("Sync: application or file client not available.")
+		return
+	}
+	win := AppInstance.NewWindow(fmt.Sprintf("Sync %s", remoteRoot))
+
+	localEntry := widget.NewEntry()
+	localEntry.SetPlaceHolder("Local folder")
+	browseButton := widget.NewButtonWithIcon("", theme.FolderOpenIcon(), func() {
+		dialog.ShowFolderOpen(func(uri fyne.ListableURI, err error) {
+			if err == nil && uri != nil {
+				localEntry.SetText(uri.Path())
+			}
+		}, win)
+	})
+	directionNames := []string{"Local -> Host", "Host -> Local", "Both ways (changed side wins)"}
+	directionRadio := widget.NewRadioGroup(directionNames, nil)
+	directionRadio.Horizontal = true
+	directionRadio.SetSelected(directionNames[0])
+	deleteCheck := widget.NewCheck("Delete files missing from the source (one-way only)", nil)
+	hashCheck := widget.NewCheck("Compare SHA-256 checksums (slower)", nil)
+	excludeEntry := widget.NewEntry()
+	excludeEntry.SetText(".git, node_modules")
+
+	var plan []syncAction
+	var opts *syncOptions
+	summaryLabel := widget.NewLabel("Choose a local folder and press Compare to preview changes.")
+	summaryLabel.Wrapping = fyne.TextWrapWord
+	progressBar := widget.NewProgressBar()
+	progressBar.Hide()
+
+	planList := widget.NewList(
+		func() int { return len(plan) },
+		func() fyne.CanvasObject {
+			return container.NewHBox(widget.NewLabel("Action"), widget.NewLabel("Path"), widget.NewLabel("Reason"))
+		},
+		func(id widget.ListItemID, item fyne.CanvasObject) {
+			if id >= len(plan) {
+				return
+			}
+			row := item.(*fyne.Container)
+			row.Objects[0].(*widget.Label).SetText(syncActionLabels[plan[id].Kind])
+			row.Objects[1].(*widget.Label).SetText(plan[id].RelPath)
+			row.Objects[2].(*widget.Label).SetText(plan[id].Reason)
+		},
+	)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	win.SetOnClosed(cancel)
+
+	var compareButton, applyButton *widget.Button
+	compareButton = widget.NewButtonWithIcon("Compare", theme.SearchIcon(), func() {
+		localRoot := strings.TrimSpace(localEntry.Text)
+		if localRoot == "" {
+			dialog.ShowError(fmt.Errorf("choose a local folder first"), win)
+			return
+		}
+		opts = &syncOptions{
+			LocalRoot:        localRoot,
+			RemoteRoot:       remoteRoot,
+			DeleteExtraneous: deleteCheck.Checked,
+			CompareHashes:    hashCheck.Checked,
+			Exclude:          splitPatternList(excludeEntry.Text),
+		}
+		for i, name := range directionNames {
+			if name == directionRadio.Selected {
+				opts.Direction = syncDirection(i)
+			}
+		}
+		compareButton.Disable()
+		applyButton.Disable()
+		summaryLabel.SetText("Comparing folders...")
+		go func(opts *syncOptions) {
+			defer compareButton.Enable()
+			localManifest, err := buildLocalManifest(ctx, opts.LocalRoot, opts.Exclude, opts.CompareHashes)
+			if err != nil {
+				summaryLabel.SetText("Compare failed.")
+				dialog.ShowError(fmt.Errorf("reading local folder: %v", err), win)
+				return
+			}
+			remoteManifest, err := fetchRemoteManifest(ctx, opts)
+			if err != nil {
+				summaryLabel.SetText("Compare failed.")
+				showDownloadError("Reading remote folder failed", err, win)
+				return
+			}
+			plan = planSync(localManifest, remoteManifest, loadSyncBase(opts.LocalRoot, opts.RemoteRoot), opts)
+			log.Printf("Sync plan for '%s' <-> '%s': %d actions (%d local, %d remote entries)",
+				opts.LocalRoot, opts.RemoteRoot, len(plan), len(localManifest), len(remoteManifest))
+			summaryLabel.SetText(summarizeSyncPlan(plan))
+			planList.Refresh()
+			if len(plan) > 0 {
+				applyButton.Enable()
+			}
+		}(opts)
+	})
+	applyButton = widget.NewButtonWithIcon("Apply", theme.ConfirmIcon(), func() {
+		if len(plan) == 0 || opts == nil {
+			return
+		}
+		compareButton.Disable()
+		applyButton.Disable()
+		progressBar.Max = float64(len(plan))
+		progressBar.SetValue(0)
+		progressBar.Show()
+		go func(plan []syncAction, opts *syncOptions) {
+			defer compareButton.Enable()
+			var failures []string
+			for i, action := range plan {
+				if ctx.Err() != nil {
+					return
+				}
+				summaryLabel.SetText(fmt.Sprintf("%s %s (%d/%d)", syncActionLabels[action.Kind], action.RelPath, i+1, len(plan)))
+				if err := applySyncAction(ctx, opts, action); err != nil {
+					log.Printf("Sync: %s '%s' failed: %v", syncActionLabels[action.Kind], action.RelPath, err)
+					failures = append(failures, fmt.Sprintf("%s %s: %v", syncActionLabels[action.Kind], action.RelPath, err))
+				}
+				progressBar.SetValue(float64(i + 1))
+			}
+			summaryLabel.SetText("Recording the synced state...")
+			if err := recordSyncBase(ctx, opts); err != nil {
+				log.Printf("Sync: could not record the synced state of '%s': %v", opts.LocalRoot, err)
+			}
+			summaryLabel.SetText(fmt.Sprintf("Sync finished: %d actions, %d failed. Compare again to verify.", len(plan), len(failures)))
+			if len(failures) > 0 {
+				dialog.ShowError(fmt.Errorf("some sync actions failed:\n%s", strings.Join(failures, "\n")), win)
+			}
+			treeDataMutex.RLock()
+			_, loaded := childrenMap[opts.RemoteRoot]
+			treeDataMutex.RUnlock()
+			if loaded {
+				fetchChildren(opts.RemoteRoot)
+			}
+		}(plan, opts)
+	})
+	applyButton.Disable()
+
+	form := widget.NewForm(
+		widget.NewFormItem("Host folder", widget.NewLabel(remoteRoot)),
+		widget.NewFormItem("Local folder", container.NewBorder(nil, nil, nil, browseButton, localEntry)),
+		widget.NewFormItem("Direction", directionRadio),
+		widget.NewFormItem("", deleteCheck),
+		widget.NewFormItem("", hashCheck),
+		widget.NewFormItem("Exclude", excludeEntry),
+	)
+	top := container.NewVBox(form, container.NewHBox(compareButton, applyButton), summaryLabel, progressBar)
+	win.SetContent(container.NewBorder(top, nil, nil, nil, planList))
+	win.Resize(fyne.NewSize(700, 550))
+	win.Show()
+}
+
+func summarizeSyncPlan(plan []syncAction) string {
+	if len(plan) == 0 {
+		return "Folders are already in sync."
+	}
+	counts := make(map[syncActionKind]int)
+	var uploadBytes, downloadBytes int64
+	for _, action := range plan {
+		counts[action.Kind]++
+		switch action.Kind {
+		case syncUpload:
+			uploadBytes += action.Size
+		case syncDownload:
+			downloadBytes += action.Size
+		}
+	}
+	parts := []string{
+		fmt.Sprintf("%d uploads (%s)", counts[syncUpload], formatBytes(uploadBytes)),
+		fmt.Sprintf("%d downloads (%s)", counts[syncDownload], formatBytes(downloadBytes)),
+	}
+	if n := counts[syncMkdirRemote] + counts[syncMkdirLocal]; n > 0 {
+		parts = append(parts, fmt.Sprintf("%d new folders", n))
+	}
+	if n := counts[syncDeleteRemote] + counts[syncDeleteLocal]; n > 0 {
+		parts = append(parts, fmt.Sprintf("%d deletions", n))
+	}
+	if n := counts[syncConflict]; n > 0 {
+		parts = append(parts, fmt.Sprintf("%d conflicts skipped", n))
+	}
+	if n := counts[syncSkipUnreadable]; n > 0 {
+		parts = append(parts, fmt.Sprintf("%d unreadable paths skipped", n))
+	}
+	if n := counts[syncSkipNotSynced]; n > 0 {
+		parts = append(parts, fmt.Sprintf("%d paths skipped that are not synced", n))
+	}
+	return "Dry run: " + strings.Join(parts, ", ") + ". Nothing has been changed yet; press Apply to sync."
+}
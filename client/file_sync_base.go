@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	pb "control_grpc/gen/proto"
+)
+
+const syncBaseSuffix = ".sync-base.json"
+
+// syncBase is the sidecar record, kept next to a synced local folder, of what each side
+// held when both last agreed on a file. Two-way syncs compare each side with it to tell
+// which one changed since, without trusting either clock.
+type syncBase struct {
+	RemoteRoot string                   `json:"remote_root"`
+	Local      map[string]syncBaseEntry `json:"local"`
+	Remote     map[string]syncBaseEntry `json:"remote"`
+}
+
+type syncBaseEntry struct {
+	Size         int64  `json:"size"`
+	ModifiedTime int64  `json:"modified_time"`
+	Sha256       []byte `json:"sha256,omitempty"`
+}
+
+func newSyncBase(remoteRoot string) *syncBase {
+	return &syncBase{RemoteRoot: remoteRoot, Local: make(map[string]syncBaseEntry), Remote: make(map[string]syncBaseEntry)}
+}
+
+// syncBasePath places the record beside the local folder rather than in it, so that it
+// is never synced itself.
+func syncBasePath(localRoot string) string {
+	localRoot = filepath.Clean(localRoot)
+	return filepath.Join(filepath.Dir(localRoot), "."+filepath.Base(localRoot)+syncBaseSuffix)
+}
+
+// loadSyncBase returns the record of the last sync between the two folders, or an empty
+// one when they were never synced, or the local folder was last synced with another.
+func loadSyncBase(localRoot, remoteRoot string) *syncBase {
+	data, err := os.ReadFile(syncBasePath(localRoot))
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("Sync: could not read the last sync's state: %v", err)
+		}
+		return newSyncBase(remoteRoot)
+	}
+	base := &syncBase{}
+	if err := json.Unmarshal(data, base); err != nil {
+		log.Printf("Sync: ignoring corrupt sync state file %s: %v", syncBasePath(localRoot), err)
+		return newSyncBase(remoteRoot)
+	}
+	if base.RemoteRoot != remoteRoot || base.Local == nil || base.Remote == nil {
+		return newSyncBase(remoteRoot)
+	}
+	return base
+}
+
+func saveSyncBase(localRoot string, base *syncBase) error {
+	data, err := json.Marshal(base)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(syncBasePath(localRoot), data, 0644); err != nil {
+		return fmt.Errorf("cannot save the sync state: %w", err)
+	}
+	return nil
+}
+
+// recordSyncBase reads both folders again after a sync was applied and saves what they
+// now agree on, for the next two-way sync to compare with.
+func recordSyncBase(ctx context.Context, opts *syncOptions) error {
+	local, err := buildLocalManifest(ctx, opts.LocalRoot, opts.Exclude, opts.CompareHashes)
+	if err != nil {
+		return err
+	}
+	remote, err := fetchRemoteManifest(ctx, opts)
+	if err != nil {
+		return err
+	}
+	base := loadSyncBase(opts.LocalRoot, opts.RemoteRoot)
+	base.record(local, remote, opts.CompareHashes)
+	return saveSyncBase(opts.LocalRoot, base)
+}
+
+// record notes the files both manifests agree on. Files that still differ keep what
+// was last agreed, and paths gone from both sides are forgotten.
+func (b *syncBase) record(local, remote map[string]*pb.ManifestEntry, compareHashes bool) {
+	for p := range b.Local {
+		_, inLocal := local[p]
+		_, inRemote := remote[p]
+		if !inLocal && !inRemote {
+			delete(b.Local, p)
+			delete(b.Remote, p)
+		}
+	}
+	for p, l := range local {
+		r, ok := remote[p]
+		if !ok || l.GetIsDir() || r.GetIsDir() {
+			continue
+		}
+		if _, _, skip := syncSkip(l, r); skip || !sameSyncContent(l, r, compareHashes) {
+			continue
+		}
+		b.Local[p] = syncBaseEntry{Size: l.GetSize(), ModifiedTime: l.GetModifiedTime(), Sha256: l.GetSha256()}
+		b.Remote[p] = syncBaseEntry{Size: r.GetSize(), ModifiedTime: r.GetModifiedTime(), Sha256: r.GetSha256()}
+	}
+}
+
+// agreedOn reports whether both sides held the same file at p at some sync.
+func (b *syncBase) agreedOn(p string) bool {
+	if b == nil {
+		return false
+	}
+	_, ok := b.Local[p]
+	return ok
+}
+
+// unchanged reports whether entry, on the host or locally, still holds what that side
+// held at the last agreement. A path with no agreement on record counts as changed.
+func (b *syncBase) unchanged(onHost bool, entry *pb.ManifestEntry, compareHashes bool) bool {
+	if b == nil {
+		return false
+	}
+	side := b.Local
+	if onHost {
+		side = b.Remote
+	}
+	prev, ok := side[entry.GetRelativePath()]
+	if !ok {
+		return false
+	}
+	return sameSyncContent(entry, &pb.ManifestEntry{Size: prev.Size, ModifiedTime: prev.ModifiedTime, Sha256: prev.Sha256}, compareHashes)
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	pb "control_grpc/gen/proto"
+)
+
+func TestPlanSync(t *testing.T) {
+	now := time.Now().UnixNano()
+	older := now - int64(time.Hour)
+	file := func(p string, size, mtime int64) *pb.ManifestEntry {
+		return &pb.ManifestEntry{RelativePath: p, Size: size, ModifiedTime: mtime}
+	}
+	dir := func(p string) *pb.ManifestEntry {
+		return &pb.ManifestEntry{RelativePath: p, IsDir: true, ModifiedTime: older}
+	}
+	unreadable := func(p string, isDir bool) *pb.ManifestEntry {
+		return &pb.ManifestEntry{RelativePath: p, IsDir: isDir, Unreadable: true}
+	}
+	notSynced := func(p string, isDir bool, reason string) *pb.ManifestEntry {
+		return &pb.ManifestEntry{RelativePath: p, IsDir: isDir, SkipReason: reason}
+	}
+	// synced is the base of a last sync at which both sides held entries.
+	synced := func(entries ...*pb.ManifestEntry) *syncBase {
+		base := newSyncBase("")
+		for _, e := range entries {
+			base.Local[e.GetRelativePath()] = syncBaseEntry{Size: e.GetSize(), ModifiedTime: e.GetModifiedTime()}
+			base.Remote[e.GetRelativePath()] = syncBaseEntry{Size: e.GetSize(), ModifiedTime: e.GetModifiedTime()}
+		}
+		return base
+	}
+	manifest := func(entries ...*pb.ManifestEntry) map[string]*pb.ManifestEntry {
+		m := make(map[string]*pb.ManifestEntry)
+		for _, e := range entries {
+			m[e.GetRelativePath()] = e
+		}
+		return m
+	}
+
+	testCases := []struct {
+		name             string
+		local, remote    map[string]*pb.ManifestEntry
+		base             *syncBase
+		direction        syncDirection
+		deleteExtraneous bool
+		expected         []string
+	}{
+		{
+			name:      "InSync",
+			local:     manifest(dir("a"), file("a/x", 1, now)),
+			remote:    manifest(dir("a"), file("a/x", 1, now+int64(time.Second))),
+			direction: syncBothWays,
+			expected:  nil,
+		},
+		{
+			name:      "UploadMissingAndChanged",
+			local:     manifest(dir("a"), file("a/new", 3, now), file("changed", 5, now)),
+			remote:    manifest(file("changed", 4, older), file("extra", 1, now)),
+			direction: syncLocalToRemote,
+			expected:  []string{"Create remote folder a", "Upload a/new", "Upload changed"},
+		},
+		{
+			name:             "LocalToRemoteDeletesRemoteExtras",
+			local:            manifest(file("keep", 1, now)),
+			remote:           manifest(file("keep", 1, now), dir("old"), file("old/x", 1, now), file("stale", 1, now)),
+			direction:        syncLocalToRemote,
+			deleteExtraneous: true,
+			// Only the folder is deleted, not each file below it.
+			expected: []string{"Delete remote old", "Delete remote stale"},
+		},
+		{
+			name:             "RemoteToLocalDeletesLocalExtras",
+			local:            manifest(file("stale", 1, now), file("changed", 1, now)),
+			remote:           manifest(dir("d"), file("changed", 2, older)),
+			direction:        syncRemoteToLocal,
+			deleteExtraneous: true,
+			// One-way modes take the source side even when the target is newer.
+			expected: []string{"Create local folder d", "Download changed", "Delete local stale"},
+		},
+		{
+			name:      "TwoWayCopiesTheChangedSide",
+			local:     manifest(file("mine", 2, now), file("theirs", 1, older), file("only-local", 1, now)),
+			remote:    manifest(file("mine", 1, older), file("theirs", 2, now), file("only-remote", 1, now)),
+			base:      synced(file("mine", 1, older), file("theirs", 1, older)),
+			direction: syncBothWays,
+			// Two-way never deletes, even when asked to.
+			deleteExtraneous: true,
+			expected:         []string{"Upload mine", "Upload only-local", "Download only-remote", "Download theirs"},
+		},
+		{
+			name:   "TwoWayIgnoresClocks",
+			local:  manifest(file("edited", 2, older+int64(time.Minute))),
+			remote: manifest(file("edited", 1, now)),
+			// The host's clock runs ahead, so its untouched copy looks newer.
+			base:      synced(file("edited", 1, now)),
+			direction: syncBothWays,
+			expected:  []string{"Upload edited"},
+		},
+		{
+			name:      "TwoWayChangeOnBothSidesIsAConflict",
+			local:     manifest(file("both", 2, now), file("same", 1, now)),
+			remote:    manifest(file("both", 3, now+int64(time.Minute)), file("same", 1, now)),
+			base:      synced(file("both", 1, older), file("same", 1, now)),
+			direction: syncBothWays,
+			expected:  []string{"Skip (conflict) both"},
+		},
+		{
+			name:      "TwoWayWithoutBaseIsAConflict",
+			local:     manifest(file("mine", 2, now)),
+			remote:    manifest(file("mine", 1, older)),
+			direction: syncBothWays,
+			expected:  []string{"Skip (conflict) mine"},
+		},
+		{
+			name:      "FileAgainstFolderIsAConflict",
+			local:     manifest(file("x", 1, now)),
+			remote:    manifest(dir("x")),
+			direction: syncBothWays,
+			expected:  []string{"Skip (conflict) x"},
+		},
+		{
+			name:             "UnreadableHostFolderIsNotDeletedLocally",
+			local:            manifest(dir("private"), file("private/a", 1, now), dir("private/sub"), file("private/sub/b", 1, now), file("other", 1, now)),
+			remote:           manifest(unreadable("private", true)),
+			direction:        syncRemoteToLocal,
+			deleteExtraneous: true,
+			expected:         []string{"Skip (unreadable) private", "Delete local other"},
+		},
+		{
+			name:             "UnreadableLocalFolderIsNotDeletedOnHost",
+			local:            manifest(unreadable("private", true)),
+			remote:           manifest(dir("private"), file("private/a", 1, now)),
+			direction:        syncLocalToRemote,
+			deleteExtraneous: true,
+			expected:         []string{"Skip (unreadable) private"},
+		},
+		{
+			name:      "NothingIsUploadedIntoAnUnreadableHostFolder",
+			local:     manifest(dir("private"), file("private/a", 1, now)),
+			remote:    manifest(unreadable("private", true)),
+			direction: syncLocalToRemote,
+			expected:  []string{"Skip (unreadable) private"},
+		},
+		{
+			name:             "UnhashableFileIsNotReplacedOrDeleted",
+			local:            manifest(file("locked", 1, now), file("gone", 1, now)),
+			remote:           manifest(unreadable("locked", false)),
+			direction:        syncRemoteToLocal,
+			deleteExtraneous: true,
+			expected:         []string{"Skip (unreadable) locked", "Delete local gone"},
+		},
+		{
+			name:             "SiblingWithSharedPrefixIsStillSynced",
+			local:            manifest(file("private2", 1, now)),
+			remote:           manifest(unreadable("private", true)),
+			direction:        syncRemoteToLocal,
+			deleteExtraneous: true,
+			expected:         []string{"Skip (unreadable) private", "Delete local private2"},
+		},
+		{
+			name: "HiddenAndNonRegularHostEntriesAreNotDeletedLocally",
+			local: manifest(file("secrets.key", 1, now), file("link", 1, now), dir("private"), file("private/a", 1, now),
+				file("other", 1, now)),
+			remote: manifest(notSynced("secrets.key", false, "hidden by the host's file policy"),
+				notSynced("link", false, "a symlink on the host"),
+				notSynced("private", true, "hidden by the host's file policy")),
+			direction:        syncRemoteToLocal,
+			deleteExtraneous: true,
+			expected:         []string{"Skip (not synced) link", "Skip (not synced) private", "Skip (not synced) secrets.key", "Delete local other"},
+		},
+		{
+			name:             "LocalSymlinkIsNotReplacedOnHost",
+			local:            manifest(notSynced("link", false, "a symlink locally")),
+			remote:           manifest(file("link", 1, now), file("gone", 1, now)),
+			direction:        syncLocalToRemote,
+			deleteExtraneous: true,
+			expected:         []string{"Skip (not synced) link", "Delete remote gone"},
+		},
+		{
+			name:      "LocalFileIsNotReplacedByHostSymlink",
+			local:     manifest(file("link", 1, older)),
+			remote:    manifest(notSynced("link", false, "a symlink on the host")),
+			direction: syncBothWays,
+			expected:  []string{"Skip (not synced) link"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			opts := &syncOptions{Direction: tc.direction, DeleteExtraneous: tc.deleteExtraneous}
+			plan := planSync(tc.local, tc.remote, tc.base, opts)
+			var got []string
+			for _, action := range plan {
+				got = append(got, fmt.Sprintf("%s %s", syncActionLabels[action.Kind], action.RelPath))
+			}
+			if !slices.Equal(got, tc.expected) {
+				t.Errorf("planSync = %q, expected %q", got, tc.expected)
+			}
+			for _, action := range plan {
+				if skip := tc.local[action.RelPath].GetSkipReason() + tc.remote[action.RelPath].GetSkipReason(); skip != "" && (action.Kind == syncDeleteLocal || action.Kind == syncDeleteRemote) {
+					t.Errorf("planned to delete %s, which is not synced: %s", action.RelPath, skip)
+				}
+			}
+		})
+	}
+}
+
+func TestSyncBase(t *testing.T) {
+	localRoot := filepath.Join(t.TempDir(), "docs")
+	if base := loadSyncBase(localRoot, "/srv/docs"); len(base.Local) != 0 {
+		t.Fatalf("a folder never synced should have an empty base, got %v", base.Local)
+	}
+
+	entry := func(p string, size int64) *pb.ManifestEntry {
+		return &pb.ManifestEntry{RelativePath: p, Size: size, ModifiedTime: int64(size) * int64(time.Hour)}
+	}
+	manifest := func(entries ...*pb.ManifestEntry) map[string]*pb.ManifestEntry {
+		m := make(map[string]*pb.ManifestEntry)
+		for _, e := range entries {
+			m[e.GetRelativePath()] = e
+		}
+		return m
+	}
+	base := newSyncBase("/srv/docs")
+	base.record(manifest(entry("kept", 1), entry("differs", 1), entry("gone", 1)), manifest(entry("kept", 1), entry("differs", 1), entry("gone", 1)), false)
+	// differs changed on one side since, gone was deleted on both.
+	base.record(manifest(entry("kept", 1), entry("differs", 2), entry("new", 3)), manifest(entry("kept", 1), entry("differs", 1), entry("new", 3)), false)
+	if err := saveSyncBase(localRoot, base); err != nil {
+		t.Fatal(err)
+	}
+	if filepath.Dir(syncBasePath(localRoot)) != filepath.Dir(localRoot) {
+		t.Errorf("the base %s should be beside the folder, not in it", syncBasePath(localRoot))
+	}
+
+	loaded := loadSyncBase(localRoot, "/srv/docs")
+	var paths []string
+	for p := range loaded.Local {
+		paths = append(paths, p)
+	}
+	slices.Sort(paths)
+	if expected := []string{"differs", "kept", "new"}; !slices.Equal(paths, expected) {
+		t.Errorf("recorded paths = %v, expected %v", paths, expected)
+	}
+	if got := loaded.Local["differs"].Size; got != 1 {
+		t.Errorf("a file that differs should keep its last agreed state, got size %d", got)
+	}
+	if other := loadSyncBase(localRoot, "/srv/other"); len(other.Local) != 0 {
+		t.Errorf("the base of another host folder should not be used, got %v", other.Local)
+	}
+}
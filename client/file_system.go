@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"os"
 	"path/filepath"
 	"runtime"
 	"strings"
@@ -29,8 +30,12 @@ var (
 	treeDataMutex   sync.RWMutex
 	nodesMap        = make(map[string]*pb.FSNode)
 	childrenMap     = make(map[string][]string)
+	parentMap       = make(map[string]string)
 )
 
+// fsPageSize is the number of directory entries requested per GetFS call.
+const fsPageSize = 500
+
 func InitializeSharedGlobals(theApp fyne.App, mainWin fyne.Window, client pb.FileTransferServiceClient) {
 	AppInstance = theApp
 	mainWindow = mainWin
@@ -61,9 +66,13 @@ func isTreeNodeBranchFunc(id widget.TreeNodeID) bool {
 func createTreeNodeFunc(isBranch bool) fyne.CanvasObject {
 	icon := widget.NewIcon(nil)
 	label := widget.NewLabel("Template")
+	detailLabel := widget.NewLabel("")
+	detailLabel.Importance = widget.LowImportance
 	downloadButton := widget.NewButtonWithIcon("", theme.DownloadIcon(), nil)
 	downloadButton.Hide()
-	return container.NewHBox(icon, label, downloadButton)
+	uploadButton := widget.NewButtonWithIcon("", theme.UploadIcon(), nil)
+	uploadButton.Hide()
+	return newTreeNodeRow(container.NewHBox(icon, label, detailLabel, downloadButton, uploadButton))
 }
 
 func updateTreeNodeFunc(id widget.TreeNodeID, isBranch bool, node fyne.CanvasObject) {
@@ -75,10 +84,14 @@ func updateTreeNodeFunc(id widget.TreeNodeID, isBranch bool, node fyne.CanvasObj
 	fsNode, ok := nodesMap[id]
 	treeDataMutex.RUnlock()
 
-	hbox := node.(*fyne.Container)
+	row := node.(*treeNodeRow)
+	row.nodeID = id
+	hbox := row.content
 	icon := hbox.Objects[0].(*widget.Icon)
 	label := hbox.Objects[1].(*widget.Label)
-	downloadButton := hbox.Objects[2].(*widget.Button)
+	detailLabel := hbox.Objects[2].(*widget.Label)
+	downloadButton := hbox.Objects[3].(*widget.Button)
+	uploadButton := hbox.Objects[4].(*widget.Button)
 
 	if ok {
 		displayName := fsNode.Name
@@ -90,7 +103,16 @@ func updateTreeNodeFunc(id widget.TreeNodeID, isBranch bool, node fyne.CanvasObj
 		} else if runtime.GOOS == "windows" && strings.HasSuffix(fsNode.Path, `:\`) {
 			displayName = fsNode.Path
 		}
+		if fsNode.IsSymlink && fsNode.SymlinkTarget != "" {
+			displayName += " -> " + fsNode.SymlinkTarget
+		}
+		if fsNode.IsHidden {
+			label.Importance = widget.LowImportance
+		} else {
+			label.Importance = widget.MediumImportance
+		}
 		label.SetText(displayName)
+		detailLabel.SetText(formatNodeDetails(fsNode))
 
 		if fsNode.Type == pb.FSNode_FOLDER {
 			icon.SetResource(theme.FolderIcon())
@@ -115,6 +137,29 @@ func updateTreeNodeFunc(id widget.TreeNodeID, isBranch bool, node fyne.CanvasObj
 					log.Printf("Error: Download button tapped for non-folder or unknown node: %s", buttonNodeID)
 				}
 			}
+			if fsNode.ReadOnly {
+				uploadButton.Hide()
+			} else {
+				uploadButton.Show()
+			}
+			uploadButton.OnTapped = func() {
+				log.Printf("'Upload here' button clicked for: %s", buttonNodeID)
+				treeDataMutex.RLock()
+				targetNode, nodeOk := nodesMap[buttonNodeID]
+				treeDataMutex.RUnlock()
+				if !nodeOk || targetNode.Type != pb.FSNode_FOLDER {
+					log.Printf("Error: Upload button tapped for non-folder or unknown node: %s", buttonNodeID)
+					return
+				}
+				if AppInstance == nil {
+					log.Println("ERROR: AppInstance is nil in upload button callback (updateTreeNodeFunc)!")
+					if mainWindow != nil {
+						dialog.ShowError(fmt.Errorf("Application instance not available for upload window."), mainWindow)
+					}
+					return
+				}
+				startUpload(AppInstance, targetNode.Path)
+			}
 		} else {
 			if strings.HasPrefix(displayName, "[Error:") || strings.HasPrefix(displayName, "[Server Error:") {
 				icon.SetResource(theme.ErrorIcon())
@@ -123,12 +168,17 @@ func updateTreeNodeFunc(id widget.TreeNodeID, isBranch bool, node fyne.CanvasObj
 			}
 			downloadButton.Hide()
 			downloadButton.OnTapped = nil
+			uploadButton.Hide()
+			uploadButton.OnTapped = nil
 		}
 	} else {
 		label.SetText("Error: Node data missing")
+		detailLabel.SetText("")
 		icon.SetResource(theme.ErrorIcon())
 		downloadButton.Hide()
 		downloadButton.OnTapped = nil
+		uploadButton.Hide()
+		uploadButton.OnTapped = nil
 		log.Printf("Error: Tree update - Node not found in nodesMap for ID: %s", id)
 	}
 }
@@ -157,10 +207,14 @@ func onTreeBranchOpened(id widget.TreeNodeID, fileTree *widget.Tree) {
 	} else {
 		log.Printf("Node '%s' children already loaded or not a loadable branch.", id)
 	}
+	if nodeExists && nodeInfo.Type == pb.FSNode_FOLDER {
+		startWatchingFolder(id)
+	}
 }
 
 func onTreeBranchClosed(id widget.TreeNodeID) {
 	log.Printf("Tree branch closed: %s", id)
+	stopWatchingFolder(id)
 }
 
 func onTreeNodeSelected(id string) {
@@ -170,23 +224,31 @@ func onTreeNodeSelected(id string) {
 
 	if ok {
 		log.Printf("Selected: %s (Path: %s, Type: %s)", id, node.Path, node.Type)
+		if node.Type == pb.FSNode_FOLDER {
+			setLastSelectedFolder(node.Path)
+		} else {
+			treeDataMutex.RLock()
+			parentPath := parentMap[id]
+			treeDataMutex.RUnlock()
+			setLastSelectedFolder(parentPath)
+		}
 		if node.Type == pb.FSNode_FILE {
 			displayName := node.Name
 			if displayName == "" {
 				displayName = filepath.Base(node.Path)
 			}
 			if !(strings.HasPrefix(displayName, "[Error:") || strings.HasPrefix(displayName, "[Server Error:")) {
-				log.Printf("File selected: %s. Initiating download.", node.Path)
+				log.Printf("File selected: %s. Showing preview.", node.Path)
 				if AppInstance == nil {
 					log.Println("ERROR: AppInstance is nil in onTreeNodeSelected!")
 					if mainWindow != nil {
-						dialog.ShowError(fmt.Errorf("Application instance not available for download."), mainWindow)
+						dialog.ShowError(fmt.Errorf("Application instance not available for preview."), mainWindow)
 					}
 					return
 				}
-				go startDownload(AppInstance, node.Path, false)
+				showFilePreview(node)
 			} else {
-				log.Printf("Error node selected: %s. No download action.", node.Path)
+				log.Printf("Error node selected: %s. No preview action.", node.Path)
 			}
 		}
 	} else {
@@ -212,60 +274,116 @@ func fetchChildren(parentPath string) {
 		return
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-	defer cancel()
+	treeDataMutex.RLock()
+	previousChildren := childrenMap[parentPath]
+	treeDataMutex.RUnlock()
 
-	req := &pb.FSRequest{Path: parentPath}
-	res, err := filesClient.GetFS(ctx, req)
+	var childPaths []string
+	cursor := ""
+	for page := 1; ; page++ {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		res, err := filesClient.GetFS(ctx, &pb.FSRequest{Path: parentPath, Cursor: cursor, PageSize: fsPageSize})
+		cancel()
 
-	treeDataMutex.Lock()
-	defer treeDataMutex.Unlock()
+		treeDataMutex.Lock()
+		if err != nil {
+			log.Printf("Error fetching children for '%s' (page %d): %v", parentPath, page, err)
+			s, _ := status.FromError(err)
+			childPaths = append(childPaths, addErrorNodeLocked(parentPath, fmt.Sprintf("[Error %s: %s]", s.Code(), s.Message())))
+		} else if res.ErrorMessage != "" {
+			log.Printf("Server reported error for path '%s': %s", parentPath, res.ErrorMessage)
+			childPaths = append(childPaths, addErrorNodeLocked(parentPath, fmt.Sprintf("[Server Error: %s]", res.ErrorMessage)))
+		} else {
+			log.Printf("Received %d children for path '%s' (page %d)", len(res.Nodes), parentPath, page)
+			for _, node := range res.Nodes {
+				if node == nil || node.Path == "" {
+					log.Printf("Warning: Received nil or empty node for parent '%s'. Skipping.", parentPath)
+					continue
+				}
+				if node.Name == "" {
+					node.Name = filepath.Base(node.Path)
+				}
+				nodesMap[node.Path] = node
+				parentMap[node.Path] = parentPath
+				childPaths = append(childPaths, node.Path)
+			}
+			cursor = res.NextCursor
+		}
+		// Publish every page as it arrives so large folders fill in progressively.
+		childrenMap[parentPath] = append([]string(nil), childPaths...)
+		done := err != nil || res.ErrorMessage != "" || cursor == ""
+		if done {
+			forgetRemovedNodesLocked(previousChildren, childPaths)
+			log.Printf("Updated childrenMap for '%s' with %d paths", parentPath, len(childPaths))
+		}
+		treeDataMutex.Unlock()
 
-	if err != nil {
-		log.Printf("Error fetching children for '%s': %v", parentPath, err)
-		s, _ := status.FromError(err)
-		errorMsg := fmt.Sprintf("[Error %s: %s]", s.Code(), s.Message())
-		errorNodePath := filepath.Join(parentPath, errorMsg)
-		if len(errorNodePath) > 250 {
-			errorNodePath = errorNodePath[:247] + "..."
+		select {
+		case refreshTreeChan <- parentPath:
+			log.Printf("Sent refresh signal for parent: '%s'", parentPath)
+		default:
+			log.Printf("Warning: Refresh channel full, could not signal for parent: '%s'", parentPath)
 		}
-		nodesMap[errorNodePath] = &pb.FSNode{Path: errorNodePath, Name: filepath.Base(errorNodePath), Type: pb.FSNode_FILE}
-		childrenMap[parentPath] = []string{errorNodePath}
-	} else if res.ErrorMessage != "" {
-		log.Printf("Server reported error for path '%s': %s", parentPath, res.ErrorMessage)
-		errorMsg := fmt.Sprintf("[Server Error: %s]", res.ErrorMessage)
-		errorNodePath := filepath.Join(parentPath, errorMsg)
-		if len(errorNodePath) > 250 {
-			errorNodePath = errorNodePath[:247] + "..."
+		if done {
+			return
 		}
-		nodesMap[errorNodePath] = &pb.FSNode{Path: errorNodePath, Name: filepath.Base(errorNodePath), Type: pb.FSNode_FILE}
-		childrenMap[parentPath] = []string{errorNodePath}
-	} else {
-		log.Printf("Received %d children for path '%s'", len(res.Nodes), parentPath)
-		childPaths := make([]string, 0, len(res.Nodes))
-		childrenMap[parentPath] = []string{}
-
-		for _, node := range res.Nodes {
-			if node == nil || node.Path == "" {
-				log.Printf("Warning: Received nil or empty node for parent '%s'. Skipping.", parentPath)
-				continue
-			}
-			if node.Name == "" {
-				node.Name = filepath.Base(node.Path)
-			}
-			nodesMap[node.Path] = node
-			childPaths = append(childPaths, node.Path)
+	}
+}
+
+// addErrorNodeLocked adds a placeholder node under parentPath that shows errorMsg in
+// the tree and returns its ID. treeDataMutex must be held for writing.
+func addErrorNodeLocked(parentPath, errorMsg string) string {
+	errorNodePath := filepath.Join(parentPath, errorMsg)
+	if len(errorNodePath) > 250 {
+		errorNodePath = errorNodePath[:247] + "..."
+	}
+	nodesMap[errorNodePath] = &pb.FSNode{Path: errorNodePath, Name: filepath.Base(errorNodePath), Type: pb.FSNode_FILE}
+	return errorNodePath
+}
+
+// forgetRemovedNodesLocked drops cached tree data for children that are no longer
+// listed (deleted, renamed or moved away), including everything below them.
+// treeDataMutex must be held for writing.
+func forgetRemovedNodesLocked(previous, current []string) {
+	stillPresent := make(map[string]bool, len(current))
+	for _, id := range current {
+		stillPresent[id] = true
+	}
+	for _, id := range previous {
+		if !stillPresent[id] {
+			forgetSubtreeLocked(id)
 		}
-		childrenMap[parentPath] = childPaths
-		log.Printf("Updated childrenMap for '%s' with %d paths", parentPath, len(childPaths))
 	}
+}
+
+func forgetSubtreeLocked(id string) {
+	for _, child := range childrenMap[id] {
+		forgetSubtreeLocked(child)
+	}
+	delete(childrenMap, id)
+	delete(nodesMap, id)
+	delete(parentMap, id)
+}
 
-	select {
-	case refreshTreeChan <- parentPath:
-		log.Printf("Sent refresh signal for parent: '%s'", parentPath)
-	default:
-		log.Printf("Warning: Refresh channel full, could not signal for parent: '%s'", parentPath)
+// formatNodeDetails summarises size, modification time, owner and permissions
+// for display next to a node's name.
+func formatNodeDetails(node *pb.FSNode) string {
+	var parts []string
+	if node.Type == pb.FSNode_FILE && node.ModifiedTime != 0 {
+		parts = append(parts, formatBytes(node.Size))
 	}
+	if node.ModifiedTime != 0 {
+		parts = append(parts, time.Unix(0, node.ModifiedTime).Format("2006-01-02 15:04"))
+	}
+	if node.Owner != "" {
+		parts = append(parts, node.Owner)
+	}
+	if node.Attributes != "" {
+		parts = append(parts, node.Attributes)
+	} else if node.Permissions != "" {
+		parts = append(parts, node.Permissions)
+	}
+	return strings.Join(parts, "  ")
 }
 
 func startDownload(theApp fyne.App, remotePath string, isFolder bool) {
@@ -295,11 +413,16 @@ func startDownload(theApp fyne.App, remotePath string, isFolder bool) {
 		return
 	}
 
-	localFileName := filepath.Base(remotePath)
 	if isFolder {
-		localFileName += ".zip"
+		promptArchiveOptions(filepath.Base(remotePath), func(opts *archiveOptions) {
+			showDownloadSaveDialog(theApp, remotePath, filepath.Base(remotePath)+archiveExtensions[opts.Format], true, opts)
+		})
+		return
 	}
+	showDownloadSaveDialog(theApp, remotePath, filepath.Base(remotePath), false, nil)
+}
 
+func showDownloadSaveDialog(theApp fyne.App, remotePath, localFileName string, isFolder bool, opts *archiveOptions) {
 	saveDialog := dialog.NewFileSave(func(writer fyne.URIWriteCloser, err error) {
 		if err != nil {
 			log.Printf("Error from file save dialog: %v", err)
@@ -312,16 +435,24 @@ func startDownload(theApp fyne.App, remotePath string, isFolder bool) {
 		}
 		localFilePath := writer.URI().Path()
 		log.Printf("User selected local path: '%s' for remote '%s'", localFilePath, remotePath)
-		go performDownload(theApp, remotePath, localFilePath, writer, isFolder)
+		go performDownload(theApp, remotePath, localFilePath, writer, isFolder, opts)
 	}, mainWindow)
 
 	saveDialog.SetFileName(localFileName)
 	saveDialog.Show()
 }
 
-func performDownload(theApp fyne.App, remotePath string, localFilePath string, writer fyne.URIWriteCloser, isFolder bool) {
+func performDownload(theApp fyne.App, remotePath string, localFilePath string, writer fyne.URIWriteCloser, isFolder bool, opts *archiveOptions) {
 	log.Printf("Performing download of '%s' (Folder: %v) to '%s'", remotePath, isFolder, localFilePath)
-	defer writer.Close()
+	if isFolder {
+		defer writer.Close()
+	} else {
+		// Single files are written through a ".part" file that is renamed over the
+		// chosen path once complete and verified, so the save dialog's handle is not needed.
+		if err := writer.Close(); err != nil {
+			log.Printf("Warning: closing save dialog writer for '%s': %v", localFilePath, err)
+		}
+	}
 
 	if theApp == nil {
 		log.Println("CRITICAL: Fyne app instance is nil in performDownload. Cannot create download window.")
@@ -363,20 +494,43 @@ func performDownload(theApp fyne.App, remotePath string, localFilePath string, w
 		dlWindow.Close()
 	}()
 
-	var streamClient interface {
-		Recv() (*pb.FileChunk, error)
+	if !isFolder {
+		result, err := performResumableDownload(ctx, remotePath, localFilePath, statusLabel, progressBar, progressBytesLabel)
+		dlWindow.Hide()
+		if err != nil {
+			if status.Code(err) == codes.Canceled || ctx.Err() == context.Canceled {
+				log.Printf("Download of '%s' was cancelled. Partial data kept for resuming.", remotePath)
+			} else {
+				log.Printf("Download of '%s' failed: %v", remotePath, err)
+				showDownloadError(fmt.Sprintf("Failed during download of %s", filepath.Base(localFilePath)), err, mainWindow)
+			}
+			return
+		}
+		log.Printf("Download of '%s' finished successfully. Total bytes: %d (resumed from %d)", remotePath, result.BytesReceived, result.ResumedFrom)
+		if mainWindow != nil {
+			details := fmt.Sprintf("Downloaded %s successfully to %s\n(%s received)",
+				filepath.Base(localFilePath), localFilePath, formatBytes(result.BytesReceived-result.ResumedFrom))
+			if result.ResumedFrom > 0 {
+				details += fmt.Sprintf("\nResumed from %s", formatBytes(result.ResumedFrom))
+			}
+			if result.DigestVerified {
+				details += "\nSHA-256 verified"
+			}
+			dialog.ShowInformation("Download Complete", details, mainWindow)
+		}
+		return
 	}
-	var streamErr error
 
-	if isFolder {
-		s, e := filesClient.DownloadFolderAsZip(ctx, &pb.FileRequest{Path: remotePath})
-		streamClient = s
-		streamErr = e
-	} else {
-		s, e := filesClient.DownloadFile(ctx, &pb.FileRequest{Path: remotePath})
-		streamClient = s
-		streamErr = e
+	if opts == nil {
+		opts = &archiveOptions{}
 	}
+	streamClient, streamErr := filesClient.DownloadFolderAsZip(ctx, &pb.FileRequest{
+		Path:             remotePath,
+		ArchiveFormat:    opts.Format,
+		CompressionLevel: opts.Level,
+		IncludePatterns:  opts.Include,
+		ExcludePatterns:  opts.Exclude,
+	})
 
 	if streamErr != nil {
 		log.Printf("Error initiating download stream for '%s': %v", remotePath, streamErr)
@@ -392,7 +546,7 @@ func performDownload(theApp fyne.App, remotePath string, localFilePath string, w
 	}
 
 	totalBytesReceived := int64(0)
-	var totalSize int64 = 0
+	var sourceTotalSize, sourceBytesDone int64
 	firstChunk := true
 
 	for {
@@ -437,15 +591,19 @@ func performDownload(theApp fyne.App, remotePath string, localFilePath string, w
 		}
 
 		if firstChunk {
-			if chunk.Metadata != nil && chunk.Metadata.TotalSize > 0 {
-				totalSize = chunk.Metadata.TotalSize
-				progressBar.Max = float64(totalSize)
-				statusLabel.SetText(fmt.Sprintf("Downloading %s...", filepath.Base(localFilePath)))
+			if chunk.Metadata != nil && chunk.Metadata.SourceTotalSize > 0 {
+				sourceTotalSize = chunk.Metadata.SourceTotalSize
+				progressBar.Max = float64(sourceTotalSize)
+				statusLabel.SetText(fmt.Sprintf("Downloading %s (%d files, %s before compression)...",
+					filepath.Base(localFilePath), chunk.Metadata.SourceFileCount, formatBytes(sourceTotalSize)))
 			} else {
 				statusLabel.SetText(fmt.Sprintf("Downloading %s (size unknown)...", filepath.Base(localFilePath)))
 			}
 			firstChunk = false
 		}
+		if chunk.Metadata != nil {
+			sourceBytesDone = chunk.Metadata.SourceBytesDone
+		}
 
 		n, writeErr := writer.Write(chunk.Content)
 		if writeErr != nil {
@@ -457,15 +615,189 @@ func performDownload(theApp fyne.App, remotePath string, localFilePath string, w
 		}
 		totalBytesReceived += int64(n)
 
-		if totalSize > 0 {
-			progressBar.SetValue(float64(totalBytesReceived))
-			progressBytesLabel.SetText(fmt.Sprintf("%s / %s", formatBytes(totalBytesReceived), formatBytes(totalSize)))
+		if sourceTotalSize > 0 {
+			progressBar.SetValue(float64(sourceBytesDone))
+			progressBytesLabel.SetText(fmt.Sprintf("%s / %s archived, %s received",
+				formatBytes(sourceBytesDone), formatBytes(sourceTotalSize), formatBytes(totalBytesReceived)))
 		} else {
 			progressBytesLabel.SetText(fmt.Sprintf("%s received", formatBytes(totalBytesReceived)))
 		}
 	}
 }
 
+func startUpload(theApp fyne.App, remoteDir string) {
+	log.Printf("Initiating upload into remote folder: '%s'", remoteDir)
+
+	if filesClient == nil {
+		log.Println("Error: filesClient is nil. Cannot start upload.")
+		if mainWindow != nil {
+			dialog.ShowError(fmt.Errorf("file transfer client not initialized"), mainWindow)
+		}
+		return
+	}
+	if mainWindow == nil {
+		log.Println("Error: mainWindow is nil in startUpload. Cannot show open dialog.")
+		return
+	}
+
+	openDialog := dialog.NewFileOpen(func(reader fyne.URIReadCloser, err error) {
+		if err != nil {
+			log.Printf("Error from file open dialog: %v", err)
+			dialog.ShowError(fmt.Errorf("error selecting file to upload: %v", err), mainWindow)
+			return
+		}
+		if reader == nil {
+			log.Println("File open dialog cancelled by user.")
+			return
+		}
+		remotePath := joinRemotePath(remoteDir, reader.URI().Name())
+		log.Printf("User selected local file '%s' for upload to '%s'", reader.URI().Path(), remotePath)
+		go performUpload(theApp, reader, remoteDir, remotePath)
+	}, mainWindow)
+	openDialog.Show()
+}
+
+func performUpload(theApp fyne.App, reader fyne.URIReadCloser, remoteDir string, remotePath string) {
+	localFilePath := reader.URI().Path()
+	log.Printf("Performing upload of '%s' to '%s'", localFilePath, remotePath)
+	defer reader.Close()
+
+	if theApp == nil {
+		log.Println("CRITICAL: Fyne app instance is nil in performUpload. Cannot create upload window.")
+		return
+	}
+
+	var totalSize int64
+	if info, err := os.Stat(localFilePath); err == nil {
+		totalSize = info.Size()
+	} else {
+		log.Printf("Warning: could not stat local file '%s': %v. Upload size unknown.", localFilePath, err)
+	}
+
+	ulWindow := theApp.NewWindow(fmt.Sprintf("Uploading %s", reader.URI().Name()))
+
+	statusLabel := widget.NewLabel(fmt.Sprintf("Uploading %s to %s...", reader.URI().Name(), remoteDir))
+	progressBar := widget.NewProgressBar()
+	progressBar.Min = 0
+	progressBar.Max = float64(totalSize)
+	progressBytesLabel := widget.NewLabel(fmt.Sprintf("0 B / %s", formatBytes(totalSize)))
+	progressBytesLabel.Alignment = fyne.TextAlignCenter
+
+	ctx, cancelFunc := context.WithCancel(context.Background())
+	defer cancelFunc()
+
+	cancelButton := widget.NewButtonWithIcon("Cancel", theme.CancelIcon(), func() {
+		log.Printf("Upload cancel button clicked for '%s'", remotePath)
+		cancelFunc()
+	})
+
+	ulWindow.SetContent(container.NewVBox(
+		statusLabel,
+		progressBar,
+		progressBytesLabel,
+		cancelButton,
+	))
+	ulWindow.Resize(fyne.NewSize(400, 150))
+	ulWindow.CenterOnScreen()
+	ulWindow.SetCloseIntercept(func() {
+		log.Printf("Upload window close intercepted for '%s'. Cancelling upload.", remotePath)
+		cancelFunc()
+	})
+	ulWindow.Show()
+	defer func() {
+		log.Printf("Closing upload window for %s", remotePath)
+		ulWindow.Close()
+	}()
+
+	stream, err := filesClient.UploadFile(ctx)
+	if err != nil {
+		log.Printf("Error initiating upload stream for '%s': %v", remotePath, err)
+		ulWindow.Hide()
+		showDownloadError("Failed to start upload", err, mainWindow)
+		return
+	}
+
+	buffer := make([]byte, 1024*64)
+	totalBytesSent := int64(0)
+	firstChunk := true
+
+	for {
+		n, readErr := reader.Read(buffer)
+		if n > 0 || (firstChunk && readErr == io.EOF) {
+			chunk := &pb.FileChunk{Content: buffer[:n]}
+			if firstChunk {
+				chunk.Metadata = &pb.FileChunkMetadata{TotalSize: totalSize, Path: remotePath}
+				firstChunk = false
+			}
+			if sendErr := stream.Send(chunk); sendErr != nil {
+				// The server reports the actual failure reason when the stream is closed.
+				_, closeErr := stream.CloseAndRecv()
+				if closeErr == nil {
+					closeErr = sendErr
+				}
+				log.Printf("Error sending upload chunk for '%s': %v", remotePath, closeErr)
+				ulWindow.Hide()
+				if status.Code(closeErr) != codes.Canceled && ctx.Err() == nil {
+					showDownloadError(fmt.Sprintf("Failed during upload of %s", reader.URI().Name()), closeErr, mainWindow)
+				}
+				return
+			}
+			totalBytesSent += int64(n)
+			if totalSize > 0 {
+				progressBar.SetValue(float64(totalBytesSent))
+				progressBytesLabel.SetText(fmt.Sprintf("%s / %s", formatBytes(totalBytesSent), formatBytes(totalSize)))
+			} else {
+				progressBytesLabel.SetText(fmt.Sprintf("%s sent", formatBytes(totalBytesSent)))
+			}
+		}
+		if readErr != nil {
+			if readErr == io.EOF {
+				break
+			}
+			log.Printf("Error reading local file '%s': %v", localFilePath, readErr)
+			cancelFunc()
+			ulWindow.Hide()
+			showDownloadError(fmt.Sprintf("Error reading %s", localFilePath), readErr, mainWindow)
+			return
+		}
+	}
+
+	res, err := stream.CloseAndRecv()
+	if err != nil {
+		log.Printf("Upload of '%s' failed: %v", remotePath, err)
+		ulWindow.Hide()
+		if status.Code(err) != codes.Canceled && ctx.Err() == nil {
+			showDownloadError(fmt.Sprintf("Failed to upload %s", reader.URI().Name()), err, mainWindow)
+		}
+		return
+	}
+
+	log.Printf("Upload of '%s' finished successfully. Server wrote %d bytes to '%s'", localFilePath, res.GetBytesWritten(), res.GetPath())
+	ulWindow.Hide()
+	if mainWindow != nil {
+		dialog.ShowInformation("Upload Complete",
+			fmt.Sprintf("Uploaded %s successfully to %s\n(%s sent)",
+				reader.URI().Name(),
+				res.GetPath(),
+				formatBytes(res.GetBytesWritten())),
+			mainWindow)
+	}
+	go fetchChildren(remoteDir)
+}
+
+// joinRemotePath joins a file name onto a directory path reported by the server,
+// keeping the server's path separator rather than the client's.
+func joinRemotePath(remoteDir, name string) string {
+	separator := "/"
+	if strings.Contains(remoteDir, `\`) {
+		separator = `\`
+	}
+	if strings.HasSuffix(remoteDir, separator) {
+		return remoteDir + name
+	}
+	return remoteDir + separator + name
+}
+
 func showDownloadError(title string, err error, parent fyne.Window) {
 	if parent == nil {
 		log.Printf("Error: parent window is nil in showDownloadError. Cannot show dialog. Title: %s, Error: %v", title, err)
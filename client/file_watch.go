@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	pb "control_grpc/gen/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// watchRefreshDelay coalesces bursts of change events (e.g. an extracting archive)
+// into a single listing per directory.
+const watchRefreshDelay = 300 * time.Millisecond
+
+var (
+	watchMutex     sync.Mutex
+	activeWatches  = make(map[string]*folderWatch)
+	pendingRefresh = make(map[string]*time.Timer)
+)
+
+type folderWatch struct {
+	cancel context.CancelFunc
+}
+
+// startWatchingFolder subscribes to changes in an expanded folder so its branch
+// refreshes when files are added, removed or modified on the server.
+func startWatchingFolder(path string) {
+	if path == "" || filesClient == nil {
+		return
+	}
+	watchMutex.Lock()
+	if _, ok := activeWatches[path]; ok {
+		watchMutex.Unlock()
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	w := &folderWatch{cancel: cancel}
+	activeWatches[path] = w
+	watchMutex.Unlock()
+
+	go runFolderWatch(ctx, path, w)
+}
+
+func stopWatchingFolder(path string) {
+	watchMutex.Lock()
+	defer watchMutex.Unlock()
+	if w, ok := activeWatches[path]; ok {
+		w.cancel()
+		delete(activeWatches, path)
+		log.Printf("Stopped watching '%s'", path)
+	}
+	if timer, ok := pendingRefresh[path]; ok {
+		timer.Stop()
+		delete(pendingRefresh, path)
+	}
+}
+
+func runFolderWatch(ctx context.Context, path string, w *folderWatch) {
+	defer func() {
+		w.cancel()
+		watchMutex.Lock()
+		// The branch may have been closed and reopened meanwhile; leave a newer watch alone.
+		if activeWatches[path] == w {
+			delete(activeWatches, path)
+		}
+		watchMutex.Unlock()
+	}()
+
+	stream, err := filesClient.WatchPath(ctx, &pb.WatchRequest{Path: path})
+	if err != nil {
+		log.Printf("Error starting watch for '%s': %v", path, err)
+		return
+	}
+	log.Printf("Watching '%s' for changes", path)
+	for {
+		event, err := stream.Recv()
+		if err != nil {
+			if status.Code(err) != codes.Canceled {
+				log.Printf("Watch for '%s' ended: %v", path, err)
+			}
+			return
+		}
+		if event.GetOverflow() {
+			log.Printf("Watch for '%s' overflowed; reloading folder.", path)
+			scheduleFolderRefresh(path)
+			continue
+		}
+		if event.GetPath() == path && (event.GetOp() == pb.WatchEvent_REMOVE || event.GetOp() == pb.WatchEvent_RENAME) {
+			// The watched folder itself is gone; its parent's watch picks up the change.
+			log.Printf("Watched folder '%s' was removed or renamed.", path)
+			return
+		}
+		if event.GetOp() == pb.WatchEvent_CHMOD && event.GetNode() != nil {
+			updateWatchedNode(event.GetNode())
+			continue
+		}
+		scheduleFolderRefresh(event.GetDirectory())
+	}
+}
+
+// updateWatchedNode refreshes a node's metadata in place when only its attributes changed.
+func updateWatchedNode(node *pb.FSNode) {
+	treeDataMutex.Lock()
+	existing, ok := nodesMap[node.Path]
+	if ok {
+		if node.Name == "" {
+			node.Name = existing.Name
+		}
+		nodesMap[node.Path] = node
+	}
+	parentPath := parentMap[node.Path]
+	treeDataMutex.Unlock()
+	if !ok {
+		return
+	}
+	select {
+	case refreshTreeChan <- parentPath:
+	default:
+	}
+}
+
+// scheduleFolderRefresh reloads a folder's children after watchRefreshDelay, unless
+// another refresh for it is already pending. Folders that were never expanded are skipped.
+func scheduleFolderRefresh(dir string) {
+	treeDataMutex.RLock()
+	_, loaded := childrenMap[dir]
+	treeDataMutex.RUnlock()
+	if !loaded {
+		return
+	}
+
+	watchMutex.Lock()
+	defer watchMutex.Unlock()
+	if _, pending := pendingRefresh[dir]; pending {
+		return
+	}
+	pendingRefresh[dir] = time.AfterFunc(watchRefreshDelay, func() {
+		watchMutex.Lock()
+		delete(pendingRefresh, dir)
+		watchMutex.Unlock()
+		fetchChildren(dir)
+	})
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	pb "control_grpc/gen/proto"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/widget"
+)
+
+// archiveOptions are the FileRequest settings used for folder downloads.
+type archiveOptions struct {
+	Format  pb.ArchiveFormat
+	Level   int32
+	Include []string
+	Exclude []string
+}
+
+var archiveExtensions = map[pb.ArchiveFormat]string{
+	pb.ArchiveFormat_ARCHIVE_ZIP:     ".zip",
+	pb.ArchiveFormat_ARCHIVE_TAR_GZ:  ".tar.gz",
+	pb.ArchiveFormat_ARCHIVE_TAR_ZST: ".tar.zst",
+}
+
+var archiveFormatLabels = []struct {
+	label  string
+	format pb.ArchiveFormat
+}{
+	{"ZIP (.zip)", pb.ArchiveFormat_ARCHIVE_ZIP},
+	{"Gzipped tar (.tar.gz)", pb.ArchiveFormat_ARCHIVE_TAR_GZ},
+	{"Zstandard tar (.tar.zst)", pb.ArchiveFormat_ARCHIVE_TAR_ZST},
+}
+
+// lastArchiveOptions remembers the previous choice so repeated downloads keep it.
+var lastArchiveOptions = archiveOptions{Exclude: []string{".git", "node_modules"}}
+
+// promptArchiveOptions asks for the archive format, compression level and filters
+// before a folder download and calls onConfirm with the result.
+func promptArchiveOptions(folderName string, onConfirm func(*archiveOptions)) {
+	formatNames := make([]string, len(archiveFormatLabels))
+	selectedFormat := archiveFormatLabels[0].label
+	for i, f := range archiveFormatLabels {
+		formatNames[i] = f.label
+		if f.format == lastArchiveOptions.Format {
+			selectedFormat = f.label
+		}
+	}
+	formatSelect := widget.NewSelect(formatNames, nil)
+	formatSelect.SetSelected(selectedFormat)
+
+	levelNames := []string{"Default", "1 (fastest)", "2", "3", "4", "5", "6", "7", "8", "9 (smallest)"}
+	levelSelect := widget.NewSelect(levelNames, nil)
+	levelSelect.SetSelected(levelNames[lastArchiveOptions.Level])
+
+	includeEntry := widget.NewEntry()
+	includeEntry.SetPlaceHolder("All files, or e.g. *.log, *.txt")
+	includeEntry.SetText(strings.Join(lastArchiveOptions.Include, ", "))
+	excludeEntry := widget.NewEntry()
+	excludeEntry.SetPlaceHolder("e.g. node_modules, .git, *.tmp")
+	excludeEntry.SetText(strings.Join(lastArchiveOptions.Exclude, ", "))
+
+	dialog.ShowForm(fmt.Sprintf("Download %s", folderName), "Continue", "Cancel", []*widget.FormItem{
+		widget.NewFormItem("Format", formatSelect),
+		widget.NewFormItem("Compression", levelSelect),
+		widget.NewFormItem("Include", includeEntry),
+		widget.NewFormItem("Exclude", excludeEntry),
+	}, func(confirmed bool) {
+		if !confirmed {
+			return
+		}
+		opts := &archiveOptions{
+			Level:   int32(levelSelect.SelectedIndex()),
+			Include: splitPatternList(includeEntry.Text),
+			Exclude: splitPatternList(excludeEntry.Text),
+		}
+		if opts.Level < 0 {
+			opts.Level = 0
+		}
+		for _, f := range archiveFormatLabels {
+			if f.label == formatSelect.Selected {
+				opts.Format = f.format
+			}
+		}
+		lastArchiveOptions = *opts
+		onConfirm(opts)
+	}, mainWindow)
+}
+
+// splitPatternList splits a comma separated list of glob patterns.
+func splitPatternList(text string) []string {
+	var patterns []string
+	for _, p := range strings.Split(text, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			patterns = append(patterns, p)
+		}
+	}
+	return patterns
+}
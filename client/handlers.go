@@ -11,7 +11,6 @@ import (
 
 	pb "control_grpc/gen/proto"
 	"fyne.io/fyne/v2"
-	"fyne.io/fyne/v2/container"
 	"fyne.io/fyne/v2/dialog"
 	"fyne.io/fyne/v2/driver/desktop"
 	"fyne.io/fyne/v2/widget"
@@ -47,6 +46,12 @@ func newMouseOverlay(inputChan chan<- *pb.FeedRequest, win fyne.Window) *mouseOv
 	}
 	mo.ExtendBaseWidget(mo)
 
+	go func() {
+		for range hostCursorChanged {
+			mo.Refresh()
+		}
+	}()
+
 	go func() {
 		defer func() {
 
@@ -63,7 +68,7 @@ func newMouseOverlay(inputChan chan<- *pb.FeedRequest, win fyne.Window) *mouseOv
 }
 
 func (mo *mouseOverlay) CreateRenderer() fyne.WidgetRenderer {
-	return widget.NewSimpleRenderer(container.NewWithoutLayout())
+	return newOverlayRenderer(mo)
 }
 
 func (mo *mouseOverlay) Focusable() bool {
@@ -210,16 +215,23 @@ func (mo *mouseOverlay) Tapped(_ *fyne.PointEvent) {
 
 }
 
+// scaleCoordinates maps a position on the overlay to a pixel of the host's video. The
+// video is letterboxed to keep its aspect ratio, so positions in the bars are clamped
+// to its edges.
 func (mo *mouseOverlay) scaleCoordinates(pos fyne.Position) (float32, float32) {
 	sz := mo.Size()
 	if sz.Width == 0 || sz.Height == 0 {
 		return 0, 0
 	}
-	targetWidth := float32(1920.0)
-	targetHeight := float32(1080.0)
-	scaleX := targetWidth / sz.Width
-	scaleY := targetHeight / sz.Height
-	return pos.X * scaleX, pos.Y * scaleY
+	frameW, frameH := videoFrameSize()
+	targetWidth := float32(frameW)
+	targetHeight := float32(frameH)
+	scale := min(sz.Width/targetWidth, sz.Height/targetHeight)
+	offsetX := (sz.Width - targetWidth*scale) / 2
+	offsetY := (sz.Height - targetHeight*scale) / 2
+	x := min(max((pos.X-offsetX)/scale, 0), targetWidth-1)
+	y := min(max((pos.Y-offsetY)/scale, 0), targetHeight-1)
+	return x, y
 }
 
 func (mo *mouseOverlay) sendMouseEvent(eventType, btn string, pos fyne.Position) {
@@ -228,14 +240,15 @@ func (mo *mouseOverlay) sendMouseEvent(eventType, btn string, pos fyne.Position)
 		return
 	}
 	sx, sy := mo.scaleCoordinates(pos)
+	frameW, frameH := videoFrameSize()
 	req := &pb.FeedRequest{
 		Message:        "mouse_event",
 		MouseX:         int32(sx),
 		MouseY:         int32(sy),
 		MouseBtn:       btn,
 		MouseEventType: eventType,
-		ClientWidth:    1920,
-		ClientHeight:   1080,
+		ClientWidth:    frameW,
+		ClientHeight:   frameH,
 		Timestamp:      time.Now().UnixNano(),
 	}
 
@@ -289,13 +302,14 @@ func (mo *mouseOverlay) sendBatchedMovesLocked() {
 	movesToSend := make([]*pb.MouseMovePoint, len(mo.batchedMoves))
 	copy(movesToSend, mo.batchedMoves)
 
+	frameW, frameH := videoFrameSize()
 	req := &pb.FeedRequest{
 		Message:           "mouse_event",
 		MouseEventType:    "batched_mouse_moves",
 		BatchedMouseMoves: movesToSend,
 		Timestamp:         time.Now().UnixNano(),
-		ClientWidth:       1920,
-		ClientHeight:      1080,
+		ClientWidth:       frameW,
+		ClientHeight:      frameH,
 	}
 
 	log.Printf("Sending batched mouse moves: %d points", len(req.BatchedMouseMoves))
@@ -367,13 +381,14 @@ func (mo *mouseOverlay) sendScrollEvent(scrollX, scrollY float32) {
 		log.Printf("Scroll event (dX: %.2f, dY: %.2f) dropped due to host permissions.", scrollX, scrollY)
 		return
 	}
+	frameW, frameH := videoFrameSize()
 	req := &pb.FeedRequest{
 		Message:        "mouse_event",
 		MouseEventType: "scroll",
 		ScrollX:        scrollX,
 		ScrollY:        scrollY,
-		ClientWidth:    1920,
-		ClientHeight:   1080,
+		ClientWidth:    frameW,
+		ClientHeight:   frameH,
 		Timestamp:      time.Now().UnixNano(),
 	}
 
@@ -391,12 +406,17 @@ func (mo *mouseOverlay) Scrolled(ev *fyne.ScrollEvent) {
 	mo.sendScrollEvent(ev.Scrolled.DX, ev.Scrolled.DY)
 }
 
-func forwardVideoFeed(stream pb.RemoteControlService_GetFeedClient, ffmpegInput io.Writer) {
+// forwardVideoFeed feeds the host's stream to a decoder, starting a new one whenever the
+// codec or size of the host's frames changes.
+func forwardVideoFeed(stream pb.RemoteControlService_GetFeedClient, client pb.RemoteControlServiceClient) {
+	var decoder videoDecoder
+	var decoderInfo string // the codec, format and size decoder was started for
+	gate := keyframeGate{client: client}
 	defer func() {
 		log.Println("ForwardVideoFeed: Goroutine stopped.")
-		if closer, ok := ffmpegInput.(io.Closer); ok {
-			log.Println("ForwardVideoFeed: Closing ffmpegInput pipe writer.")
-			closer.Close()
+		if decoder != nil {
+			log.Println("ForwardVideoFeed: Closing the decoder.")
+			decoder.Close()
 		}
 	}()
 	log.Println("ForwardVideoFeed: Goroutine started.")
@@ -433,14 +453,43 @@ func forwardVideoFeed(stream pb.RemoteControlService_GetFeedClient, ffmpegInput
 			return // Stop processing video
 		}
 
-		videoChunk := frame.GetData()
-		if videoChunk == nil || len(videoChunk) == 0 {
+		showSelectedDisplay(frame.GetDisplay())
+		showVideoSettings(frame.GetVideoSettings())
+		if cursor := frame.GetCursor(); cursor != nil {
+			updateHostCursor(cursor)
+		}
+
+		if len(frame.GetData()) == 0 && len(frame.GetTiles()) == 0 {
+			continue
+		}
+
+		width, height := int(frame.GetWidth()), int(frame.GetHeight())
+		if width <= 0 || height <= 0 {
+			width, height = videoWidth, videoHeight
+		}
+		// Older hosts send MPEG-TS chunks, which carry no picture boundaries.
+		codec, format := decoderInput(frame)
+		accessUnits := format != "mpegts"
+		info := fmt.Sprintf("%s in %s at %dx%d", codec, format, width, height)
+		if decoder == nil || info != decoderInfo {
+			if decoder != nil {
+				log.Printf("ForwardVideoFeed: Host stream changed from %s to %s, restarting the decoder.", decoderInfo, info)
+				decoder.Close()
+			}
+			decoder, decoderInfo = newVideoDecoder(codec, format, width, height), info
+			showFrameSize(frame)
+			gate.reset()
+			if accessUnits && !frame.GetKeyframe() {
+				gate.request(frame.GetFeedId(), "decoder restarted")
+			}
+		}
+		if accessUnits && !gate.admit(frame) {
+			framesDropped.Add(1)
 			continue
 		}
 
-		_, writeErr := ffmpegInput.Write(videoChunk)
-		if writeErr != nil {
-			log.Printf("ForwardVideoFeed: Error writing video chunk to FFmpeg input pipe: %v", writeErr)
+		if err := decoder.Decode(frame); err != nil {
+			log.Printf("ForwardVideoFeed: Error decoding the host's video: %v", err)
 			return
 		}
 	}
@@ -16,7 +16,6 @@ import (
 	"os"
 	"regexp"
 	"strings"
-	"sync"
 	"time"
 
 	"fyne.io/fyne/v2"
@@ -33,8 +32,6 @@ import (
 	"google.golang.org/grpc/status"
 
 	pb "control_grpc/gen/proto"
-
-	"github.com/matwachich/fynex-widgets"
 )
 
 //go:embed client.crt
@@ -52,6 +49,9 @@ var (
 	canAccessFileSystem bool = true
 	canAccessTerminal   bool = true
 	permissionsFetched  bool = false
+	// terminalShells are the shells the host lets the client start, offered when opening
+	// a new terminal.
+	terminalShells []*pb.TerminalShell
 
 	inputEvents         = make(chan *pb.FeedRequest, 120)
 	pingLabel           *widget.Label
@@ -59,14 +59,6 @@ var (
 	remoteControlClient pb.RemoteControlServiceClient
 	terminalClient      pb.TerminalServiceClient
 
-	terminalWindow        fyne.Window
-	currentTerminalStream pb.TerminalService_CommandStreamClient
-	terminalStreamCancel  context.CancelFunc
-	terminalOutputDisplay *wx.EntryEx
-	terminalInput         *widget.Entry
-	terminalScroll        *container.Scroll
-	terminalMutex         sync.Mutex
-
 	serverAddrActual      *string
 	connectionType        *string
 	sessionToken          *string
@@ -135,6 +127,19 @@ func main() {
 	connectionType = clientFlags.String("connectionType", "direct", "Connection type: 'direct' or 'relay'")
 	sessionToken = clientFlags.String("sessionToken", "", "Session token for relay connection")
 	allowLocalInsecureOpt = clientFlags.Bool("allowLocalInsecure", false, "Allow insecure TLS for local IP addresses (dev only)")
+	execMode := clientFlags.Bool("exec", false, "Run the command given after the flags on the host without opening a window, print its output and exit with its exit code, e.g. -exec -- ls -la")
+	var execOpts execOptions
+	clientFlags.StringVar(&execOpts.dir, "execDir", "", "Working directory on the host for -exec")
+	clientFlags.Var(&execOpts.env, "execEnv", "KEY=VALUE added to the environment for -exec. May be repeated.")
+	clientFlags.DurationVar(&execOpts.timeout, "execTimeout", 0, "Kill the -exec command after this long. 0 for no limit.")
+	clientFlags.BoolVar(&execOpts.stdin, "execStdin", false, "Send this process's standard input to the -exec command")
+	videoSize := clientFlags.String("videoSize", "native", "Largest video size to ask the host for, as WIDTHxHEIGHT, or native for the display's own size")
+	videoFps := clientFlags.Int("videoFps", 30, "Highest video frame rate to ask the host for")
+	videoBitrate := clientFlags.Int("videoBitrate", 3000, "Highest video bit rate to ask the host for, in kbit/s")
+	adaptiveVideo := clientFlags.Bool("adaptiveVideo", true, "Let the host lower the video quality when the connection cannot keep up")
+	remoteCursor := clientFlags.Bool("remoteCursor", true, "Have the host send its cursor apart from the video and draw it here, so it stays sharp and responsive at low bit rates")
+	tileFormat := clientFlags.String("tileFormat", "", "Get the screen as changed tiles in jpeg or png instead of video, which needs no ffmpeg on either side. Empty for video.")
+	videoCodecs := clientFlags.String("videoCodecs", "", "Comma separated codecs to offer the host: h264, hevc, vp9, av1. Empty offers those the local ffmpeg can decode.")
 
 	err := clientFlags.Parse(os.Args[1:])
 	if err != nil {
@@ -144,115 +149,37 @@ func main() {
 
 	log.SetFlags(log.LstdFlags | log.Lshortfile)
 
+	if *execMode {
+		os.Exit(runExecCommand(allowLocalInsecure, clientFlags.Args(), execOpts))
+	}
+
+	requestedWidth, requestedHeight, err := parseVideoSize(*videoSize)
+	if err != nil {
+		log.Fatalf("FATAL: %v", err)
+	}
+	if requestedWidth > 0 {
+		videoWidth, videoHeight = requestedWidth, requestedHeight
+	}
+	requestedVideo = &pb.VideoSettings{
+		Width:       int32(requestedWidth),
+		Height:      int32(requestedHeight),
+		Framerate:   int32(*videoFps),
+		BitrateKbps: int32(*videoBitrate),
+		Adaptive:    *adaptiveVideo,
+	}
+
 	currentFyneApp := app.NewWithID("com.example.controlgrpcclient.v5")
 	mainAppWindow := currentFyneApp.NewWindow("Control GRPC client")
 
 	normalSize := fyne.NewSize(1280, 720)
 	fullSize := fyne.NewSize(1920, 1080)
-	imageCanvas := canvas.NewImageFromImage(image.NewRGBA(image.Rect(0, 0, 1920, 1080)))
+	imageCanvas := canvas.NewImageFromImage(image.NewRGBA(image.Rect(0, 0, videoWidth, videoHeight)))
 	imageCanvas.SetMinSize(normalSize)
-	imageCanvas.FillMode = canvas.ImageFillStretch
-
-	var conn *grpc.ClientConn
-	var dialErr error
-
-	log.Printf("INFO: Client attempting to connect. Type: '%s', Address: '%s', AllowLocalInsecure: %t", *connectionType, *serverAddrActual, allowLocalInsecure)
-
-	if *connectionType == "direct" {
-		log.Println("INFO: Attempting secure direct connection...")
-		log.Println("INFO: Attempting secure direct connection (with blocking dial)...")
-		tlsCreds, err := loadTLSCredentialsFromEmbed(*serverAddrActual, false)
-		if err != nil {
-			log.Fatalf("FATAL: Cannot load initial TLS credentials: %v", err)
-		}
-		opts := []grpc.DialOption{
-			grpc.WithTransportCredentials(tlsCreds),
-			grpc.WithBlock(),
-		}
-		dialCtx, dialCancel := context.WithTimeout(context.Background(), 5*time.Second)
-		conn, dialErr = grpc.DialContext(dialCtx, *serverAddrActual, opts...)
-		dialCancel()
-
-		if dialErr != nil {
-			log.Printf("WARN: Initial secure connection attempt to %s failed: %v", *serverAddrActual, dialErr)
-
-			ipRegex := regexp.MustCompile(`^(\d{1,3}\.\d{1,3}\.\d{1,3}\.\d{1,3}|\[[a-fA-F0-9:]+\])(:\d+)?$`)
-			localhostRegex := regexp.MustCompile(`^(?i)(localhost|127\.0\.0\.1|::1)(:\d+)?$`)
-			isPotentiallyLocal := ipRegex.MatchString(*serverAddrActual) || localhostRegex.MatchString(*serverAddrActual)
-
-			isTLSHandshakeOrConnectivityError := false
-			if s, ok := status.FromError(dialErr); ok {
-				switch s.Code() {
-				case codes.Unavailable, codes.DeadlineExceeded:
-					isTLSHandshakeOrConnectivityError = true
-					log.Printf("DEBUG: gRPC status error indicative of TLS/connectivity issue: %s", s.Code())
-				}
-			}
-			if !isTLSHandshakeOrConnectivityError {
-				var x509UnknownAuthErr x509.UnknownAuthorityError
-				var x509CertInvalidErr x509.CertificateInvalidError
-				var netOpErr *net.OpError
-
-				if errors.As(dialErr, &x509UnknownAuthErr) || errors.As(dialErr, &x509CertInvalidErr) || errors.Is(dialErr, credentials.ErrConnDispatched) || errors.As(dialErr, &netOpErr) {
-					isTLSHandshakeOrConnectivityError = true
-					log.Printf("DEBUG: Underlying error indicative of TLS/connectivity issue: %T, %v", dialErr, dialErr)
-				}
-			}
-			if !isTLSHandshakeOrConnectivityError && errors.Is(dialErr, context.DeadlineExceeded) {
-				isTLSHandshakeOrConnectivityError = true
-				log.Printf("DEBUG: Dial error is context.DeadlineExceeded, considering it a connectivity issue for retry.")
-			}
-
-			if allowLocalInsecure && isPotentiallyLocal && isTLSHandshakeOrConnectivityError {
-				log.Printf("INFO: Conditions met for insecure retry to %s (local-like address, error: %v, flag enabled).", *serverAddrActual, dialErr)
-				log.Printf("WARN: Retrying connection to %s with InsecureSkipVerify enabled.", *serverAddrActual)
-
-				tlsCredsRetry, errRetry := loadTLSCredentialsFromEmbed(*serverAddrActual, true)
-				if errRetry != nil {
-					log.Fatalf("FATAL: Cannot load TLS credentials for insecure retry: %v", errRetry)
-				}
-
-				var retryOpts []grpc.DialOption
-				if tlsCredsRetry == nil {
-					log.Println("ERROR: loadTLSCredentialsFromEmbed returned nil for retry credentials. Attempting with fully insecure.")
-					retryOpts = []grpc.DialOption{grpc.WithTransportCredentials(insecure.NewCredentials()), grpc.WithBlock()}
-				} else {
-					retryOpts = []grpc.DialOption{grpc.WithTransportCredentials(tlsCredsRetry), grpc.WithBlock()}
-				}
+	// Letterbox the host's video rather than stretch it, mouseOverlay maps positions
+	// the same way.
+	imageCanvas.FillMode = canvas.ImageFillContain
 
-				dialCtxRetry, dialCancelRetry := context.WithTimeout(context.Background(), 15*time.Second)
-				conn, dialErr = grpc.DialContext(dialCtxRetry, *serverAddrActual, retryOpts...)
-				dialCancelRetry()
-
-				if dialErr != nil {
-					log.Printf("ERROR: Insecure retry connection attempt to %s also failed: %v", *serverAddrActual, dialErr)
-				} else {
-					log.Printf("INFO: Insecure retry connection to %s succeeded.", *serverAddrActual)
-				}
-			} else {
-				log.Printf("INFO: Conditions for insecure retry not met (allowLocalInsecure: %t, isPotentiallyLocal: %t, isTLSHandshakeOrConnectivityError: %t). Original error: %v",
-					allowLocalInsecure, isPotentiallyLocal, isTLSHandshakeOrConnectivityError, dialErr)
-			}
-		}
-	} else if *connectionType == "relay" {
-		if *sessionToken == "" {
-			log.Fatalf("FATAL: Relay connection type specified but no session token provided.")
-		}
-		log.Printf("INFO: Using custom dialer for relay connection to %s with session token %s", *serverAddrActual, *sessionToken)
-		tlsCreds, err := loadTLSCredentialsFromEmbed(*serverAddrActual, allowLocalInsecure)
-		if err != nil {
-			log.Fatalf("FATAL: Cannot load TLS credentials for relay: %v", err)
-		}
-		opts := []grpc.DialOption{
-			grpc.WithTransportCredentials(tlsCreds),
-			grpc.WithContextDialer(customRelayDialer),
-		}
-		dialCtx, dialCancel := context.WithTimeout(context.Background(), 20*time.Second)
-		conn, dialErr = grpc.DialContext(dialCtx, *serverAddrActual, opts...)
-		dialCancel()
-	} else {
-		dialErr = fmt.Errorf("unknown connection type: '%s'", *connectionType)
-	}
+	conn, dialErr := dialServer(allowLocalInsecure)
 
 	if dialErr != nil {
 		log.Printf("ERROR: Final connection attempt failed for '%s' (type: %s): %v", *serverAddrActual, *connectionType, dialErr)
@@ -299,6 +226,7 @@ func main() {
 		canControlKeyboard = sessionInfo.Permissions.AllowKeyboardControl
 		canAccessFileSystem = sessionInfo.Permissions.AllowFileSystemAccess
 		canAccessTerminal = sessionInfo.Permissions.AllowTerminalAccess
+		terminalShells = sessionInfo.GetTerminalShells()
 		permissionsFetched = true
 		log.Printf("INFO: Session permissions received: Mouse:%t, Keyboard:%t, FS:%t, Terminal:%t", canControlMouse, canControlKeyboard, canAccessFileSystem, canAccessTerminal)
 	} else {
@@ -320,7 +248,13 @@ func main() {
 	}
 
 	initRequest := &pb.FeedRequest{
-		Message: "init", MouseX: 0, MouseY: 0, ClientWidth: 1920, ClientHeight: 1080, Timestamp: time.Now().UnixNano(),
+		Message: "init", MouseX: 0, MouseY: 0, ClientWidth: 1920, ClientHeight: 1080, DisplayIndex: 0, Timestamp: time.Now().UnixNano(),
+		VideoSettings: requestedVideo,
+		TileFormat:    *tileFormat,
+		RemoteCursor:  *remoteCursor,
+	}
+	if *tileFormat == "" {
+		initRequest.Codecs = decodableCodecs(*videoCodecs)
 	}
 	if err := stream.Send(initRequest); err != nil {
 		log.Printf("ERROR: Error sending initialization message: %v", err)
@@ -393,8 +327,8 @@ func main() {
 			return
 		}
 		filesWindow := AppInstance.NewWindow("File Browser")
-		filesWindow.SetContent(treeContainer)
-		filesWindow.Resize(fyne.NewSize(500, 600))
+		filesWindow.SetContent(newFileBrowserContent(treeContainer, filesWindow))
+		filesWindow.Resize(fyne.NewSize(1000, 600))
 		filesWindow.Show()
 		if filesClient != nil {
 			go fetchChildren("")
@@ -423,14 +357,19 @@ func main() {
 
 	pingLabel = widget.NewLabel("RTT: --- ms")
 	fpsLabel = widget.NewLabel("FPS: ---")
-	topBar := container.NewHBox(widgetLabel, toggleButton, getFSButton, terminalButton, widget.NewSeparator(), pingLabel, widget.NewSeparator(), fpsLabel)
+	videoLabel = widget.NewLabel("Video: ---")
+	topBar := container.NewHBox(widgetLabel, toggleButton, getFSButton, terminalButton, widget.NewSeparator(), pingLabel, widget.NewSeparator(), fpsLabel,
+		widget.NewSeparator(), widget.NewLabel("Quality:"), newVideoQualitySelect(inputEvents), videoLabel)
+	if picker := newDisplaySelect(fetchDisplays(remoteControlClient), inputEvents); picker != nil {
+		topBar.Add(widget.NewSeparator())
+		topBar.Add(widget.NewLabel("Display:"))
+		topBar.Add(picker)
+	}
 	content := container.NewBorder(topBar, nil, nil, nil, videoContainer)
 	mainAppWindow.SetContent(content)
 
 	go startPinger(streamCtx, remoteControlClient)
-
-	grpcToFFmpegReader, grpcToFFmpegWriter := io.Pipe()
-	ffmpegToBufferReader, ffmpegToBufferWriter := io.Pipe()
+	go reportVideoStats(streamCtx, inputEvents)
 
 	go func() {
 		for parentIdToRefresh := range refreshTreeChan {
@@ -442,11 +381,8 @@ func main() {
 		log.Println("Tree refresh goroutine stopped.")
 	}()
 
-	go runFFmpegProcess(grpcToFFmpegReader, ffmpegToBufferWriter)
-	go readFFmpegOutputToBuffer(ffmpegToBufferReader, rawFrameBuffer)
-	go processRawFramesToImage(rawFrameBuffer, frameImageData)
-	go drawFrames(imageCanvas, frameImageData, fpsLabel)
-	go forwardVideoFeed(stream, grpcToFFmpegWriter)
+	go drawFrames(imageCanvas, decodedFrames, fpsLabel)
+	go forwardVideoFeed(stream, remoteControlClient)
 
 	if overlay != nil {
 		mainAppWindow.Canvas().Focus(overlay)
@@ -458,16 +394,117 @@ func main() {
 	close(inputEvents)
 	close(refreshTreeChan)
 
-	terminalMutex.Lock()
-	if terminalStreamCancel != nil {
-		log.Println("DEBUG: main exit - Calling terminalStreamCancel")
-		terminalStreamCancel()
-		terminalStreamCancel = nil
-	}
-	terminalMutex.Unlock()
+	detachTerminalSessions()
 	log.Println("Client shutdown complete.")
 }
 
+// dialServer connects to the host directly or through the relay, as chosen by the
+// command line flags. A direct connection to a local address is retried without
+// certificate verification when allowLocalInsecure is set.
+func dialServer(allowLocalInsecure bool) (*grpc.ClientConn, error) {
+	var conn *grpc.ClientConn
+	var dialErr error
+
+	log.Printf("INFO: Client attempting to connect. Type: '%s', Address: '%s', AllowLocalInsecure: %t", *connectionType, *serverAddrActual, allowLocalInsecure)
+
+	if *connectionType == "direct" {
+		log.Println("INFO: Attempting secure direct connection...")
+		log.Println("INFO: Attempting secure direct connection (with blocking dial)...")
+		tlsCreds, err := loadTLSCredentialsFromEmbed(*serverAddrActual, false)
+		if err != nil {
+			return nil, fmt.Errorf("cannot load initial TLS credentials: %w", err)
+		}
+		opts := []grpc.DialOption{
+			grpc.WithTransportCredentials(tlsCreds),
+			grpc.WithBlock(),
+		}
+		dialCtx, dialCancel := context.WithTimeout(context.Background(), 5*time.Second)
+		conn, dialErr = grpc.DialContext(dialCtx, *serverAddrActual, opts...)
+		dialCancel()
+
+		if dialErr != nil {
+			log.Printf("WARN: Initial secure connection attempt to %s failed: %v", *serverAddrActual, dialErr)
+
+			ipRegex := regexp.MustCompile(`^(\d{1,3}\.\d{1,3}\.\d{1,3}\.\d{1,3}|\[[a-fA-F0-9:]+\])(:\d+)?$`)
+			localhostRegex := regexp.MustCompile(`^(?i)(localhost|127\.0\.0\.1|::1)(:\d+)?$`)
+			isPotentiallyLocal := ipRegex.MatchString(*serverAddrActual) || localhostRegex.MatchString(*serverAddrActual)
+
+			isTLSHandshakeOrConnectivityError := false
+			if s, ok := status.FromError(dialErr); ok {
+				switch s.Code() {
+				case codes.Unavailable, codes.DeadlineExceeded:
+					isTLSHandshakeOrConnectivityError = true
+					log.Printf("DEBUG: gRPC status error indicative of TLS/connectivity issue: %s", s.Code())
+				}
+			}
+			if !isTLSHandshakeOrConnectivityError {
+				var x509UnknownAuthErr x509.UnknownAuthorityError
+				var x509CertInvalidErr x509.CertificateInvalidError
+				var netOpErr *net.OpError
+
+				if errors.As(dialErr, &x509UnknownAuthErr) || errors.As(dialErr, &x509CertInvalidErr) || errors.Is(dialErr, credentials.ErrConnDispatched) || errors.As(dialErr, &netOpErr) {
+					isTLSHandshakeOrConnectivityError = true
+					log.Printf("DEBUG: Underlying error indicative of TLS/connectivity issue: %T, %v", dialErr, dialErr)
+				}
+			}
+			if !isTLSHandshakeOrConnectivityError && errors.Is(dialErr, context.DeadlineExceeded) {
+				isTLSHandshakeOrConnectivityError = true
+				log.Printf("DEBUG: Dial error is context.DeadlineExceeded, considering it a connectivity issue for retry.")
+			}
+
+			if allowLocalInsecure && isPotentiallyLocal && isTLSHandshakeOrConnectivityError {
+				log.Printf("INFO: Conditions met for insecure retry to %s (local-like address, error: %v, flag enabled).", *serverAddrActual, dialErr)
+				log.Printf("WARN: Retrying connection to %s with InsecureSkipVerify enabled.", *serverAddrActual)
+
+				tlsCredsRetry, errRetry := loadTLSCredentialsFromEmbed(*serverAddrActual, true)
+				if errRetry != nil {
+					return nil, fmt.Errorf("cannot load TLS credentials for insecure retry: %w", errRetry)
+				}
+
+				var retryOpts []grpc.DialOption
+				if tlsCredsRetry == nil {
+					log.Println("ERROR: loadTLSCredentialsFromEmbed returned nil for retry credentials. Attempting with fully insecure.")
+					retryOpts = []grpc.DialOption{grpc.WithTransportCredentials(insecure.NewCredentials()), grpc.WithBlock()}
+				} else {
+					retryOpts = []grpc.DialOption{grpc.WithTransportCredentials(tlsCredsRetry), grpc.WithBlock()}
+				}
+
+				dialCtxRetry, dialCancelRetry := context.WithTimeout(context.Background(), 15*time.Second)
+				conn, dialErr = grpc.DialContext(dialCtxRetry, *serverAddrActual, retryOpts...)
+				dialCancelRetry()
+
+				if dialErr != nil {
+					log.Printf("ERROR: Insecure retry connection attempt to %s also failed: %v", *serverAddrActual, dialErr)
+				} else {
+					log.Printf("INFO: Insecure retry connection to %s succeeded.", *serverAddrActual)
+				}
+			} else {
+				log.Printf("INFO: Conditions for insecure retry not met (allowLocalInsecure: %t, isPotentiallyLocal: %t, isTLSHandshakeOrConnectivityError: %t). Original error: %v",
+					allowLocalInsecure, isPotentiallyLocal, isTLSHandshakeOrConnectivityError, dialErr)
+			}
+		}
+	} else if *connectionType == "relay" {
+		if *sessionToken == "" {
+			return nil, fmt.Errorf("relay connection type specified but no session token provided")
+		}
+		log.Printf("INFO: Using custom dialer for relay connection to %s with session token %s", *serverAddrActual, *sessionToken)
+		tlsCreds, err := loadTLSCredentialsFromEmbed(*serverAddrActual, allowLocalInsecure)
+		if err != nil {
+			return nil, fmt.Errorf("cannot load TLS credentials for relay: %w", err)
+		}
+		opts := []grpc.DialOption{
+			grpc.WithTransportCredentials(tlsCreds),
+			grpc.WithContextDialer(customRelayDialer),
+		}
+		dialCtx, dialCancel := context.WithTimeout(context.Background(), 20*time.Second)
+		conn, dialErr = grpc.DialContext(dialCtx, *serverAddrActual, opts...)
+		dialCancel()
+	} else {
+		dialErr = fmt.Errorf("unknown connection type: '%s'", *connectionType)
+	}
+	return conn, dialErr
+}
+
 func loadTLSCredentialsFromEmbed(serverAddrString string, isRetryInsecure bool) (credentials.TransportCredentials, error) {
 	clientCert, err := tls.X509KeyPair(clientCertEmbed, clientKeyEmbed)
 	if err != nil {
@@ -542,7 +579,10 @@ func startPinger(ctx context.Context, client pb.RemoteControlServiceClient) {
 				log.Printf("WARN: Ping response is nil despite no error")
 				continue
 			}
-			rttMillis := float64(time.Since(startTime).Nanoseconds()) / 1_000_000.0
+			rtt := time.Since(startTime)
+			lastPingRTT.Store(int64(rtt))
+			updateHostClock(startTime, rtt, resp.GetServerTimestampNano())
+			rttMillis := float64(rtt.Nanoseconds()) / 1_000_000.0
 			if pingLabel != nil {
 				pingLabel.SetText(fmt.Sprintf("RTT: %.2f ms", rttMillis))
 			}
@@ -555,240 +595,3 @@ func startPinger(ctx context.Context, client pb.RemoteControlServiceClient) {
 		}
 	}
 }
-
-func appendToTerminalOutput(textChunk string) {
-	terminalMutex.Lock()
-	defer terminalMutex.Unlock()
-
-	if terminalOutputDisplay == nil {
-		log.Println("DEBUG: appendToTerminalOutput - terminalOutputDisplay is nil, cannot append.")
-		return
-	}
-
-	currentText := terminalOutputDisplay.Text
-	newText := currentText + textChunk
-	terminalOutputDisplay.SetText(newText)
-	terminalOutputDisplay.Refresh()
-
-	targetRow := strings.Count(newText, "\n")
-	terminalOutputDisplay.CursorRow = targetRow
-	terminalOutputDisplay.Refresh()
-	terminalScroll.Refresh()
-}
-
-func openTerminalWindow(theApp fyne.App) {
-	if !canAccessTerminal {
-		log.Println("INFO: Attempted to open terminal window, but access is denied by host.")
-		var parentWin fyne.Window
-		if mainWindow != nil {
-			parentWin = mainWindow
-		} else if AppInstance != nil {
-			allWindows := AppInstance.Driver().AllWindows()
-			if len(allWindows) > 0 {
-				parentWin = allWindows[0]
-			}
-		}
-		if parentWin != nil {
-			dialog.ShowInformation("Access Denied", "Terminal access has been disabled by the host.", parentWin)
-		}
-		return
-	}
-
-	terminalMutex.Lock()
-	if terminalWindow != nil {
-		log.Println("DEBUG: openTerminalWindow - Window already open. Requesting focus.")
-		terminalWindow.RequestFocus()
-		terminalMutex.Unlock()
-		return
-	}
-	terminalMutex.Unlock()
-
-	if terminalClient == nil {
-		log.Println("ERROR: openTerminalWindow - Terminal client not initialized.")
-		parentWin := mainWindow
-		if parentWin != nil {
-			dialog.ShowError(fmt.Errorf("Terminal client not available"), parentWin)
-		} else {
-			log.Println("Cannot show terminal error dialog: global mainWindow is nil.")
-			if drv := theApp.Driver(); drv != nil && len(drv.AllWindows()) > 0 {
-				dialog.ShowError(fmt.Errorf("Terminal client not available (main window nil)"), drv.AllWindows()[0])
-			}
-		}
-		return
-	}
-
-	w := theApp.NewWindow("Remote PTY Terminal")
-	ctx, thisWindowCancelFunc := context.WithCancel(context.Background())
-	log.Printf("DEBUG: openTerminalWindow - Created new stream context %p with cancel func %p", ctx, thisWindowCancelFunc)
-
-	currentOutputDisplay := wx.NewEntryEx(10)
-	currentOutputDisplay.Wrapping = fyne.TextWrapBreak
-	currentOutputDisplay.SetReadOnly(true)
-	currentOutputDisplay.TextStyle = fyne.TextStyle{Monospace: true}
-
-	currentScroll := container.NewScroll(currentOutputDisplay)
-	currentScroll.SetMinSize(fyne.NewSize(640, 400))
-
-	currentInput := widget.NewEntry()
-	currentInput.SetPlaceHolder("Enter command or input here...")
-
-	terminalMutex.Lock()
-	terminalWindow = w
-	terminalStreamCancel = thisWindowCancelFunc
-	terminalOutputDisplay = currentOutputDisplay
-	terminalInput = currentInput
-	terminalScroll = currentScroll
-	terminalMutex.Unlock()
-
-	sendButton := widget.NewButton("Send", func() { sendTerminalCommand(currentInput) })
-	currentInput.OnSubmitted = func(cmdText string) { sendTerminalCommand(currentInput) }
-	inputBox := container.NewBorder(nil, nil, nil, sendButton, currentInput)
-	content := container.NewBorder(nil, inputBox, nil, nil, currentScroll)
-	w.SetContent(content)
-	w.Resize(fyne.NewSize(700, 500))
-
-	log.Println("DEBUG: openTerminalWindow - Attempting to establish terminal command stream...")
-	stream, err := terminalClient.CommandStream(ctx)
-	if err != nil {
-		log.Printf("ERROR: openTerminalWindow - Could not create terminal command stream: %v. Context %p", err, ctx)
-		appendToTerminalOutput(fmt.Sprintf("--- Error connecting to terminal: %v ---", err))
-		log.Printf("DEBUG: openTerminalWindow - Stream creation failed, calling thisWindowCancelFunc (%p) for context %p", thisWindowCancelFunc, ctx)
-		thisWindowCancelFunc()
-
-		terminalMutex.Lock()
-		if terminalWindow == w {
-			log.Println("DEBUG: Stream creation failed for the current global terminal window. Setting global terminalStreamCancel to nil.")
-			terminalStreamCancel = nil
-		}
-		terminalMutex.Unlock()
-		w.Show()
-		return
-	}
-
-	terminalMutex.Lock()
-	currentTerminalStream = stream
-	terminalMutex.Unlock()
-	log.Printf("DEBUG: openTerminalWindow - Terminal command stream established. Stream: %p, Context: %p", stream, ctx)
-	go receiveTerminalOutput(stream, ctx, thisWindowCancelFunc)
-
-	w.SetOnClosed(func() {
-		log.Printf("DEBUG: SetOnClosed - Terminal window %p closed by user. Calling its cancel func %p for context %p", w, thisWindowCancelFunc, ctx)
-		thisWindowCancelFunc()
-
-		terminalMutex.Lock()
-		if terminalWindow == w {
-			terminalWindow = nil
-			currentTerminalStream = nil
-			log.Println("DEBUG: SetOnClosed - Cleaning global resources for the closed terminal window.")
-			terminalStreamCancel = nil
-			terminalOutputDisplay = nil
-			terminalInput = nil
-			terminalScroll = nil
-		} else {
-			log.Println("DEBUG: SetOnClosed - Closed window was not the current active terminalWindow, or already cleaned/reassigned.")
-		}
-		terminalMutex.Unlock()
-	})
-	w.Show()
-	w.Canvas().Focus(currentInput)
-}
-
-func sendTerminalCommand(inputWidget *widget.Entry) {
-	if inputWidget == nil {
-		log.Println("DEBUG: sendTerminalCommand - inputWidget is nil")
-		return
-	}
-	commandText := inputWidget.Text
-
-	terminalMutex.Lock()
-	activeStream := currentTerminalStream
-	cancelFuncForActiveStream := terminalStreamCancel
-	currentWin := terminalWindow
-	terminalMutex.Unlock()
-
-	if activeStream == nil {
-		log.Printf("DEBUG: No active terminal stream. Cannot send: '%s'", commandText)
-		appendToTerminalOutput("--- Error: Not connected. ---")
-		return
-	}
-	log.Printf("DEBUG: Sending to stream %p: '%s'", activeStream, commandText)
-	req := &pb.TerminalRequest{Command: commandText}
-
-	if err := activeStream.Send(req); err != nil {
-		log.Printf("ERROR: Send to terminal stream %p failed: %v", activeStream, err)
-		appendToTerminalOutput(fmt.Sprintf("--- Error sending: %v ---", err))
-
-		if cancelFuncForActiveStream != nil {
-			log.Printf("DEBUG: Send error on stream %p. Calling its associated cancel func %p.", activeStream, cancelFuncForActiveStream)
-			cancelFuncForActiveStream()
-		}
-
-		terminalMutex.Lock()
-		if currentTerminalStream == activeStream {
-			log.Printf("DEBUG: Send error. Clearing global currentTerminalStream (was %p). Global terminalStreamCancel (was %p) is now nilled as it was called.", currentTerminalStream, terminalStreamCancel)
-			currentTerminalStream = nil
-			terminalStreamCancel = nil
-		} else {
-			log.Printf("DEBUG: Send error for stream %p, but global currentTerminalStream is now %p. Global terminalStreamCancel (%p) might be for the new stream.", activeStream, currentTerminalStream, terminalStreamCancel)
-		}
-		terminalMutex.Unlock()
-		return
-	}
-	inputWidget.SetText("")
-	if currentWin != nil && inputWidget != nil {
-		currentWin.Canvas().Focus(inputWidget)
-	}
-}
-
-func receiveTerminalOutput(stream pb.TerminalService_CommandStreamClient, streamCtx context.Context, streamCancel context.CancelFunc) {
-	log.Printf("DEBUG: receiveTerminalOutput started. Stream: %p, Context: %p, CancelFunc: %p", stream, streamCtx, streamCancel)
-	defer func() {
-		log.Printf("DEBUG: receiveTerminalOutput stopped. Stream: %p, Context: %p", stream, streamCtx)
-		terminalMutex.Lock()
-		if currentTerminalStream == stream {
-			log.Printf("DEBUG: receiveTerminalOutput exit. Clearing global currentTerminalStream (%p).", currentTerminalStream)
-			currentTerminalStream = nil
-			terminalStreamCancel = nil
-		}
-		terminalMutex.Unlock()
-	}()
-
-	for {
-		select {
-		case <-streamCtx.Done():
-			log.Printf("DEBUG: receiveTerminalOutput - Context %p done: %v", streamCtx, streamCtx.Err())
-			errMsg := fmt.Sprintf("--- PTY Disconnected (context %p: %v) ---", streamCtx, streamCtx.Err())
-			appendToTerminalOutput(errMsg)
-			return
-		default:
-		}
-		resp, err := stream.Recv()
-		if err != nil {
-			log.Printf("DEBUG: Recv() error on stream %p (context %p): %v", stream, streamCtx, err)
-			errMsg := ""
-			if streamCtx.Err() == context.Canceled {
-				errMsg = fmt.Sprintf("--- PTY Disconnected (context %p canceled, Recv err: %v) ---", streamCtx, err)
-			} else if err == io.EOF {
-				errMsg = fmt.Sprintf("--- PTY Session ended by server (EOF on stream %p) ---", stream)
-				if streamCancel != nil {
-					log.Printf("DEBUG: receiveTerminalOutput - EOF received, calling this stream's cancel func %p", streamCancel)
-					streamCancel()
-				}
-			} else {
-				errMsg = fmt.Sprintf("--- PTY Stream error (stream %p): %v ---", stream, err)
-				if streamCancel != nil {
-					log.Printf("DEBUG: receiveTerminalOutput - Recv error, calling this stream's cancel func %p", streamCancel)
-					streamCancel()
-				}
-			}
-			appendToTerminalOutput(errMsg)
-			return
-		}
-		if resp.GetOutputLine() != "" {
-			appendToTerminalOutput(resp.GetOutputLine())
-		}
-		if resp.GetCommandEnded() {
-			log.Printf("DEBUG: Server indicated CommandEnded for stream %p. Output: %q", stream, resp.GetOutputLine())
-		}
-	}
-}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	pb "control_grpc/gen/proto"
+	"fyne.io/fyne/v2/widget"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	partialDownloadSuffix = ".part"
+	downloadStateSuffix   = ".part.json"
+	maxDownloadRetries    = 5
+)
+
+var errDigestMismatch = errors.New("SHA-256 of the downloaded file does not match the host")
+
+// downloadState is the sidecar record kept next to a partial download so that an
+// interrupted transfer can continue from where it stopped instead of from byte zero.
+type downloadState struct {
+	RemotePath   string `json:"remote_path"`
+	TotalSize    int64  `json:"total_size"`
+	ModifiedTime int64  `json:"modified_time"`
+}
+
+// downloadResult describes a finished resumable download.
+type downloadResult struct {
+	BytesReceived  int64
+	ResumedFrom    int64
+	DigestVerified bool
+}
+
+func loadDownloadState(statePath string) (*downloadState, error) {
+	data, err := os.ReadFile(statePath)
+	if err != nil {
+		return nil, err
+	}
+	state := &downloadState{}
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, fmt.Errorf("corrupt download state file %s: %w", statePath, err)
+	}
+	return state, nil
+}
+
+func saveDownloadState(statePath string, state *downloadState) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(statePath, data, 0644)
+}
+
+func removePartialDownload(localFilePath string) {
+	for _, p := range []string{localFilePath + partialDownloadSuffix, localFilePath + downloadStateSuffix} {
+		if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+			log.Printf("Warning: could not remove '%s': %v", p, err)
+		}
+	}
+}
+
+// isRetryableDownloadError reports whether a failed stream is worth resuming
+// automatically, e.g. after a relay connection dropped mid-transfer.
+func isRetryableDownloadError(err error) bool {
+	switch status.Code(err) {
+	case codes.Unavailable, codes.Aborted, codes.Internal, codes.Unknown:
+		return true
+	}
+	return false
+}
+
+// performResumableDownload downloads remotePath into localFilePath through a ".part"
+// file and a sidecar state file. If both exist from an earlier attempt for the same
+// remote file, the transfer resumes from the end of the partial file. Transient stream
+// errors are retried from the current offset. Once the whole file has arrived, its
+// SHA-256 is compared with the digest reported by the host before the partial file
+// is renamed into place.
+func performResumableDownload(ctx context.Context, remotePath, localFilePath string, statusLabel *widget.Label, progressBar *widget.ProgressBar, progressBytesLabel *widget.Label) (*downloadResult, error) {
+	partPath := localFilePath + partialDownloadSuffix
+	statePath := localFilePath + downloadStateSuffix
+
+	state, err := loadDownloadState(statePath)
+	if err != nil || state.RemotePath != remotePath {
+		if err != nil && !os.IsNotExist(err) {
+			log.Printf("Ignoring download state '%s': %v", statePath, err)
+		}
+		state = &downloadState{RemotePath: remotePath}
+	}
+
+	partFile, err := os.OpenFile(partPath, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("cannot open partial file %s: %w", partPath, err)
+	}
+	defer func() {
+		if partFile != nil {
+			partFile.Close()
+		}
+	}()
+
+	var offset int64
+	if partInfo, statErr := partFile.Stat(); statErr == nil && state.TotalSize > 0 && partInfo.Size() <= state.TotalSize {
+		offset = partInfo.Size()
+	}
+
+	hasher := sha256.New()
+	if err := preparePartialFile(partFile, hasher, offset); err != nil {
+		return nil, err
+	}
+	result := &downloadResult{ResumedFrom: offset}
+	if offset > 0 {
+		log.Printf("Resuming download of '%s' at offset %d (%s already on disk)", remotePath, offset, formatBytes(offset))
+		statusLabel.SetText(fmt.Sprintf("Resuming %s at %s...", filepath.Base(localFilePath), formatBytes(offset)))
+	}
+
+	var serverDigest []byte
+	for attempt := 0; ; attempt++ {
+		serverDigest, err = receiveFileRange(ctx, remotePath, partFile, hasher, &offset, state, statePath, statusLabel, progressBar, progressBytesLabel)
+		if err == nil {
+			break
+		}
+		if errors.Is(err, errRemoteFileChanged) {
+			log.Printf("Remote file '%s' changed since the partial download started. Restarting from zero.", remotePath)
+			statusLabel.SetText(fmt.Sprintf("%s changed on the host, restarting...", filepath.Base(localFilePath)))
+			offset = 0
+			result.ResumedFrom = 0
+			hasher.Reset()
+			if err := preparePartialFile(partFile, hasher, 0); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if ctx.Err() != nil || !isRetryableDownloadError(err) || attempt >= maxDownloadRetries {
+			return nil, err
+		}
+		backoff := time.Duration(1<<attempt) * time.Second
+		log.Printf("Download of '%s' interrupted at offset %d: %v. Retrying in %v (attempt %d/%d)", remotePath, offset, err, backoff, attempt+1, maxDownloadRetries)
+		statusLabel.SetText(fmt.Sprintf("Connection lost at %s, resuming in %v (attempt %d/%d)...", formatBytes(offset), backoff, attempt+1, maxDownloadRetries))
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(backoff):
+		}
+	}
+
+	if err := partFile.Close(); err != nil {
+		partFile = nil
+		return nil, fmt.Errorf("cannot close partial file %s: %w", partPath, err)
+	}
+	partFile = nil
+
+	localDigest := hasher.Sum(nil)
+	if len(serverDigest) > 0 {
+		if !bytes.Equal(localDigest, serverDigest) {
+			log.Printf("Integrity check failed for '%s': local %x, host %x", remotePath, localDigest, serverDigest)
+			removePartialDownload(localFilePath)
+			return nil, errDigestMismatch
+		}
+		result.DigestVerified = true
+		log.Printf("Integrity check passed for '%s' (SHA-256 %x)", remotePath, localDigest)
+	} else {
+		log.Printf("Host did not report a SHA-256 for '%s'; skipping integrity check.", remotePath)
+	}
+
+	if err := os.Rename(partPath, localFilePath); err != nil {
+		return nil, fmt.Errorf("cannot move %s into place: %w", partPath, err)
+	}
+	if err := os.Remove(statePath); err != nil && !os.IsNotExist(err) {
+		log.Printf("Warning: could not remove download state '%s': %v", statePath, err)
+	}
+	result.BytesReceived = offset
+	return result, nil
+}
+
+var errRemoteFileChanged = errors.New("remote file changed since the partial download started")
+
+// preparePartialFile truncates the partial file to offset, feeds the bytes it keeps
+// into hasher and leaves the file positioned for appending.
+func preparePartialFile(partFile *os.File, hasher hash.Hash, offset int64) error {
+	if err := partFile.Truncate(offset); err != nil {
+		return fmt.Errorf("cannot truncate partial file: %w", err)
+	}
+	if _, err := partFile.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("cannot seek partial file: %w", err)
+	}
+	if offset > 0 {
+		if _, err := io.CopyN(hasher, partFile, offset); err != nil {
+			return fmt.Errorf("cannot read partial file: %w", err)
+		}
+	}
+	return nil
+}
+
+// receiveFileRange streams the remote file from *offset to its end into partFile,
+// advancing *offset as data is written. It returns the digest from the trailing chunk.
+func receiveFileRange(ctx context.Context, remotePath string, partFile *os.File, hasher hash.Hash, offset *int64, state *downloadState, statePath string, statusLabel *widget.Label, progressBar *widget.ProgressBar, progressBytesLabel *widget.Label) ([]byte, error) {
+	stream, err := filesClient.DownloadFile(ctx, &pb.FileRequest{Path: remotePath, Offset: *offset})
+	if err != nil {
+		return nil, err
+	}
+
+	var digest []byte
+	firstChunk := true
+	for {
+		chunk, err := stream.Recv()
+		if err != nil {
+			if err == io.EOF {
+				return digest, nil
+			}
+			return nil, err
+		}
+
+		if metadata := chunk.GetMetadata(); metadata != nil {
+			if firstChunk {
+				if state.TotalSize != 0 && *offset > 0 && (metadata.GetTotalSize() != state.TotalSize || metadata.GetModifiedTime() != state.ModifiedTime) {
+					return nil, errRemoteFileChanged
+				}
+				state.TotalSize = metadata.GetTotalSize()
+				state.ModifiedTime = metadata.GetModifiedTime()
+				if err := saveDownloadState(statePath, state); err != nil {
+					log.Printf("Warning: could not write download state '%s': %v", statePath, err)
+				}
+				progressBar.Max = float64(state.TotalSize)
+				statusLabel.SetText(fmt.Sprintf("Downloading %s...", filepath.Base(remotePath)))
+			}
+			if len(metadata.GetSha256()) > 0 {
+				digest = metadata.GetSha256()
+			}
+		}
+		firstChunk = false
+
+		if len(chunk.GetContent()) == 0 {
+			continue
+		}
+		n, writeErr := partFile.Write(chunk.GetContent())
+		hasher.Write(chunk.GetContent()[:n])
+		*offset += int64(n)
+		if writeErr != nil {
+			return nil, fmt.Errorf("error writing to disk: %w", writeErr)
+		}
+
+		if state.TotalSize > 0 {
+			progressBar.SetValue(float64(*offset))
+			progressBytesLabel.SetText(fmt.Sprintf("%s / %s", formatBytes(*offset), formatBytes(state.TotalSize)))
+		} else {
+			progressBytesLabel.SetText(fmt.Sprintf("%s received", formatBytes(*offset)))
+		}
+	}
+}
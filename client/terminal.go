@@ -0,0 +1,513 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	pb "control_grpc/gen/proto"
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/widget"
+)
+
+var (
+	terminalWindow fyne.Window
+	terminalTabs   *container.DocTabs
+	// openTerminals maps each tab of the terminal window to its session.
+	openTerminals = make(map[*container.TabItem]*terminalTab)
+	terminalMutex sync.Mutex
+)
+
+// terminalTab is one host terminal session shown in a tab. Closing the tab ends the
+// session; losing the connection or closing the window only detaches from it, and
+// typing in a detached tab reattaches.
+type terminalTab struct {
+	item  *container.TabItem
+	view  *terminalView
+	start terminalStartOptions
+
+	mu        sync.Mutex
+	sessionID string
+	stream    pb.TerminalService_CommandStreamClient
+	cancel    context.CancelFunc
+	ended     bool // the session's shell has exited
+	// sendMu serialises Send, which is called from the UI and, for answers to status
+	// queries, from the receive goroutine.
+	sendMu sync.Mutex
+}
+
+func openTerminalWindow(theApp fyne.App) {
+	if !canAccessTerminal {
+		log.Println("INFO: Attempted to open terminal window, but access is denied by host.")
+		var parentWin fyne.Window
+		if mainWindow != nil {
+			parentWin = mainWindow
+		} else if AppInstance != nil {
+			allWindows := AppInstance.Driver().AllWindows()
+			if len(allWindows) > 0 {
+				parentWin = allWindows[0]
+			}
+		}
+		if parentWin != nil {
+			dialog.ShowInformation("Access Denied", "Terminal access has been disabled by the host.", parentWin)
+		}
+		return
+	}
+
+	terminalMutex.Lock()
+	if terminalWindow != nil {
+		log.Println("DEBUG: openTerminalWindow - Window already open. Requesting focus.")
+		terminalWindow.RequestFocus()
+		terminalMutex.Unlock()
+		return
+	}
+	terminalMutex.Unlock()
+
+	if terminalClient == nil {
+		log.Println("ERROR: openTerminalWindow - Terminal client not initialized.")
+		if mainWindow != nil {
+			dialog.ShowError(fmt.Errorf("Terminal client not available"), mainWindow)
+		}
+		return
+	}
+
+	w := theApp.NewWindow("Remote PTY Terminal")
+	tabs := container.NewDocTabs()
+	tabs.CreateTab = func() *container.TabItem {
+		return newTerminalTab(w, "", terminalStartOptions{}).item
+	}
+	tabs.OnClosed = func(item *container.TabItem) {
+		terminalMutex.Lock()
+		tab := openTerminals[item]
+		delete(openTerminals, item)
+		terminalMutex.Unlock()
+		if tab != nil {
+			tab.stop()
+		}
+	}
+	tabs.OnSelected = func(item *container.TabItem) {
+		terminalMutex.Lock()
+		tab := openTerminals[item]
+		terminalMutex.Unlock()
+		if tab != nil {
+			w.Canvas().Focus(tab.view)
+		}
+	}
+
+	toolbar := container.NewHBox(
+		widget.NewButton("New...", func() { showNewTerminalDialog(w) }),
+		widget.NewButton("Sessions...", func() { showTerminalSessions(w) }),
+		widget.NewButton("Recordings...", func() { showTerminalRecordings(w) }),
+	)
+
+	terminalMutex.Lock()
+	terminalWindow = w
+	terminalTabs = tabs
+	terminalMutex.Unlock()
+
+	w.SetContent(container.NewBorder(toolbar, nil, nil, nil, tabs))
+	w.Resize(fyne.NewSize(900, 550))
+	w.SetOnClosed(func() {
+		log.Println("DEBUG: Terminal window closed. Detaching from its sessions.")
+		detachTerminalSessions()
+	})
+
+	first := newTerminalTab(w, "", terminalStartOptions{})
+	tabs.Append(first.item)
+	w.Show()
+	w.Canvas().Focus(first.view)
+}
+
+// detachTerminalSessions closes every tab's stream without ending the sessions, which
+// keep running on the host until its grace period expires.
+func detachTerminalSessions() {
+	terminalMutex.Lock()
+	tabs := make([]*terminalTab, 0, len(openTerminals))
+	for item, tab := range openTerminals {
+		tabs = append(tabs, tab)
+		delete(openTerminals, item)
+	}
+	terminalWindow = nil
+	terminalTabs = nil
+	terminalMutex.Unlock()
+
+	for _, tab := range tabs {
+		tab.detach()
+	}
+}
+
+// terminalStartOptions choose how the host starts a new session. Zero values leave the
+// choice to the host.
+type terminalStartOptions struct {
+	name  string
+	shell string // ID of one of terminalShells
+	dir   string
+	env   map[string]string
+}
+
+// newTerminalTab creates a tab and connects it to the session with sessionID, or to a
+// new session started with opts when sessionID is empty.
+func newTerminalTab(w fyne.Window, sessionID string, opts terminalStartOptions) *terminalTab {
+	tab := &terminalTab{view: newTerminalView(w), sessionID: sessionID, start: opts}
+	tab.item = container.NewTabItem("Connecting...", tab.view)
+	tab.view.onInput = tab.typed
+	tab.view.onResize = func(cols, rows int) {
+		tab.send(&pb.TerminalRequest{Resize: &pb.TerminalSize{Cols: uint32(cols), Rows: uint32(rows)}})
+	}
+
+	terminalMutex.Lock()
+	openTerminals[tab.item] = tab
+	terminalMutex.Unlock()
+
+	tab.connect()
+	return tab
+}
+
+func (tab *terminalTab) connect() {
+	ctx, cancel := context.WithCancel(context.Background())
+	stream, err := terminalClient.CommandStream(ctx)
+	if err != nil {
+		cancel()
+		log.Printf("ERROR: Could not open terminal stream: %v", err)
+		tab.view.showMessage(fmt.Sprintf("--- Error connecting to terminal: %v ---", err))
+		return
+	}
+
+	tab.mu.Lock()
+	tab.stream = stream
+	tab.cancel = cancel
+	sessionID := tab.sessionID
+	tab.mu.Unlock()
+
+	cols, rows := tab.view.emu.Size()
+	first := &pb.TerminalRequest{SessionId: sessionID, Resize: &pb.TerminalSize{Cols: uint32(cols), Rows: uint32(rows)}}
+	if sessionID == "" {
+		first.SessionName = tab.start.name
+		first.Shell = tab.start.shell
+		first.WorkingDirectory = tab.start.dir
+		first.Environment = tab.start.env
+	}
+	if !tab.send(first) {
+		return
+	}
+	go tab.receive(ctx, stream)
+}
+
+// typed sends keystrokes, reattaching first when the connection was lost.
+func (tab *terminalTab) typed(data []byte) {
+	tab.mu.Lock()
+	connected := tab.stream != nil
+	canReattach := !tab.ended && tab.sessionID != ""
+	tab.mu.Unlock()
+
+	if !connected {
+		if canReattach {
+			tab.view.showMessage("--- Reattaching... ---")
+			tab.connect()
+		}
+		return
+	}
+	tab.send(&pb.TerminalRequest{Input: data})
+}
+
+// send reports whether req was sent. A failed send drops the connection.
+func (tab *terminalTab) send(req *pb.TerminalRequest) bool {
+	tab.mu.Lock()
+	stream := tab.stream
+	tab.mu.Unlock()
+	if stream == nil {
+		return false
+	}
+
+	tab.sendMu.Lock()
+	err := stream.Send(req)
+	tab.sendMu.Unlock()
+	if err != nil {
+		log.Printf("ERROR: Send to terminal session %s failed: %v", tab.sessionID, err)
+		tab.disconnected(stream, fmt.Sprintf("--- Error sending: %v ---", err))
+		return false
+	}
+	return true
+}
+
+func (tab *terminalTab) receive(ctx context.Context, stream pb.TerminalService_CommandStreamClient) {
+	for {
+		resp, err := stream.Recv()
+		if err != nil {
+			switch {
+			case ctx.Err() != nil:
+				tab.disconnected(stream, "")
+			case err == io.EOF:
+				tab.disconnected(stream, "--- PTY Session ended by server ---")
+			default:
+				log.Printf("DEBUG: Terminal session %s stream error: %v", tab.sessionID, err)
+				tab.disconnected(stream, fmt.Sprintf("--- PTY Stream error: %v ---", err))
+			}
+			return
+		}
+
+		if info := resp.GetSession(); info != nil {
+			// The host replays the session's scrollback next.
+			tab.view.emu.clear()
+			tab.mu.Lock()
+			tab.sessionID = info.GetSessionId()
+			tab.mu.Unlock()
+			tab.item.Text = info.GetName()
+			terminalMutex.Lock()
+			tabs := terminalTabs
+			terminalMutex.Unlock()
+			if tabs != nil {
+				tabs.Refresh()
+			}
+			log.Printf("INFO: Attached to terminal session %s (%q)", info.GetSessionId(), info.GetName())
+		}
+		if len(resp.GetOutput()) > 0 {
+			tab.view.Write(resp.GetOutput())
+		}
+		if resp.GetOutputLine() != "" {
+			tab.view.showMessage(resp.GetOutputLine())
+		}
+		if resp.GetCommandEnded() && resp.GetOutputType() == pb.TerminalResponse_SYSTEM_MESSAGE {
+			tab.mu.Lock()
+			tab.ended = true
+			tab.mu.Unlock()
+		}
+	}
+}
+
+// disconnected forgets stream if it is still the tab's current one.
+func (tab *terminalTab) disconnected(stream pb.TerminalService_CommandStreamClient, message string) {
+	tab.mu.Lock()
+	if tab.stream != stream {
+		tab.mu.Unlock()
+		return
+	}
+	tab.stream = nil
+	cancel := tab.cancel
+	tab.cancel = nil
+	canReattach := !tab.ended && tab.sessionID != ""
+	tab.mu.Unlock()
+
+	if cancel != nil {
+		cancel()
+	}
+	if message != "" {
+		tab.view.showMessage(message)
+	}
+	if canReattach {
+		tab.view.showMessage("--- Disconnected. Press any key to reattach. ---")
+	}
+}
+
+// detach closes the stream; the session keeps running on the host.
+func (tab *terminalTab) detach() {
+	tab.mu.Lock()
+	cancel := tab.cancel
+	tab.stream = nil
+	tab.cancel = nil
+	tab.mu.Unlock()
+	if cancel != nil {
+		cancel()
+	}
+}
+
+// stop ends the session on the host, then closes the stream.
+func (tab *terminalTab) stop() {
+	tab.mu.Lock()
+	tab.ended = true
+	connected := tab.stream != nil
+	sessionID := tab.sessionID
+	tab.mu.Unlock()
+
+	if !connected {
+		if sessionID != "" {
+			go stopTerminalSession(sessionID)
+		}
+		return
+	}
+	tab.send(&pb.TerminalRequest{StopSession: true})
+	// Give the request a moment to reach the host before cancelling the stream.
+	time.AfterFunc(time.Second, tab.detach)
+}
+
+// stopTerminalSession ends a session the client is not attached to.
+func stopTerminalSession(sessionID string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	stream, err := terminalClient.CommandStream(ctx)
+	if err == nil {
+		err = stream.Send(&pb.TerminalRequest{SessionId: sessionID, StopSession: true})
+	}
+	if err != nil {
+		log.Printf("WARN: Could not stop terminal session %s: %v", sessionID, err)
+		return
+	}
+	// The host ends the stream once the shell has exited.
+	for {
+		if _, err := stream.Recv(); err != nil {
+			return
+		}
+	}
+}
+
+// showTerminalSessions lists the host's terminal sessions and opens or focuses a tab
+// for the chosen one.
+func showTerminalSessions(w fyne.Window) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	resp, err := terminalClient.ListSessions(ctx, &pb.ListTerminalSessionsRequest{})
+	if err != nil {
+		dialog.ShowError(fmt.Errorf("Failed to list terminal sessions: %w", err), w)
+		return
+	}
+	sessions := resp.GetSessions()
+	if len(sessions) == 0 {
+		dialog.ShowInformation("Terminal Sessions", "The host has no terminal sessions.", w)
+		return
+	}
+
+	var d dialog.Dialog
+	list := widget.NewList(
+		func() int { return len(sessions) },
+		func() fyne.CanvasObject { return widget.NewLabel("") },
+		func(id widget.ListItemID, obj fyne.CanvasObject) {
+			info := sessions[id]
+			state := "attached"
+			if !info.GetAttached() {
+				state = "detached"
+				if info.GetDetachedTime() != 0 {
+					state = fmt.Sprintf("detached %s ago", time.Since(time.Unix(0, info.GetDetachedTime())).Round(time.Second))
+				}
+			}
+			obj.(*widget.Label).SetText(fmt.Sprintf("%s - %s, %dx%d, %s", info.GetName(), info.GetShell(), info.GetCols(), info.GetRows(), state))
+		},
+	)
+	list.OnSelected = func(id widget.ListItemID) {
+		d.Hide()
+		attachTerminalSession(w, sessions[id].GetSessionId())
+	}
+	d = dialog.NewCustom("Terminal Sessions", "Close", list, w)
+	d.Resize(fyne.NewSize(520, 320))
+	d.Show()
+}
+
+// attachTerminalSession focuses the tab already showing sessionID, or opens one.
+func attachTerminalSession(w fyne.Window, sessionID string) {
+	terminalMutex.Lock()
+	tabs := terminalTabs
+	var existing *terminalTab
+	for _, tab := range openTerminals {
+		tab.mu.Lock()
+		if tab.sessionID == sessionID {
+			existing = tab
+		}
+		tab.mu.Unlock()
+	}
+	terminalMutex.Unlock()
+	if tabs == nil {
+		return
+	}
+
+	if existing == nil {
+		existing = newTerminalTab(w, sessionID, terminalStartOptions{})
+		tabs.Append(existing.item)
+	} else {
+		existing.mu.Lock()
+		connected := existing.stream != nil
+		existing.mu.Unlock()
+		if !connected {
+			existing.connect()
+		}
+	}
+	tabs.Select(existing.item)
+}
+
+// showNewTerminalDialog asks for the shell, name, starting directory and environment of
+// a new session and opens it in a tab.
+func showNewTerminalDialog(w fyne.Window) {
+	shellNames := make([]string, len(terminalShells))
+	selectedShell := ""
+	for i, shell := range terminalShells {
+		shellNames[i] = fmt.Sprintf("%s (%s)", shell.GetName(), shell.GetPath())
+		if shell.GetIsDefault() {
+			selectedShell = shellNames[i]
+		}
+	}
+	shellSelect := widget.NewSelect(shellNames, nil)
+	if len(shellNames) == 0 {
+		shellSelect.PlaceHolder = "Host default"
+		shellSelect.Disable()
+	} else if selectedShell != "" {
+		shellSelect.SetSelected(selectedShell)
+	}
+
+	nameEntry := widget.NewEntry()
+	nameEntry.SetPlaceHolder("Optional")
+	dirEntry := widget.NewEntry()
+	dirEntry.SetPlaceHolder("Host default")
+	envEntry := widget.NewMultiLineEntry()
+	envEntry.SetPlaceHolder("KEY=value, one per line")
+	envEntry.SetMinRowsVisible(4)
+
+	items := []*widget.FormItem{
+		widget.NewFormItem("Shell", shellSelect),
+		widget.NewFormItem("Name", nameEntry),
+		widget.NewFormItem("Directory", dirEntry),
+		widget.NewFormItem("Environment", envEntry),
+	}
+	d := dialog.NewForm("New Terminal", "Start", "Cancel", items, func(ok bool) {
+		if !ok {
+			return
+		}
+		env, err := parseTerminalEnvironment(envEntry.Text)
+		if err != nil {
+			dialog.ShowError(err, w)
+			return
+		}
+		opts := terminalStartOptions{
+			name: strings.TrimSpace(nameEntry.Text),
+			dir:  strings.TrimSpace(dirEntry.Text),
+			env:  env,
+		}
+		if i := shellSelect.SelectedIndex(); i >= 0 && i < len(terminalShells) {
+			opts.shell = terminalShells[i].GetId()
+		}
+
+		terminalMutex.Lock()
+		tabs := terminalTabs
+		terminalMutex.Unlock()
+		if tabs == nil {
+			return
+		}
+		tab := newTerminalTab(w, "", opts)
+		tabs.Append(tab.item)
+		tabs.Select(tab.item)
+	}, w)
+	d.Resize(fyne.NewSize(480, 360))
+	d.Show()
+}
+
+// parseTerminalEnvironment reads KEY=value lines. Blank lines and lines starting with #
+// are skipped.
+func parseTerminalEnvironment(text string) (map[string]string, error) {
+	env := make(map[string]string)
+	for i, line := range strings.Split(text, "\n") {
+		line = strings.TrimRight(line, "\r")
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(strings.TrimSpace(line), "#") {
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" {
+			return nil, fmt.Errorf("Environment line %d is not KEY=value: %q", i+1, line)
+		}
+		env[key] = value
+	}
+	return env, nil
+}
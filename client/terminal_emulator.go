@@ -0,0 +1,741 @@
+package main
+
+import (
+	"fmt"
+	"image/color"
+	"strconv"
+	"sync"
+	"unicode/utf8"
+)
+
+// termCell is one character cell of the terminal screen. A nil colour means the
+// theme's default.
+type termCell struct {
+	r       rune
+	fg, bg  color.Color
+	bold    bool
+	dim     bool
+	reverse bool
+}
+
+// terminalHistoryLines bounds the lines kept after they scroll off the top of the screen.
+const terminalHistoryLines = 2000
+
+type termParserState int
+
+const (
+	stateGround termParserState = iota
+	stateEscape
+	stateCharset // ESC ( and friends, waiting for the charset byte
+	stateCSI
+	stateOSC
+	stateString // DCS, PM and APC strings, ignored until ST
+	stateStringEsc
+)
+
+// terminalEmulator interprets the VT100/xterm control sequences commonly emitted by
+// shells and full screen programs such as vim, top and less, and keeps the resulting
+// screen. It is safe for concurrent use: output is written from the stream goroutine
+// while the UI reads snapshots.
+type terminalEmulator struct {
+	mu sync.Mutex
+
+	cols, rows int
+	lines      [][]termCell
+	mainLines  [][]termCell // the normal screen while the alternate screen is shown
+	altScreen  bool
+	history    [][]termCell // lines scrolled off the normal screen, oldest first
+
+	curX, curY  int
+	wrapPending bool // the cursor is past the last column; the next character wraps
+	pen         termCell
+	savedX      int
+	savedY      int
+	savedPen    termCell
+	top, bottom int // scrolling region, inclusive
+
+	cursorVisible  bool
+	appCursorKeys  bool
+	bracketedPaste bool
+	autowrap       bool
+	title          string
+
+	state        termParserState
+	params       []int
+	private      byte
+	intermediate byte
+	oscBuf       []byte
+	utf8Buf      []byte
+
+	// reply sends answers to status queries back to the host. It is called after
+	// Write has released the lock.
+	reply   func([]byte)
+	replies [][]byte
+}
+
+func newTerminalEmulator(cols, rows int) *terminalEmulator {
+	t := &terminalEmulator{cursorVisible: true, autowrap: true}
+	t.pen.r = ' '
+	t.resizeLocked(cols, rows)
+	return t
+}
+
+// Write feeds terminal output into the emulator.
+func (t *terminalEmulator) Write(data []byte) (int, error) {
+	t.mu.Lock()
+	for _, b := range data {
+		t.advance(b)
+	}
+	replies := t.replies
+	t.replies = nil
+	t.mu.Unlock()
+
+	if t.reply != nil {
+		for _, r := range replies {
+			t.reply(r)
+		}
+	}
+	return len(data), nil
+}
+
+// Resize changes the screen size and reports whether it differs from the old one.
+func (t *terminalEmulator) Resize(cols, rows int) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if cols < 1 {
+		cols = 1
+	}
+	if rows < 1 {
+		rows = 1
+	}
+	if cols == t.cols && rows == t.rows {
+		return false
+	}
+	t.resizeLocked(cols, rows)
+	return true
+}
+
+func (t *terminalEmulator) Size() (cols, rows int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.cols, t.rows
+}
+
+// snapshot copies the screen for rendering, scrolled back by offset lines of history.
+// The cursor is reported hidden when it is scrolled out of view.
+func (t *terminalEmulator) snapshot(offset int) (lines [][]termCell, curX, curY int, cursorVisible bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.altScreen {
+		offset = 0
+	}
+	offset = max(0, min(offset, len(t.history)))
+	lines = make([][]termCell, 0, t.rows)
+	for _, line := range t.history[len(t.history)-offset:] {
+		lines = append(lines, append([]termCell(nil), line...))
+	}
+	for _, line := range t.lines[:t.rows-min(offset, t.rows)] {
+		lines = append(lines, append([]termCell(nil), line...))
+	}
+	lines = lines[:t.rows]
+	curY = t.curY + offset
+	return lines, t.curX, curY, t.cursorVisible && curY < t.rows
+}
+
+// historyLen is how far the normal screen can be scrolled back.
+func (t *terminalEmulator) historyLen() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.altScreen {
+		return 0
+	}
+	return len(t.history)
+}
+
+// pushHistory keeps a copy of a line leaving the top of the normal screen.
+func (t *terminalEmulator) pushHistory(line []termCell) {
+	end := len(line)
+	for end > 0 && line[end-1] == (termCell{r: ' '}) {
+		end--
+	}
+	if len(t.history) >= terminalHistoryLines {
+		t.history = append(t.history[:0], t.history[len(t.history)-terminalHistoryLines+1:]...)
+	}
+	t.history = append(t.history, append([]termCell(nil), line[:end]...))
+}
+
+// modes reports the input modes the host program requested.
+func (t *terminalEmulator) modes() (appCursorKeys, bracketedPaste bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.appCursorKeys, t.bracketedPaste
+}
+
+func (t *terminalEmulator) resizeLocked(cols, rows int) {
+	if t.lines != nil && t.curY >= rows {
+		// Keep the cursor line on screen by dropping lines from the top.
+		shift := t.curY - rows + 1
+		if !t.altScreen {
+			for _, line := range t.lines[:shift] {
+				t.pushHistory(line)
+			}
+		}
+		t.lines = t.lines[shift:]
+		t.curY -= shift
+	}
+	t.lines = resizeScreen(t.lines, cols, rows)
+	if t.mainLines != nil {
+		t.mainLines = resizeScreen(t.mainLines, cols, rows)
+	}
+	t.cols, t.rows = cols, rows
+	t.top, t.bottom = 0, rows-1
+	t.curX = min(t.curX, cols-1)
+	t.curY = min(t.curY, rows-1)
+	t.savedX = min(t.savedX, cols-1)
+	t.savedY = min(t.savedY, rows-1)
+	t.wrapPending = false
+}
+
+func resizeScreen(lines [][]termCell, cols, rows int) [][]termCell {
+	resized := make([][]termCell, rows)
+	for y := range resized {
+		line := make([]termCell, cols)
+		for x := range line {
+			line[x].r = ' '
+		}
+		if y < len(lines) {
+			copy(line, lines[y])
+		}
+		resized[y] = line
+	}
+	return resized
+}
+
+func (t *terminalEmulator) advance(b byte) {
+	switch t.state {
+	case stateGround:
+		t.ground(b)
+	case stateEscape:
+		t.escape(b)
+	case stateCharset:
+		t.state = stateGround
+	case stateCSI:
+		t.csi(b)
+	case stateOSC:
+		switch b {
+		case 0x07:
+			t.oscDispatch()
+			t.state = stateGround
+		case 0x1b:
+			t.oscDispatch()
+			t.state = stateStringEsc
+		default:
+			if len(t.oscBuf) < 4096 {
+				t.oscBuf = append(t.oscBuf, b)
+			}
+		}
+	case stateString:
+		switch b {
+		case 0x07:
+			t.state = stateGround
+		case 0x1b:
+			t.state = stateStringEsc
+		}
+	case stateStringEsc:
+		// ESC \ terminates the string; anything else starts a new sequence.
+		t.state = stateGround
+		if b != '\\' {
+			t.escape(b)
+		}
+	}
+}
+
+func (t *terminalEmulator) ground(b byte) {
+	if len(t.utf8Buf) > 0 || b >= 0x80 {
+		t.utf8Buf = append(t.utf8Buf, b)
+		if utf8.FullRune(t.utf8Buf) {
+			r, _ := utf8.DecodeRune(t.utf8Buf)
+			t.utf8Buf = t.utf8Buf[:0]
+			t.put(r)
+		}
+		return
+	}
+	switch b {
+	case 0x07: // BEL
+	case 0x08: // BS
+		t.wrapPending = false
+		if t.curX > 0 {
+			t.curX--
+		}
+	case 0x09: // HT
+		t.wrapPending = false
+		t.curX = min((t.curX/8+1)*8, t.cols-1)
+	case 0x0a, 0x0b, 0x0c: // LF, VT, FF
+		t.lineFeed()
+	case 0x0d: // CR
+		t.wrapPending = false
+		t.curX = 0
+	case 0x1b:
+		t.state = stateEscape
+	default:
+		if b >= 0x20 && b != 0x7f {
+			t.put(rune(b))
+		}
+	}
+}
+
+func (t *terminalEmulator) escape(b byte) {
+	t.state = stateGround
+	switch b {
+	case '[':
+		t.state = stateCSI
+		t.params = t.params[:0]
+		t.private = 0
+		t.intermediate = 0
+	case ']':
+		t.state = stateOSC
+		t.oscBuf = t.oscBuf[:0]
+	case 'P', '^', '_', 'X':
+		t.state = stateString
+	case '(', ')', '*', '+', '#', '%':
+		t.state = stateCharset
+	case '7':
+		t.saveCursor()
+	case '8':
+		t.restoreCursor()
+	case 'D':
+		t.lineFeed()
+	case 'E':
+		t.curX = 0
+		t.lineFeed()
+	case 'M':
+		t.reverseIndex()
+	case 'c':
+		t.reset()
+	}
+}
+
+func (t *terminalEmulator) csi(b byte) {
+	switch {
+	case b >= '0' && b <= '9':
+		if len(t.params) == 0 {
+			t.params = append(t.params, 0)
+		}
+		last := &t.params[len(t.params)-1]
+		if *last < 100000 {
+			*last = *last*10 + int(b-'0')
+		}
+	case b == ';' || b == ':':
+		if len(t.params) == 0 {
+			t.params = append(t.params, 0)
+		}
+		t.params = append(t.params, 0)
+	case b == '?' || b == '>' || b == '=' || b == '<':
+		t.private = b
+	case b >= 0x20 && b <= 0x2f:
+		t.intermediate = b
+	case b >= 0x40 && b <= 0x7e:
+		t.state = stateGround
+		t.csiDispatch(b)
+	case b == 0x1b:
+		t.state = stateEscape
+	case b == 0x18 || b == 0x1a: // CAN, SUB abort the sequence
+		t.state = stateGround
+	default:
+		// Control characters inside a sequence are executed immediately.
+		if b < 0x20 {
+			t.ground(b)
+		}
+	}
+}
+
+// param returns the i-th CSI parameter, or def when it is missing or zero.
+func (t *terminalEmulator) param(i, def int) int {
+	if i < len(t.params) && t.params[i] != 0 {
+		return t.params[i]
+	}
+	return def
+}
+
+func (t *terminalEmulator) csiDispatch(final byte) {
+	if t.intermediate != 0 {
+		// Cursor style (CSI SP q), soft reset (CSI ! p) and similar are not supported.
+		return
+	}
+	if t.private == '?' {
+		switch final {
+		case 'h':
+			t.setPrivateModes(true)
+		case 'l':
+			t.setPrivateModes(false)
+		}
+		return
+	}
+	if t.private != 0 {
+		if t.private == '>' && final == 'c' {
+			t.sendReply("\x1b[>0;0;0c")
+		}
+		return
+	}
+
+	n := t.param(0, 1)
+	switch final {
+	case 'A':
+		t.moveCursor(t.curX, max(t.curY-n, t.top))
+	case 'B', 'e':
+		t.moveCursor(t.curX, min(t.curY+n, t.bottom))
+	case 'C', 'a':
+		t.moveCursor(t.curX+n, t.curY)
+	case 'D':
+		t.moveCursor(t.curX-n, t.curY)
+	case 'E':
+		t.moveCursor(0, min(t.curY+n, t.bottom))
+	case 'F':
+		t.moveCursor(0, max(t.curY-n, t.top))
+	case 'G', '`':
+		t.moveCursor(n-1, t.curY)
+	case 'd':
+		t.moveCursor(t.curX, n-1)
+	case 'H', 'f':
+		t.moveCursor(t.param(1, 1)-1, n-1)
+	case 'J':
+		t.eraseDisplay(t.param(0, 0))
+	case 'K':
+		t.eraseLine(t.param(0, 0))
+	case 'L':
+		if t.curY >= t.top && t.curY <= t.bottom {
+			t.scrollDown(t.curY, t.bottom, n)
+		}
+	case 'M':
+		if t.curY >= t.top && t.curY <= t.bottom {
+			t.scrollUp(t.curY, t.bottom, n)
+		}
+	case 'P':
+		line := t.lines[t.curY]
+		n = min(n, t.cols-t.curX)
+		copy(line[t.curX:], line[t.curX+n:])
+		t.blank(line[t.cols-n:])
+	case '@':
+		line := t.lines[t.curY]
+		n = min(n, t.cols-t.curX)
+		copy(line[t.curX+n:], line[t.curX:])
+		t.blank(line[t.curX : t.curX+n])
+	case 'X':
+		t.blank(t.lines[t.curY][t.curX:min(t.curX+n, t.cols)])
+	case 'S':
+		t.scrollUp(t.top, t.bottom, n)
+	case 'T':
+		t.scrollDown(t.top, t.bottom, n)
+	case 'm':
+		t.selectGraphicRendition()
+	case 'r':
+		top, bottom := t.param(0, 1)-1, t.param(1, t.rows)-1
+		if top < bottom && bottom < t.rows {
+			t.top, t.bottom = top, bottom
+			t.moveCursor(0, 0)
+		}
+	case 's':
+		t.saveCursor()
+	case 'u':
+		t.restoreCursor()
+	case 'n':
+		switch t.param(0, 0) {
+		case 5:
+			t.sendReply("\x1b[0n")
+		case 6:
+			t.sendReply(fmt.Sprintf("\x1b[%d;%dR", t.curY+1, t.curX+1))
+		}
+	case 'c':
+		t.sendReply("\x1b[?1;2c")
+	}
+}
+
+func (t *terminalEmulator) setPrivateModes(set bool) {
+	for _, mode := range t.params {
+		switch mode {
+		case 1:
+			t.appCursorKeys = set
+		case 7:
+			t.autowrap = set
+		case 25:
+			t.cursorVisible = set
+		case 47, 1047:
+			t.switchScreen(set)
+		case 1049:
+			if set {
+				t.saveCursor()
+				t.switchScreen(true)
+			} else {
+				t.switchScreen(false)
+				t.restoreCursor()
+			}
+		case 2004:
+			t.bracketedPaste = set
+		}
+	}
+}
+
+func (t *terminalEmulator) switchScreen(alt bool) {
+	if alt == t.altScreen {
+		return
+	}
+	t.altScreen = alt
+	if alt {
+		t.mainLines = t.lines
+		t.lines = resizeScreen(nil, t.cols, t.rows)
+	} else {
+		t.lines = t.mainLines
+		t.mainLines = nil
+	}
+	t.wrapPending = false
+}
+
+func (t *terminalEmulator) selectGraphicRendition() {
+	if len(t.params) == 0 {
+		t.params = append(t.params, 0)
+	}
+	for i := 0; i < len(t.params); i++ {
+		p := t.params[i]
+		switch {
+		case p == 0:
+			t.pen = termCell{r: ' '}
+		case p == 1:
+			t.pen.bold = true
+		case p == 2:
+			t.pen.dim = true
+		case p == 7:
+			t.pen.reverse = true
+		case p == 22:
+			t.pen.bold, t.pen.dim = false, false
+		case p == 27:
+			t.pen.reverse = false
+		case p >= 30 && p <= 37:
+			t.pen.fg = xtermColor(p - 30)
+		case p == 38 || p == 48:
+			c, used := t.extendedColor(i + 1)
+			i += used
+			if p == 38 {
+				t.pen.fg = c
+			} else {
+				t.pen.bg = c
+			}
+		case p == 39:
+			t.pen.fg = nil
+		case p >= 40 && p <= 47:
+			t.pen.bg = xtermColor(p - 40)
+		case p == 49:
+			t.pen.bg = nil
+		case p >= 90 && p <= 97:
+			t.pen.fg = xtermColor(p - 90 + 8)
+		case p >= 100 && p <= 107:
+			t.pen.bg = xtermColor(p - 100 + 8)
+		}
+	}
+}
+
+// extendedColor parses the "5;n" or "2;r;g;b" arguments of SGR 38 and 48 starting at
+// params[i], returning the colour and how many parameters it consumed.
+func (t *terminalEmulator) extendedColor(i int) (color.Color, int) {
+	if i >= len(t.params) {
+		return nil, 0
+	}
+	switch t.params[i] {
+	case 5:
+		if i+1 < len(t.params) {
+			return xtermColor(t.params[i+1]), 2
+		}
+	case 2:
+		if i+3 < len(t.params) {
+			return color.RGBA{R: uint8(t.params[i+1]), G: uint8(t.params[i+2]), B: uint8(t.params[i+3]), A: 0xff}, 4
+		}
+	}
+	return nil, len(t.params) - i
+}
+
+func (t *terminalEmulator) oscDispatch() {
+	// OSC 0 and 2 set the window title; other commands are ignored.
+	s := string(t.oscBuf)
+	for i := 0; i < len(s); i++ {
+		if s[i] == ';' {
+			if code, err := strconv.Atoi(s[:i]); err == nil && (code == 0 || code == 2) {
+				t.title = s[i+1:]
+			}
+			return
+		}
+	}
+}
+
+func (t *terminalEmulator) sendReply(s string) {
+	t.replies = append(t.replies, []byte(s))
+}
+
+func (t *terminalEmulator) put(r rune) {
+	if t.wrapPending {
+		t.wrapPending = false
+		if t.autowrap {
+			t.curX = 0
+			t.lineFeed()
+		}
+	}
+	cell := t.pen
+	cell.r = r
+	t.lines[t.curY][t.curX] = cell
+	if t.curX == t.cols-1 {
+		t.wrapPending = true
+	} else {
+		t.curX++
+	}
+}
+
+func (t *terminalEmulator) lineFeed() {
+	t.wrapPending = false
+	switch {
+	case t.curY == t.bottom:
+		t.scrollUp(t.top, t.bottom, 1)
+	case t.curY < t.rows-1:
+		t.curY++
+	}
+}
+
+func (t *terminalEmulator) reverseIndex() {
+	t.wrapPending = false
+	switch {
+	case t.curY == t.top:
+		t.scrollDown(t.top, t.bottom, 1)
+	case t.curY > 0:
+		t.curY--
+	}
+}
+
+// scrollUp moves lines top..bottom up by n, blanking the lines at the bottom.
+func (t *terminalEmulator) scrollUp(top, bottom, n int) {
+	n = min(n, bottom-top+1)
+	removed := make([][]termCell, n)
+	copy(removed, t.lines[top:top+n])
+	if top == 0 && !t.altScreen {
+		for _, line := range removed {
+			t.pushHistory(line)
+		}
+	}
+	copy(t.lines[top:], t.lines[top+n:bottom+1])
+	for i, line := range removed {
+		t.blank(line)
+		t.lines[bottom-n+1+i] = line
+	}
+}
+
+// scrollDown moves lines top..bottom down by n, blanking the lines at the top.
+func (t *terminalEmulator) scrollDown(top, bottom, n int) {
+	n = min(n, bottom-top+1)
+	removed := make([][]termCell, n)
+	copy(removed, t.lines[bottom-n+1:bottom+1])
+	copy(t.lines[top+n:bottom+1], t.lines[top:bottom-n+1])
+	for i, line := range removed {
+		t.blank(line)
+		t.lines[top+i] = line
+	}
+}
+
+// blank erases cells using the current background colour, as xterm does.
+func (t *terminalEmulator) blank(cells []termCell) {
+	for i := range cells {
+		cells[i] = termCell{r: ' ', bg: t.pen.bg}
+	}
+}
+
+func (t *terminalEmulator) eraseDisplay(mode int) {
+	switch mode {
+	case 0:
+		t.blank(t.lines[t.curY][t.curX:])
+		for _, line := range t.lines[t.curY+1:] {
+			t.blank(line)
+		}
+	case 1:
+		for _, line := range t.lines[:t.curY] {
+			t.blank(line)
+		}
+		t.blank(t.lines[t.curY][:t.curX+1])
+	case 2, 3:
+		for _, line := range t.lines {
+			t.blank(line)
+		}
+	}
+}
+
+func (t *terminalEmulator) eraseLine(mode int) {
+	line := t.lines[t.curY]
+	switch mode {
+	case 0:
+		t.blank(line[t.curX:])
+	case 1:
+		t.blank(line[:t.curX+1])
+	case 2:
+		t.blank(line)
+	}
+}
+
+func (t *terminalEmulator) moveCursor(x, y int) {
+	t.wrapPending = false
+	t.curX = max(0, min(x, t.cols-1))
+	t.curY = max(0, min(y, t.rows-1))
+}
+
+func (t *terminalEmulator) saveCursor() {
+	t.savedX, t.savedY, t.savedPen = t.curX, t.curY, t.pen
+}
+
+func (t *terminalEmulator) restoreCursor() {
+	t.pen = t.savedPen
+	t.moveCursor(t.savedX, t.savedY)
+}
+
+// clear resets the terminal and drops its history, before a session's output is
+// replayed into it.
+func (t *terminalEmulator) clear() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.history = nil
+	t.state = stateGround
+	t.utf8Buf = t.utf8Buf[:0]
+	t.reset()
+}
+
+func (t *terminalEmulator) reset() {
+	t.lines = resizeScreen(nil, t.cols, t.rows)
+	t.mainLines = nil
+	t.altScreen = false
+	t.pen = termCell{r: ' '}
+	t.top, t.bottom = 0, t.rows-1
+	t.cursorVisible, t.autowrap = true, true
+	t.appCursorKeys, t.bracketedPaste = false, false
+	t.moveCursor(0, 0)
+}
+
+// xtermColor returns colour n of the xterm 256 colour palette.
+func xtermColor(n int) color.Color {
+	basic := [16]color.RGBA{
+		{0x00, 0x00, 0x00, 0xff}, {0xcd, 0x00, 0x00, 0xff}, {0x00, 0xcd, 0x00, 0xff}, {0xcd, 0xcd, 0x00, 0xff},
+		{0x00, 0x00, 0xee, 0xff}, {0xcd, 0x00, 0xcd, 0xff}, {0x00, 0xcd, 0xcd, 0xff}, {0xe5, 0xe5, 0xe5, 0xff},
+		{0x7f, 0x7f, 0x7f, 0xff}, {0xff, 0x00, 0x00, 0xff}, {0x00, 0xff, 0x00, 0xff}, {0xff, 0xff, 0x00, 0xff},
+		{0x5c, 0x5c, 0xff, 0xff}, {0xff, 0x00, 0xff, 0xff}, {0x00, 0xff, 0xff, 0xff}, {0xff, 0xff, 0xff, 0xff},
+	}
+	switch {
+	case n < 0 || n > 255:
+		return nil
+	case n < 16:
+		return basic[n]
+	case n < 232:
+		// 6x6x6 colour cube.
+		n -= 16
+		level := func(v int) uint8 {
+			if v == 0 {
+				return 0
+			}
+			return uint8(55 + v*40)
+		}
+		return color.RGBA{level(n / 36), level(n / 6 % 6), level(n % 6), 0xff}
+	default:
+		gray := uint8(8 + (n-232)*10)
+		return color.RGBA{gray, gray, gray, 0xff}
+	}
+}
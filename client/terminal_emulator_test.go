@@ -0,0 +1,288 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+// screenText renders the screen as its lines with trailing blanks trimmed, joined by
+// "|", without the empty lines at the bottom.
+func screenText(e *terminalEmulator) string {
+	lines, _, _, _ := e.snapshot(0)
+	rows := make([]string, len(lines))
+	for y, line := range lines {
+		var b strings.Builder
+		for _, c := range line {
+			b.WriteRune(c.r)
+		}
+		rows[y] = strings.TrimRight(b.String(), " ")
+	}
+	return strings.TrimRight(strings.Join(rows, "|"), "|")
+}
+
+func TestTerminalEmulator(t *testing.T) {
+	testCases := []struct {
+		name       string
+		cols, rows int
+		chunks     []string // written one Write call each
+		screen     string
+		curX, curY int
+		replies    []string
+		check      func(t *testing.T, e *terminalEmulator)
+	}{
+		{
+			name: "TextAndNewlines", cols: 10, rows: 4,
+			chunks: []string{"hello\r\nworld"},
+			screen: "hello|world", curX: 5, curY: 1,
+		},
+		{
+			name: "UTF8SplitAcrossWrites", cols: 10, rows: 2,
+			chunks: []string{"w\xc3", "\xb6rld \xe6\x97", "\xa5\xe6\x9c\xac"},
+			screen: "wörld 日本", curX: 8, curY: 0,
+		},
+		{
+			name: "BackspaceAndTab", cols: 12, rows: 2,
+			chunks: []string{"ab\bc\tX"},
+			screen: "ac      X", curX: 9, curY: 0,
+		},
+		{
+			name: "WrapIsDeferredUntilTheNextCharacter", cols: 5, rows: 3,
+			chunks: []string{"abcde"},
+			screen: "abcde", curX: 4, curY: 0,
+		},
+		{
+			name: "Autowrap", cols: 5, rows: 3,
+			chunks: []string{"abcdefg"},
+			screen: "abcde|fg", curX: 2, curY: 1,
+		},
+		{
+			name: "AutowrapOff", cols: 5, rows: 3,
+			chunks: []string{"\x1b[?7labcdefg"},
+			screen: "abcdg", curX: 4, curY: 0,
+		},
+		{
+			name: "CursorMovement", cols: 10, rows: 4,
+			chunks: []string{"\x1b[2;3HX", "\x1b[AY", "\x1b[2DZ"},
+			screen: "  ZY|  X", curX: 3, curY: 0,
+		},
+		{
+			name: "CursorMovementIsClamped", cols: 10, rows: 4,
+			chunks: []string{"\x1b[99;99H", "\x1b[99CX"},
+			screen: "|||         X", curX: 9, curY: 3,
+		},
+		{
+			name: "EraseToEndOfDisplay", cols: 10, rows: 4,
+			chunks: []string{"abcdef\r\nghij\r\nklm", "\x1b[2;2H\x1b[J"},
+			screen: "abcdef|g", curX: 1, curY: 1,
+		},
+		{
+			name: "EraseLine", cols: 10, rows: 2,
+			chunks: []string{"abcdef\x1b[3G\x1b[1K", "\r\nxyz\x1b[2K"},
+			screen: "   def", curX: 3, curY: 1,
+		},
+		{
+			name: "DeleteAndInsertCharacters", cols: 10, rows: 2,
+			chunks: []string{"abcdef\r\x1b[2P", "\r\nabcdef\r\x1b[2@"},
+			screen: "cdef|  abcdef", curX: 0, curY: 1,
+		},
+		{
+			name: "InsertAndDeleteLines", cols: 10, rows: 4,
+			// The inserted line pushes 4 off the bottom, deleting 2 pulls up a blank one.
+			chunks: []string{"1\r\n2\r\n3\r\n4", "\x1b[2;1H\x1b[L", "\x1b[3;1H\x1b[M"},
+			screen: "1||3", curX: 0, curY: 2,
+		},
+		{
+			name: "ScrollRegion", cols: 10, rows: 4,
+			chunks: []string{"1\r\n2\r\n3\r\n4", "\x1b[2;3r", "\x1b[3;1H\nx"},
+			screen: "1|3|x|4", curX: 1, curY: 2,
+			check: func(t *testing.T, e *terminalEmulator) {
+				if n := e.historyLen(); n != 0 {
+					t.Errorf("scrolling a region below the top should keep no history, got %d lines", n)
+				}
+			},
+		},
+		{
+			name: "ReverseIndexAtTopScrollsDown", cols: 10, rows: 3,
+			chunks: []string{"a\r\nb\r\nc", "\x1b[H\x1bM"},
+			screen: "|a|b", curX: 0, curY: 0,
+		},
+		{
+			name: "ScrollbackHistory", cols: 10, rows: 3,
+			chunks: []string{"1\r\n2\r\n3\r\n4\r\n5"},
+			screen: "3|4|5", curX: 1, curY: 2,
+			check: func(t *testing.T, e *terminalEmulator) {
+				if n := e.historyLen(); n != 2 {
+					t.Errorf("historyLen = %d, expected 2", n)
+				}
+				lines, _, _, _ := e.snapshot(2)
+				if lines[0][0].r != '1' || lines[2][0].r != '3' {
+					t.Errorf("scrolled back snapshot starts with %q and %q", lines[0][0].r, lines[2][0].r)
+				}
+			},
+		},
+		{
+			name: "AlternateScreen", cols: 10, rows: 3,
+			chunks: []string{"main", "\x1b[?1049h\x1b[H", "vim buffer"},
+			screen: "vim buffer", curX: 9, curY: 0,
+			check: func(t *testing.T, e *terminalEmulator) {
+				if n := e.historyLen(); n != 0 {
+					t.Errorf("the alternate screen has no scrollback, got %d lines", n)
+				}
+			},
+		},
+		{
+			name: "LeavingAlternateScreenRestoresMainScreen", cols: 10, rows: 3,
+			chunks: []string{"$ vim", "\x1b[?1049h\x1b[2J\x1b[Hbuffer\r\n~", "\x1b[?1049l"},
+			screen: "$ vim", curX: 5, curY: 0,
+		},
+		{
+			name: "SelectGraphicRendition", cols: 10, rows: 2,
+			chunks: []string{"\x1b[1;31mR", "\x1b[0mN\x1b[38;5;196mA", "\x1b[48;2;1;2;3mB\x1b[7mC\x1b[27;39;49mD"},
+			screen: "RNABCD", curX: 6, curY: 0,
+			check: func(t *testing.T, e *terminalEmulator) {
+				lines, _, _, _ := e.snapshot(0)
+				row := lines[0]
+				if !row[0].bold || row[0].fg != xtermColor(1) {
+					t.Errorf("R: expected bold red, got %+v", row[0])
+				}
+				if row[1].bold || row[1].fg != nil {
+					t.Errorf("N: expected the default pen after SGR 0, got %+v", row[1])
+				}
+				if row[2].fg != xtermColor(196) {
+					t.Errorf("A: expected 256-colour foreground 196, got %+v", row[2])
+				}
+				if row[3].bg == nil {
+					t.Errorf("B: expected a true colour background, got %+v", row[3])
+				} else if r, g, b, _ := row[3].bg.RGBA(); r>>8 != 1 || g>>8 != 2 || b>>8 != 3 {
+					t.Errorf("B: background = %d,%d,%d, expected 1,2,3", r>>8, g>>8, b>>8)
+				}
+				if !row[4].reverse {
+					t.Errorf("C: expected reverse video, got %+v", row[4])
+				}
+				if row[5].reverse || row[5].fg != nil || row[5].bg != nil {
+					t.Errorf("D: expected reverse and colours reset, got %+v", row[5])
+				}
+			},
+		},
+		{
+			name: "EraseUsesBackgroundColour", cols: 4, rows: 1,
+			chunks: []string{"\x1b[44m\x1b[2J"},
+			screen: "", curX: 0, curY: 0,
+			check: func(t *testing.T, e *terminalEmulator) {
+				lines, _, _, _ := e.snapshot(0)
+				if lines[0][3].bg != xtermColor(4) {
+					t.Errorf("erased cells should take the pen's background, got %+v", lines[0][3])
+				}
+			},
+		},
+		{
+			name: "CtrlCEcho", cols: 20, rows: 3,
+			chunks: []string{"$ top", "\r\n", "^C", "\r\n$ "},
+			screen: "$ top|^C|$", curX: 2, curY: 2,
+		},
+		{
+			name: "HideCursor", cols: 10, rows: 2,
+			chunks: []string{"\x1b[?25l"},
+			screen: "", curX: 0, curY: 0,
+			check: func(t *testing.T, e *terminalEmulator) {
+				if _, _, _, visible := e.snapshot(0); visible {
+					t.Error("the cursor should be hidden")
+				}
+			},
+		},
+		{
+			name: "InputModes", cols: 10, rows: 2,
+			chunks: []string{"\x1b[?1h\x1b[?2004h"},
+			screen: "", curX: 0, curY: 0,
+			check: func(t *testing.T, e *terminalEmulator) {
+				if appKeys, paste := e.modes(); !appKeys || !paste {
+					t.Errorf("modes = %v, %v, expected application cursor keys and bracketed paste", appKeys, paste)
+				}
+			},
+		},
+		{
+			name: "WindowTitle", cols: 10, rows: 2,
+			chunks: []string{"\x1b]0;my ti", "tle\x07prompt"},
+			screen: "prompt", curX: 6, curY: 0,
+			check: func(t *testing.T, e *terminalEmulator) {
+				if e.title != "my title" {
+					t.Errorf("title = %q", e.title)
+				}
+			},
+		},
+		{
+			name: "IgnoredStringsAndCharsets", cols: 10, rows: 2,
+			chunks: []string{"\x1bP+q544e\x1b\\", "\x1b(Bok"},
+			screen: "ok", curX: 2, curY: 0,
+		},
+		{
+			name: "SaveAndRestoreCursor", cols: 10, rows: 3,
+			chunks: []string{"ab\x1b7", "\x1b[3;5Hz", "\x1b8c"},
+			screen: "abc||    z", curX: 3, curY: 0,
+		},
+		{
+			name: "StatusReports", cols: 10, rows: 3,
+			chunks: []string{"ab\r\nc", "\x1b[6n", "\x1b[5n\x1b[c"},
+			screen: "ab|c", curX: 1, curY: 1,
+			replies: []string{"\x1b[2;2R", "\x1b[0n", "\x1b[?1;2c"},
+		},
+	}
+
+	for _, tc := range testCases {
+		// Programs' output arrives in arbitrary pieces, so every case is also fed one
+		// byte at a time.
+		var bytewise []string
+		for _, b := range []byte(strings.Join(tc.chunks, "")) {
+			bytewise = append(bytewise, string([]byte{b}))
+		}
+		for _, feed := range []struct {
+			name   string
+			chunks []string
+		}{{"Chunks", tc.chunks}, {"Bytewise", bytewise}} {
+			t.Run(tc.name+"/"+feed.name, func(t *testing.T) {
+				e := newTerminalEmulator(tc.cols, tc.rows)
+				var replies []string
+				e.reply = func(b []byte) { replies = append(replies, string(b)) }
+				for _, chunk := range feed.chunks {
+					if n, err := e.Write([]byte(chunk)); n != len(chunk) || err != nil {
+						t.Fatalf("Write(%q) = %d, %v", chunk, n, err)
+					}
+				}
+				if got := screenText(e); got != tc.screen {
+					t.Errorf("screen = %q, expected %q", got, tc.screen)
+				}
+				if _, x, y, _ := e.snapshot(0); x != tc.curX || y != tc.curY {
+					t.Errorf("cursor = %d,%d, expected %d,%d", x, y, tc.curX, tc.curY)
+				}
+				if !slices.Equal(replies, tc.replies) {
+					t.Errorf("replies = %q, expected %q", replies, tc.replies)
+				}
+				if tc.check != nil {
+					tc.check(t, e)
+				}
+			})
+		}
+	}
+}
+
+func TestTerminalEmulatorResize(t *testing.T) {
+	e := newTerminalEmulator(10, 4)
+	e.Write([]byte("1\r\n2\r\n3\r\n4"))
+	if !e.Resize(6, 2) {
+		t.Fatal("Resize should report a changed size")
+	}
+	if e.Resize(6, 2) {
+		t.Error("Resize to the same size should report no change")
+	}
+	// The cursor line stays on screen, the lines above it go to the history.
+	if got := screenText(e); got != "3|4" {
+		t.Errorf("screen = %q, expected %q", got, "3|4")
+	}
+	if _, x, y, _ := e.snapshot(0); x != 1 || y != 1 {
+		t.Errorf("cursor = %d,%d, expected 1,1", x, y)
+	}
+	if n := e.historyLen(); n != 2 {
+		t.Errorf("historyLen = %d, expected 2", n)
+	}
+}
@@ -0,0 +1,337 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"sync"
+	"time"
+
+	pb "control_grpc/gen/proto"
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/theme"
+	"fyne.io/fyne/v2/widget"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// recordingIdleLimit is the longest pause played back when idle time is skipped.
+const recordingIdleLimit = 2 * time.Second
+
+var recordingSpeeds = []string{"0.5x", "1x", "2x", "4x", "8x"}
+
+// recordingHeader is the part of an asciicast v2 header the player uses.
+type recordingHeader struct {
+	Version   int    `json:"version"`
+	Width     int    `json:"width"`
+	Height    int    `json:"height"`
+	Timestamp int64  `json:"timestamp"`
+	Title     string `json:"title"`
+}
+
+// recordingEvent is one [seconds, code, data] line of an asciicast v2 file.
+type recordingEvent struct {
+	at   time.Duration
+	code string // "o" output, "i" input, "r" resize, "m" marker
+	data string
+}
+
+// parseAsciicast reads an asciicast v2 file. Lines that are not valid events, such as
+// the last one of a recording still being written, are skipped.
+func parseAsciicast(data []byte) (recordingHeader, []recordingEvent, error) {
+	var header recordingHeader
+	lines := bytes.Split(data, []byte("\n"))
+	if err := json.Unmarshal(lines[0], &header); err != nil {
+		return header, nil, fmt.Errorf("Not an asciicast recording: %w", err)
+	}
+	if header.Version != 2 {
+		return header, nil, fmt.Errorf("Unsupported asciicast version %d", header.Version)
+	}
+	if header.Width <= 0 || header.Height <= 0 {
+		header.Width, header.Height = defaultTerminalCols, defaultTerminalRows
+	}
+
+	var events []recordingEvent
+	for _, line := range lines[1:] {
+		var fields []json.RawMessage
+		if len(bytes.TrimSpace(line)) == 0 || json.Unmarshal(line, &fields) != nil || len(fields) < 3 {
+			continue
+		}
+		var seconds float64
+		var ev recordingEvent
+		if json.Unmarshal(fields[0], &seconds) != nil || json.Unmarshal(fields[1], &ev.code) != nil || json.Unmarshal(fields[2], &ev.data) != nil {
+			continue
+		}
+		ev.at = time.Duration(seconds * float64(time.Second))
+		events = append(events, ev)
+	}
+	return header, events, nil
+}
+
+// showTerminalRecordings lists the host's terminal session recordings and opens the
+// chosen one in a player.
+func showTerminalRecordings(w fyne.Window) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	resp, err := terminalClient.ListRecordings(ctx, &pb.ListTerminalRecordingsRequest{})
+	if status.Code(err) == codes.FailedPrecondition {
+		dialog.ShowInformation("Terminal Recordings", "The host does not record terminal sessions.", w)
+		return
+	}
+	if err != nil {
+		dialog.ShowError(fmt.Errorf("Failed to list terminal recordings: %w", err), w)
+		return
+	}
+	recordings := resp.GetRecordings()
+	if len(recordings) == 0 {
+		dialog.ShowInformation("Terminal Recordings", "The host has no terminal recordings.", w)
+		return
+	}
+
+	var d dialog.Dialog
+	list := widget.NewList(
+		func() int { return len(recordings) },
+		func() fyne.CanvasObject { return widget.NewLabel("") },
+		func(id widget.ListItemID, obj fyne.CanvasObject) {
+			info := recordings[id]
+			started := time.Unix(0, info.GetStartTime()).Format("2006-01-02 15:04:05")
+			text := fmt.Sprintf("%s - %s, %s", started, info.GetTitle(), formatBytes(info.GetSize()))
+			if info.GetActive() {
+				text += " (in progress)"
+			}
+			obj.(*widget.Label).SetText(text)
+		},
+	)
+	list.OnSelected = func(id widget.ListItemID) {
+		d.Hide()
+		info := recordings[id]
+		go func() {
+			data, err := fetchTerminalRecording(info.GetName())
+			if err != nil {
+				dialog.ShowError(fmt.Errorf("Failed to download recording: %w", err), w)
+				return
+			}
+			header, events, err := parseAsciicast(data)
+			if err != nil {
+				dialog.ShowError(err, w)
+				return
+			}
+			openRecordingPlayer(info.GetName(), header, events)
+		}()
+	}
+	d = dialog.NewCustom("Terminal Recordings", "Close", list, w)
+	d.Resize(fyne.NewSize(560, 320))
+	d.Show()
+}
+
+func fetchTerminalRecording(name string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+	stream, err := terminalClient.GetRecording(ctx, &pb.GetTerminalRecordingRequest{Name: name})
+	if err != nil {
+		return nil, err
+	}
+	var data bytes.Buffer
+	for {
+		chunk, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			return data.Bytes(), nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		data.Write(chunk.GetData())
+	}
+}
+
+// recordingPlayer replays a recording's output into a terminal view in real time.
+type recordingPlayer struct {
+	view     *terminalView
+	header   recordingHeader
+	events   []recordingEvent
+	duration time.Duration
+
+	mu       sync.Mutex
+	next     int           // index of the next event to play
+	clock    time.Duration // playback position
+	marker   string        // the last marker passed, such as a client attaching
+	playing  bool
+	speed    float64
+	skipIdle bool
+}
+
+func openRecordingPlayer(name string, header recordingHeader, events []recordingEvent) {
+	title := header.Title
+	if title == "" {
+		title = name
+	}
+	w := AppInstance.NewWindow("Recording - " + title)
+	p := &recordingPlayer{view: newTerminalView(w), header: header, events: events, speed: 1, skipIdle: true}
+	if len(events) > 0 {
+		p.duration = events[len(events)-1].at
+	}
+	p.view.setFixedSize(header.Width, header.Height)
+
+	timeLabel := widget.NewLabel("")
+	markerLabel := widget.NewLabel("")
+	slider := widget.NewSlider(0, max(p.duration.Seconds(), 0.1))
+	slider.Step = 0.1
+	var playButton *widget.Button
+	update := func() {
+		p.mu.Lock()
+		clock, playing, marker := p.clock, p.playing, p.marker
+		p.mu.Unlock()
+		slider.SetValue(clock.Seconds())
+		timeLabel.SetText(fmt.Sprintf("%s / %s", formatPlaybackTime(clock), formatPlaybackTime(p.duration)))
+		markerLabel.SetText(marker)
+		if playing {
+			playButton.SetIcon(theme.MediaPauseIcon())
+		} else {
+			playButton.SetIcon(theme.MediaPlayIcon())
+		}
+	}
+
+	playButton = widget.NewButtonWithIcon("", theme.MediaPlayIcon(), func() {
+		p.mu.Lock()
+		restart := !p.playing && p.next >= len(p.events)
+		p.playing = !p.playing
+		p.mu.Unlock()
+		if restart {
+			p.seek(0)
+		}
+		update()
+	})
+	restartButton := widget.NewButtonWithIcon("", theme.MediaReplayIcon(), func() {
+		p.seek(0)
+		update()
+	})
+	slider.OnChangeEnded = func(seconds float64) {
+		p.seek(time.Duration(seconds * float64(time.Second)))
+		update()
+	}
+	speedSelect := widget.NewSelect(recordingSpeeds, func(s string) {
+		var speed float64
+		if _, err := fmt.Sscanf(s, "%gx", &speed); err == nil && speed > 0 {
+			p.mu.Lock()
+			p.speed = speed
+			p.mu.Unlock()
+		}
+	})
+	speedSelect.SetSelected("1x")
+	skipIdleCheck := widget.NewCheck(fmt.Sprintf("Skip pauses over %v", recordingIdleLimit), func(on bool) {
+		p.mu.Lock()
+		p.skipIdle = on
+		p.mu.Unlock()
+	})
+	skipIdleCheck.SetChecked(true)
+
+	controls := container.NewVBox(
+		slider,
+		container.NewHBox(playButton, restartButton, speedSelect, skipIdleCheck, timeLabel),
+		markerLabel,
+	)
+	w.SetContent(container.NewBorder(nil, controls, nil, nil, p.view))
+	cell := terminalCellSize()
+	w.Resize(fyne.NewSize(cell.Width*float32(header.Width)+16, cell.Height*float32(header.Height)+140))
+
+	done := make(chan struct{})
+	w.SetOnClosed(func() { close(done) })
+	go func() {
+		ticker := time.NewTicker(terminalRefreshInterval)
+		defer ticker.Stop()
+		last := time.Now()
+		for {
+			select {
+			case <-done:
+				return
+			case now := <-ticker.C:
+				if p.advance(now.Sub(last)) {
+					update()
+				}
+				last = now
+			}
+		}
+	}()
+
+	log.Printf("INFO: Playing terminal recording %s (%d events, %v)", name, len(events), p.duration)
+	update()
+	w.Show()
+	p.mu.Lock()
+	p.playing = true
+	p.mu.Unlock()
+}
+
+// advance moves playback on by elapsed wall time and reports whether it was playing.
+func (p *recordingPlayer) advance(elapsed time.Duration) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.playing {
+		return false
+	}
+	p.clock += time.Duration(float64(elapsed) * p.speed)
+	if p.skipIdle && p.next < len(p.events) {
+		var prev time.Duration
+		if p.next > 0 {
+			prev = p.events[p.next-1].at
+		}
+		if next := p.events[p.next].at; next-prev > recordingIdleLimit && p.clock-prev > recordingIdleLimit {
+			p.clock = next
+		}
+	}
+	p.playToLocked(p.clock)
+	if p.next >= len(p.events) {
+		p.playing = false
+		p.clock = p.duration
+	}
+	return true
+}
+
+// seek replays the recording from the start up to position.
+func (p *recordingPlayer) seek(position time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.view.emu.clear()
+	p.view.emu.Resize(p.header.Width, p.header.Height)
+	p.next = 0
+	p.marker = ""
+	p.clock = min(max(position, 0), p.duration)
+	p.playToLocked(p.clock)
+	p.view.scheduleRender()
+}
+
+func (p *recordingPlayer) playToLocked(position time.Duration) {
+	var output bytes.Buffer
+	flush := func() {
+		if output.Len() > 0 {
+			p.view.Write(output.Bytes())
+			output.Reset()
+		}
+	}
+	for ; p.next < len(p.events) && p.events[p.next].at <= position; p.next++ {
+		ev := p.events[p.next]
+		switch ev.code {
+		case "o":
+			output.WriteString(ev.data)
+		case "r":
+			var cols, rows int
+			if _, err := fmt.Sscanf(ev.data, "%dx%d", &cols, &rows); err == nil && cols > 0 && rows > 0 {
+				flush()
+				p.view.emu.Resize(cols, rows)
+			}
+		case "m":
+			p.marker = fmt.Sprintf("%s: %s", formatPlaybackTime(ev.at), ev.data)
+		}
+	}
+	flush()
+}
+
+func formatPlaybackTime(d time.Duration) string {
+	seconds := int(d.Round(time.Second).Seconds())
+	return fmt.Sprintf("%d:%02d", seconds/60, seconds%60)
+}
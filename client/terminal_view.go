@@ -0,0 +1,384 @@
+package main
+
+import (
+	"image/color"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/theme"
+	"fyne.io/fyne/v2/widget"
+)
+
+const (
+	defaultTerminalCols = 120
+	defaultTerminalRows = 30
+	// terminalRefreshInterval coalesces bursts of output into one redraw.
+	terminalRefreshInterval = 16 * time.Millisecond
+)
+
+// terminalView renders a terminalEmulator in a TextGrid and turns keystrokes into the
+// bytes a terminal would send. Its size in cells follows the widget size.
+type terminalView struct {
+	widget.BaseWidget
+
+	emu    *terminalEmulator
+	grid   *widget.TextGrid
+	window fyne.Window
+
+	// onInput receives keystrokes and pasted text; onResize the new size in cells.
+	onInput  func([]byte)
+	onResize func(cols, rows int)
+
+	mu             sync.Mutex
+	focused        bool
+	refreshPending bool
+	scrollOffset   int  // lines of history scrolled back
+	fixedSize      bool // the size in cells is set by setFixedSize, not the widget size
+}
+
+func newTerminalView(window fyne.Window) *terminalView {
+	t := &terminalView{
+		emu:    newTerminalEmulator(defaultTerminalCols, defaultTerminalRows),
+		grid:   widget.NewTextGrid(),
+		window: window,
+	}
+	// Answers to status queries must not scroll the view back like typing does.
+	t.emu.reply = func(data []byte) {
+		if t.onInput != nil {
+			t.onInput(data)
+		}
+	}
+	t.ExtendBaseWidget(t)
+	return t
+}
+
+func (t *terminalView) CreateRenderer() fyne.WidgetRenderer {
+	return widget.NewSimpleRenderer(t.grid)
+}
+
+// MinSize is kept small so the window can shrink; the grid follows the widget size.
+func (t *terminalView) MinSize() fyne.Size {
+	cell := terminalCellSize()
+	return fyne.NewSize(cell.Width*20, cell.Height*5)
+}
+
+func (t *terminalView) Resize(size fyne.Size) {
+	t.BaseWidget.Resize(size)
+	t.mu.Lock()
+	fixed := t.fixedSize
+	t.mu.Unlock()
+	if fixed {
+		return
+	}
+	cell := terminalCellSize()
+	cols, rows := int(size.Width/cell.Width), int(size.Height/cell.Height)
+	if cols < 1 || rows < 1 {
+		return
+	}
+	if t.emu.Resize(cols, rows) && t.onResize != nil {
+		t.onResize(cols, rows)
+	}
+	t.render()
+}
+
+// setFixedSize stops the view from following the widget size, for replaying output
+// recorded at a known size.
+func (t *terminalView) setFixedSize(cols, rows int) {
+	t.mu.Lock()
+	t.fixedSize = true
+	t.mu.Unlock()
+	t.emu.Resize(cols, rows)
+	t.scheduleRender()
+}
+
+// terminalCellSize matches the cell size TextGrid uses for its monospace text.
+func terminalCellSize() fyne.Size {
+	return fyne.MeasureText("M", theme.TextSize(), fyne.TextStyle{Monospace: true})
+}
+
+// Write feeds output from the host into the emulator and schedules a redraw.
+func (t *terminalView) Write(data []byte) (int, error) {
+	n, err := t.emu.Write(data)
+	t.scheduleRender()
+	return n, err
+}
+
+func (t *terminalView) scheduleRender() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.refreshPending {
+		return
+	}
+	t.refreshPending = true
+	time.AfterFunc(terminalRefreshInterval, func() {
+		t.mu.Lock()
+		t.refreshPending = false
+		t.mu.Unlock()
+		t.render()
+	})
+}
+
+func (t *terminalView) render() {
+	t.mu.Lock()
+	offset := t.scrollOffset
+	focused := t.focused
+	t.mu.Unlock()
+	lines, curX, curY, cursorVisible := t.emu.snapshot(offset)
+	showCursor := cursorVisible && focused
+
+	rows := make([]widget.TextGridRow, len(lines))
+	for y, line := range lines {
+		cells := make([]widget.TextGridCell, len(line))
+		var lastCell termCell
+		var lastStyle widget.TextGridStyle
+		for x, c := range line {
+			cursor := showCursor && x == curX && y == curY
+			if cursor {
+				c.reverse = !c.reverse
+			}
+			style := lastStyle
+			if x == 0 || cursor || c.fg != lastCell.fg || c.bg != lastCell.bg || c.bold != lastCell.bold || c.dim != lastCell.dim || c.reverse != lastCell.reverse {
+				style = terminalCellStyle(c)
+			}
+			cells[x] = widget.TextGridCell{Rune: c.r, Style: style}
+			lastCell, lastStyle = c, style
+			if cursor {
+				// Do not let the cursor's style leak into the next cell.
+				lastCell.reverse = !lastCell.reverse
+				lastStyle = nil
+			}
+		}
+		rows[y] = widget.TextGridRow{Cells: cells}
+	}
+	t.grid.Rows = rows
+	t.grid.Refresh()
+}
+
+func terminalCellStyle(c termCell) widget.TextGridStyle {
+	fg, bg := c.fg, c.bg
+	if c.bold {
+		// Bold text uses the bright variant of the eight basic colours.
+		for i := 0; i < 8; i++ {
+			if fg == xtermColor(i) {
+				fg = xtermColor(i + 8)
+				break
+			}
+		}
+	}
+	if c.reverse {
+		fg, bg = bg, fg
+		if fg == nil {
+			fg = theme.Color(theme.ColorNameBackground)
+		}
+		if bg == nil {
+			bg = theme.Color(theme.ColorNameForeground)
+		}
+	}
+	if c.dim && fg != nil {
+		r, g, b, _ := fg.RGBA()
+		fg = color.RGBA{uint8(r >> 9), uint8(g >> 9), uint8(b >> 9), 0xff}
+	}
+	if fg == nil && bg == nil {
+		return nil
+	}
+	return &widget.CustomTextGridStyle{FGColor: fg, BGColor: bg}
+}
+
+func (t *terminalView) send(data []byte) {
+	if t.onInput == nil || len(data) == 0 {
+		return
+	}
+	// Typing returns to the live screen, as in other terminals.
+	t.mu.Lock()
+	scrolled := t.scrollOffset != 0
+	t.scrollOffset = 0
+	t.mu.Unlock()
+	if scrolled {
+		t.render()
+	}
+	t.onInput(data)
+}
+
+// Scrolled moves through the history with the mouse wheel.
+func (t *terminalView) Scrolled(ev *fyne.ScrollEvent) {
+	lines := int(ev.Scrolled.DY / terminalCellSize().Height)
+	if lines == 0 {
+		if ev.Scrolled.DY > 0 {
+			lines = 1
+		} else if ev.Scrolled.DY < 0 {
+			lines = -1
+		}
+	}
+	t.mu.Lock()
+	t.scrollOffset = max(0, min(t.scrollOffset+lines*3, t.emu.historyLen()))
+	t.mu.Unlock()
+	t.render()
+}
+
+// showMessage prints a status line from the client itself, such as a disconnect notice.
+func (t *terminalView) showMessage(message string) {
+	t.Write([]byte("\r\n\x1b[0m" + message + "\r\n"))
+}
+
+func (t *terminalView) FocusGained() {
+	t.mu.Lock()
+	t.focused = true
+	t.mu.Unlock()
+	t.render()
+}
+
+func (t *terminalView) FocusLost() {
+	t.mu.Lock()
+	t.focused = false
+	t.mu.Unlock()
+	t.render()
+}
+
+// AcceptsTab keeps Tab for shell completion instead of moving focus.
+func (t *terminalView) AcceptsTab() bool {
+	return true
+}
+
+func (t *terminalView) Tapped(_ *fyne.PointEvent) {
+	if c := fyne.CurrentApp().Driver().CanvasForObject(t); c != nil {
+		c.Focus(t)
+	}
+}
+
+func (t *terminalView) TypedRune(r rune) {
+	t.send([]byte(string(r)))
+}
+
+func (t *terminalView) TypedKey(ev *fyne.KeyEvent) {
+	appCursorKeys, _ := t.emu.modes()
+	cursorKey := func(final string) string {
+		if appCursorKeys {
+			return "\x1bO" + final
+		}
+		return "\x1b[" + final
+	}
+	var seq string
+	switch ev.Name {
+	case fyne.KeyReturn, fyne.KeyEnter:
+		seq = "\r"
+	case fyne.KeyBackspace:
+		seq = "\x7f"
+	case fyne.KeyTab:
+		seq = "\t"
+	case fyne.KeyEscape:
+		seq = "\x1b"
+	case fyne.KeyUp:
+		seq = cursorKey("A")
+	case fyne.KeyDown:
+		seq = cursorKey("B")
+	case fyne.KeyRight:
+		seq = cursorKey("C")
+	case fyne.KeyLeft:
+		seq = cursorKey("D")
+	case fyne.KeyHome:
+		seq = cursorKey("H")
+	case fyne.KeyEnd:
+		seq = cursorKey("F")
+	case fyne.KeyInsert:
+		seq = "\x1b[2~"
+	case fyne.KeyDelete:
+		seq = "\x1b[3~"
+	case fyne.KeyPageUp:
+		seq = "\x1b[5~"
+	case fyne.KeyPageDown:
+		seq = "\x1b[6~"
+	case fyne.KeyF1:
+		seq = "\x1bOP"
+	case fyne.KeyF2:
+		seq = "\x1bOQ"
+	case fyne.KeyF3:
+		seq = "\x1bOR"
+	case fyne.KeyF4:
+		seq = "\x1bOS"
+	case fyne.KeyF5:
+		seq = "\x1b[15~"
+	case fyne.KeyF6:
+		seq = "\x1b[17~"
+	case fyne.KeyF7:
+		seq = "\x1b[18~"
+	case fyne.KeyF8:
+		seq = "\x1b[19~"
+	case fyne.KeyF9:
+		seq = "\x1b[20~"
+	case fyne.KeyF10:
+		seq = "\x1b[21~"
+	case fyne.KeyF11:
+		seq = "\x1b[23~"
+	case fyne.KeyF12:
+		seq = "\x1b[24~"
+	}
+	t.send([]byte(seq))
+}
+
+// TypedShortcut turns Ctrl and Alt combinations into control characters and escape
+// prefixed keys. Ctrl+Shift+V, and Cmd+V on macOS, paste the clipboard.
+func (t *terminalView) TypedShortcut(s fyne.Shortcut) {
+	if _, ok := s.(*fyne.ShortcutPaste); ok && runtime.GOOS == "darwin" {
+		t.paste()
+		return
+	}
+	ks, ok := s.(fyne.KeyboardShortcut)
+	if !ok {
+		return
+	}
+	switch ks.Mod() {
+	case fyne.KeyModifierControl | fyne.KeyModifierShift:
+		if ks.Key() == fyne.KeyV {
+			t.paste()
+		}
+	case fyne.KeyModifierControl:
+		if b, ok := controlCharacter(ks.Key()); ok {
+			t.send([]byte{b})
+		}
+	case fyne.KeyModifierAlt:
+		if key := string(ks.Key()); len(key) == 1 {
+			t.send([]byte("\x1b" + strings.ToLower(key)))
+		}
+	}
+}
+
+// controlCharacter maps the key pressed with Ctrl to its ASCII control code.
+func controlCharacter(key fyne.KeyName) (byte, bool) {
+	k := string(key)
+	if len(k) != 1 {
+		if key == fyne.KeySpace {
+			return 0, true
+		}
+		return 0, false
+	}
+	switch c := k[0]; {
+	case c >= 'A' && c <= 'Z':
+		return c - 'A' + 1, true
+	case c >= 'a' && c <= 'z':
+		return c - 'a' + 1, true
+	case c == '[':
+		return 0x1b, true
+	case c == '\\':
+		return 0x1c, true
+	case c == ']':
+		return 0x1d, true
+	case c == '/':
+		return 0x1f, true
+	}
+	return 0, false
+}
+
+func (t *terminalView) paste() {
+	if t.window == nil {
+		return
+	}
+	text := strings.ReplaceAll(t.window.Clipboard().Content(), "\r\n", "\r")
+	text = strings.ReplaceAll(text, "\n", "\r")
+	if _, bracketed := t.emu.modes(); bracketed {
+		text = "\x1b[200~" + text + "\x1b[201~"
+	}
+	t.send([]byte(text))
+}
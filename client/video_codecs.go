@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"log"
+	"os/exec"
+	"strings"
+	"time"
+
+	pb "control_grpc/gen/proto"
+)
+
+// knownCodecs are the codecs the host may offer, as named in FeedRequest.codecs.
+var knownCodecs = []string{"av1", "hevc", "vp9", "h264"}
+
+// decodableCodecs returns the codecs to advertise to the host: those listed in the
+// -videoCodecs flag, or else those the local ffmpeg has a decoder for, always with
+// H.264, which every host can encode.
+func decodableCodecs(flagValue string) []string {
+	var codecs []string
+	if flagValue != "" {
+		for _, c := range strings.Split(flagValue, ",") {
+			if c = strings.TrimSpace(strings.ToLower(c)); c != "" {
+				codecs = append(codecs, c)
+			}
+		}
+		return codecs
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	out, err := exec.CommandContext(ctx, "ffmpeg", "-hide_banner", "-decoders").Output()
+	if err != nil {
+		log.Printf("WARN: Failed to list ffmpeg's decoders, only offering H.264: %v", err)
+		return []string{"h264"}
+	}
+	decoders := parseFFmpegDecoders(string(out))
+	for _, c := range knownCodecs {
+		if decoders[c] || c == "h264" {
+			codecs = append(codecs, c)
+		}
+	}
+	log.Printf("INFO: Offering the host the codecs %v", codecs)
+	return codecs
+}
+
+// parseFFmpegDecoders reads `ffmpeg -decoders` into the codecs that have a decoder.
+// Lines are "V..... name description", and decoders named after a library end their
+// description with "(codec name)".
+func parseFFmpegDecoders(out string) map[string]bool {
+	codecs := make(map[string]bool)
+	listing := false
+	scanner := bufio.NewScanner(strings.NewReader(out))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "--") {
+			listing = true
+			continue
+		}
+		fields := strings.Fields(line)
+		if !listing || len(fields) < 2 || !strings.HasPrefix(fields[0], "V") {
+			continue
+		}
+		codecs[fields[1]] = true
+		if i := strings.LastIndex(line, "(codec "); i >= 0 {
+			codecs[strings.TrimSuffix(line[i+len("(codec "):], ")")] = true
+		}
+	}
+	return codecs
+}
+
+// decoderInput returns the codec of a host's video and the ffmpeg input format to
+// decode it with, or "tiles" for a tile mode feed. Older hosts send H.264 in MPEG-TS
+// chunks and do not name the codec.
+func decoderInput(frame *pb.FeedResponse) (codec, format string) {
+	if frame.GetContentType() == "video/mp2t" {
+		return "h264", "mpegts"
+	}
+	if tileFormat, ok := strings.CutPrefix(frame.GetContentType(), "image/"); ok {
+		return tileFormat, "tiles"
+	}
+	codec = frame.GetCodec()
+	if codec == "" {
+		codec = strings.TrimPrefix(frame.GetContentType(), "video/")
+	}
+	switch codec {
+	case "vp9", "av1":
+		// There is no raw input format for these, so pictures are framed in IVF.
+		return codec, "ivf"
+	}
+	return codec, codec
+}
+
+// ivfFourCC identifies the codec in an IVF file header.
+var ivfFourCC = map[string]string{"vp9": "VP90", "av1": "AV01"}
+
+// ivfFileHeader starts an IVF stream with a millisecond time base.
+func ivfFileHeader(codec string, width, height int) []byte {
+	h := make([]byte, 32)
+	copy(h, "DKIF")
+	binary.LittleEndian.PutUint16(h[6:], 32)
+	copy(h[8:], ivfFourCC[codec])
+	binary.LittleEndian.PutUint16(h[12:], uint16(width))
+	binary.LittleEndian.PutUint16(h[14:], uint16(height))
+	binary.LittleEndian.PutUint32(h[16:], 1000)
+	binary.LittleEndian.PutUint32(h[20:], 1)
+	return h
+}
+
+// ivfFrame frames a picture with its size and timestamp.
+func ivfFrame(picture []byte, pts int64) []byte {
+	f := make([]byte, 12, 12+len(picture))
+	binary.LittleEndian.PutUint32(f, uint32(len(picture)))
+	binary.LittleEndian.PutUint64(f[4:], uint64(pts))
+	return append(f, picture...)
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"log"
+	"sync"
+
+	pb "control_grpc/gen/proto"
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/canvas"
+)
+
+// The host's cursor as last sent apart from the video, which the overlay draws when
+// the client asked for a remote cursor.
+var (
+	hostCursorMu      sync.Mutex
+	hostCursorInfo    *pb.CursorInfo
+	hostCursorImage   image.Image = defaultCursorImage
+	hostCursorHotspot image.Point
+	// hostCursorChanged wakes the overlay to redraw the cursor.
+	hostCursorChanged = make(chan struct{}, 1)
+)
+
+// defaultCursorArrow is drawn until the host sends a shape, and for hosts that cannot
+// read theirs. '#' is the outline and '.' the fill.
+var defaultCursorArrow = []string{
+	"#",
+	"##",
+	"#.#",
+	"#..#",
+	"#...#",
+	"#....#",
+	"#.....#",
+	"#......#",
+	"#.......#",
+	"#........#",
+	"#.....#####",
+	"#..#..#",
+	"#.# #..#",
+	"##  #..#",
+	"#    #..#",
+	"     #..#",
+	"      ##",
+}
+
+var defaultCursorImage = arrowImage(defaultCursorArrow)
+
+func arrowImage(rows []string) image.Image {
+	width := 0
+	for _, row := range rows {
+		width = max(width, len(row))
+	}
+	img := image.NewNRGBA(image.Rect(0, 0, width, len(rows)))
+	for y, row := range rows {
+		for x, c := range row {
+			switch c {
+			case '#':
+				img.SetNRGBA(x, y, color.NRGBA{0, 0, 0, 255})
+			case '.':
+				img.SetNRGBA(x, y, color.NRGBA{255, 255, 255, 255})
+			}
+		}
+	}
+	return img
+}
+
+// updateHostCursor keeps the cursor from a feed message, decoding its shape when it
+// comes with one.
+func updateHostCursor(info *pb.CursorInfo) {
+	var shape image.Image
+	if data := info.GetShape().GetPng(); len(data) > 0 {
+		var err error
+		if shape, err = png.Decode(bytes.NewReader(data)); err != nil {
+			log.Printf("ForwardVideoFeed: Failed to decode the host's cursor shape: %v", err)
+		}
+	}
+
+	hostCursorMu.Lock()
+	hostCursorInfo = info
+	if shape != nil {
+		hostCursorImage = shape
+		hostCursorHotspot = image.Pt(int(info.GetShape().GetHotX()), int(info.GetShape().GetHotY()))
+	}
+	hostCursorMu.Unlock()
+
+	select {
+	case hostCursorChanged <- struct{}{}:
+	default:
+	}
+}
+
+// overlayRenderer draws the host's cursor over the video, positioned and scaled the
+// way the video is letterboxed.
+type overlayRenderer struct {
+	overlay *mouseOverlay
+	cursor  *canvas.Image
+	shape   image.Image // the shape cursor shows
+}
+
+func newOverlayRenderer(mo *mouseOverlay) *overlayRenderer {
+	cursor := canvas.NewImageFromImage(defaultCursorImage)
+	cursor.FillMode = canvas.ImageFillStretch
+	cursor.ScaleMode = canvas.ImageScalePixels
+	cursor.Hide()
+	return &overlayRenderer{overlay: mo, cursor: cursor}
+}
+
+func (r *overlayRenderer) Layout(size fyne.Size) {
+	hostCursorMu.Lock()
+	info, shape, hotspot := hostCursorInfo, hostCursorImage, hostCursorHotspot
+	hostCursorMu.Unlock()
+	frameW, frameH := videoFrameSize()
+	if info == nil || !info.GetVisible() || size.Width == 0 || size.Height == 0 || frameW <= 0 || frameH <= 0 {
+		r.cursor.Hide()
+		return
+	}
+
+	// The inverse of scaleCoordinates, with the shape scaled from desktop pixels like
+	// the video is.
+	scale := min(size.Width/float32(frameW), size.Height/float32(frameH))
+	offsetX := (size.Width - float32(frameW)*scale) / 2
+	offsetY := (size.Height - float32(frameH)*scale) / 2
+	shapeScale := scale
+	videoLabelMu.Lock()
+	if captureW := shownCapture[0]; captureW > 0 {
+		shapeScale *= float32(frameW) / float32(captureW)
+	}
+	videoLabelMu.Unlock()
+
+	if shape != r.shape {
+		r.cursor.Image = shape
+		r.shape = shape
+		r.cursor.Refresh()
+	}
+	bounds := shape.Bounds()
+	r.cursor.Resize(fyne.NewSize(float32(bounds.Dx())*shapeScale, float32(bounds.Dy())*shapeScale))
+	r.cursor.Move(fyne.NewPos(
+		offsetX+info.GetX()*float32(frameW)*scale-float32(hotspot.X)*shapeScale,
+		offsetY+info.GetY()*float32(frameH)*scale-float32(hotspot.Y)*shapeScale,
+	))
+	r.cursor.Show()
+}
+
+func (r *overlayRenderer) MinSize() fyne.Size {
+	return fyne.NewSize(0, 0)
+}
+
+func (r *overlayRenderer) Refresh() {
+	r.Layout(r.overlay.Size())
+	canvas.Refresh(r.cursor)
+}
+
+func (r *overlayRenderer) Objects() []fyne.CanvasObject {
+	return []fyne.CanvasObject{r.cursor}
+}
+
+func (r *overlayRenderer) Destroy() {}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sync/atomic"
+	"time"
+
+	pb "control_grpc/gen/proto"
+)
+
+// maxFrameLatency is how old a picture may be when it arrives before the client skips
+// to the next keyframe rather than falling further behind the host.
+const maxFrameLatency = 500 * time.Millisecond
+
+var (
+	// hostClockOffset is the host's clock minus ours in nanoseconds, estimated from
+	// Ping. It is only valid once hostClockKnown is set, which older hosts never allow.
+	hostClockOffset atomic.Int64
+	hostClockKnown  atomic.Bool
+)
+
+// updateHostClock estimates the host's clock from a Ping sent at start that took rtt,
+// assuming the host answered halfway through.
+func updateHostClock(start time.Time, rtt time.Duration, serverNano int64) {
+	if serverNano == 0 {
+		return
+	}
+	hostClockOffset.Store(serverNano - start.Add(rtt/2).UnixNano())
+	hostClockKnown.Store(true)
+}
+
+// frameLatency returns how long ago the host captured a picture, when both the host's
+// clock and the capture time are known.
+func frameLatency(frame *pb.FeedResponse) (time.Duration, bool) {
+	if !hostClockKnown.Load() || frame.GetCaptureTimestamp() == 0 {
+		return 0, false
+	}
+	hostNow := time.Now().UnixNano() + hostClockOffset.Load()
+	return time.Duration(hostNow - frame.GetCaptureTimestamp()), true
+}
+
+// keyframeGate drops the pictures of an H.264 feed that cannot be shown: those that
+// reference pictures the decoder never saw, and those too late to be worth decoding.
+// Either way it lets pictures through again from the next keyframe. Only a decoder
+// that starts mid-stream asks the host for one; a late client waits for the encoder's
+// own, at most two seconds away, as a request restarts the encoder and delays the
+// video further. Tile feeds have no keyframes of their own, but sending a whole frame
+// costs the host nothing more than its size, so a late tile client asks for one.
+type keyframeGate struct {
+	client  pb.RemoteControlServiceClient
+	waiting bool
+}
+
+// reset is called when a new decoder starts, which needs a keyframe first.
+func (g *keyframeGate) reset() {
+	g.waiting = true
+}
+
+// admit reports whether the picture should be decoded.
+func (g *keyframeGate) admit(frame *pb.FeedResponse) bool {
+	if frame.GetKeyframe() {
+		g.waiting = false
+		return true
+	}
+	if g.waiting {
+		return false
+	}
+	if latency, ok := frameLatency(frame); ok && latency > maxFrameLatency {
+		log.Printf("ForwardVideoFeed: Picture %d arrived %v after capture, skipping to the next keyframe.", frame.GetFrameNumber(), latency.Round(time.Millisecond))
+		g.waiting = true
+		if len(frame.GetTiles()) > 0 {
+			g.request(frame.GetFeedId(), "client fell behind")
+		}
+		return false
+	}
+	return true
+}
+
+// request asks the host for a keyframe without holding up the video.
+func (g *keyframeGate) request(feedID, reason string) {
+	if g.client == nil || feedID == "" {
+		return
+	}
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		if _, err := g.client.RequestKeyframe(ctx, &pb.KeyframeRequest{FeedId: feedID, Reason: reason}); err != nil {
+			log.Printf("WARN: Failed to request a keyframe: %v", err)
+		}
+	}()
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	pb "control_grpc/gen/proto"
+	"google.golang.org/grpc"
+)
+
+// keyframeRequestCounter counts RequestKeyframe calls; other methods are not used.
+type keyframeRequestCounter struct {
+	pb.RemoteControlServiceClient
+	requests atomic.Int32
+}
+
+func (c *keyframeRequestCounter) RequestKeyframe(ctx context.Context, req *pb.KeyframeRequest, opts ...grpc.CallOption) (*pb.KeyframeResponse, error) {
+	c.requests.Add(1)
+	return &pb.KeyframeResponse{KeyframeComing: true}, nil
+}
+
+func TestKeyframeGate(t *testing.T) {
+	hostClockOffset.Store(0)
+	hostClockKnown.Store(true)
+	defer hostClockKnown.Store(false)
+
+	client := &keyframeRequestCounter{}
+	gate := keyframeGate{client: client}
+	picture := func(number int32, keyframe bool, age time.Duration) *pb.FeedResponse {
+		return &pb.FeedResponse{FeedId: "feed", FrameNumber: number, Keyframe: keyframe, CaptureTimestamp: time.Now().Add(-age).UnixNano()}
+	}
+
+	steps := []struct {
+		frame *pb.FeedResponse
+		admit bool
+	}{
+		{picture(1, true, 0), true},
+		{picture(2, false, 100*time.Millisecond), true},
+		// A late picture drops everything up to the encoder's next keyframe.
+		{picture(3, false, 2*maxFrameLatency), false},
+		{picture(4, false, 0), false},
+		{picture(5, true, 0), true},
+		{picture(6, false, 0), true},
+	}
+	for _, step := range steps {
+		if got := gate.admit(step.frame); got != step.admit {
+			t.Errorf("admit(picture %d) = %v, expected %v", step.frame.GetFrameNumber(), got, step.admit)
+		}
+	}
+	if n := client.requests.Load(); n != 0 {
+		t.Errorf("falling behind should wait for the next keyframe, but %d were requested", n)
+	}
+
+	waitForRequests := func(expected int32) {
+		t.Helper()
+		deadline := time.Now().Add(time.Second)
+		for client.requests.Load() < expected && time.Now().Before(deadline) {
+			time.Sleep(time.Millisecond)
+		}
+		if n := client.requests.Load(); n != expected {
+			t.Errorf("%d keyframe requests, expected %d", n, expected)
+		}
+	}
+
+	// A decoder starting mid-stream still waits for and asks for one.
+	gate.reset()
+	gate.request("feed", "decoder restarted")
+	if gate.admit(picture(7, false, 0)) {
+		t.Error("a new decoder should not be given pictures before a keyframe")
+	}
+	waitForRequests(1)
+
+	// A tile feed only sends a whole frame when asked.
+	gate.admit(picture(8, true, 0))
+	tiles := picture(9, false, 2*maxFrameLatency)
+	tiles.Tiles = []*pb.Tile{{Width: 64, Height: 64}}
+	if gate.admit(tiles) {
+		t.Error("late tiles should be dropped")
+	}
+	waitForRequests(2)
+}
@@ -6,35 +6,199 @@ import (
 	"image"
 	"io"
 	"log"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	pb "control_grpc/gen/proto"
 	"fyne.io/fyne/v2/canvas"
 	"fyne.io/fyne/v2/widget"
 	ffmpeg "github.com/u2takey/ffmpeg-go"
 )
 
 var (
-	frameImageData = make(chan image.Image, 30)
-	rawFrameBuffer = make(chan []byte, 10)
-)
+	// decodedFrames carries frames from the decoder to drawFrames. A frame that does not
+	// fit is dropped, as only the newest is worth showing.
+	decodedFrames = make(chan *videoFrame, 4)
+
+	// videoWidth and videoHeight are the size frames are decoded to until the host
+	// reports the size of its frames, which older hosts never do.
+	videoWidth  = 1920
+	videoHeight = 1080
+
+	// frameWidth and frameHeight are the size of the frames being decoded, which the
+	// mouse overlay maps pointer positions into.
+	frameWidth  atomic.Int32
+	frameHeight atomic.Int32
 
-const (
-	videoWidth     = 1920
-	videoHeight    = 1080
-	bytesPerPixel  = 4
-	frameSizeBytes = videoWidth * videoHeight * bytesPerPixel
+	// framesDecoded and framesDropped are counted for the next video_stats report, and
+	// the decode latency, from receiving a picture to its frame, since the last one.
+	framesDecoded      atomic.Int64
+	framesDropped      atomic.Int64
+	decodeLatencySum   atomic.Int64
+	decodeLatencyCount atomic.Int64
+	decodeLatencyMax   atomic.Int64
+
+	// framePool recycles the pixels of frames, which are megabytes each.
+	framePool sync.Pool
 )
 
-func runFFmpegProcess(ffmpegInputReader *io.PipeReader, ffmpegOutputWriter *io.PipeWriter) {
-	log.Println("FFmpeg process starting...")
+const bytesPerPixel = 4
+
+// videoFrameSize returns the size of the frames being decoded.
+func videoFrameSize() (int32, int32) {
+	w, h := frameWidth.Load(), frameHeight.Load()
+	if w <= 0 || h <= 0 {
+		return int32(videoWidth), int32(videoHeight)
+	}
+	return w, h
+}
+
+// videoDecoder turns the host's feed messages into frames on decodedFrames. Decoders
+// handle one codec and frame size; forwardVideoFeed starts a new one when they change.
+type videoDecoder interface {
+	// Decode takes a message's picture or tiles. The frame may come out later.
+	Decode(frame *pb.FeedResponse) error
+	Close() error
+}
+
+// newVideoDecoder starts a decoder for a codec in an input format, as returned by
+// decoderInput. Tiles are decoded in process, video by an ffmpeg process.
+func newVideoDecoder(codec, format string, width, height int) videoDecoder {
+	frameWidth.Store(int32(width))
+	frameHeight.Store(int32(height))
+	if format == "tiles" {
+		return newTileDecoder(width, height)
+	}
+	return startFFmpegDecoder(codec, format, width, height)
+}
+
+// videoFrame is a decoded frame. Its pixels go back to framePool once released.
+type videoFrame struct {
+	img *image.RGBA
+}
+
+func newVideoFrame(width, height int) *videoFrame {
+	size := width * height * bytesPerPixel
+	var pix []byte
+	if buf, ok := framePool.Get().(*[]byte); ok && cap(*buf) >= size {
+		pix = (*buf)[:size]
+	} else {
+		pix = make([]byte, size)
+	}
+	return &videoFrame{img: &image.RGBA{Pix: pix, Stride: width * bytesPerPixel, Rect: image.Rect(0, 0, width, height)}}
+}
+
+func (f *videoFrame) release() {
+	pix := f.img.Pix
+	framePool.Put(&pix)
+}
+
+// deliverFrame hands a frame to drawFrames. received is when its picture arrived, zero
+// when unknown.
+func deliverFrame(f *videoFrame, received time.Time) {
+	framesDecoded.Add(1)
+	if !received.IsZero() {
+		latency := int64(time.Since(received))
+		decodeLatencySum.Add(latency)
+		decodeLatencyCount.Add(1)
+		for {
+			m := decodeLatencyMax.Load()
+			if latency <= m || decodeLatencyMax.CompareAndSwap(m, latency) {
+				break
+			}
+		}
+	}
+	select {
+	case decodedFrames <- f:
+	default:
+		framesDropped.Add(1)
+		f.release()
+	}
+}
+
+// takeDecodeLatency returns the average and longest decode latency since the last call.
+func takeDecodeLatency() (avg, longest time.Duration) {
+	sum, count := decodeLatencySum.Swap(0), decodeLatencyCount.Swap(0)
+	longest = time.Duration(decodeLatencyMax.Swap(0))
+	if count > 0 {
+		avg = time.Duration(sum / count)
+	}
+	return avg, longest
+}
+
+// ffmpegDecoder runs ffmpeg to decode the host's stream, pictures of the negotiated
+// codec or MPEG-TS from older hosts, into RGBA frames of one size.
+type ffmpegDecoder struct {
+	codec         string
+	format        string // ffmpeg input format
+	width, height int
+	input         *io.PipeWriter
+	closed        atomic.Bool
+	// received holds when each picture in ffmpeg arrived, to measure decode latency.
+	// MPEG-TS chunks are not pictures, so it is unused for them.
+	received chan time.Time
+
+	// For IVF input, pictures are framed with a timestamp in milliseconds since start.
+	started time.Time
+	lastPTS int64
+}
+
+func startFFmpegDecoder(codec, format string, width, height int) *ffmpegDecoder {
+	inputReader, inputWriter := io.Pipe()
+	outputReader, outputWriter := io.Pipe()
+	d := &ffmpegDecoder{
+		codec:    codec,
+		format:   format,
+		width:    width,
+		height:   height,
+		input:    inputWriter,
+		received: make(chan time.Time, 64),
+		lastPTS:  -1,
+	}
+	go d.run(inputReader, outputWriter)
+	go d.readFrames(outputReader)
+	return d
+}
+
+// Decode feeds a picture, or a chunk of an MPEG-TS stream, to ffmpeg.
+func (d *ffmpegDecoder) Decode(frame *pb.FeedResponse) error {
+	data := frame.GetData()
+	if d.format != "mpegts" {
+		select {
+		case d.received <- time.Now():
+		default:
+		}
+	}
+	if d.format == "ivf" {
+		var header []byte
+		if d.started.IsZero() {
+			d.started = time.Now()
+			header = ivfFileHeader(d.codec, d.width, d.height)
+		}
+		d.lastPTS = max(time.Since(d.started).Milliseconds(), d.lastPTS+1)
+		data = append(header, ivfFrame(data, d.lastPTS)...)
+	}
+	_, err := d.input.Write(data)
+	return err
+}
+
+// Close ends the stream, after which ffmpeg and the frame reader exit.
+func (d *ffmpegDecoder) Close() error {
+	d.closed.Store(true)
+	return d.input.Close()
+}
+
+func (d *ffmpegDecoder) run(ffmpegInputReader *io.PipeReader, ffmpegOutputWriter *io.PipeWriter) {
+	log.Printf("FFmpeg process starting, decoding %s in %s to %dx%d...", d.codec, d.format, d.width, d.height)
 	defer log.Println("FFmpeg process stopped.")
 	defer ffmpegInputReader.Close()
 	defer ffmpegOutputWriter.Close()
 
-	for {
+	for !d.closed.Load() {
 		stderr := &bytes.Buffer{}
 		err := ffmpeg.Input("pipe:0", ffmpeg.KwArgs{
-			"format":             "mpegts",
+			"format":             d.format,
 			"flags":              "low_delay",
 			"fflags":             "nobuffer+discardcorrupt",
 			"protocol_whitelist": "pipe",
@@ -44,11 +208,13 @@ func runFFmpegProcess(ffmpegInputReader *io.PipeReader, ffmpegOutputWriter *io.P
 			Output("pipe:1", ffmpeg.KwArgs{
 				"format":    "rawvideo",
 				"pix_fmt":   "rgba",
-				"s":         fmt.Sprintf("%dx%d", videoWidth, videoHeight),
+				"s":         fmt.Sprintf("%dx%d", d.width, d.height),
 				"flags":     "low_delay",
 				"fflags":    "+nobuffer",
 				"avioflags": "direct",
-				"r":         "30",
+				// Output every picture as it is decoded instead of pacing them by
+				// timestamps, which raw H.264 does not carry.
+				"vsync": "passthrough",
 			}).
 			OverWriteOutput().
 			WithInput(ffmpegInputReader).
@@ -56,6 +222,9 @@ func runFFmpegProcess(ffmpegInputReader *io.PipeReader, ffmpegOutputWriter *io.P
 			WithErrorOutput(stderr).
 			Run()
 
+		if d.closed.Load() {
+			return
+		}
 		if err != nil {
 			log.Printf("FFmpeg process error: %v\nFFmpeg stderr: %s", err, stderr.String())
 			time.Sleep(2 * time.Second)
@@ -68,15 +237,15 @@ func runFFmpegProcess(ffmpegInputReader *io.PipeReader, ffmpegOutputWriter *io.P
 	}
 }
 
-func readFFmpegOutputToBuffer(ffmpegOutputReader *io.PipeReader, rawFrameBuffer chan []byte) {
+func (d *ffmpegDecoder) readFrames(ffmpegOutputReader *io.PipeReader) {
 	log.Println("FFmpeg output reader goroutine starting...")
 	defer log.Println("FFmpeg output reader goroutine stopped.")
-	defer close(rawFrameBuffer)
 
-	frameBufferBytes := make([]byte, frameSizeBytes)
 	for {
-		n, err := io.ReadFull(ffmpegOutputReader, frameBufferBytes)
+		frame := newVideoFrame(d.width, d.height)
+		n, err := io.ReadFull(ffmpegOutputReader, frame.img.Pix)
 		if err != nil {
+			frame.release()
 			if err == io.EOF || err == io.ErrClosedPipe {
 				log.Println("FFmpeg output stream EOF or pipe closed.")
 			} else {
@@ -85,54 +254,23 @@ func readFFmpegOutputToBuffer(ffmpegOutputReader *io.PipeReader, rawFrameBuffer
 			return
 		}
 
-		if n != frameSizeBytes {
-			log.Printf("Warning: Read %d bytes from FFmpeg, expected %d. Skipping frame.", n, frameSizeBytes)
-			continue
-		}
-
-		frameDataCopy := make([]byte, frameSizeBytes)
-		copy(frameDataCopy, frameBufferBytes)
-
-		select {
-		case rawFrameBuffer <- frameDataCopy:
-
-		default:
-
-		}
-	}
-}
-
-func processRawFramesToImage(rawFrameBuffer chan []byte, frameImageData chan image.Image) {
-	log.Println("Raw frame to image processor goroutine starting...")
-	defer log.Println("Raw frame to image processor goroutine stopped.")
-
-	for rawFrameData := range rawFrameBuffer {
-		if len(rawFrameData) != frameSizeBytes {
-			log.Printf("Warning: Received raw frame data of unexpected size: %d (expected %d) in processor", len(rawFrameData), frameSizeBytes)
-			continue
-		}
-
-		img := &image.RGBA{
-			Pix:    rawFrameData,
-			Stride: videoWidth * bytesPerPixel,
-			Rect:   image.Rect(0, 0, videoWidth, videoHeight),
-		}
-
+		var received time.Time
 		select {
-		case frameImageData <- img:
-
+		case received = <-d.received:
 		default:
-
 		}
+		deliverFrame(frame, received)
 	}
 }
 
-func drawFrames(imageCanvas *canvas.Image, frameImageChan chan image.Image, fpsDisplayLabel *widget.Label) {
+// drawFrames shows the newest frame on every UI refresh. A frame stays in use until the
+// one after it is shown, as Fyne may still be painting it, and then goes back to the pool.
+func drawFrames(imageCanvas *canvas.Image, frames chan *videoFrame, fpsDisplayLabel *widget.Label) {
 	log.Println("Frame drawing goroutine starting...")
 	defer log.Println("Frame drawing goroutine stopped.")
 
 	var frameCountSinceLastFPSCalc int64
-	var lastImageRendered image.Image
+	var pending, shown, previous *videoFrame
 	lastFPSTime := time.Now()
 
 	uiRefreshTicker := time.NewTicker(16 * time.Millisecond)
@@ -143,22 +281,31 @@ func drawFrames(imageCanvas *canvas.Image, frameImageChan chan image.Image, fpsD
 
 	for {
 		select {
-		case img, ok := <-frameImageChan:
+		case frame, ok := <-frames:
 			if !ok {
-				log.Println("frameImageChan closed. Exiting drawFrames.")
+				log.Println("Frame channel closed. Exiting drawFrames.")
 				if fpsDisplayLabel != nil {
 					fpsDisplayLabel.SetText("FPS: N/A (Stream Ended)")
 				}
 				return
 			}
-			lastImageRendered = img
+			if pending != nil {
+				// Never shown, a newer frame came before the refresh. This is not counted
+				// as dropped, as frame rates above the refresh rate would look congested.
+				pending.release()
+			}
+			pending = frame
 			frameCountSinceLastFPSCalc++
 
 		case <-uiRefreshTicker.C:
 
-			if lastImageRendered != nil && imageCanvas != nil {
-				imageCanvas.Image = lastImageRendered
+			if pending != nil && imageCanvas != nil {
+				imageCanvas.Image = pending.img
 				imageCanvas.Refresh()
+				if previous != nil {
+					previous.release()
+				}
+				previous, shown, pending = shown, pending, nil
 			}
 
 		case <-fpsDisplayUpdateTicker.C:
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	pb "control_grpc/gen/proto"
+	"fyne.io/fyne/v2/widget"
+)
+
+// videoStatsInterval is how often playback statistics are reported to the host, which
+// adapts the video to them.
+const videoStatsInterval = 2 * time.Second
+
+const autoQualityLabel = "Auto"
+
+var (
+	// requestedVideo is what the client negotiates in the init message, set from the
+	// command line flags.
+	requestedVideo *pb.VideoSettings
+	// lastPingRTT is the latest Ping round trip in nanoseconds, reported with the stats.
+	lastPingRTT atomic.Int64
+
+	videoLabel *widget.Label
+	// videoLabelMu guards what videoLabel shows: the host's latest settings and the
+	// size of its frames and of the display they show.
+	videoLabelMu  sync.Mutex
+	shownSettings *pb.VideoSettings
+	shownCodec    string
+	shownFrame    [2]int32
+	shownCapture  [2]int32
+	// shownDecode is the decode latency and frames dropped in the latest stats period.
+	shownDecode string
+)
+
+// videoQualityPreset is a fixed quality offered in the picker besides "Auto".
+type videoQualityPreset struct {
+	label                             string
+	width, height, framerate, bitrate int32
+}
+
+var videoQualityPresets = []videoQualityPreset{
+	{"1080p 60 fps", 1920, 1080, 60, 6000},
+	{"1080p 30 fps", 1920, 1080, 30, 3000},
+	{"720p 30 fps", 1280, 720, 30, 1800},
+	{"540p 15 fps", 960, 540, 15, 700},
+}
+
+// parseVideoSize reads a -videoSize value such as "1280x720", or "native" which
+// returns 0x0.
+func parseVideoSize(s string) (int, int, error) {
+	if s == "native" {
+		return 0, 0, nil
+	}
+	var w, h int
+	if _, err := fmt.Sscanf(s, "%dx%d", &w, &h); err != nil || w <= 0 || h <= 0 {
+		return 0, 0, fmt.Errorf("invalid video size %q, expected native or WIDTHxHEIGHT such as 1920x1080", s)
+	}
+	return w, h, nil
+}
+
+// newVideoQualitySelect returns a picker that asks the host for another quality
+// mid-session. "Auto" restores the negotiated settings and lets the host adapt them.
+func newVideoQualitySelect(events chan<- *pb.FeedRequest) *widget.Select {
+	options := []string{autoQualityLabel}
+	for _, p := range videoQualityPresets {
+		options = append(options, p.label)
+	}
+	sel := widget.NewSelect(options, nil)
+	if requestedVideo.GetAdaptive() {
+		sel.SetSelected(autoQualityLabel)
+	}
+	sel.OnChanged = func(label string) {
+		settings := requestedVideo
+		for _, p := range videoQualityPresets {
+			if p.label == label {
+				settings = &pb.VideoSettings{Width: p.width, Height: p.height, Framerate: p.framerate, BitrateKbps: p.bitrate}
+			}
+		}
+		log.Printf("INFO: Requesting video quality %q", label)
+		req := &pb.FeedRequest{Message: "video_settings", VideoSettings: settings, Timestamp: time.Now().UnixNano()}
+		select {
+		case events <- req:
+		default:
+			log.Println("Video settings request dropped (inputEvents channel full)")
+		}
+	}
+	return sel
+}
+
+// showVideoSettings displays the settings the host reports encoding at.
+func showVideoSettings(settings *pb.VideoSettings) {
+	if settings == nil {
+		return
+	}
+	log.Printf("INFO: Host video is now up to %dx%d at %d fps, %d kbps (adaptive: %t)", settings.GetWidth(), settings.GetHeight(), settings.GetFramerate(), settings.GetBitrateKbps(), settings.GetAdaptive())
+	videoLabelMu.Lock()
+	shownSettings = settings
+	videoLabelMu.Unlock()
+	updateVideoLabel()
+}
+
+// showFrameSize displays the codec and size of the host's frames, and whether they are
+// scaled down from the display's native size.
+func showFrameSize(frame *pb.FeedResponse) {
+	log.Printf("INFO: Host frames are %s %dx%d (%s), from a %dx%d display", frame.GetCodec(), frame.GetWidth(), frame.GetHeight(), frame.GetHwAccel(), frame.GetCaptureWidth(), frame.GetCaptureHeight())
+	videoLabelMu.Lock()
+	shownCodec = strings.ToUpper(frame.GetCodec())
+	if format, ok := strings.CutPrefix(frame.GetContentType(), "image/"); ok {
+		shownCodec = strings.ToUpper(format) + " tiles"
+	}
+	shownFrame = [2]int32{frame.GetWidth(), frame.GetHeight()}
+	shownCapture = [2]int32{frame.GetCaptureWidth(), frame.GetCaptureHeight()}
+	videoLabelMu.Unlock()
+	updateVideoLabel()
+}
+
+func updateVideoLabel() {
+	if videoLabel == nil {
+		return
+	}
+	videoLabelMu.Lock()
+	defer videoLabelMu.Unlock()
+	text := "Video:"
+	if shownCodec != "" {
+		text += " " + shownCodec
+	}
+	if shownFrame[0] > 0 {
+		text += fmt.Sprintf(" %dx%d", shownFrame[0], shownFrame[1])
+		if shownFrame == shownCapture {
+			text += " (native)"
+		} else if shownCapture[0] > 0 {
+			text += fmt.Sprintf(" of %dx%d", shownCapture[0], shownCapture[1])
+		}
+	}
+	if shownSettings != nil {
+		text += fmt.Sprintf(" %d fps %d kbps", shownSettings.GetFramerate(), shownSettings.GetBitrateKbps())
+	}
+	if shownDecode != "" {
+		text += ", " + shownDecode
+	}
+	videoLabel.SetText(text)
+}
+
+// reportVideoStats sends the frames decoded and dropped since the previous report,
+// with the latest round trip, until ctx is done. The decode latency and drops are
+// also shown with the video's details.
+func reportVideoStats(ctx context.Context, events chan<- *pb.FeedRequest) {
+	ticker := time.NewTicker(videoStatsInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if ctx.Err() != nil {
+				return
+			}
+			stats := &pb.VideoStats{
+				FramesDecoded: int32(framesDecoded.Swap(0)),
+				FramesDropped: int32(framesDropped.Swap(0)),
+				RttMs:         float64(lastPingRTT.Load()) / float64(time.Millisecond),
+			}
+			showDecodeStats(stats)
+			req := &pb.FeedRequest{Message: "video_stats", VideoStats: stats, Timestamp: time.Now().UnixNano()}
+			select {
+			case events <- req:
+			default:
+				log.Println("Video stats report dropped (inputEvents channel full)")
+			}
+		}
+	}
+}
+
+// showDecodeStats displays the decode latency and drops of a stats period.
+func showDecodeStats(stats *pb.VideoStats) {
+	avg, longest := takeDecodeLatency()
+	text := fmt.Sprintf("decode %d ms (max %d)", avg.Milliseconds(), longest.Milliseconds())
+	if stats.GetFramesDropped() > 0 {
+		text += fmt.Sprintf(", %d of %d dropped", stats.GetFramesDropped(), stats.GetFramesDecoded())
+		log.Printf("INFO: Video: %d of %d frames dropped, decode latency %v (max %v)", stats.GetFramesDropped(), stats.GetFramesDecoded(), avg, longest)
+	}
+	videoLabelMu.Lock()
+	shownDecode = text
+	videoLabelMu.Unlock()
+	updateVideoLabel()
+}
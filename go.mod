@@ -4,13 +4,15 @@ go 1.23.6
 
 require (
 	fyne.io/fyne/v2 v2.5.4
-	github.com/StackExchange/wmi v1.2.1
+	github.com/fsnotify/fsnotify v1.7.0
 	github.com/go-vgo/robotgo v0.110.5
 	github.com/google/uuid v1.6.0
 	github.com/iamacarpet/go-winpty v1.0.4
 	github.com/kbinani/screenshot v0.0.0-20250118074034-a3924b7bbc8c
+	github.com/klauspost/compress v1.18.0
 	github.com/u2takey/ffmpeg-go v0.5.0
 	golang.org/x/crypto v0.32.0
+	golang.org/x/sys v0.29.0
 	google.golang.org/grpc v1.70.0
 	google.golang.org/protobuf v1.36.5
 )
@@ -23,7 +25,6 @@ require (
 	github.com/dblohm7/wingoes v0.0.0-20240820181039-f2b84150679e // indirect
 	github.com/ebitengine/purego v0.8.0 // indirect
 	github.com/fredbi/uri v1.1.0 // indirect
-	github.com/fsnotify/fsnotify v1.7.0 // indirect
 	github.com/fyne-io/gl-js v0.0.0-20220119005834-d2da28d9ccfe // indirect
 	github.com/fyne-io/glfw-js v0.0.0-20241126112943-313d8a0fe1d0 // indirect
 	github.com/fyne-io/image v0.0.0-20220602074514-4956b0afb3d2 // indirect
@@ -70,7 +71,6 @@ require (
 	golang.org/x/image v0.21.0 // indirect
 	golang.org/x/mobile v0.0.0-20231127183840-76ac6878050a // indirect
 	golang.org/x/net v0.34.0 // indirect
-	golang.org/x/sys v0.29.0 // indirect
 	golang.org/x/text v0.22.0 // indirect
 	google.golang.org/genproto/googleapis/rpc v0.0.0-20241202173237-19429a94021a // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"log"
+	"math"
+	"sync"
+	"time"
+
+	pb "control_grpc/gen/proto"
+	"control_grpc/server/screen"
+)
+
+// Limits of the video a client can negotiate.
+const (
+	minVideoWidth, maxVideoWidth   = 320, 7680
+	minVideoHeight, maxVideoHeight = 180, 4320
+	minVideoFramerate              = 5
+	maxVideoFramerate              = 60
+	minVideoBitrateKbps            = 250
+	maxVideoBitrateKbps            = 20000
+)
+
+// adaptiveStep scales the negotiated settings down.
+type adaptiveStep struct {
+	scale, framerate, bitrate float64
+}
+
+// adaptiveLadder goes from the negotiated settings down to the lowest quality the
+// controller uses. Bit rate is lowered first and frame rate last, as choppy input
+// feedback is what users notice most.
+var adaptiveLadder = []adaptiveStep{
+	{scale: 1, framerate: 1, bitrate: 1},
+	{scale: 1, framerate: 1, bitrate: 0.6},
+	{scale: 0.75, framerate: 1, bitrate: 0.4},
+	{scale: 0.5, framerate: 1, bitrate: 0.25},
+	{scale: 0.5, framerate: 0.5, bitrate: 0.15},
+}
+
+const (
+	// A report is congested when the client dropped more than congestionDropRatio of
+	// its frames, or the round trip grew by more than congestionQueueingMs over the
+	// session's lowest, meaning the video is queueing up on the way.
+	congestionDropRatio  = 0.1
+	congestionQueueingMs = 150
+	healthyDropRatio     = 0.02
+	healthyQueueingMs    = 50
+	// adaptiveSettleTime is how long reports are ignored after a change, as restarting
+	// ffmpeg stalls the video for a moment.
+	adaptiveSettleTime = 4 * time.Second
+	// adaptiveStepUpAfter is how long the connection must stay healthy before the
+	// quality is raised again.
+	adaptiveStepUpAfter = 15 * time.Second
+)
+
+// negotiateVideoSettings clamps the settings a client asked for to what the host
+// supports. Clients that ask for nothing get the defaults, adapted to the connection,
+// and a size of 0x0 asks for the display's native size.
+func negotiateVideoSettings(req *pb.VideoSettings) (screen.VideoSettings, bool) {
+	settings := screen.DefaultVideoSettings
+	if req == nil {
+		return settings, true
+	}
+	if req.GetWidth() == 0 && req.GetHeight() == 0 {
+		settings.Width, settings.Height = maxVideoWidth, maxVideoHeight
+	} else if req.GetWidth() > 0 && req.GetHeight() > 0 {
+		settings.Width = evenDimension(float64(clampInt(int(req.GetWidth()), minVideoWidth, maxVideoWidth)))
+		settings.Height = evenDimension(float64(clampInt(int(req.GetHeight()), minVideoHeight, maxVideoHeight)))
+	}
+	if req.GetFramerate() > 0 {
+		settings.Framerate = clampInt(int(req.GetFramerate()), minVideoFramerate, maxVideoFramerate)
+	}
+	if req.GetBitrateKbps() > 0 {
+		settings.BitrateKbps = clampInt(int(req.GetBitrateKbps()), minVideoBitrateKbps, maxVideoBitrateKbps)
+	}
+	return settings, req.GetAdaptive()
+}
+
+func clampInt(v, lo, hi int) int {
+	return min(max(v, lo), hi)
+}
+
+// evenDimension rounds a size down to an even number, which yuv420p requires.
+func evenDimension(v float64) int {
+	return max(int(v)&^1, 2)
+}
+
+func videoSettingsInfo(s screen.VideoSettings, adaptive bool) *pb.VideoSettings {
+	return &pb.VideoSettings{
+		Width:       int32(s.Width),
+		Height:      int32(s.Height),
+		Framerate:   int32(s.Framerate),
+		BitrateKbps: int32(s.BitrateKbps),
+		Adaptive:    adaptive,
+	}
+}
+
+// adaptiveVideo picks the video settings of a GetFeed session. It starts at the
+// negotiated settings and, when the client allows it, steps down the ladder while the
+// client reports congestion and back up once the connection has been healthy a while.
+type adaptiveVideo struct {
+	mu           sync.Mutex
+	target       screen.VideoSettings // negotiated with the client
+	adaptive     bool
+	step         int
+	lastChange   time.Time
+	healthySince time.Time // zero unless the latest reports were healthy
+	minRTT       float64
+	changed      bool // the client has not been told about the settings yet
+}
+
+func newAdaptiveVideo(req *pb.VideoSettings, now time.Time) *adaptiveVideo {
+	a := &adaptiveVideo{}
+	a.configureLocked(req, now)
+	return a
+}
+
+func (a *adaptiveVideo) configureLocked(req *pb.VideoSettings, now time.Time) {
+	a.target, a.adaptive = negotiateVideoSettings(req)
+	a.step = 0
+	a.lastChange = now
+	a.healthySince = time.Time{}
+	a.changed = true
+	log.Printf("Adaptive video: Negotiated %v (adaptive: %t)", a.target, a.adaptive)
+}
+
+// settings are what the screen should be encoded at now.
+func (a *adaptiveVideo) settings() screen.VideoSettings {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.currentLocked()
+}
+
+func (a *adaptiveVideo) currentLocked() screen.VideoSettings {
+	step := adaptiveLadder[a.step]
+	return screen.VideoSettings{
+		Width:       a.target.Width,
+		Height:      a.target.Height,
+		Scale:       step.scale,
+		Framerate:   max(int(math.Round(float64(a.target.Framerate)*step.framerate)), minVideoFramerate),
+		BitrateKbps: max(int(float64(a.target.BitrateKbps)*step.bitrate), minVideoBitrateKbps),
+	}
+}
+
+// request renegotiates with settings the client asked for mid-session. It returns the
+// settings to encode at and whether they changed.
+func (a *adaptiveVideo) request(req *pb.VideoSettings, now time.Time) (screen.VideoSettings, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	before := a.currentLocked()
+	a.configureLocked(req, now)
+	after := a.currentLocked()
+	return after, after != before
+}
+
+// observe takes a client's statistics report. It returns the settings to encode at and
+// whether they changed.
+func (a *adaptiveVideo) observe(stats *pb.VideoStats, now time.Time) (screen.VideoSettings, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	rtt := stats.GetRttMs()
+	if rtt > 0 && (a.minRTT == 0 || rtt < a.minRTT) {
+		a.minRTT = rtt
+	}
+	if !a.adaptive || now.Sub(a.lastChange) < adaptiveSettleTime {
+		return a.currentLocked(), false
+	}
+
+	var dropRatio float64
+	if total := stats.GetFramesDecoded() + stats.GetFramesDropped(); total > 0 {
+		dropRatio = float64(stats.GetFramesDropped()) / float64(total)
+	}
+	var queueing float64
+	if rtt > 0 {
+		queueing = rtt - a.minRTT
+	}
+
+	switch {
+	case dropRatio > congestionDropRatio || queueing > congestionQueueingMs:
+		a.healthySince = time.Time{}
+		if a.step < len(adaptiveLadder)-1 {
+			log.Printf("Adaptive video: Congested (%.0f%% of frames dropped, round trip %.0f ms over %.0f ms), lowering quality.", dropRatio*100, queueing, a.minRTT)
+			return a.stepLocked(a.step+1, now), true
+		}
+	case dropRatio <= healthyDropRatio && queueing <= healthyQueueingMs:
+		if a.healthySince.IsZero() {
+			a.healthySince = now
+		}
+		if a.step > 0 && now.Sub(a.healthySince) >= adaptiveStepUpAfter {
+			log.Printf("Adaptive video: Healthy for %v, raising quality.", now.Sub(a.healthySince).Round(time.Second))
+			return a.stepLocked(a.step-1, now), true
+		}
+	default:
+		a.healthySince = time.Time{}
+	}
+	return a.currentLocked(), false
+}
+
+func (a *adaptiveVideo) stepLocked(step int, now time.Time) screen.VideoSettings {
+	a.step = step
+	a.lastChange = now
+	a.healthySince = time.Time{}
+	a.changed = true
+	settings := a.currentLocked()
+	log.Printf("Adaptive video: Now encoding at %v", settings)
+	return settings
+}
+
+// takeChange returns the new settings once, to be sent with the next frame.
+func (a *adaptiveVideo) takeChange() *pb.VideoSettings {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if !a.changed {
+		return nil
+	}
+	a.changed = false
+	return videoSettingsInfo(a.currentLocked(), a.adaptive)
+}
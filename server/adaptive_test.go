@@ -0,0 +1,82 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	pb "control_grpc/gen/proto"
+	"control_grpc/server/screen"
+)
+
+func TestNegotiateVideoSettings(t *testing.T) {
+	if got, adaptive := negotiateVideoSettings(nil); got != screen.DefaultVideoSettings || !adaptive {
+		t.Errorf("no request: got %v (adaptive %t), want the adaptive defaults", got, adaptive)
+	}
+
+	got, adaptive := negotiateVideoSettings(&pb.VideoSettings{Width: 8000, Height: 101, Framerate: 120, BitrateKbps: 10})
+	want := screen.VideoSettings{Width: maxVideoWidth, Height: minVideoHeight, Scale: 1, Framerate: maxVideoFramerate, BitrateKbps: minVideoBitrateKbps}
+	if got != want || adaptive {
+		t.Errorf("out of range request: got %v (adaptive %t), want %v", got, adaptive, want)
+	}
+
+	got, _ = negotiateVideoSettings(&pb.VideoSettings{Width: 1365, Height: 767, Adaptive: true})
+	if got.Width != 1364 || got.Height != 766 || got.Framerate != screen.DefaultVideoSettings.Framerate {
+		t.Errorf("odd size: got %v, want 1364x766 at the default frame rate", got)
+	}
+
+	if got, _ = negotiateVideoSettings(&pb.VideoSettings{Framerate: 60}); got.Width != maxVideoWidth || got.Height != maxVideoHeight {
+		t.Errorf("native size: got %v, want bounds of %dx%d", got, maxVideoWidth, maxVideoHeight)
+	}
+}
+
+func TestAdaptiveVideo(t *testing.T) {
+	start := time.Now()
+	at := func(d time.Duration) time.Time { return start.Add(d) }
+	healthy := &pb.VideoStats{FramesDecoded: 60, RttMs: 20}
+	dropping := &pb.VideoStats{FramesDecoded: 40, FramesDropped: 20, RttMs: 20}
+	queueing := &pb.VideoStats{FramesDecoded: 60, RttMs: 400}
+
+	a := newAdaptiveVideo(&pb.VideoSettings{Width: 1920, Height: 1080, Framerate: 30, BitrateKbps: 4000, Adaptive: true}, start)
+	if info := a.takeChange(); info == nil || info.GetWidth() != 1920 || !info.GetAdaptive() {
+		t.Fatalf("negotiated settings were not announced: %v", info)
+	}
+	if info := a.takeChange(); info != nil {
+		t.Errorf("settings announced twice: %v", info)
+	}
+
+	a.observe(healthy, at(time.Second))
+	if _, changed := a.observe(dropping, at(2*time.Second)); changed {
+		t.Error("stepped down while settling after the start")
+	}
+	settings, changed := a.observe(dropping, at(5*time.Second))
+	if !changed || settings.BitrateKbps != 2400 || settings.Width != 1920 {
+		t.Errorf("dropped frames: got %v (changed %t), want 1920x1080 at 2400 kbps", settings, changed)
+	}
+	settings, changed = a.observe(queueing, at(10*time.Second))
+	if !changed || settings.Scale != 0.75 || settings.BitrateKbps != 1600 {
+		t.Errorf("queueing: got %v (changed %t), want three quarters of the size at 1600 kbps", settings, changed)
+	}
+	if info := a.takeChange(); info == nil || info.GetBitrateKbps() != 1600 {
+		t.Errorf("adapted settings were not announced: %v", info)
+	}
+
+	for d := 15 * time.Second; d < 29*time.Second; d += 2 * time.Second {
+		if _, changed := a.observe(healthy, at(d)); changed {
+			t.Fatalf("stepped up after %v of health", d-15*time.Second)
+		}
+	}
+	if settings, changed = a.observe(healthy, at(31*time.Second)); !changed || settings.Scale != 1 || settings.BitrateKbps != 2400 {
+		t.Errorf("healthy: got %v (changed %t), want one step back up", settings, changed)
+	}
+
+	// Settings the client asks for replace the negotiated ones and stop adapting.
+	settings, changed = a.request(&pb.VideoSettings{Width: 1280, Height: 720, Framerate: 60, BitrateKbps: 2000}, at(32*time.Second))
+	if !changed || settings != (screen.VideoSettings{Width: 1280, Height: 720, Scale: 1, Framerate: 60, BitrateKbps: 2000}) {
+		t.Errorf("request: got %v (changed %t)", settings, changed)
+	}
+	for d := 40 * time.Second; d < 60*time.Second; d += 5 * time.Second {
+		if _, changed := a.observe(dropping, at(d)); changed {
+			t.Fatal("adapted although the client turned it off")
+		}
+	}
+}
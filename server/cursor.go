@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"image/png"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/go-vgo/robotgo"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	pb "control_grpc/gen/proto"
+)
+
+// cursorPollInterval is how often the host cursor is checked for a remote cursor feed,
+// about once a frame at 60 fps.
+const cursorPollInterval = 16 * time.Millisecond
+
+// syncFeedStream serializes sends on a GetFeed stream, which the screen feed and the
+// cursor sender share, as gRPC streams must not be sent on concurrently.
+type syncFeedStream struct {
+	pb.RemoteControlService_GetFeedServer
+	mu sync.Mutex
+}
+
+func (s *syncFeedStream) Send(resp *pb.FeedResponse) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.RemoteControlService_GetFeedServer.Send(resp)
+}
+
+// sendCursor sends the host cursor's position, and its shape when it changes, apart
+// from the video, for a client that draws the cursor itself. Messages are only sent
+// when something changed.
+func sendCursor(stream pb.RemoteControlService_GetFeedServer, display *feedDisplay) error {
+	log.Println("Cursor sender goroutine started.")
+	defer log.Println("Cursor sender goroutine stopped.")
+
+	ticker := time.NewTicker(cursorPollInterval)
+	defer ticker.Stop()
+
+	var last *pb.CursorInfo
+	var lastHandle uintptr
+	shapeKnown := false
+	for {
+		select {
+		case <-ticker.C:
+		case <-stream.Context().Done():
+			return nil
+		}
+
+		mouseX, mouseY := robotgo.Location()
+		x, y, onDisplay := display.fromDesktop(mouseX, mouseY)
+		handle, shown, ok := hostCursor()
+		info := &pb.CursorInfo{X: x, Y: y, Visible: onDisplay && shown}
+		if ok && shown && (!shapeKnown || handle != lastHandle) {
+			shape, err := cursorShape(handle)
+			if err != nil {
+				log.Printf("Cursor sender: Failed to read the cursor shape: %v", err)
+			} else {
+				info.Shape = shape
+			}
+			// Failed shapes are not retried until the cursor changes, the client keeps
+			// drawing the previous one.
+			lastHandle, shapeKnown = handle, true
+		}
+		if last != nil && info.Shape == nil && info.X == last.X && info.Y == last.Y && info.Visible == last.Visible {
+			continue
+		}
+		last = info
+
+		if err := stream.Send(&pb.FeedResponse{Timestamp: time.Now().UnixNano(), Cursor: info}); err != nil {
+			s, ok := status.FromError(err)
+			if ok && (s.Code() == codes.Canceled || s.Code() == codes.Unavailable) {
+				log.Printf("Client disconnected or stream unavailable during send: %v", err)
+				return nil
+			}
+			log.Printf("Error sending the cursor to client: %v", err)
+			return status.Errorf(codes.Internal, "Failed to send cursor: %v", err)
+		}
+	}
+}
+
+// cursorShape reads the bitmap of a host cursor as PNG, with its hot spot.
+func cursorShape(handle uintptr) (*pb.CursorShape, error) {
+	img, hotX, hotY, err := hostCursorShape(handle)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	return &pb.CursorShape{Png: buf.Bytes(), HotX: int32(hotX), HotY: int32(hotY)}, nil
+}
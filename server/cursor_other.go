@@ -0,0 +1,19 @@
+//go:build !windows
+
+package main
+
+import (
+	"errors"
+	"image"
+)
+
+// hostCursor returns the cursor's handle and whether it is shown. Reading the cursor
+// shape is only supported on Windows, so ok is false here and clients draw their own
+// arrow at the position.
+func hostCursor() (handle uintptr, shown, ok bool) {
+	return 0, true, false
+}
+
+func hostCursorShape(handle uintptr) (image.Image, int, int, error) {
+	return nil, 0, 0, errors.New("reading the cursor shape is not supported on this platform")
+}
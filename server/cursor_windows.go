@@ -0,0 +1,188 @@
+//go:build windows
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+var (
+	user32 = windows.NewLazySystemDLL("user32.dll")
+	gdi32  = windows.NewLazySystemDLL("gdi32.dll")
+
+	procGetCursorInfo = user32.NewProc("GetCursorInfo")
+	procGetIconInfo   = user32.NewProc("GetIconInfo")
+	procGetDC         = user32.NewProc("GetDC")
+	procReleaseDC     = user32.NewProc("ReleaseDC")
+	procGetDIBits     = gdi32.NewProc("GetDIBits")
+	procGetObjectW    = gdi32.NewProc("GetObjectW")
+	procDeleteObject  = gdi32.NewProc("DeleteObject")
+)
+
+const cursorShowing = 0x00000001
+
+type cursorInfo struct {
+	size   uint32
+	flags  uint32
+	cursor windows.Handle
+	x, y   int32
+}
+
+type iconInfo struct {
+	icon         int32
+	hotX, hotY   uint32
+	mask, colour windows.Handle
+}
+
+type bitmap struct {
+	typ        int32
+	width      int32
+	height     int32
+	widthBytes int32
+	planes     uint16
+	bitsPixel  uint16
+	bits       uintptr
+}
+
+type bitmapInfoHeader struct {
+	size          uint32
+	width         int32
+	height        int32
+	planes        uint16
+	bitCount      uint16
+	compression   uint32
+	sizeImage     uint32
+	xPelsPerMeter int32
+	yPelsPerMeter int32
+	clrUsed       uint32
+	clrImportant  uint32
+}
+
+// hostCursor returns the handle of the current cursor, which changes with its shape,
+// and whether it is shown.
+func hostCursor() (handle uintptr, shown, ok bool) {
+	info := cursorInfo{size: uint32(unsafe.Sizeof(cursorInfo{}))}
+	if r, _, _ := procGetCursorInfo.Call(uintptr(unsafe.Pointer(&info))); r == 0 {
+		return 0, false, false
+	}
+	return uintptr(info.cursor), info.flags&cursorShowing != 0, true
+}
+
+// hostCursorShape reads a cursor's bitmaps into an image and its hot spot. Colour
+// cursors carry alpha, or else a mask of the transparent pixels; monochrome cursors
+// have an AND mask above an XOR mask, and the pixels they invert are drawn black.
+func hostCursorShape(handle uintptr) (image.Image, int, int, error) {
+	var info iconInfo
+	if r, _, err := procGetIconInfo.Call(handle, uintptr(unsafe.Pointer(&info))); r == 0 {
+		return nil, 0, 0, fmt.Errorf("GetIconInfo: %w", err)
+	}
+	defer procDeleteObject.Call(uintptr(info.mask))
+	if info.colour != 0 {
+		defer procDeleteObject.Call(uintptr(info.colour))
+	}
+
+	mask, w, h, err := bitmapPixels(info.mask)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	if info.colour == 0 {
+		// The AND mask is the top half and the XOR mask the bottom one.
+		h /= 2
+		img := image.NewNRGBA(image.Rect(0, 0, w, h))
+		for y := 0; y < h; y++ {
+			for x := 0; x < w; x++ {
+				and := mask[(y*w+x)*4] != 0
+				xor := mask[((y+h)*w+x)*4] != 0
+				switch {
+				case and && !xor:
+					// transparent
+				case !and && xor:
+					img.SetNRGBA(x, y, color.NRGBA{255, 255, 255, 255})
+				default:
+					img.SetNRGBA(x, y, color.NRGBA{0, 0, 0, 255})
+				}
+			}
+		}
+		return img, int(info.hotX), int(info.hotY), nil
+	}
+
+	pix, cw, ch, err := bitmapPixels(info.colour)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	hasAlpha := false
+	for i := 3; i < len(pix); i += 4 {
+		if pix[i] != 0 {
+			hasAlpha = true
+			break
+		}
+	}
+	img := image.NewNRGBA(image.Rect(0, 0, cw, ch))
+	for y := 0; y < ch; y++ {
+		for x := 0; x < cw; x++ {
+			i := (y*cw + x) * 4
+			c := color.NRGBA{R: pix[i+2], G: pix[i+1], B: pix[i], A: 255}
+			if hasAlpha {
+				// Colour cursors with alpha are premultiplied.
+				c.A = pix[i+3]
+				c.R, c.G, c.B = unpremultiply(c.R, c.A), unpremultiply(c.G, c.A), unpremultiply(c.B, c.A)
+			} else if x < w && y < h && mask[(y*w+x)*4] != 0 {
+				c.A = 0
+			}
+			img.SetNRGBA(x, y, c)
+		}
+	}
+	return img, int(info.hotX), int(info.hotY), nil
+}
+
+func unpremultiply(v, a uint8) uint8 {
+	if a == 0 {
+		return 0
+	}
+	return uint8(min(int(v)*255/int(a), 255))
+}
+
+// bitmapPixels reads a bitmap as top-down 32 bit BGRA rows, which monochrome bitmaps
+// are expanded to as black and white.
+func bitmapPixels(hbm windows.Handle) ([]byte, int, int, error) {
+	if hbm == 0 {
+		return nil, 0, 0, errors.New("cursor has no bitmap")
+	}
+	var bm bitmap
+	if r, _, _ := procGetObjectW.Call(uintptr(hbm), unsafe.Sizeof(bm), uintptr(unsafe.Pointer(&bm))); r == 0 {
+		return nil, 0, 0, errors.New("GetObject failed for the cursor bitmap")
+	}
+	w, h := int(bm.width), int(bm.height)
+	if w <= 0 || h <= 0 {
+		return nil, 0, 0, fmt.Errorf("cursor bitmap has no size (%dx%d)", w, h)
+	}
+
+	dc, _, _ := procGetDC.Call(0)
+	if dc == 0 {
+		return nil, 0, 0, errors.New("GetDC failed")
+	}
+	defer procReleaseDC.Call(0, dc)
+
+	// BITMAPINFO, with room for the colour table GetDIBits may write for monochrome bitmaps.
+	var header struct {
+		bitmapInfoHeader
+		colours [2]uint32
+	}
+	header.size = uint32(unsafe.Sizeof(bitmapInfoHeader{}))
+	header.width = int32(w)
+	header.height = -int32(h) // top-down
+	header.planes = 1
+	header.bitCount = 32
+	pix := make([]byte, w*h*4)
+	r, _, _ := procGetDIBits.Call(dc, uintptr(hbm), 0, uintptr(h), uintptr(unsafe.Pointer(&pix[0])), uintptr(unsafe.Pointer(&header)), 0)
+	if r == 0 {
+		return nil, 0, 0, errors.New("GetDIBits failed for the cursor bitmap")
+	}
+	return pix, w, h, nil
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sync"
+
+	"github.com/go-vgo/robotgo"
+
+	pb "control_grpc/gen/proto"
+	"control_grpc/server/screen"
+)
+
+// Clients report their view size in this coordinate space unless the init message says
+// otherwise, matching the scaled video.
+const (
+	defaultFeedWidth  = 1920
+	defaultFeedHeight = 1080
+)
+
+func (s *server) ListDisplays(ctx context.Context, req *pb.ListDisplaysRequest) (*pb.ListDisplaysResponse, error) {
+	resp := &pb.ListDisplaysResponse{}
+	for _, d := range screen.Displays() {
+		resp.Displays = append(resp.Displays, displayInfo(d.Index, d.Region, d.Primary))
+	}
+	return resp, nil
+}
+
+func displayInfo(index int, region screen.Region, primary bool) *pb.DisplayInfo {
+	return &pb.DisplayInfo{
+		Index:   int32(index),
+		X:       int32(region.X),
+		Y:       int32(region.Y),
+		Width:   int32(region.Width),
+		Height:  int32(region.Height),
+		Primary: primary,
+	}
+}
+
+// feedDisplay is the display a GetFeed session is showing. Input coordinates from the
+// client are relative to its video and are mapped into that display's part of the desktop.
+type feedDisplay struct {
+	mu      sync.Mutex
+	index   int
+	region  screen.Region
+	changed bool // the client has not been told about the display yet
+}
+
+// newFeedDisplay selects the display with the given index, falling back to the first
+// one when it does not exist, and returns its region to capture.
+func newFeedDisplay(index int) (*feedDisplay, screen.Region) {
+	d := &feedDisplay{index: -2} // no display yet, so the first set is a change
+	region, _ := d.set(index)
+	return d, region
+}
+
+// set selects another display and returns its region. It reports false when the
+// display was already selected.
+func (d *feedDisplay) set(index int) (screen.Region, bool) {
+	region, err := screen.DisplayRegion(index)
+	if err != nil {
+		log.Printf("Display selection: %v. Using the first display.", err)
+		index = 0
+		region, _ = screen.DisplayRegion(0)
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if index == d.index && region == d.region {
+		return region, false
+	}
+	d.index, d.region, d.changed = index, region, true
+	log.Printf("Display selection: Showing display %d (%dx%d at %d,%d)", index, region.Width, region.Height, region.X, region.Y)
+	return region, true
+}
+
+// toDesktop maps a point in the client's view of clientW by clientH to desktop pixels.
+func (d *feedDisplay) toDesktop(x, y float32, clientW, clientH int32) (int, int) {
+	d.mu.Lock()
+	region := d.region
+	d.mu.Unlock()
+	if region.Width <= 0 || region.Height <= 0 {
+		// The displays could not be enumerated, so the whole desktop is captured.
+		region.X, region.Y = 0, 0
+		region.Width, region.Height = robotgo.GetScreenSize()
+	}
+	if clientW <= 0 || clientH <= 0 {
+		clientW, clientH = defaultFeedWidth, defaultFeedHeight
+	}
+	return region.X + int(x*float32(region.Width)/float32(clientW)),
+		region.Y + int(y*float32(region.Height)/float32(clientH))
+}
+
+// fromDesktop maps a desktop point to fractions of the shown display, and reports
+// whether the point is on it.
+func (d *feedDisplay) fromDesktop(x, y int) (float32, float32, bool) {
+	d.mu.Lock()
+	region := d.region
+	d.mu.Unlock()
+	if region.Width <= 0 || region.Height <= 0 {
+		region.X, region.Y = 0, 0
+		region.Width, region.Height = robotgo.GetScreenSize()
+		if region.Width <= 0 || region.Height <= 0 {
+			return 0, 0, false
+		}
+	}
+	fx := float32(x-region.X) / float32(region.Width)
+	fy := float32(y-region.Y) / float32(region.Height)
+	return fx, fy, fx >= 0 && fx < 1 && fy >= 0 && fy < 1
+}
+
+// takeChange returns the newly selected display once, to be sent with the next frame.
+func (d *feedDisplay) takeChange() *pb.DisplayInfo {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if !d.changed {
+		return nil
+	}
+	d.changed = false
+	primary := false
+	if d.index != screen.AllDisplays {
+		primary = d.region.X == 0 && d.region.Y == 0
+	}
+	return displayInfo(d.index, d.region, primary)
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"sync"
+
+	pb "control_grpc/gen/proto"
+	"control_grpc/server/screen"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// feedCapture is what a GetFeed stream captures the screen with: a video encoder, or
+// tiles compared in process.
+type feedCapture interface {
+	SetRegion(region screen.Region) error
+	SetVideoSettings(settings screen.VideoSettings) error
+	RequestKeyframe() bool
+	Close()
+}
+
+// feedRegistry tracks the screen captures of running GetFeed streams, so that
+// RequestKeyframe can reach them.
+type feedRegistry struct {
+	mu    sync.Mutex
+	feeds map[string]feedCapture
+}
+
+func newFeedRegistry() *feedRegistry {
+	return &feedRegistry{feeds: make(map[string]feedCapture)}
+}
+
+// add registers a capture and returns the ID clients refer to it by.
+func (r *feedRegistry) add(capture feedCapture) (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	id := hex.EncodeToString(b)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.feeds[id] = capture
+	return id, nil
+}
+
+func (r *feedRegistry) remove(id string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.feeds, id)
+}
+
+// matching returns the feed with the ID, or every feed for an empty ID.
+func (r *feedRegistry) matching(id string) []feedCapture {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if id != "" {
+		if capture, ok := r.feeds[id]; ok {
+			return []feedCapture{capture}
+		}
+		return nil
+	}
+	captures := make([]feedCapture, 0, len(r.feeds))
+	for _, capture := range r.feeds {
+		captures = append(captures, capture)
+	}
+	return captures
+}
+
+// feedCodecs reads the codecs a client can decode, skipping those the host does not
+// know. Clients that list none only decode H.264.
+func feedCodecs(names []string) []screen.Codec {
+	var codecs []screen.Codec
+	for _, name := range names {
+		if codec, ok := screen.ParseCodec(name); ok {
+			codecs = append(codecs, codec)
+		} else {
+			log.Printf("Ignoring unknown codec %q offered by the client.", name)
+		}
+	}
+	if len(codecs) == 0 {
+		return []screen.Codec{screen.CodecH264}
+	}
+	return codecs
+}
+
+func (s *server) RequestKeyframe(ctx context.Context, req *pb.KeyframeRequest) (*pb.KeyframeResponse, error) {
+	captures := s.feeds.matching(req.GetFeedId())
+	if len(captures) == 0 && req.GetFeedId() != "" {
+		return nil, status.Errorf(codes.NotFound, "Feed %q not found", req.GetFeedId())
+	}
+	log.Printf("Keyframe requested for feed %q: %s", req.GetFeedId(), req.GetReason())
+	coming := len(captures) > 0
+	for _, capture := range captures {
+		if !capture.RequestKeyframe() {
+			coming = false
+		}
+	}
+	return &pb.KeyframeResponse{KeyframeComing: coming}, nil
+}
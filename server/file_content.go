@@ -0,0 +1,244 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"unicode/utf8"
+
+	pb "control_grpc/gen/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	defaultReadFileLength = 256 * 1024
+	// maxReadFileLength keeps responses well below the client's default 4 MB message limit.
+	maxReadFileLength = 2 * 1024 * 1024
+	// maxWriteFileSize keeps requests below the server's MaxRecvMsgSize.
+	maxWriteFileSize = 8 * 1024 * 1024
+)
+
+// writeFileMutex serialises WriteFile so the concurrency check and the replace happen
+// atomically with respect to other editors.
+var writeFileMutex sync.Mutex
+
+func (s *server) ReadFile(ctx context.Context, req *pb.ReadFileRequest) (*pb.ReadFileResponse, error) {
+	filePath := req.GetPath()
+	log.Printf("ReadFile request received for '%s' (offset: %d, length: %d)", filePath, req.GetOffset(), req.GetLength())
+	if filePath == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "File path must not be empty.")
+	}
+	if req.GetOffset() < 0 || req.GetLength() < 0 {
+		return nil, status.Errorf(codes.InvalidArgument, "Offset and length must not be negative.")
+	}
+	filePath = filepath.Clean(filePath)
+
+	file, err := os.Open(filePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, status.Errorf(codes.NotFound, "File not found: %v", err)
+		}
+		if os.IsPermission(err) {
+			return nil, status.Errorf(codes.PermissionDenied, "Permission denied: %v", err)
+		}
+		return nil, status.Errorf(codes.Internal, "Failed to open file: %v", err)
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "Failed to stat file: %v", err)
+	}
+	if info.IsDir() {
+		return nil, status.Errorf(codes.InvalidArgument, "Path '%s' is a directory.", filePath)
+	}
+
+	length := req.GetLength()
+	if length == 0 {
+		length = defaultReadFileLength
+	}
+	if length > maxReadFileLength {
+		length = maxReadFileLength
+	}
+	offset := req.GetOffset()
+	if offset > info.Size() {
+		offset = info.Size()
+	}
+	if remaining := info.Size() - offset; length > remaining {
+		length = remaining
+	}
+
+	data := make([]byte, length)
+	n, err := file.ReadAt(data, offset)
+	if err != nil && err != io.EOF {
+		return nil, status.Errorf(codes.Internal, "Failed to read file: %v", err)
+	}
+	data = data[:n]
+
+	resp := &pb.ReadFileResponse{
+		Data:         data,
+		TotalSize:    info.Size(),
+		ModifiedTime: info.ModTime().UnixNano(),
+		Eof:          offset+int64(n) >= info.Size(),
+	}
+	if offset == 0 && resp.Eof {
+		digest := sha256.Sum256(data)
+		resp.Sha256 = digest[:]
+	}
+
+	// Byte order marks only appear at the very start of the file.
+	head := data
+	if offset > 0 {
+		head = make([]byte, 3)
+		m, _ := file.ReadAt(head, 0)
+		head = head[:m]
+	}
+	resp.Encoding = detectTextEncoding(head, data, !resp.Eof)
+	if offset == 0 {
+		resp.MimeType = http.DetectContentType(data)
+	}
+	return resp, nil
+}
+
+// detectTextEncoding guesses how data is encoded. head is the start of the file, used
+// for byte order marks; truncated means data may end in the middle of a character.
+func detectTextEncoding(head, data []byte, truncated bool) pb.TextEncoding {
+	switch {
+	case bytes.HasPrefix(head, []byte{0xEF, 0xBB, 0xBF}):
+		return pb.TextEncoding_ENCODING_UTF8_BOM
+	case bytes.HasPrefix(head, []byte{0xFF, 0xFE}):
+		return pb.TextEncoding_ENCODING_UTF16_LE
+	case bytes.HasPrefix(head, []byte{0xFE, 0xFF}):
+		return pb.TextEncoding_ENCODING_UTF16_BE
+	}
+	if bytes.IndexByte(data, 0) >= 0 {
+		return pb.TextEncoding_ENCODING_BINARY
+	}
+
+	valid := data
+	if truncated {
+		// Drop a partial multi-byte sequence cut off by the read length.
+		for i := 0; i < utf8.UTFMax-1 && len(valid) > 0 && !utf8.Valid(valid); i++ {
+			valid = valid[:len(valid)-1]
+		}
+	}
+	if utf8.Valid(valid) {
+		return pb.TextEncoding_ENCODING_UTF8
+	}
+
+	var control int
+	for _, b := range data {
+		if b < 0x20 && b != '\t' && b != '\n' && b != '\r' && b != '\f' && b != 0x1b {
+			control++
+		}
+	}
+	if control*10 > len(data) {
+		return pb.TextEncoding_ENCODING_BINARY
+	}
+	return pb.TextEncoding_ENCODING_LATIN1
+}
+
+func (s *server) WriteFile(ctx context.Context, req *pb.WriteFileRequest) (*pb.WriteFileResponse, error) {
+	filePath := req.GetPath()
+	log.Printf("WriteFile request received for '%s' (%d bytes)", filePath, len(req.GetData()))
+	if filePath == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "File path must not be empty.")
+	}
+	if len(req.GetData()) > maxWriteFileSize {
+		return nil, status.Errorf(codes.InvalidArgument, "Content of %d bytes exceeds the %d byte limit.", len(req.GetData()), maxWriteFileSize)
+	}
+	filePath = filepath.Clean(filePath)
+	// Write through symlinks: renaming over a link would replace it with a regular file
+	// and leave its target unchanged.
+	realPath, err := resolveSymlinks(filePath, 0)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "Failed to resolve path: %v", err)
+	}
+	if realPath != filePath {
+		log.Printf("WriteFile: '%s' resolves to '%s', writing there.", filePath, realPath)
+		filePath = realPath
+	}
+
+	writeFileMutex.Lock()
+	defer writeFileMutex.Unlock()
+
+	mode := os.FileMode(0644)
+	info, err := os.Stat(filePath)
+	switch {
+	case err == nil:
+		if info.IsDir() {
+			return nil, status.Errorf(codes.InvalidArgument, "Path '%s' is a directory.", filePath)
+		}
+		mode = info.Mode().Perm()
+		if expected := req.GetExpectedModifiedTime(); expected != 0 && info.ModTime().UnixNano() != expected {
+			log.Printf("WriteFile: '%s' was modified since it was read.", filePath)
+			return nil, status.Errorf(codes.FailedPrecondition, "File was modified on the server since it was read.")
+		}
+		if expected := req.GetExpectedSha256(); len(expected) > 0 {
+			digest, err := hashFile(filePath)
+			if err != nil {
+				return nil, status.Errorf(codes.Internal, "Failed to hash existing file: %v", err)
+			}
+			if !bytes.Equal(digest, expected) {
+				log.Printf("WriteFile: content of '%s' changed since it was read.", filePath)
+				return nil, status.Errorf(codes.FailedPrecondition, "File content changed on the server since it was read.")
+			}
+		}
+	case os.IsNotExist(err):
+		if req.GetExpectedModifiedTime() != 0 || len(req.GetExpectedSha256()) > 0 {
+			return nil, status.Errorf(codes.FailedPrecondition, "File was deleted on the server since it was read.")
+		}
+		if !req.GetCreate() {
+			return nil, status.Errorf(codes.NotFound, "File not found: %v", err)
+		}
+	default:
+		return nil, status.Errorf(codes.Internal, "Failed to access file: %v", err)
+	}
+
+	// Write a sibling temp file and rename it over the original so readers never see
+	// a half-written file.
+	tempFile, err := os.CreateTemp(filepath.Dir(filePath), "."+filepath.Base(filePath)+".*.tmp")
+	if err != nil {
+		if os.IsPermission(err) {
+			return nil, status.Errorf(codes.PermissionDenied, "Permission denied: %v", err)
+		}
+		return nil, status.Errorf(codes.Internal, "Failed to create temporary file: %v", err)
+	}
+	tempPath := tempFile.Name()
+	_, err = tempFile.Write(req.GetData())
+	if err == nil {
+		err = tempFile.Sync()
+	}
+	if closeErr := tempFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tempPath, mode)
+	}
+	if err == nil {
+		err = os.Rename(tempPath, filePath)
+	}
+	if err != nil {
+		os.Remove(tempPath)
+		log.Printf("WriteFile: failed to write '%s': %v", filePath, err)
+		return nil, status.Errorf(codes.Internal, "Failed to write file: %v", err)
+	}
+
+	info, err = os.Stat(filePath)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "Failed to stat written file: %v", err)
+	}
+	digest := sha256.Sum256(req.GetData())
+	log.Printf("WriteFile: wrote %d bytes to '%s'", info.Size(), filePath)
+	return &pb.WriteFileResponse{
+		Size:         info.Size(),
+		ModifiedTime: info.ModTime().UnixNano(),
+		Sha256:       digest[:],
+	}, nil
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	pb "control_grpc/gen/proto"
+)
+
+func TestWriteFileThroughSymlink(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("creating symlinks needs extra privileges on Windows")
+	}
+	base := t.TempDir()
+	target := filepath.Join(base, "real", "app.conf")
+	link := filepath.Join(base, "app.conf")
+	writeTestFiles(t, map[string]string{target: "old"})
+	if err := os.Symlink(target, link); err != nil {
+		t.Fatal(err)
+	}
+	danglingTarget := filepath.Join(base, "real", "new.conf")
+	dangling := filepath.Join(base, "new.conf")
+	if err := os.Symlink(filepath.Join("real", "new.conf"), dangling); err != nil {
+		t.Fatal(err)
+	}
+
+	s := &server{}
+	testCases := []struct {
+		name   string
+		link   string
+		target string
+		create bool
+	}{
+		{"ExistingTarget", link, target, false},
+		{"DanglingLink", dangling, danglingTarget, true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := s.WriteFile(context.Background(), &pb.WriteFileRequest{Path: tc.link, Data: []byte("new"), Create: tc.create}); err != nil {
+				t.Fatalf("WriteFile(%s): %v", tc.link, err)
+			}
+			info, err := os.Lstat(tc.link)
+			if err != nil || info.Mode()&os.ModeSymlink == 0 {
+				t.Fatalf("%s should still be a symlink: %v %v", tc.link, info, err)
+			}
+			if got := readTestFile(t, tc.target); got != "new" {
+				t.Errorf("target content = %q, expected the new content", got)
+			}
+			entries, _ := os.ReadDir(filepath.Dir(tc.target))
+			for _, entry := range entries {
+				if filepath.Ext(entry.Name()) == ".tmp" {
+					t.Errorf("temporary file left behind: %s", entry.Name())
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"crypto/sha256"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+
+	pb "control_grpc/gen/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const manifestBatchSize = 500
+
+func (s *server) GetManifest(req *pb.ManifestRequest, stream pb.FileTransferService_GetManifestServer) error {
+	root := req.GetRootPath()
+	log.Printf("GetManifest request received for '%s' (hashes: %v, exclude: %v)", root, req.GetIncludeHashes(), req.GetExcludePatterns())
+	if root == "" {
+		return status.Errorf(codes.InvalidArgument, "Manifest root path must not be empty.")
+	}
+	root = filepath.Clean(root)
+	rootInfo, err := os.Stat(root)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return status.Errorf(codes.NotFound, "Folder not found: %v", err)
+		}
+		return status.Errorf(codes.Internal, "Failed to access folder: %v", err)
+	}
+	if !rootInfo.IsDir() {
+		return status.Errorf(codes.InvalidArgument, "Path '%s' is not a directory.", root)
+	}
+	filter, err := newFolderFilter(nil, req.GetExcludePatterns())
+	if err != nil {
+		return status.Errorf(codes.InvalidArgument, "Invalid filter: %v", err)
+	}
+
+	ctx := stream.Context()
+	batch := &pb.ManifestBatch{}
+	var total int
+	flush := func() error {
+		if len(batch.Entries) == 0 {
+			return nil
+		}
+		total += len(batch.Entries)
+		err := stream.Send(batch)
+		batch = &pb.ManifestBatch{}
+		return err
+	}
+
+	add := func(entry *pb.ManifestEntry) error {
+		batch.Entries = append(batch.Entries, entry)
+		if len(batch.Entries) >= manifestBatchSize {
+			return flush()
+		}
+		return nil
+	}
+	// skip reports an entry that could not be read, so that clients do not take what is
+	// missing from the manifest below it as deleted.
+	skip := func(rel string, isDir bool) error {
+		if err := add(&pb.ManifestEntry{RelativePath: rel, IsDir: isDir, Unreadable: true}); err != nil {
+			return err
+		}
+		if isDir {
+			return fs.SkipDir
+		}
+		return nil
+	}
+	// leaveOut reports an entry the host does not sync, for the same reason: the client's
+	// own copy must not be deleted as if the host had.
+	leaveOut := func(rel string, isDir bool, reason string) error {
+		if err := add(&pb.ManifestEntry{RelativePath: rel, IsDir: isDir, SkipReason: reason}); err != nil {
+			return err
+		}
+		if isDir {
+			return fs.SkipDir
+		}
+		return nil
+	}
+
+	walkErr := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		if err != nil && p == root {
+			// An empty manifest would read as a folder whose contents were all deleted.
+			return err
+		}
+		if p == root {
+			return nil
+		}
+		rel, relErr := filepath.Rel(root, p)
+		if relErr != nil {
+			return nil
+		}
+		rel = filepath.ToSlash(rel)
+		if err != nil {
+			log.Printf("GetManifest: skipping '%s': %v", p, err)
+			return skip(rel, d != nil && d.IsDir())
+		}
+		if filter.excluded(rel) {
+			if d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if !s.filePolicy.visible(p) {
+			return leaveOut(rel, d.IsDir(), "hidden by the host's file policy")
+		}
+		info, err := d.Info()
+		if err != nil {
+			log.Printf("GetManifest: could not stat '%s': %v. Skipping.", p, err)
+			return skip(rel, d.IsDir())
+		}
+		if !d.IsDir() && !info.Mode().IsRegular() {
+			// Symlinks and special files are not synced.
+			return leaveOut(rel, false, describeUnsyncedFile(info.Mode())+" on the host")
+		}
+
+		entry := &pb.ManifestEntry{
+			RelativePath: rel,
+			IsDir:        d.IsDir(),
+			ModifiedTime: info.ModTime().UnixNano(),
+		}
+		if !d.IsDir() {
+			entry.Size = info.Size()
+			if req.GetIncludeHashes() {
+				digest, err := hashFile(p)
+				if err != nil {
+					log.Printf("GetManifest: could not hash '%s': %v. Skipping.", p, err)
+					return skip(rel, false)
+				}
+				entry.Sha256 = digest
+			}
+		}
+		return add(entry)
+	})
+	if walkErr == nil {
+		walkErr = flush()
+	}
+	if walkErr != nil {
+		if ctx.Err() != nil {
+			return status.FromContextError(ctx.Err()).Err()
+		}
+		if _, ok := status.FromError(walkErr); ok {
+			return walkErr
+		}
+		return status.Errorf(codes.Internal, "Failed to build manifest: %v", walkErr)
+	}
+	log.Printf("GetManifest for '%s' finished: %d entries.", root, total)
+	return nil
+}
+
+// describeUnsyncedFile names the kind of a file that is not regular.
+func describeUnsyncedFile(mode fs.FileMode) string {
+	switch {
+	case mode&fs.ModeSymlink != 0:
+		return "a symlink"
+	case mode&fs.ModeNamedPipe != 0:
+		return "a named pipe"
+	case mode&fs.ModeSocket != 0:
+		return "a socket"
+	case mode&fs.ModeDevice != 0:
+		return "a device"
+	}
+	return "not a regular file"
+}
+
+func hashFile(path string) ([]byte, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, file); err != nil {
+		return nil, err
+	}
+	return hasher.Sum(nil), nil
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	pb "control_grpc/gen/proto"
+	"google.golang.org/grpc"
+)
+
+// manifestStream collects the batches GetManifest sends.
+type manifestStream struct {
+	grpc.ServerStream
+	entries map[string]*pb.ManifestEntry
+}
+
+func (s *manifestStream) Context() context.Context { return context.Background() }
+
+func (s *manifestStream) Send(batch *pb.ManifestBatch) error {
+	for _, entry := range batch.GetEntries() {
+		s.entries[entry.GetRelativePath()] = entry
+	}
+	return nil
+}
+
+func TestGetManifestReportsEntriesLeftOut(t *testing.T) {
+	root := t.TempDir()
+	writeTestFiles(t, map[string]string{
+		filepath.Join(root, "notes.txt"):             "notes",
+		filepath.Join(root, "secrets.key"):           "key",
+		filepath.Join(root, ".ssh", "id_ed25519"):    "key",
+		filepath.Join(root, "sub", "server.key"):     "key",
+		filepath.Join(root, "sub", "readme.md"):      "readme",
+		filepath.Join(root, "excluded", "build.log"): "log",
+	})
+	canSymlink := runtime.GOOS != "windows"
+	if canSymlink {
+		if err := os.Symlink("notes.txt", filepath.Join(root, "link")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	policy, err := newFilePolicy(root, ".ssh, *.key", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &server{filePolicy: policy}
+	stream := &manifestStream{entries: make(map[string]*pb.ManifestEntry)}
+	if err := s.GetManifest(&pb.ManifestRequest{RootPath: root, ExcludePatterns: []string{"excluded"}}, stream); err != nil {
+		t.Fatalf("GetManifest: %v", err)
+	}
+
+	expected := map[string]string{
+		"notes.txt":      "",
+		"sub":            "",
+		"sub/readme.md":  "",
+		"secrets.key":    "hidden by the host's file policy",
+		".ssh":           "hidden by the host's file policy",
+		"sub/server.key": "hidden by the host's file policy",
+	}
+	if canSymlink {
+		expected["link"] = "a symlink on the host"
+	}
+	for rel, reason := range expected {
+		entry, ok := stream.entries[rel]
+		if !ok {
+			t.Errorf("%s is missing from the manifest", rel)
+			continue
+		}
+		if entry.GetSkipReason() != reason {
+			t.Errorf("%s: skip reason = %q, expected %q", rel, entry.GetSkipReason(), reason)
+		}
+	}
+	// The client's own exclusions are left out silently, as it excludes them locally too,
+	// and nothing below a hidden folder is listed.
+	for rel := range stream.entries {
+		if _, ok := expected[rel]; !ok {
+			t.Errorf("unexpected manifest entry %s", rel)
+		}
+	}
+}
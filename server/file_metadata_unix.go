@@ -0,0 +1,48 @@
+//go:build !windows
+
+package main
+
+import (
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+var (
+	ownerNameCache   = make(map[uint32]string)
+	ownerNameCacheMu sync.Mutex
+)
+
+// fileOwner returns the user name owning the file, falling back to the numeric uid
+// when it cannot be resolved. Lookups are cached per uid.
+func fileOwner(path string, info os.FileInfo) string {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok || stat == nil {
+		return ""
+	}
+	uid := stat.Uid
+
+	ownerNameCacheMu.Lock()
+	defer ownerNameCacheMu.Unlock()
+	if name, ok := ownerNameCache[uid]; ok {
+		return name
+	}
+	name := strconv.FormatUint(uint64(uid), 10)
+	if u, err := user.LookupId(name); err == nil {
+		name = u.Username
+	}
+	ownerNameCache[uid] = name
+	return name
+}
+
+func isHiddenFile(name string, info os.FileInfo) bool {
+	return strings.HasPrefix(name, ".")
+}
+
+// fileAttributes has no POSIX equivalent; Permissions carries the mode instead.
+func fileAttributes(info os.FileInfo) string {
+	return ""
+}
@@ -0,0 +1,91 @@
+//go:build windows
+
+package main
+
+import (
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"syscall"
+
+	"golang.org/x/sys/windows"
+)
+
+var (
+	ownerNameCache   = make(map[string]string)
+	ownerNameCacheMu sync.Mutex
+)
+
+// fileOwner returns the owner of path as DOMAIN\user, resolved from the owner SID of
+// the file's security descriptor. Account lookups are cached per SID.
+func fileOwner(path string, info os.FileInfo) string {
+	sd, err := windows.GetNamedSecurityInfo(path, windows.SE_FILE_OBJECT, windows.OWNER_SECURITY_INFORMATION)
+	if err != nil {
+		return ""
+	}
+	sid, _, err := sd.Owner()
+	if err != nil || sid == nil {
+		return ""
+	}
+	sidString := sid.String()
+
+	ownerNameCacheMu.Lock()
+	defer ownerNameCacheMu.Unlock()
+	if name, ok := ownerNameCache[sidString]; ok {
+		return name
+	}
+	name := sidString
+	account, domain, _, err := sid.LookupAccount("")
+	if err == nil {
+		name = account
+		if domain != "" {
+			name = domain + `\` + account
+		}
+	} else {
+		log.Printf("Could not resolve owner SID %s: %v", sidString, err)
+	}
+	ownerNameCache[sidString] = name
+	return name
+}
+
+func win32Attributes(info os.FileInfo) (uint32, bool) {
+	data, ok := info.Sys().(*syscall.Win32FileAttributeData)
+	if !ok || data == nil {
+		return 0, false
+	}
+	return data.FileAttributes, true
+}
+
+func isHiddenFile(name string, info os.FileInfo) bool {
+	attrs, ok := win32Attributes(info)
+	if !ok {
+		return strings.HasPrefix(name, ".")
+	}
+	return attrs&windows.FILE_ATTRIBUTE_HIDDEN != 0
+}
+
+// fileAttributes renders the classic attribute letters shown by "attrib".
+func fileAttributes(info os.FileInfo) string {
+	attrs, ok := win32Attributes(info)
+	if !ok {
+		return ""
+	}
+	var b strings.Builder
+	for _, a := range []struct {
+		flag   uint32
+		letter byte
+	}{
+		{windows.FILE_ATTRIBUTE_READONLY, 'R'},
+		{windows.FILE_ATTRIBUTE_HIDDEN, 'H'},
+		{windows.FILE_ATTRIBUTE_SYSTEM, 'S'},
+		{windows.FILE_ATTRIBUTE_ARCHIVE, 'A'},
+		{windows.FILE_ATTRIBUTE_COMPRESSED, 'C'},
+		{windows.FILE_ATTRIBUTE_ENCRYPTED, 'E'},
+	} {
+		if attrs&a.flag != 0 {
+			b.WriteByte(a.letter)
+		}
+	}
+	return b.String()
+}
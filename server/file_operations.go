@@ -0,0 +1,302 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	pb "control_grpc/gen/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func (s *server) MakeDirectory(ctx context.Context, req *pb.MakeDirectoryRequest) (*pb.FileOperationResponse, error) {
+	dirPath := req.GetPath()
+	log.Printf("MakeDirectory request received for path: '%s' (parents: %v)", dirPath, req.GetParents())
+	if dirPath == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "Directory path must not be empty.")
+	}
+	dirPath = filepath.Clean(dirPath)
+
+	var err error
+	if req.GetParents() {
+		err = os.MkdirAll(dirPath, 0755)
+	} else {
+		err = os.Mkdir(dirPath, 0755)
+	}
+	return &pb.FileOperationResponse{Results: []*pb.PathResult{newPathResult(dirPath, dirPath, err)}}, nil
+}
+
+func (s *server) RenamePath(ctx context.Context, req *pb.RenamePathRequest) (*pb.FileOperationResponse, error) {
+	srcPath := req.GetPath()
+	newName := req.GetNewName()
+	log.Printf("RenamePath request received: '%s' -> '%s'", srcPath, newName)
+	if srcPath == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "Path must not be empty.")
+	}
+	if newName == "" || newName == "." || newName == ".." || strings.ContainsAny(newName, `/\`) {
+		return nil, status.Errorf(codes.InvalidArgument, "Invalid new name '%s'. It must be a plain file name without separators.", newName)
+	}
+	srcPath = filepath.Clean(srcPath)
+	destPath := filepath.Join(filepath.Dir(srcPath), newName)
+
+	err := ensureNotRoot(srcPath)
+	if err == nil {
+		err = ensureAbsent(destPath)
+	}
+	if err == nil {
+		err = os.Rename(srcPath, destPath)
+	}
+	return &pb.FileOperationResponse{Results: []*pb.PathResult{newPathResult(srcPath, destPath, err)}}, nil
+}
+
+func (s *server) MovePaths(ctx context.Context, req *pb.TransferPathsRequest) (*pb.FileOperationResponse, error) {
+	return transferPaths(ctx, req, movePath)
+}
+
+func (s *server) CopyPaths(ctx context.Context, req *pb.TransferPathsRequest) (*pb.FileOperationResponse, error) {
+	return transferPaths(ctx, req, copyPath)
+}
+
+func (s *server) DeletePaths(ctx context.Context, req *pb.DeletePathsRequest) (*pb.FileOperationResponse, error) {
+	log.Printf("DeletePaths request received for %d paths (recursive: %v)", len(req.GetPaths()), req.GetRecursive())
+	if len(req.GetPaths()) == 0 {
+		return nil, status.Errorf(codes.InvalidArgument, "No paths to delete.")
+	}
+
+	response := &pb.FileOperationResponse{}
+	for _, p := range req.GetPaths() {
+		if err := ctx.Err(); err != nil {
+			return nil, status.FromContextError(err).Err()
+		}
+		target := filepath.Clean(p)
+		err := ensureNotRoot(target)
+		if err == nil {
+			if req.GetRecursive() {
+				// RemoveAll reports success for missing paths; surface that as an error instead.
+				if _, err = os.Lstat(target); err == nil {
+					err = os.RemoveAll(target)
+				}
+			} else {
+				err = os.Remove(target)
+			}
+		}
+		response.Results = append(response.Results, newPathResult(target, "", err))
+	}
+	return response, nil
+}
+
+type transferFunc func(src, dest string) error
+
+func transferPaths(ctx context.Context, req *pb.TransferPathsRequest, transfer transferFunc) (*pb.FileOperationResponse, error) {
+	destDir := req.GetDestinationDir()
+	log.Printf("Transfer request received for %d paths into '%s' (overwrite: %v)", len(req.GetSourcePaths()), destDir, req.GetOverwrite())
+	if len(req.GetSourcePaths()) == 0 {
+		return nil, status.Errorf(codes.InvalidArgument, "No source paths given.")
+	}
+	if destDir == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "Destination directory must not be empty.")
+	}
+	destDir = filepath.Clean(destDir)
+	destInfo, err := os.Stat(destDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, status.Errorf(codes.NotFound, "Destination directory not found: %v", err)
+		}
+		return nil, status.Errorf(codes.Internal, "Failed to access destination directory: %v", err)
+	}
+	if !destInfo.IsDir() {
+		return nil, status.Errorf(codes.InvalidArgument, "Destination '%s' is not a directory.", destDir)
+	}
+
+	response := &pb.FileOperationResponse{}
+	for _, p := range req.GetSourcePaths() {
+		if err := ctx.Err(); err != nil {
+			return nil, status.FromContextError(err).Err()
+		}
+		src := filepath.Clean(p)
+		dest := filepath.Join(destDir, filepath.Base(src))
+
+		err := ensureNotRoot(src)
+		if err == nil && (src == dest || isWithin(dest, src)) {
+			err = fmt.Errorf("cannot place '%s' inside itself", src)
+		}
+		if err == nil && isWithin(src, dest) {
+			err = fmt.Errorf("cannot replace '%s' with '%s', which is inside it", dest, src)
+		}
+		if err == nil {
+			if _, err = os.Lstat(src); err == nil {
+				if req.GetOverwrite() {
+					err = replacePath(src, dest, transfer)
+				} else if err = ensureAbsent(dest); err == nil {
+					err = transfer(src, dest)
+				}
+			}
+		}
+		response.Results = append(response.Results, newPathResult(src, dest, err))
+	}
+	return response, nil
+}
+
+// replacePath transfers src next to dest first and only then swaps it into place, so
+// that dest survives a failed transfer. The old dest is removed last.
+func replacePath(src, dest string, transfer transferFunc) error {
+	staging, err := os.MkdirTemp(filepath.Dir(dest), ".transfer-*")
+	if err != nil {
+		return err
+	}
+	staged := filepath.Join(staging, "new")
+	replaced := filepath.Join(staging, "old")
+	if err := transfer(src, staged); err != nil {
+		os.RemoveAll(staging)
+		return err
+	}
+
+	_, err = os.Lstat(dest)
+	if err == nil {
+		err = os.Rename(dest, replaced)
+	} else if os.IsNotExist(err) {
+		err = nil
+	}
+	if err == nil {
+		if err = os.Rename(staged, dest); err != nil {
+			if restoreErr := os.Rename(replaced, dest); restoreErr != nil && !os.IsNotExist(restoreErr) {
+				log.Printf("Failed to restore '%s' from '%s': %v", dest, replaced, restoreErr)
+				return err
+			}
+		}
+	}
+	if err != nil {
+		if _, statErr := os.Lstat(src); os.IsNotExist(statErr) {
+			// A move consumed the source, put it back rather than delete it with the staging directory.
+			if restoreErr := movePath(staged, src); restoreErr != nil {
+				log.Printf("Failed to move '%s' back to '%s', leaving it in place: %v", staged, src, restoreErr)
+				return err
+			}
+		}
+	}
+	if removeErr := os.RemoveAll(staging); removeErr != nil {
+		log.Printf("Failed to remove staging directory '%s': %v", staging, removeErr)
+	}
+	return err
+}
+
+// renamePath is os.Rename, replaced in tests to simulate moves across volumes.
+var renamePath = os.Rename
+
+// movePath renames src to dest, falling back to copy-and-delete when they are on
+// different volumes. Other rename failures are returned, as deleting the source after
+// a copy that should not have been needed risks leaving both trees half-present.
+func movePath(src, dest string) error {
+	err := renamePath(src, dest)
+	if err == nil || !isCrossDeviceError(err) {
+		return err
+	}
+	log.Printf("Rename '%s' -> '%s' failed (%v). Falling back to copy and delete.", src, dest, err)
+	if copyErr := copyPath(src, dest); copyErr != nil {
+		os.RemoveAll(dest)
+		return copyErr
+	}
+	return os.RemoveAll(src)
+}
+
+// copyPath copies a file, symlink or directory tree from src to dest, preserving permissions.
+func copyPath(src, dest string) error {
+	return filepath.WalkDir(src, func(path string, d fs.DirEntry, walkErr error) error {
+		if walkErr != nil {
+			return walkErr
+		}
+		relPath, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dest, relPath)
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case d.IsDir():
+			return os.Mkdir(target, info.Mode().Perm()|0700)
+		case info.Mode()&os.ModeSymlink != 0:
+			linkTarget, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(linkTarget, target)
+		case info.Mode().IsRegular():
+			return copyFile(path, target, info.Mode().Perm())
+		default:
+			log.Printf("Skipping special file '%s' during copy.", path)
+			return nil
+		}
+	})
+}
+
+func copyFile(src, dest string, perm os.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dest, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+func ensureNotRoot(path string) error {
+	if filepath.Dir(path) == path {
+		return fmt.Errorf("refusing to modify filesystem root '%s'", path)
+	}
+	return nil
+}
+
+func ensureAbsent(path string) error {
+	if _, err := os.Lstat(path); err == nil {
+		return fmt.Errorf("'%s' already exists", filepath.Base(path))
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// isWithin reports whether path lies strictly inside dir.
+func isWithin(path, dir string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != "." && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+func newPathResult(path, newPath string, err error) *pb.PathResult {
+	if err != nil {
+		log.Printf("File operation on '%s' failed: %v", path, err)
+		return &pb.PathResult{Path: path, ErrorMessage: describeFileError(err)}
+	}
+	log.Printf("File operation on '%s' succeeded (new path: '%s')", path, newPath)
+	return &pb.PathResult{Path: path, Success: true, NewPath: newPath}
+}
+
+func describeFileError(err error) string {
+	switch {
+	case os.IsPermission(err):
+		return fmt.Sprintf("Permission denied: %v", err)
+	case os.IsNotExist(err):
+		return fmt.Sprintf("Path does not exist: %v", err)
+	case os.IsExist(err):
+		return fmt.Sprintf("Already exists: %v", err)
+	default:
+		return err.Error()
+	}
+}
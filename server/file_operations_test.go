@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"syscall"
+	"testing"
+
+	pb "control_grpc/gen/proto"
+)
+
+func writeTestFiles(t *testing.T, files map[string]string) {
+	t.Helper()
+	for path, content := range files {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func readTestFile(t *testing.T, path string) string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile(%s): %v", path, err)
+	}
+	return string(data)
+}
+
+func TestTransferPathsOverwrite(t *testing.T) {
+	base := t.TempDir()
+	src := filepath.Join(base, "src", "docs")
+	destDir := filepath.Join(base, "dest")
+	dest := filepath.Join(destDir, "docs")
+	writeTestFiles(t, map[string]string{filepath.Join(src, "new.txt"): "new"})
+
+	// The copy runs first, the move then replaces what it made.
+	for _, tc := range []struct {
+		name     string
+		transfer transferFunc
+	}{{"Copy", copyPath}, {"Move", movePath}} {
+		t.Run(tc.name, func(t *testing.T) {
+			writeTestFiles(t, map[string]string{filepath.Join(dest, "old.txt"): "old"})
+			req := &pb.TransferPathsRequest{SourcePaths: []string{src}, DestinationDir: destDir, Overwrite: true}
+			resp, err := transferPaths(context.Background(), req, tc.transfer)
+			if err != nil || !resp.GetResults()[0].GetSuccess() {
+				t.Fatalf("transferPaths: %v %v", err, resp)
+			}
+			if got := readTestFile(t, filepath.Join(dest, "new.txt")); got != "new" {
+				t.Errorf("new.txt = %q", got)
+			}
+			if _, err := os.Stat(filepath.Join(dest, "old.txt")); !os.IsNotExist(err) {
+				t.Errorf("the replaced destination should be gone: %v", err)
+			}
+			entries, _ := os.ReadDir(destDir)
+			if len(entries) != 1 {
+				t.Errorf("staging directories left behind in %s: %v", destDir, entries)
+			}
+		})
+	}
+	if _, err := os.Stat(src); !os.IsNotExist(err) {
+		t.Errorf("the moved source should be gone: %v", err)
+	}
+}
+
+func TestTransferPathsFailedOverwriteKeepsDestination(t *testing.T) {
+	base := t.TempDir()
+	src := filepath.Join(base, "src", "docs")
+	destDir := filepath.Join(base, "dest")
+	dest := filepath.Join(destDir, "docs")
+	writeTestFiles(t, map[string]string{
+		filepath.Join(src, "new.txt"):  "new",
+		filepath.Join(dest, "old.txt"): "old",
+	})
+
+	failing := func(src, dest string) error {
+		if err := copyPath(src, dest); err != nil {
+			return err
+		}
+		return syscall.EIO
+	}
+	req := &pb.TransferPathsRequest{SourcePaths: []string{src}, DestinationDir: destDir, Overwrite: true}
+	resp, err := transferPaths(context.Background(), req, failing)
+	if err != nil || resp.GetResults()[0].GetSuccess() {
+		t.Fatalf("transferPaths should report the failure: %v %v", err, resp)
+	}
+	if got := readTestFile(t, filepath.Join(dest, "old.txt")); got != "old" {
+		t.Errorf("the destination should be untouched after a failed transfer, old.txt = %q", got)
+	}
+	if entries, _ := os.ReadDir(destDir); len(entries) != 1 {
+		t.Errorf("staging directories left behind in %s: %v", destDir, entries)
+	}
+}
+
+func TestTransferPathsIntoItself(t *testing.T) {
+	base := t.TempDir()
+	foo := filepath.Join(base, "foo")
+	inner := filepath.Join(foo, "foo")
+	writeTestFiles(t, map[string]string{
+		filepath.Join(inner, "keep.txt"): "inner",
+		filepath.Join(foo, "top.txt"):    "outer",
+	})
+
+	testCases := []struct {
+		name    string
+		src     string
+		destDir string
+	}{
+		{"SameDirectory", foo, base},
+		{"IntoItself", foo, foo},
+		{"IntoDescendant", foo, inner},
+		// dest is base/foo, an ancestor of the source, which overwriting would delete first.
+		{"OverAncestor", inner, base},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			for _, transfer := range []transferFunc{copyPath, movePath} {
+				req := &pb.TransferPathsRequest{SourcePaths: []string{tc.src}, DestinationDir: tc.destDir, Overwrite: true}
+				resp, err := transferPaths(context.Background(), req, transfer)
+				if err != nil {
+					t.Fatalf("transferPaths: %v", err)
+				}
+				if resp.GetResults()[0].GetSuccess() {
+					t.Errorf("transferring %s into %s should fail", tc.src, tc.destDir)
+				}
+			}
+			if got := readTestFile(t, filepath.Join(inner, "keep.txt")); got != "inner" {
+				t.Errorf("keep.txt = %q", got)
+			}
+			if got := readTestFile(t, filepath.Join(foo, "top.txt")); got != "outer" {
+				t.Errorf("top.txt = %q", got)
+			}
+		})
+	}
+}
+
+func TestMovePathFallback(t *testing.T) {
+	crossDevice := syscall.EXDEV
+	if runtime.GOOS == "windows" {
+		crossDevice = syscall.Errno(17) // ERROR_NOT_SAME_DEVICE
+	}
+	testCases := []struct {
+		name       string
+		renameErr  error
+		expectMove bool
+	}{
+		{"CrossDevice", crossDevice, true},
+		{"PermissionDenied", syscall.EACCES, false},
+		{"Busy", syscall.EBUSY, false},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			base := t.TempDir()
+			src := filepath.Join(base, "a")
+			dest := filepath.Join(base, "b")
+			writeTestFiles(t, map[string]string{filepath.Join(src, "file.txt"): "data"})
+
+			renamePath = func(oldpath, newpath string) error {
+				return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: tc.renameErr}
+			}
+			defer func() { renamePath = os.Rename }()
+
+			err := movePath(src, dest)
+			_, srcErr := os.Stat(src)
+			_, destErr := os.Stat(dest)
+			if tc.expectMove {
+				if err != nil {
+					t.Fatalf("movePath: %v", err)
+				}
+				if !os.IsNotExist(srcErr) || readTestFile(t, filepath.Join(dest, "file.txt")) != "data" {
+					t.Errorf("expected a copy and delete, source: %v", srcErr)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatal("movePath should return the rename error")
+			}
+			if srcErr != nil || !os.IsNotExist(destErr) {
+				t.Errorf("nothing should be copied or deleted: source %v, destination %v", srcErr, destErr)
+			}
+		})
+	}
+}
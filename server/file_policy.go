@@ -0,0 +1,477 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	pb "control_grpc/gen/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// fileRoot is a directory shared with clients through FileTransferService.
+type fileRoot struct {
+	path     string // cleaned absolute path, as clients see it
+	realPath string // path with symlinks resolved, for containment checks
+	readOnly bool
+}
+
+// filePolicy limits FileTransferService to a set of shared roots. Every path a client
+// sends is checked by resolve before a handler sees it; see authorizeFileRequest.
+type filePolicy struct {
+	roots    []fileRoot
+	deny     *folderFilter // patterns no client may access, matched like archive excludes
+	readOnly bool          // reject every modification, whatever the roots allow
+}
+
+type fileAccess int
+
+const (
+	readAccess   fileAccess = iota
+	writeAccess             // create or change content below a root
+	removeAccess            // write access to a path that is moved, renamed or deleted itself
+)
+
+// newFilePolicy builds the policy from the -fileRoots, -fileDeny and -fileReadOnly
+// flags. rootsSpec lists directories separated by the OS path list separator, each
+// optionally suffixed with "=ro" or "=rw". Without roots every drive present at
+// startup ("/" outside Windows) is shared.
+func newFilePolicy(rootsSpec, denySpec string, readOnly bool) (*filePolicy, error) {
+	p := &filePolicy{readOnly: readOnly}
+	for _, spec := range filepath.SplitList(rootsSpec) {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+		var rootReadOnly bool
+		if i := strings.LastIndex(spec, "="); i >= 0 {
+			switch strings.ToLower(spec[i+1:]) {
+			case "ro":
+				rootReadOnly = true
+				spec = spec[:i]
+			case "rw":
+				spec = spec[:i]
+			}
+		}
+		root, err := newFileRoot(spec, rootReadOnly)
+		if err != nil {
+			return nil, err
+		}
+		p.roots = append(p.roots, root)
+	}
+	if len(p.roots) == 0 {
+		for _, path := range systemRoots() {
+			root, err := newFileRoot(path, false)
+			if err != nil {
+				log.Printf("Warning: skipping file root '%s': %v", path, err)
+				continue
+			}
+			p.roots = append(p.roots, root)
+		}
+	}
+
+	deny, err := newFolderFilter(nil, strings.Split(denySpec, ","))
+	if err != nil {
+		return nil, fmt.Errorf("deny patterns: %w", err)
+	}
+	p.deny = deny
+	return p, nil
+}
+
+func newFileRoot(path string, readOnly bool) (fileRoot, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return fileRoot{}, fmt.Errorf("file root %q: %w", path, err)
+	}
+	real, err := filepath.EvalSymlinks(abs)
+	if err != nil {
+		return fileRoot{}, fmt.Errorf("file root %q: %w", path, err)
+	}
+	info, err := os.Stat(real)
+	if err != nil {
+		return fileRoot{}, fmt.Errorf("file root %q: %w", path, err)
+	}
+	if !info.IsDir() {
+		return fileRoot{}, fmt.Errorf("file root %q is not a directory", path)
+	}
+	return fileRoot{path: abs, realPath: real, readOnly: readOnly}, nil
+}
+
+// systemRoots lists the drives on Windows and "/" elsewhere.
+func systemRoots() []string {
+	if runtime.GOOS != "windows" {
+		return []string{"/"}
+	}
+	var drives []string
+	for _, driveLetter := range "ABCDEFGHIJKLMNOPQRSTUVWXYZ" {
+		path := string(driveLetter) + ":" + string(os.PathSeparator)
+		if info, err := os.Stat(path); err == nil && info.IsDir() {
+			drives = append(drives, path)
+		}
+	}
+	return drives
+}
+
+func (p *filePolicy) String() string {
+	var parts []string
+	for _, root := range p.roots {
+		mode := "rw"
+		if root.readOnly || p.readOnly {
+			mode = "ro"
+		}
+		parts = append(parts, root.path+"="+mode)
+	}
+	summary := strings.Join(parts, ", ")
+	if len(p.deny.exclude) > 0 {
+		summary += fmt.Sprintf("; denied: %s", strings.Join(p.deny.exclude, ", "))
+	}
+	return summary
+}
+
+// containsPath reports whether path is dir or lies below it. filepath.Rel compares
+// case-insensitively on Windows.
+func containsPath(dir, path string) (string, bool) {
+	rel, err := filepath.Rel(dir, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", false
+	}
+	return rel, true
+}
+
+// rootFor returns the innermost root containing path. real selects whether path is
+// compared against the roots' symlink-resolved locations.
+func (p *filePolicy) rootFor(path string, real bool) (fileRoot, string, bool) {
+	var best fileRoot
+	var bestRel string
+	bestLen := -1
+	for _, root := range p.roots {
+		dir := root.path
+		if real {
+			dir = root.realPath
+		}
+		if rel, ok := containsPath(dir, path); ok && len(dir) > bestLen {
+			best, bestRel, bestLen = root, rel, len(dir)
+		}
+	}
+	return best, bestRel, bestLen >= 0
+}
+
+// isDenied reports whether relPath (relative to a root) or any folder above it matches
+// a deny pattern, so denying ".ssh" also hides everything inside it.
+func (p *filePolicy) isDenied(relPath string) bool {
+	if len(p.deny.exclude) == 0 || relPath == "." {
+		return false
+	}
+	parts := strings.Split(filepath.ToSlash(relPath), "/")
+	for i := range parts {
+		if p.deny.excluded(strings.Join(parts[:i+1], "/")) {
+			return true
+		}
+	}
+	return false
+}
+
+// resolve checks a client supplied path against the policy and returns it cleaned.
+// ".." elements are collapsed before the root check, and the path is checked again
+// with symlinks resolved so a link inside a root cannot reach outside of it.
+func (p *filePolicy) resolve(path string, access fileAccess) (string, error) {
+	if path == "" {
+		return "", status.Errorf(codes.InvalidArgument, "Path must not be empty.")
+	}
+	if !filepath.IsAbs(path) {
+		return "", status.Errorf(codes.InvalidArgument, "Path '%s' must be absolute.", path)
+	}
+	cleaned := filepath.Clean(path)
+	root, rel, ok := p.rootFor(cleaned, false)
+	if !ok {
+		return "", status.Errorf(codes.PermissionDenied, "Path '%s' is outside the folders shared by the host.", cleaned)
+	}
+	var real string
+	var err error
+	if access == removeAccess && rel != "." {
+		// Moving, renaming or deleting acts on a symlink itself, not on its target.
+		var realParent string
+		if realParent, err = resolveSymlinks(filepath.Dir(cleaned), 0); err == nil {
+			real = filepath.Join(realParent, filepath.Base(cleaned))
+		}
+	} else {
+		real, err = resolveSymlinks(cleaned, 0)
+	}
+	if err != nil {
+		return "", status.Errorf(codes.PermissionDenied, "Cannot resolve path '%s': %v", cleaned, err)
+	}
+	realRoot, realRel, ok := p.rootFor(real, true)
+	if !ok {
+		log.Printf("File policy: '%s' resolves to '%s' outside the shared folders.", cleaned, real)
+		return "", status.Errorf(codes.PermissionDenied, "Path '%s' leads outside the folders shared by the host.", cleaned)
+	}
+	if p.isDenied(rel) || p.isDenied(realRel) {
+		return "", status.Errorf(codes.PermissionDenied, "Access to '%s' is denied by the host.", cleaned)
+	}
+	if access == readAccess {
+		return cleaned, nil
+	}
+	if p.readOnly || root.readOnly || realRoot.readOnly {
+		return "", status.Errorf(codes.PermissionDenied, "'%s' is read-only.", cleaned)
+	}
+	if access == removeAccess && (rel == "." || realRel == ".") {
+		return "", status.Errorf(codes.PermissionDenied, "The shared folder '%s' itself cannot be moved, renamed or deleted.", cleaned)
+	}
+	return cleaned, nil
+}
+
+// resolveSymlinks is filepath.EvalSymlinks for paths that may not exist yet: the
+// longest existing prefix is resolved and the rest appended. Dangling symlinks are
+// followed to their target so writing through one cannot escape a root.
+func resolveSymlinks(path string, depth int) (string, error) {
+	if depth > 40 {
+		return "", fmt.Errorf("too many levels of symbolic links")
+	}
+	real, err := filepath.EvalSymlinks(path)
+	if err == nil {
+		return real, nil
+	}
+	if !os.IsNotExist(err) {
+		return "", err
+	}
+	if info, lerr := os.Lstat(path); lerr == nil && info.Mode()&os.ModeSymlink != 0 {
+		target, err := os.Readlink(path)
+		if err != nil {
+			return "", err
+		}
+		if !filepath.IsAbs(target) {
+			target = filepath.Join(filepath.Dir(path), target)
+		}
+		return resolveSymlinks(target, depth+1)
+	}
+	parent := filepath.Dir(path)
+	if parent == path {
+		return path, nil
+	}
+	realParent, err := resolveSymlinks(parent, depth)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(realParent, filepath.Base(path)), nil
+}
+
+// visible reports whether an entry found while walking or listing a resolved folder
+// may be shown. The folder itself already passed resolve, so only deny patterns matter.
+func (p *filePolicy) visible(path string) bool {
+	if p == nil || len(p.deny.exclude) == 0 {
+		return true
+	}
+	_, rel, ok := p.rootFor(path, false)
+	return ok && !p.isDenied(rel)
+}
+
+// checkSubtree rejects recursive operations on folders that contain denied entries,
+// which would otherwise copy or delete them on the client's behalf.
+func (p *filePolicy) checkSubtree(path string) error {
+	if len(p.deny.exclude) == 0 {
+		return nil
+	}
+	var denied string
+	filepath.WalkDir(path, func(entryPath string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if !p.visible(entryPath) {
+			denied = entryPath
+			return fs.SkipAll
+		}
+		return nil
+	})
+	if denied != "" {
+		return status.Errorf(codes.PermissionDenied, "'%s' contains '%s', which is denied by the host.", path, denied)
+	}
+	return nil
+}
+
+// checkRemovable rejects removing a folder that contains another shared folder,
+// which resolve cannot see from the outer path, or denied entries, as checkSubtree.
+func (p *filePolicy) checkRemovable(path string) error {
+	real, err := resolveSymlinks(path, 0)
+	if err != nil {
+		real = path
+	}
+	for _, root := range p.roots {
+		if isWithin(root.path, path) || isWithin(root.realPath, real) {
+			return status.Errorf(codes.PermissionDenied, "'%s' contains the shared folder '%s', which cannot be removed.", path, root.path)
+		}
+	}
+	return p.checkSubtree(path)
+}
+
+// rootNodes returns the shared roots as the top level of the file tree.
+func (p *filePolicy) rootNodes() []*pb.FSNode {
+	var nodes []*pb.FSNode
+	for _, root := range p.roots {
+		node := &pb.FSNode{
+			Path:        root.path,
+			Name:        root.path,
+			Type:        pb.FSNode_FOLDER,
+			HasChildren: true,
+			ReadOnly:    root.readOnly || p.readOnly,
+		}
+		if info, err := os.Stat(root.path); err == nil {
+			node.ModifiedTime = info.ModTime().UnixNano()
+			node.Permissions = info.Mode().String()
+			node.Owner = fileOwner(root.path, info)
+		} else {
+			log.Printf("Warning: Cannot stat file root '%s': %v", root.path, err)
+		}
+		nodes = append(nodes, node)
+	}
+	return nodes
+}
+
+// isReadOnly reports whether writes below path are rejected.
+func (p *filePolicy) isReadOnly(path string) bool {
+	if p.readOnly {
+		return true
+	}
+	root, _, ok := p.rootFor(path, false)
+	return !ok || root.readOnly
+}
+
+const fileTransferServicePrefix = "/control_grpc.FileTransferService/"
+
+func (s *server) fileAccessUnaryInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	if strings.HasPrefix(info.FullMethod, fileTransferServicePrefix) {
+		if err := s.authorizeFileRequest(info.FullMethod, req); err != nil {
+			return nil, err
+		}
+	}
+	return handler(ctx, req)
+}
+
+func (s *server) fileAccessStreamInterceptor(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if !strings.HasPrefix(info.FullMethod, fileTransferServicePrefix) {
+		return handler(srv, ss)
+	}
+	if err := s.checkFileSystemEnabled(info.FullMethod); err != nil {
+		return err
+	}
+	return handler(srv, &fileAccessStream{ServerStream: ss, server: s, method: info.FullMethod})
+}
+
+// fileAccessStream authorizes every message a streaming handler receives.
+type fileAccessStream struct {
+	grpc.ServerStream
+	server *server
+	method string
+}
+
+func (st *fileAccessStream) RecvMsg(m any) error {
+	if err := st.ServerStream.RecvMsg(m); err != nil {
+		return err
+	}
+	return st.server.authorizeFileRequest(st.method, m)
+}
+
+func (s *server) checkFileSystemEnabled(method string) error {
+	if !s.allowFileSystemAccess {
+		log.Printf("%s request rejected: file system access is disabled by the host.", strings.TrimPrefix(method, fileTransferServicePrefix))
+		return status.Errorf(codes.PermissionDenied, "File system access has been disabled by the host.")
+	}
+	return nil
+}
+
+// authorizeFileRequest is the single place FileTransferService requests are checked
+// against the host's file policy. Paths in req are replaced with their cleaned form.
+// Request types without a rule are rejected, so new RPCs must be added here.
+func (s *server) authorizeFileRequest(method string, req any) error {
+	if err := s.checkFileSystemEnabled(method); err != nil {
+		return err
+	}
+	p := s.filePolicy
+	var err error
+	switch r := req.(type) {
+	case *pb.FSRequest:
+		if r.Path != "" {
+			r.Path, err = p.resolve(r.Path, readAccess)
+		}
+	case *pb.FileRequest:
+		r.Path, err = p.resolve(r.Path, readAccess)
+	case *pb.ReadFileRequest:
+		r.Path, err = p.resolve(r.Path, readAccess)
+	case *pb.SearchFilesRequest:
+		r.RootPath, err = p.resolve(r.RootPath, readAccess)
+	case *pb.ManifestRequest:
+		r.RootPath, err = p.resolve(r.RootPath, readAccess)
+	case *pb.WatchRequest:
+		r.Path, err = p.resolve(r.Path, readAccess)
+	case *pb.FileChunk:
+		// Only the first upload chunk carries the destination.
+		if md := r.GetMetadata(); md != nil && md.Path != "" {
+			md.Path, err = p.resolve(md.Path, writeAccess)
+		}
+	case *pb.WriteFileRequest:
+		r.Path, err = p.resolve(r.Path, writeAccess)
+	case *pb.MakeDirectoryRequest:
+		r.Path, err = p.resolve(r.Path, writeAccess)
+	case *pb.RenamePathRequest:
+		r.Path, err = p.resolve(r.Path, removeAccess)
+		if err == nil && r.NewName != "" {
+			_, err = p.resolve(filepath.Join(filepath.Dir(r.Path), r.NewName), writeAccess)
+		}
+	case *pb.TransferPathsRequest:
+		access := readAccess
+		if strings.HasSuffix(method, "/MovePaths") {
+			access = removeAccess
+		}
+		for i := range r.SourcePaths {
+			if r.SourcePaths[i], err = p.resolve(r.SourcePaths[i], access); err != nil {
+				break
+			}
+			if access == removeAccess {
+				err = p.checkRemovable(r.SourcePaths[i])
+			} else {
+				err = p.checkSubtree(r.SourcePaths[i])
+			}
+			if err != nil {
+				break
+			}
+		}
+		if err == nil {
+			r.DestinationDir, err = p.resolve(r.DestinationDir, writeAccess)
+		}
+		// The entries written are not DestinationDir itself but each source's name in it,
+		// which an overwrite removes first.
+		for _, src := range r.SourcePaths {
+			if err != nil {
+				break
+			}
+			dest := filepath.Join(r.DestinationDir, filepath.Base(src))
+			if _, err = p.resolve(dest, removeAccess); err == nil && r.Overwrite {
+				err = p.checkRemovable(dest)
+			}
+		}
+	case *pb.DeletePathsRequest:
+		for i := range r.Paths {
+			if r.Paths[i], err = p.resolve(r.Paths[i], removeAccess); err != nil {
+				break
+			}
+			if r.Recursive {
+				if err = p.checkRemovable(r.Paths[i]); err != nil {
+					break
+				}
+			}
+		}
+	default:
+		log.Printf("File policy: no rule for %T in %s. Rejecting.", req, method)
+		return status.Errorf(codes.Internal, "No file access rule for this request.")
+	}
+	if err != nil {
+		log.Printf("File policy rejected %s: %v", strings.TrimPrefix(method, fileTransferServicePrefix), err)
+	}
+	return err
+}
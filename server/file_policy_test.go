@@ -0,0 +1,187 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	pb "control_grpc/gen/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestFilePolicyResolve(t *testing.T) {
+	base := t.TempDir()
+	shared := filepath.Join(base, "shared")
+	docs := filepath.Join(base, "docs")
+	outside := filepath.Join(base, "outside")
+	for _, dir := range []string{shared, docs, outside, filepath.Join(shared, ".ssh"), filepath.Join(shared, "sub")} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, file := range []string{filepath.Join(shared, "notes.txt"), filepath.Join(shared, "sub", "server.key"), filepath.Join(outside, "secret.txt")} {
+		if err := os.WriteFile(file, []byte("x"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	canSymlink := runtime.GOOS != "windows"
+	if canSymlink {
+		if err := os.Symlink(outside, filepath.Join(shared, "escape")); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Symlink(filepath.Join(outside, "new.txt"), filepath.Join(shared, "dangling")); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Symlink(filepath.Join(shared, "notes.txt"), filepath.Join(shared, "inside")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rootsSpec := shared + string(os.PathListSeparator) + docs + "=ro"
+	policy, err := newFilePolicy(rootsSpec, ".ssh, *.key", false)
+	if err != nil {
+		t.Fatalf("newFilePolicy(%q): %v", rootsSpec, err)
+	}
+	if len(policy.roots) != 2 || policy.roots[0].readOnly || !policy.roots[1].readOnly {
+		t.Fatalf("newFilePolicy(%q): unexpected roots %+v", rootsSpec, policy.roots)
+	}
+
+	testCases := []struct {
+		name     string
+		path     string
+		access   fileAccess
+		symlinks bool
+		expected codes.Code
+	}{
+		{"ReadInsideRoot", filepath.Join(shared, "notes.txt"), readAccess, false, codes.OK},
+		{"WriteNewFile", filepath.Join(shared, "sub", "new.txt"), writeAccess, false, codes.OK},
+		{"ReadRoot", shared, readAccess, false, codes.OK},
+		{"RemoveRoot", shared, removeAccess, false, codes.PermissionDenied},
+		{"Outside", filepath.Join(outside, "secret.txt"), readAccess, false, codes.PermissionDenied},
+		{"DotDotEscape", shared + string(os.PathSeparator) + filepath.Join("..", "outside", "secret.txt"), readAccess, false, codes.PermissionDenied},
+		{"Relative", filepath.Join("shared", "notes.txt"), readAccess, false, codes.InvalidArgument},
+		{"Empty", "", readAccess, false, codes.InvalidArgument},
+		{"DeniedFolder", filepath.Join(shared, ".ssh"), readAccess, false, codes.PermissionDenied},
+		{"BelowDeniedFolder", filepath.Join(shared, ".ssh", "id_rsa"), readAccess, false, codes.PermissionDenied},
+		{"DeniedPattern", filepath.Join(shared, "sub", "server.key"), readAccess, false, codes.PermissionDenied},
+		{"ReadOnlyRootRead", filepath.Join(docs, "a.txt"), readAccess, false, codes.OK},
+		{"ReadOnlyRootWrite", filepath.Join(docs, "a.txt"), writeAccess, false, codes.PermissionDenied},
+		{"SymlinkEscape", filepath.Join(shared, "escape", "secret.txt"), readAccess, true, codes.PermissionDenied},
+		{"DanglingSymlinkWrite", filepath.Join(shared, "dangling"), writeAccess, true, codes.PermissionDenied},
+		{"RemoveSymlinkToOutside", filepath.Join(shared, "escape"), removeAccess, true, codes.OK},
+		{"SymlinkInside", filepath.Join(shared, "inside"), readAccess, true, codes.OK},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.symlinks && !canSymlink {
+				t.Skip("symlinks are not created on Windows")
+			}
+			_, err := policy.resolve(tc.path, tc.access)
+			if code := status.Code(err); code != tc.expected {
+				t.Errorf("resolve(%q, %v): expected %v, got %v (%v)", tc.path, tc.access, tc.expected, code, err)
+			}
+		})
+	}
+
+	if !policy.visible(filepath.Join(shared, "notes.txt")) || policy.visible(filepath.Join(shared, "sub", "server.key")) {
+		t.Errorf("visible: deny patterns not applied to listed entries")
+	}
+	if err := policy.checkSubtree(shared); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("checkSubtree(%q): expected PermissionDenied for a folder containing denied entries, got %v", shared, err)
+	}
+}
+
+func TestAuthorizeFileRequest(t *testing.T) {
+	shared := t.TempDir()
+	policy, err := newFilePolicy(shared, "", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &server{allowFileSystemAccess: true, filePolicy: policy}
+
+	req := &pb.FSRequest{Path: shared + string(os.PathSeparator) + "sub" + string(os.PathSeparator) + ".."}
+	if err := s.authorizeFileRequest("/control_grpc.FileTransferService/GetFS", req); err != nil {
+		t.Fatalf("GetFS: unexpected error %v", err)
+	}
+	if strings.HasSuffix(req.Path, "..") {
+		t.Errorf("GetFS: path was not cleaned: %q", req.Path)
+	}
+
+	write := &pb.WriteFileRequest{Path: filepath.Join(shared, "a.txt")}
+	if err := s.authorizeFileRequest("/control_grpc.FileTransferService/WriteFile", write); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("WriteFile with -fileReadOnly: expected PermissionDenied, got %v", err)
+	}
+
+	s.allowFileSystemAccess = false
+	if err := s.authorizeFileRequest("/control_grpc.FileTransferService/GetFS", &pb.FSRequest{}); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("GetFS with file system access disabled: expected PermissionDenied, got %v", err)
+	}
+}
+
+func TestAuthorizeTransferDestinations(t *testing.T) {
+	base := t.TempDir()
+	data := filepath.Join(base, "data")
+	archive := filepath.Join(data, "archive")
+	inner := filepath.Join(data, "box", "inner")
+	incoming := filepath.Join(data, "incoming")
+	writeTestFiles(t, map[string]string{
+		filepath.Join(archive, "old.txt"):               "x",
+		filepath.Join(inner, "shared.txt"):              "x",
+		filepath.Join(data, "proj", "id.key"):           "x",
+		filepath.Join(incoming, "archive", "a.txt"):     "x",
+		filepath.Join(incoming, "box", "b.txt"):         "x",
+		filepath.Join(incoming, "proj", "readme.txt"):   "x",
+		filepath.Join(incoming, "plain", "c.txt"):       "x",
+		filepath.Join(data, "plain", "replaced.txt"):    "x",
+		filepath.Join(data, "outgoing", "keep.txt"):     "x",
+		filepath.Join(data, "movable", "file.txt"):      "x",
+		filepath.Join(incoming, "movable", "other.txt"): "x",
+	})
+	rootsSpec := strings.Join([]string{data, archive + "=ro", inner + "=ro"}, string(os.PathListSeparator))
+	policy, err := newFilePolicy(rootsSpec, "*.key", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &server{allowFileSystemAccess: true, filePolicy: policy}
+
+	const copyPaths = "/control_grpc.FileTransferService/CopyPaths"
+	const movePaths = "/control_grpc.FileTransferService/MovePaths"
+	testCases := []struct {
+		name      string
+		method    string
+		src       string
+		destDir   string
+		overwrite bool
+		expected  codes.Code
+	}{
+		// data/archive is a read-only shared folder, which the copy would replace.
+		{"OverwriteReadOnlyRoot", copyPaths, filepath.Join(incoming, "archive"), data, true, codes.PermissionDenied},
+		{"CreateOverReadOnlyRoot", copyPaths, filepath.Join(incoming, "archive"), data, false, codes.PermissionDenied},
+		// data/box holds the read-only root data/box/inner, which overwriting would delete.
+		{"OverwriteFolderWithNestedRoot", copyPaths, filepath.Join(incoming, "box"), data, true, codes.PermissionDenied},
+		// data/proj holds a denied file, which overwriting would delete.
+		{"OverwriteFolderWithDeniedEntry", copyPaths, filepath.Join(incoming, "proj"), data, true, codes.PermissionDenied},
+		{"OverwritePlainFolder", copyPaths, filepath.Join(incoming, "plain"), data, true, codes.OK},
+		{"MoveSourceWithDeniedEntry", movePaths, filepath.Join(data, "proj"), incoming, false, codes.PermissionDenied},
+		{"MoveSourceWithNestedRoot", movePaths, filepath.Join(data, "box"), incoming, false, codes.PermissionDenied},
+		{"MovePlainFolder", movePaths, filepath.Join(data, "outgoing"), incoming, false, codes.OK},
+		{"MoveOverwritePlainFolder", movePaths, filepath.Join(data, "movable"), incoming, true, codes.OK},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := &pb.TransferPathsRequest{SourcePaths: []string{tc.src}, DestinationDir: tc.destDir, Overwrite: tc.overwrite}
+			if err := s.authorizeFileRequest(tc.method, req); status.Code(err) != tc.expected {
+				t.Errorf("expected %v, got %v", tc.expected, err)
+			}
+		})
+	}
+
+	del := &pb.DeletePathsRequest{Paths: []string{filepath.Join(data, "box")}, Recursive: true}
+	if err := s.authorizeFileRequest("/control_grpc.FileTransferService/DeletePaths", del); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("deleting a folder holding a shared folder: expected PermissionDenied, got %v", err)
+	}
+}
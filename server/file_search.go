@@ -0,0 +1,303 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	pb "control_grpc/gen/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	defaultSearchResults = 1000
+	maxSearchResults     = 10000
+	// Files larger than this are not grepped for content_pattern.
+	maxContentSearchSize = 64 << 20
+	maxMatchLineLength   = 256
+)
+
+// searchFilter holds the compiled form of a SearchFilesRequest.
+type searchFilter struct {
+	root          string
+	namePattern   string
+	nameRegex     *regexp.Regexp
+	contentRegex  *regexp.Regexp
+	caseSensitive bool
+	minSize       int64
+	maxSize       int64
+	after         time.Time
+	before        time.Time
+	maxDepth      int
+	includeHidden bool
+}
+
+func newSearchFilter(req *pb.SearchFilesRequest) (*searchFilter, error) {
+	f := &searchFilter{
+		root:          filepath.Clean(req.GetRootPath()),
+		caseSensitive: req.GetCaseSensitive(),
+		minSize:       req.GetMinSize(),
+		maxSize:       req.GetMaxSize(),
+		maxDepth:      int(req.GetMaxDepth()),
+		includeHidden: req.GetIncludeHidden(),
+	}
+	if req.GetModifiedAfter() != 0 {
+		f.after = time.Unix(0, req.GetModifiedAfter())
+	}
+	if req.GetModifiedBefore() != 0 {
+		f.before = time.Unix(0, req.GetModifiedBefore())
+	}
+
+	flags := ""
+	if !f.caseSensitive {
+		flags = "(?i)"
+	}
+	if name := req.GetNamePattern(); name != "" {
+		if req.GetUseRegex() {
+			re, err := regexp.Compile(flags + name)
+			if err != nil {
+				return nil, status.Errorf(codes.InvalidArgument, "Invalid name pattern: %v", err)
+			}
+			f.nameRegex = re
+		} else {
+			if _, err := filepath.Match(name, ""); err != nil {
+				return nil, status.Errorf(codes.InvalidArgument, "Invalid name pattern: %v", err)
+			}
+			f.namePattern = name
+			if !f.caseSensitive {
+				f.namePattern = strings.ToLower(name)
+			}
+		}
+	}
+	if content := req.GetContentPattern(); content != "" {
+		if !req.GetUseRegex() {
+			content = regexp.QuoteMeta(content)
+		}
+		re, err := regexp.Compile(flags + content)
+		if err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "Invalid content pattern: %v", err)
+		}
+		f.contentRegex = re
+	}
+	return f, nil
+}
+
+func (f *searchFilter) matchesName(name string) bool {
+	switch {
+	case f.nameRegex != nil:
+		return f.nameRegex.MatchString(name)
+	case f.namePattern != "":
+		if !f.caseSensitive {
+			name = strings.ToLower(name)
+		}
+		matched, _ := filepath.Match(f.namePattern, name)
+		return matched
+	default:
+		return true
+	}
+}
+
+func (f *searchFilter) matchesInfo(info fs.FileInfo) bool {
+	if f.contentRegex != nil && !info.Mode().IsRegular() {
+		return false
+	}
+	if !info.IsDir() {
+		if f.minSize > 0 && info.Size() < f.minSize {
+			return false
+		}
+		if f.maxSize > 0 && info.Size() > f.maxSize {
+			return false
+		}
+	} else if f.minSize > 0 || f.maxSize > 0 {
+		return false
+	}
+	if !f.after.IsZero() && info.ModTime().Before(f.after) {
+		return false
+	}
+	if !f.before.IsZero() && info.ModTime().After(f.before) {
+		return false
+	}
+	return true
+}
+
+// grepFile returns the first line of path matching the content pattern. Binary files
+// (a NUL byte in the first 8 KiB) never match.
+func (f *searchFilter) grepFile(path string) (int64, string, bool) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, "", false
+	}
+	defer file.Close()
+
+	reader := bufio.NewReaderSize(file, 64*1024)
+	if head, _ := reader.Peek(8192); bytes.IndexByte(head, 0) >= 0 {
+		return 0, "", false
+	}
+
+	var lineNumber int64
+	for {
+		line, err := reader.ReadSlice('\n')
+		if err == bufio.ErrBufferFull {
+			// Very long line: search the part we have and skip the rest of it.
+			lineNumber++
+			if f.contentRegex.Match(line) {
+				return lineNumber, truncateMatchLine(line), true
+			}
+			for err == bufio.ErrBufferFull {
+				_, err = reader.ReadSlice('\n')
+			}
+			if err != nil {
+				return 0, "", false
+			}
+			continue
+		}
+		if len(line) > 0 {
+			lineNumber++
+			if f.contentRegex.Match(line) {
+				return lineNumber, truncateMatchLine(line), true
+			}
+		}
+		if err != nil {
+			if err != io.EOF {
+				log.Printf("SearchFiles: error reading '%s': %v", path, err)
+			}
+			return 0, "", false
+		}
+	}
+}
+
+func truncateMatchLine(line []byte) string {
+	text := strings.TrimRight(string(line), "\r\n")
+	if len(text) > maxMatchLineLength {
+		text = text[:maxMatchLineLength] + "..."
+	}
+	return text
+}
+
+func (s *server) SearchFiles(req *pb.SearchFilesRequest, stream pb.FileTransferService_SearchFilesServer) error {
+	log.Printf("SearchFiles request received: root '%s', name '%s', content '%s', regex %v, max depth %d",
+		req.GetRootPath(), req.GetNamePattern(), req.GetContentPattern(), req.GetUseRegex(), req.GetMaxDepth())
+	if req.GetRootPath() == "" {
+		return status.Errorf(codes.InvalidArgument, "Search root path must not be empty.")
+	}
+
+	filter, err := newSearchFilter(req)
+	if err != nil {
+		return err
+	}
+	rootInfo, err := os.Stat(filter.root)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return status.Errorf(codes.NotFound, "Search root not found: %v", err)
+		}
+		return status.Errorf(codes.Internal, "Failed to access search root: %v", err)
+	}
+	if !rootInfo.IsDir() {
+		return status.Errorf(codes.InvalidArgument, "Search root '%s' is not a directory.", filter.root)
+	}
+
+	maxResults := int(req.GetMaxResults())
+	if maxResults <= 0 {
+		maxResults = defaultSearchResults
+	} else if maxResults > maxSearchResults {
+		maxResults = maxSearchResults
+	}
+
+	ctx := stream.Context()
+	var matches, scanned int
+	start := time.Now()
+	walkErr := filepath.WalkDir(filter.root, func(path string, d fs.DirEntry, err error) error {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		if err != nil {
+			// Unreadable folders are skipped rather than aborting the whole search.
+			log.Printf("SearchFiles: skipping '%s': %v", path, err)
+			if d != nil && d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if path == filter.root {
+			return nil
+		}
+		if !s.filePolicy.visible(path) {
+			if d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		scanned++
+
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		if !filter.includeHidden && isHiddenFile(d.Name(), info) {
+			if d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+
+		depth := strings.Count(strings.TrimPrefix(path, filter.root), string(filepath.Separator))
+		if filter.root == filepath.Dir(filter.root) {
+			depth++ // root is "/" or a drive, so the first level has no leading separator
+		}
+		descend := filter.maxDepth <= 0 || depth < filter.maxDepth
+
+		if filter.matchesName(d.Name()) && filter.matchesInfo(info) {
+			match := &pb.SearchMatch{}
+			matched := true
+			if filter.contentRegex != nil {
+				if info.Size() > maxContentSearchSize {
+					matched = false
+				} else {
+					match.LineNumber, match.Line, matched = filter.grepFile(path)
+				}
+			}
+			if matched {
+				node, err := buildFSNode(filepath.Dir(path), d)
+				if err != nil {
+					return nil
+				}
+				match.Node = node
+				if err := stream.Send(match); err != nil {
+					log.Printf("SearchFiles: error sending match '%s': %v", path, err)
+					return err
+				}
+				matches++
+				if matches >= maxResults {
+					log.Printf("SearchFiles: reached result limit (%d).", maxResults)
+					return fs.SkipAll
+				}
+			}
+		}
+
+		if d.IsDir() && !descend {
+			return fs.SkipDir
+		}
+		return nil
+	})
+
+	if walkErr != nil {
+		if ctx.Err() != nil {
+			log.Printf("SearchFiles under '%s' cancelled after %d matches (%d entries scanned).", filter.root, matches, scanned)
+			return status.FromContextError(ctx.Err()).Err()
+		}
+		if _, ok := status.FromError(walkErr); ok {
+			return walkErr
+		}
+		return status.Errorf(codes.Internal, "Search failed: %v", walkErr)
+	}
+	log.Printf("SearchFiles under '%s' finished: %d matches, %d entries scanned in %v.", filter.root, matches, scanned, time.Since(start))
+	return nil
+}
@@ -1,14 +1,17 @@
 package main
 
 import (
-	"archive/zip"
 	"context"
+	"crypto/sha256"
 	"fmt"
 	"io"
 	"log"
 	"os"
 	"path/filepath"
-	"runtime"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	pb "control_grpc/gen/proto"
 	"google.golang.org/grpc/codes"
@@ -29,16 +32,16 @@ func (s *server) GetFS(ctx context.Context, req *pb.FSRequest) (*pb.FSResponse,
 
 	if reqPath == "" {
 
-		log.Println("Requesting filesystem roots...")
-		if runtime.GOOS == "windows" {
-			nodes = getWindowsDrives()
-		} else {
-			nodes = getPosixRoot()
-		}
+		log.Println("Requesting shared file roots...")
+		nodes = s.filePolicy.rootNodes()
 	} else {
 
-		log.Printf("Requesting contents of path: %s", reqPath)
-		nodes, err = listDirectoryContents(reqPath)
+		log.Printf("Requesting contents of path: %s (cursor: '%s', page size: %d)", reqPath, req.GetCursor(), req.GetPageSize())
+		nodes, response.NextCursor, err = listDirectoryContents(reqPath, req.GetCursor(), normalizePageSize(req.GetPageSize()), s.filePolicy.visible)
+		readOnly := s.filePolicy.isReadOnly(reqPath)
+		for _, node := range nodes {
+			node.ReadOnly = readOnly
+		}
 		if err != nil {
 			log.Printf("Error listing directory '%s': %v", reqPath, err)
 
@@ -53,7 +56,7 @@ func (s *server) GetFS(ctx context.Context, req *pb.FSRequest) (*pb.FSResponse,
 	}
 
 	response.Nodes = nodes
-	log.Printf("GetFS response for '%s': sending %d nodes, ErrorMessage: '%s'", reqPath, len(response.Nodes), response.ErrorMessage)
+	log.Printf("GetFS response for '%s': sending %d nodes, NextCursor: '%s', ErrorMessage: '%s'", reqPath, len(response.Nodes), response.NextCursor, response.ErrorMessage)
 	return response, nil
 }
 
@@ -74,6 +77,17 @@ func (s *server) DownloadFile(req *pb.FileRequest, stream pb.FileTransferService
 		return status.Errorf(codes.InvalidArgument, "Path is a directory, not a file. Use DownloadFolderAsZip for directories.")
 	}
 
+	fileSize := fileInfo.Size()
+	offset := req.GetOffset()
+	if offset < 0 || offset > fileSize {
+		log.Printf("Invalid offset %d for '%s' (size %d)", offset, filePath, fileSize)
+		return status.Errorf(codes.OutOfRange, "Offset %d is outside of the file (size %d bytes)", offset, fileSize)
+	}
+	endOffset := fileSize
+	if req.GetLength() > 0 && offset+req.GetLength() < fileSize {
+		endOffset = offset + req.GetLength()
+	}
+
 	file, err := os.Open(filePath)
 	if err != nil {
 		log.Printf("Error opening file '%s': %v", filePath, err)
@@ -84,51 +98,102 @@ func (s *server) DownloadFile(req *pb.FileRequest, stream pb.FileTransferService
 	}
 	defer file.Close()
 
+	// The digest always covers the whole file, so a resumed download hashes the part the
+	// client already has before continuing from the requested offset.
+	hasher := sha256.New()
+	computeDigest := endOffset == fileSize
+	if computeDigest && offset > 0 {
+		if _, err := io.CopyN(hasher, file, offset); err != nil {
+			log.Printf("Error hashing first %d bytes of '%s': %v", offset, filePath, err)
+			return status.Errorf(codes.Internal, "Error reading file: %v", err)
+		}
+	} else if offset > 0 {
+		if _, err := file.Seek(offset, io.SeekStart); err != nil {
+			log.Printf("Error seeking to offset %d in '%s': %v", offset, filePath, err)
+			return status.Errorf(codes.Internal, "Error seeking in file: %v", err)
+		}
+	}
+
+	metadata := &pb.FileChunkMetadata{
+		TotalSize:    fileSize,
+		Offset:       offset,
+		ModifiedTime: fileInfo.ModTime().UnixNano(),
+	}
+
 	buffer := make([]byte, 1024*64)
 	firstChunkSent := false
-	log.Printf("Starting stream for file: '%s', Total size: %d bytes", filePath, fileInfo.Size())
+	remaining := endOffset - offset
+	log.Printf("Starting stream for file: '%s', Total size: %d bytes, Range: [%d, %d)", filePath, fileSize, offset, endOffset)
 
-	for {
+	for remaining > 0 {
 		if err := stream.Context().Err(); err != nil {
 			log.Printf("Client cancelled download of '%s': %v", filePath, err)
 			return status.FromContextError(err).Err()
 		}
 
-		n, err := file.Read(buffer)
-		if err != nil {
+		readBuffer := buffer
+		if int64(len(readBuffer)) > remaining {
+			readBuffer = readBuffer[:remaining]
+		}
+		n, err := file.Read(readBuffer)
+		if n == 0 && err != nil {
 			if err == io.EOF {
-				log.Printf("Finished streaming file: '%s'", filePath)
-				break
+				log.Printf("File '%s' ended early at offset %d (file shrank while streaming?)", filePath, endOffset-remaining)
+				return status.Errorf(codes.Aborted, "File changed while it was being read")
 			}
 			log.Printf("Error reading file chunk for '%s': %v", filePath, err)
 			return status.Errorf(codes.Internal, "Error reading file chunk: %v", err)
 		}
+		remaining -= int64(n)
+		if computeDigest {
+			hasher.Write(readBuffer[:n])
+		}
 
-		chunkToSend := &pb.FileChunk{Content: buffer[:n]}
+		chunkToSend := &pb.FileChunk{Content: readBuffer[:n]}
 
 		if !firstChunkSent {
-
-			chunkToSend.Metadata = &pb.FileChunkMetadata{
-				TotalSize: fileInfo.Size(),
-			}
-			log.Printf("Sending first chunk for '%s' with metadata (TotalSize: %d)", filePath, fileInfo.Size())
+			chunkToSend.Metadata = metadata
+			log.Printf("Sending first chunk for '%s' with metadata (TotalSize: %d, Offset: %d)", filePath, fileSize, offset)
 			firstChunkSent = true
 		}
 
-		sendErr := stream.Send(chunkToSend)
-		if sendErr != nil {
-			log.Printf("Error sending file chunk for '%s': %v", filePath, sendErr)
-			if status.Code(sendErr) == codes.Canceled || status.Code(sendErr) == codes.Unavailable {
-				log.Printf("Client cancelled or stream unavailable during send for '%s'", filePath)
-				return sendErr
-			}
-			return status.Errorf(codes.Internal, "Error sending file chunk: %v", sendErr)
+		if sendErr := sendFileChunk(stream, chunkToSend, filePath); sendErr != nil {
+			return sendErr
+		}
+	}
+	log.Printf("Finished streaming file: '%s'", filePath)
+
+	if computeDigest || !firstChunkSent {
+		trailer := &pb.FileChunkMetadata{
+			TotalSize:    fileSize,
+			Offset:       offset,
+			ModifiedTime: metadata.GetModifiedTime(),
+		}
+		if computeDigest {
+			trailer.Sha256 = hasher.Sum(nil)
+			log.Printf("Sending SHA-256 for '%s': %x", filePath, trailer.Sha256)
+		}
+		if sendErr := sendFileChunk(stream, &pb.FileChunk{Metadata: trailer}, filePath); sendErr != nil {
+			return sendErr
 		}
 	}
 	log.Printf("Successfully streamed file: '%s'", filePath)
 	return nil
 }
 
+func sendFileChunk(stream pb.FileTransferService_DownloadFileServer, chunk *pb.FileChunk, filePath string) error {
+	sendErr := stream.Send(chunk)
+	if sendErr != nil {
+		log.Printf("Error sending file chunk for '%s': %v", filePath, sendErr)
+		if status.Code(sendErr) == codes.Canceled || status.Code(sendErr) == codes.Unavailable {
+			log.Printf("Client cancelled or stream unavailable during send for '%s'", filePath)
+			return sendErr
+		}
+		return status.Errorf(codes.Internal, "Error sending file chunk: %v", sendErr)
+	}
+	return nil
+}
+
 func (s *server) DownloadFolderAsZip(req *pb.FileRequest, stream pb.FileTransferService_DownloadFolderAsZipServer) error {
 	folderPath := req.GetPath()
 	log.Printf("DownloadFolderAsZip request received for path: '%s'", folderPath)
@@ -146,94 +211,66 @@ func (s *server) DownloadFolderAsZip(req *pb.FileRequest, stream pb.FileTransfer
 		return status.Errorf(codes.InvalidArgument, "Path is not a directory.")
 	}
 
+	filter, err := newFolderFilter(req.GetIncludePatterns(), req.GetExcludePatterns())
+	if err != nil {
+		return status.Errorf(codes.InvalidArgument, "Invalid filter: %v", err)
+	}
+	format := req.GetArchiveFormat()
+	if _, ok := pb.ArchiveFormat_name[int32(format)]; !ok {
+		return status.Errorf(codes.InvalidArgument, "Unsupported archive format: %v", format)
+	}
+	if level := req.GetCompressionLevel(); level < 0 || level > 9 {
+		return status.Errorf(codes.InvalidArgument, "Compression level must be between 0 and 9, got %d", level)
+	}
+
+	log.Printf("Pre-walking folder '%s' (include: %v, exclude: %v)", folderPath, filter.include, filter.exclude)
+	entries, sourceTotalSize, err := collectArchiveEntries(stream.Context(), folderPath, filter, s.filePolicy)
+	if err != nil {
+		if ctxErr := stream.Context().Err(); ctxErr != nil {
+			return status.FromContextError(ctxErr).Err()
+		}
+		return status.Errorf(codes.Internal, "Failed to walk folder: %v", err)
+	}
+	var sourceFileCount int64
+	for _, entry := range entries {
+		if !entry.info.IsDir() {
+			sourceFileCount++
+		}
+	}
+	log.Printf("Folder '%s': %d entries, %d files, %d bytes to archive as %v (level %d)",
+		folderPath, len(entries), sourceFileCount, sourceTotalSize, format, req.GetCompressionLevel())
+
 	pipeReader, pipeWriter := io.Pipe()
 	firstChunkSent := false
+	var sourceBytesDone atomic.Int64
 
 	go func() {
-		defer pipeWriter.Close()
-		zipWriter := zip.NewWriter(pipeWriter)
-		defer zipWriter.Close()
-
-		log.Printf("Starting zipping process for folder: '%s'", folderPath)
-		walkErr := filepath.Walk(folderPath, func(path string, info os.FileInfo, err error) error {
-			select {
-			case <-stream.Context().Done():
-				log.Printf("Context cancelled during folder zipping for '%s': %v", folderPath, stream.Context().Err())
-				return stream.Context().Err()
-			default:
-			}
-
-			if err != nil {
-				log.Printf("Error accessing path '%s' during walk: %v. Skipping.", path, err)
-				if os.IsPermission(err) {
-					return nil
-				}
-				return err
-			}
-
-			relPath, err := filepath.Rel(folderPath, path)
-			if err != nil {
-				log.Printf("Error getting relative path for '%s' (base '%s'): %v. Skipping.", path, folderPath, err)
-				return nil
-			}
-			if relPath == "." {
-				return nil
-			}
-
-			header, err := zip.FileInfoHeader(info)
-			if err != nil {
-				log.Printf("Error creating zip header for '%s': %v. Skipping.", path, err)
-				return nil
-			}
-			header.Name = filepath.ToSlash(relPath)
-			if info.IsDir() {
-				header.Name += "/"
-				header.Method = zip.Store
-			} else {
-				header.Method = zip.Deflate
-			}
-
-			entryWriter, err := zipWriter.CreateHeader(header)
-			if err != nil {
-				log.Printf("Error creating zip entry for '%s': %v.", path, err)
-				return err
-			}
-
-			if !info.IsDir() {
-				file, errOpen := os.Open(path)
-				if errOpen != nil {
-					log.Printf("Error opening file '%s' for zipping: %v. Skipping.", path, errOpen)
-					if os.IsPermission(errOpen) {
-						return nil
-					}
-					return errOpen
-				}
-				defer file.Close()
-				_, errCopy := io.Copy(entryWriter, file)
-				if errCopy != nil {
-					log.Printf("Error copying file content for '%s' to zip: %v.", path, errCopy)
-					return errCopy
-				}
-			}
-			return nil
-		})
+		archiveWriter, err := newArchiveWriter(format, req.GetCompressionLevel(), pipeWriter)
+		if err != nil {
+			log.Printf("Failed to create %v writer for '%s': %v", format, folderPath, err)
+			pipeWriter.CloseWithError(err)
+			return
+		}
 
-		if walkErr != nil {
-			log.Printf("Directory walk or zipping failed for '%s': %v", folderPath, walkErr)
-			if walkErr == context.Canceled || walkErr == stream.Context().Err() {
-				log.Printf("Zipping cancelled for '%s' due to client disconnect.", folderPath)
+		log.Printf("Starting archiving process for folder: '%s'", folderPath)
+		if err := writeFolderArchive(stream.Context(), archiveWriter, entries, &sourceBytesDone); err != nil {
+			log.Printf("Archiving failed for '%s': %v", folderPath, err)
+			if err == context.Canceled || err == stream.Context().Err() {
+				log.Printf("Archiving cancelled for '%s' due to client disconnect.", folderPath)
 			}
-		} else {
-			log.Printf("Finished zipping folder successfully: '%s'", folderPath)
+			pipeWriter.CloseWithError(err)
+			return
 		}
+		log.Printf("Finished archiving folder successfully: '%s'", folderPath)
+		pipeWriter.Close()
 	}()
 
 	buffer := make([]byte, 1024*64)
-	log.Printf("Starting stream of zip data for folder: '%s'", folderPath)
+	log.Printf("Starting stream of archive data for folder: '%s'", folderPath)
 
 	for {
 		if err := stream.Context().Err(); err != nil {
-			log.Printf("Client cancelled download of zip for '%s': %v", folderPath, err)
+			log.Printf("Client cancelled download of archive for '%s': %v", folderPath, err)
 			pipeReader.CloseWithError(err)
 			return status.FromContextError(err).Err()
 		}
@@ -241,127 +278,338 @@ func (s *server) DownloadFolderAsZip(req *pb.FileRequest, stream pb.FileTransfer
 		n, err := pipeReader.Read(buffer)
 		if err != nil {
 			if err == io.EOF {
-				log.Printf("Finished streaming zip data for folder: '%s' (EOF from pipe).", folderPath)
+				log.Printf("Finished streaming archive data for folder: '%s' (EOF from pipe).", folderPath)
 				break
 			}
-			log.Printf("Error reading from zip pipe for '%s': %v", folderPath, err)
-			return status.Errorf(codes.Internal, "Error reading zip data: %v", err)
+			log.Printf("Error reading from archive pipe for '%s': %v", folderPath, err)
+			return status.Errorf(codes.Internal, "Error creating archive: %v", err)
 		}
 
-		chunkToSend := &pb.FileChunk{Content: buffer[:n]}
+		chunkToSend := &pb.FileChunk{
+			Content:  buffer[:n],
+			Metadata: &pb.FileChunkMetadata{SourceBytesDone: sourceBytesDone.Load()},
+		}
 
 		if !firstChunkSent {
-
-			chunkToSend.Metadata = &pb.FileChunkMetadata{
-				TotalSize: 0,
-			}
-			log.Printf("Sending first chunk for zipped folder '%s' with metadata (TotalSize: 0 - indeterminate)", folderPath)
+			// The archive size is unknown until it is finished, so TotalSize stays 0 and
+			// progress is reported against the source size found by the pre-walk.
+			chunkToSend.Metadata.SourceTotalSize = sourceTotalSize
+			chunkToSend.Metadata.SourceFileCount = sourceFileCount
+			log.Printf("Sending first chunk for archived folder '%s' with metadata (SourceTotalSize: %d, SourceFileCount: %d)", folderPath, sourceTotalSize, sourceFileCount)
 			firstChunkSent = true
 		}
 
 		sendErr := stream.Send(chunkToSend)
 		if sendErr != nil {
-			log.Printf("Error sending zip chunk for '%s': %v", folderPath, sendErr)
+			log.Printf("Error sending archive chunk for '%s': %v", folderPath, sendErr)
 			pipeReader.CloseWithError(sendErr)
 			if status.Code(sendErr) == codes.Canceled || status.Code(sendErr) == codes.Unavailable {
 				return sendErr
 			}
-			return status.Errorf(codes.Internal, "Error sending zip chunk: %v", sendErr)
+			return status.Errorf(codes.Internal, "Error sending archive chunk: %v", sendErr)
 		}
 	}
-	log.Printf("Successfully streamed zip archive for folder: '%s'", folderPath)
+	log.Printf("Successfully streamed archive for folder: '%s'", folderPath)
 	return nil
 }
 
-func getWindowsDrives() []*pb.FSNode {
-	log.Println("Detecting Windows drives...")
-	var drives []*pb.FSNode
-	for _, driveLetter := range "ABCDEFGHIJKLMNOPQRSTUVWXYZ" {
-		path := string(driveLetter) + ":" + string(os.PathSeparator)
-		fileInfo, err := os.Stat(path)
-		if err == nil && fileInfo.IsDir() {
-			log.Printf("Found accessible drive: %s", path)
-			hasChildren := false
-			entries, readErr := os.ReadDir(path)
-			if readErr == nil {
-				hasChildren = len(entries) > 0
-			} else {
-				log.Printf("Could not read drive %s to check for children: %v (assuming no accessible children)", path, readErr)
+func (s *server) UploadFile(stream pb.FileTransferService_UploadFileServer) error {
+
+	firstChunk, err := stream.Recv()
+	if err != nil {
+		if err == io.EOF {
+			log.Println("UploadFile: client closed the stream before sending metadata.")
+			return status.Errorf(codes.InvalidArgument, "Upload stream closed before metadata was received.")
+		}
+		log.Printf("UploadFile: error receiving first chunk: %v", err)
+		return err
+	}
+
+	metadata := firstChunk.GetMetadata()
+	if metadata == nil || metadata.GetPath() == "" {
+		log.Println("UploadFile: first chunk has no destination path in metadata.")
+		return status.Errorf(codes.InvalidArgument, "First upload chunk must carry metadata with the destination path.")
+	}
+	destPath := filepath.Clean(metadata.GetPath())
+	destDir := filepath.Dir(destPath)
+	log.Printf("UploadFile request received for path: '%s' (declared size: %d bytes)", destPath, metadata.GetTotalSize())
+
+	dirInfo, err := os.Stat(destDir)
+	if err != nil {
+		log.Printf("Error stating upload destination folder '%s': %v", destDir, err)
+		if os.IsNotExist(err) {
+			return status.Errorf(codes.NotFound, "Destination folder not found: %v", err)
+		}
+		if os.IsPermission(err) {
+			return status.Errorf(codes.PermissionDenied, "Permission denied to access destination folder: %v", err)
+		}
+		return status.Errorf(codes.Internal, "Failed to access destination folder: %v", err)
+	}
+	if !dirInfo.IsDir() {
+		return status.Errorf(codes.InvalidArgument, "Destination parent is not a directory.")
+	}
+
+	fileMode := os.FileMode(0644)
+	if existingInfo, statErr := os.Stat(destPath); statErr == nil {
+		if existingInfo.IsDir() {
+			log.Printf("UploadFile: destination '%s' is an existing directory.", destPath)
+			return status.Errorf(codes.InvalidArgument, "Destination path is a directory.")
+		}
+		fileMode = existingInfo.Mode().Perm()
+	}
+
+	// Write into a temporary file next to the destination so the final rename is atomic
+	// and a cancelled upload never leaves a truncated file behind.
+	tmpFile, err := os.CreateTemp(destDir, "."+filepath.Base(destPath)+".upload-*")
+	if err != nil {
+		log.Printf("Error creating temporary upload file in '%s': %v", destDir, err)
+		if os.IsPermission(err) {
+			return status.Errorf(codes.PermissionDenied, "Permission denied to write to destination folder: %v", err)
+		}
+		return status.Errorf(codes.Internal, "Failed to create temporary file: %v", err)
+	}
+	tmpPath := tmpFile.Name()
+	committed := false
+	defer func() {
+		if !committed {
+			tmpFile.Close()
+			if removeErr := os.Remove(tmpPath); removeErr != nil && !os.IsNotExist(removeErr) {
+				log.Printf("Warning: could not remove temporary upload file '%s': %v", tmpPath, removeErr)
 			}
+		}
+	}()
+
+	var bytesWritten int64
+	chunk := firstChunk
+	for {
+		if len(chunk.GetContent()) > 0 {
+			n, writeErr := tmpFile.Write(chunk.GetContent())
+			bytesWritten += int64(n)
+			if writeErr != nil {
+				log.Printf("Error writing upload chunk for '%s': %v", destPath, writeErr)
+				return status.Errorf(codes.Internal, "Error writing file chunk: %v", writeErr)
+			}
+		}
+
+		if err := stream.Context().Err(); err != nil {
+			log.Printf("Client cancelled upload of '%s': %v", destPath, err)
+			return status.FromContextError(err).Err()
+		}
+
+		chunk, err = stream.Recv()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			log.Printf("Error receiving upload chunk for '%s': %v", destPath, err)
+			return err
+		}
+	}
+
+	if metadata.GetTotalSize() > 0 && bytesWritten != metadata.GetTotalSize() {
+		log.Printf("Upload of '%s' incomplete: received %d of %d bytes.", destPath, bytesWritten, metadata.GetTotalSize())
+		return status.Errorf(codes.DataLoss, "Upload incomplete: received %d of %d bytes", bytesWritten, metadata.GetTotalSize())
+	}
 
-			drives = append(drives, &pb.FSNode{
-				Path:        path,
-				Name:        path,
-				Type:        pb.FSNode_FOLDER,
-				HasChildren: hasChildren,
-				Size:        0,
-			})
+	if err := tmpFile.Chmod(fileMode); err != nil {
+		log.Printf("Warning: could not set mode %v on '%s': %v", fileMode, tmpPath, err)
+	}
+	if err := tmpFile.Sync(); err != nil {
+		log.Printf("Error syncing temporary upload file '%s': %v", tmpPath, err)
+		return status.Errorf(codes.Internal, "Failed to flush uploaded file: %v", err)
+	}
+	if err := tmpFile.Close(); err != nil {
+		log.Printf("Error closing temporary upload file '%s': %v", tmpPath, err)
+		return status.Errorf(codes.Internal, "Failed to close uploaded file: %v", err)
+	}
+	if err := os.Rename(tmpPath, destPath); err != nil {
+		log.Printf("Error renaming '%s' to '%s': %v", tmpPath, destPath, err)
+		if os.IsPermission(err) {
+			return status.Errorf(codes.PermissionDenied, "Permission denied to replace destination file: %v", err)
 		}
+		return status.Errorf(codes.Internal, "Failed to move uploaded file into place: %v", err)
 	}
-	if len(drives) == 0 {
-		log.Println("No accessible Windows drives found.")
+	committed = true
+
+	if mtime := metadata.GetModifiedTime(); mtime != 0 {
+		modTime := time.Unix(0, mtime)
+		if err := os.Chtimes(destPath, modTime, modTime); err != nil {
+			log.Printf("Warning: could not set modification time on '%s': %v", destPath, err)
+		}
 	}
-	return drives
+
+	log.Printf("Successfully received upload: '%s' (%d bytes)", destPath, bytesWritten)
+	return stream.SendAndClose(&pb.UploadFileResponse{
+		Path:         destPath,
+		BytesWritten: bytesWritten,
+	})
 }
 
-func getPosixRoot() []*pb.FSNode {
-	log.Println("Returning POSIX root '/'")
-	hasChildren := false
-	entries, err := os.ReadDir("/")
-	if err == nil {
-		hasChildren = len(entries) > 0
-	} else {
-		log.Printf("Warning: Cannot read root directory '/' to check for children: %v", err)
+const (
+	defaultFSPageSize = 500
+	maxFSPageSize     = 5000
+)
+
+func normalizePageSize(pageSize int32) int {
+	if pageSize <= 0 {
+		return defaultFSPageSize
 	}
-	return []*pb.FSNode{
-		{
-			Path:        "/",
-			Name:        "/",
-			Type:        pb.FSNode_FOLDER,
-			HasChildren: hasChildren,
-			Size:        0,
-		},
+	if pageSize > maxFSPageSize {
+		return maxFSPageSize
 	}
+	return int(pageSize)
 }
 
-func listDirectoryContents(dirPath string) ([]*pb.FSNode, error) {
+// listDirectoryContents returns up to pageSize entries of dirPath whose names sort
+// after cursor, plus the cursor for the next page ("" when the listing is complete).
+// Only the entries on the returned page are stat'ed, so paging through a huge
+// directory does not pay for metadata of entries that are never shown. Folders are
+// reported with HasChildren set without reading them; clients find out when expanding.
+// Entries for which visible returns false are left out.
+func listDirectoryContents(dirPath string, cursor string, pageSize int, visible func(path string) bool) ([]*pb.FSNode, string, error) {
 	log.Printf("Listing contents of directory: %s", dirPath)
 	var nodes []*pb.FSNode
-	entries, err := os.ReadDir(dirPath)
+	entries, err := readDirSorted(dirPath, cursor == "")
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 
-	log.Printf("Found %d entries in %s", len(entries), dirPath)
-	for _, entry := range entries {
-		nodePath := filepath.Join(dirPath, entry.Name())
-		info, err := entry.Info()
+	start := 0
+	if cursor != "" {
+		start = sort.Search(len(entries), func(i int) bool { return entries[i].Name() > cursor })
+	}
+	var page []os.DirEntry
+	i := start
+	for ; i < len(entries) && len(page) < pageSize; i++ {
+		if visible(filepath.Join(dirPath, entries[i].Name())) {
+			page = append(page, entries[i])
+		}
+	}
+	nextCursor := ""
+	for ; i < len(entries); i++ {
+		if visible(filepath.Join(dirPath, entries[i].Name())) {
+			nextCursor = page[len(page)-1].Name()
+			break
+		}
+	}
+
+	log.Printf("Found %d entries in %s, returning %d from entry %d", len(entries), dirPath, len(page), start)
+	for _, entry := range page {
+		node, err := buildFSNode(dirPath, entry)
 		if err != nil {
-			log.Printf("Could not get FileInfo for '%s': %v. Skipping.", nodePath, err)
+			log.Printf("Could not get FileInfo for '%s': %v. Skipping.", filepath.Join(dirPath, entry.Name()), err)
 			continue
 		}
+		nodes = append(nodes, node)
+	}
+	log.Printf("Finished listing '%s'. Found %d valid nodes.", dirPath, len(nodes))
+	return nodes, nextCursor, nil
+}
 
-		node := &pb.FSNode{
-			Path: nodePath,
-			Name: entry.Name(),
-			Size: info.Size(),
-		}
+const (
+	// dirListingCacheSize is how many directories' entries are kept for later pages.
+	dirListingCacheSize = 8
+	dirListingCacheTTL  = time.Minute
+)
 
-		if entry.IsDir() {
-			node.Type = pb.FSNode_FOLDER
-			subEntries, subErr := os.ReadDir(node.Path)
-			if subErr != nil {
-				log.Printf("Error reading subdirectory '%s' for HasChildren check: %v. Assuming no accessible children.", node.Path, subErr)
-				node.HasChildren = false
-			} else {
-				node.HasChildren = len(subEntries) > 0
+// dirListing is a directory's entries as read for the first page of a listing.
+type dirListing struct {
+	entries []os.DirEntry
+	modTime time.Time
+	readAt  time.Time
+}
+
+var (
+	dirListingsMu sync.Mutex
+	dirListings   = make(map[string]*dirListing)
+)
+
+// readDirSorted returns the entries of dirPath sorted by name, which keeps cursors
+// stable between pages. The first page reads the directory, later pages reuse that
+// read while the directory's modification time is unchanged, so paging through a huge
+// directory reads it once rather than once per page.
+func readDirSorted(dirPath string, firstPage bool) ([]os.DirEntry, error) {
+	info, err := os.Stat(dirPath)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+
+	dirListingsMu.Lock()
+	cached := dirListings[dirPath]
+	dirListingsMu.Unlock()
+	if !firstPage && cached != nil && cached.modTime.Equal(info.ModTime()) && now.Sub(cached.readAt) < dirListingCacheTTL {
+		return cached.entries, nil
+	}
+
+	// os.ReadDir returns entries sorted by name.
+	entries, err := os.ReadDir(dirPath)
+	if err != nil {
+		return nil, err
+	}
+
+	dirListingsMu.Lock()
+	defer dirListingsMu.Unlock()
+	for path, listing := range dirListings {
+		if now.Sub(listing.readAt) >= dirListingCacheTTL {
+			delete(dirListings, path)
+		}
+	}
+	if _, ok := dirListings[dirPath]; !ok && len(dirListings) >= dirListingCacheSize {
+		oldest := ""
+		for path, listing := range dirListings {
+			if oldest == "" || listing.readAt.Before(dirListings[oldest].readAt) {
+				oldest = path
 			}
+		}
+		delete(dirListings, oldest)
+	}
+	dirListings[dirPath] = &dirListing{entries: entries, modTime: info.ModTime(), readAt: now}
+	return entries, nil
+}
+
+// buildFSNode fills an FSNode for a directory entry, including ownership, permissions,
+// hidden flag and symlink details. Symlinks pointing at directories are reported as
+// folders so they can be browsed.
+func buildFSNode(dirPath string, entry os.DirEntry) (*pb.FSNode, error) {
+	nodePath := filepath.Join(dirPath, entry.Name())
+	info, err := entry.Info()
+	if err != nil {
+		return nil, err
+	}
+
+	node := &pb.FSNode{
+		Path:         nodePath,
+		Name:         entry.Name(),
+		Type:         pb.FSNode_FILE,
+		Size:         info.Size(),
+		ModifiedTime: info.ModTime().UnixNano(),
+		Permissions:  info.Mode().String(),
+		Owner:        fileOwner(nodePath, info),
+		IsHidden:     isHiddenFile(entry.Name(), info),
+		Attributes:   fileAttributes(info),
+	}
+
+	isDir := info.IsDir()
+	if info.Mode()&os.ModeSymlink != 0 {
+		node.IsSymlink = true
+		if target, err := os.Readlink(nodePath); err == nil {
+			node.SymlinkTarget = target
 		} else {
-			node.Type = pb.FSNode_FILE
-			node.HasChildren = false
+			log.Printf("Could not read symlink target of '%s': %v", nodePath, err)
+		}
+		if targetInfo, err := os.Stat(nodePath); err == nil {
+			isDir = targetInfo.IsDir()
+			if !isDir {
+				node.Size = targetInfo.Size()
+			}
 		}
-		nodes = append(nodes, node)
 	}
-	log.Printf("Finished listing '%s'. Found %d valid nodes.", dirPath, len(nodes))
-	return nodes, nil
+
+	if isDir {
+		node.Type = pb.FSNode_FOLDER
+		node.HasChildren = true
+		node.Size = 0
+	}
+	return node, nil
 }
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestListDirectoryContents(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"a", "b", "c", "d.key", "e", "f", "g"} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	visible := func(path string) bool { return !strings.HasSuffix(path, ".key") }
+
+	listAll := func() []string {
+		var names []string
+		cursor := ""
+		for page := 0; ; page++ {
+			nodes, next, err := listDirectoryContents(dir, cursor, 3, visible)
+			if err != nil {
+				t.Fatalf("listDirectoryContents(%q): %v", cursor, err)
+			}
+			if len(nodes) > 3 {
+				t.Fatalf("page %d has %d entries, more than the page size", page, len(nodes))
+			}
+			for _, node := range nodes {
+				names = append(names, node.GetName())
+			}
+			if next == "" {
+				return names
+			}
+			if page == 0 {
+				dirListingsMu.Lock()
+				_, cached := dirListings[dir]
+				dirListingsMu.Unlock()
+				if !cached {
+					t.Error("the first page should keep the directory's entries for the next pages")
+				}
+			}
+			cursor = next
+		}
+	}
+
+	expected := []string{"a", "b", "c", "e", "f", "g"}
+	if names := listAll(); !slices.Equal(names, expected) {
+		t.Errorf("listing = %v, expected %v", names, expected)
+	}
+
+	// A directory that changes between pages is read again rather than served from the cache.
+	nodes, next, err := listDirectoryContents(dir, "", 3, visible)
+	if err != nil || next != "c" || len(nodes) != 3 {
+		t.Fatalf("first page = %d nodes, cursor %q, %v", len(nodes), next, err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "h"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	// Some filesystems keep modification times in whole seconds.
+	dirListingsMu.Lock()
+	dirListings[dir].modTime = dirListings[dir].modTime.Add(-1)
+	dirListingsMu.Unlock()
+	var rest []string
+	for cursor := next; cursor != ""; {
+		nodes, cursor, err = listDirectoryContents(dir, cursor, 3, visible)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, node := range nodes {
+			rest = append(rest, node.GetName())
+		}
+	}
+	if expected := []string{"e", "f", "g", "h"}; !slices.Equal(rest, expected) {
+		t.Errorf("pages after a change = %v, expected %v", rest, expected)
+	}
+}
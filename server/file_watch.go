@@ -0,0 +1,160 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+
+	pb "control_grpc/gen/proto"
+	"github.com/fsnotify/fsnotify"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// maxWatchedDirs caps recursive watches so a watch on a huge tree cannot exhaust the
+// host's inotify/kqueue limits.
+const maxWatchedDirs = 4096
+
+func (s *server) WatchPath(req *pb.WatchRequest, stream pb.FileTransferService_WatchPathServer) error {
+	root := req.GetPath()
+	log.Printf("WatchPath request received for '%s' (recursive: %v)", root, req.GetRecursive())
+	if root == "" {
+		return status.Errorf(codes.InvalidArgument, "Watch path must not be empty.")
+	}
+	root = filepath.Clean(root)
+	info, err := os.Stat(root)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return status.Errorf(codes.NotFound, "Folder not found: %v", err)
+		}
+		return status.Errorf(codes.Internal, "Failed to access folder: %v", err)
+	}
+	if !info.IsDir() {
+		return status.Errorf(codes.InvalidArgument, "Path '%s' is not a directory.", root)
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		log.Printf("WatchPath: failed to create watcher: %v", err)
+		return status.Errorf(codes.ResourceExhausted, "Failed to create file watcher: %v", err)
+	}
+	defer watcher.Close()
+
+	w := &pathWatch{watcher: watcher, recursive: req.GetRecursive(), policy: s.filePolicy}
+	if err := watcher.Add(root); err != nil {
+		log.Printf("WatchPath: failed to watch '%s': %v", root, err)
+		return status.Errorf(codes.Internal, "Failed to watch folder: %v", err)
+	}
+	w.count = 1
+	if w.recursive {
+		w.addTree(root)
+	}
+	log.Printf("WatchPath: watching %d directories under '%s'", w.count, root)
+
+	ctx := stream.Context()
+	for {
+		select {
+		case <-ctx.Done():
+			log.Printf("WatchPath for '%s' ended: %v", root, ctx.Err())
+			return nil
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+			if !w.policy.visible(event.Name) {
+				continue
+			}
+			watchEvent := w.convert(event)
+			if err := stream.Send(watchEvent); err != nil {
+				log.Printf("WatchPath: error sending event for '%s': %v", event.Name, err)
+				return err
+			}
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+			if errors.Is(err, fsnotify.ErrEventOverflow) {
+				log.Printf("WatchPath: event queue overflowed for '%s'", root)
+				if err := stream.Send(&pb.WatchEvent{Overflow: true}); err != nil {
+					return err
+				}
+				continue
+			}
+			log.Printf("WatchPath: watcher error for '%s': %v", root, err)
+		}
+	}
+}
+
+type pathWatch struct {
+	watcher   *fsnotify.Watcher
+	recursive bool
+	count     int
+	policy    *filePolicy
+}
+
+// addTree adds watches for every directory below dir, up to maxWatchedDirs in total.
+func (w *pathWatch) addTree(dir string) {
+	filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if d != nil && d.IsDir() && p != dir {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if !d.IsDir() || p == dir {
+			return nil
+		}
+		if !w.policy.visible(p) {
+			return fs.SkipDir
+		}
+		if w.count >= maxWatchedDirs {
+			log.Printf("WatchPath: reached limit of %d watched directories; '%s' and the rest are not watched.", maxWatchedDirs, p)
+			return fs.SkipAll
+		}
+		if err := w.watcher.Add(p); err != nil {
+			log.Printf("WatchPath: could not watch '%s': %v", p, err)
+			return fs.SkipDir
+		}
+		w.count++
+		return nil
+	})
+}
+
+func (w *pathWatch) convert(event fsnotify.Event) *pb.WatchEvent {
+	watchEvent := &pb.WatchEvent{
+		Path:      event.Name,
+		Directory: filepath.Dir(event.Name),
+	}
+	switch {
+	case event.Has(fsnotify.Remove):
+		watchEvent.Op = pb.WatchEvent_REMOVE
+	case event.Has(fsnotify.Rename):
+		watchEvent.Op = pb.WatchEvent_RENAME
+	case event.Has(fsnotify.Create):
+		watchEvent.Op = pb.WatchEvent_CREATE
+	case event.Has(fsnotify.Write):
+		watchEvent.Op = pb.WatchEvent_WRITE
+	default:
+		watchEvent.Op = pb.WatchEvent_CHMOD
+	}
+
+	if watchEvent.Op == pb.WatchEvent_REMOVE || watchEvent.Op == pb.WatchEvent_RENAME {
+		return watchEvent
+	}
+	info, err := os.Lstat(event.Name)
+	if err != nil {
+		return watchEvent
+	}
+	if node, err := buildFSNode(watchEvent.Directory, fs.FileInfoToDirEntry(info)); err == nil {
+		watchEvent.Node = node
+	}
+	if w.recursive && watchEvent.Op == pb.WatchEvent_CREATE && info.IsDir() && w.count < maxWatchedDirs {
+		if err := w.watcher.Add(event.Name); err == nil {
+			w.count++
+			w.addTree(event.Name)
+		}
+	}
+	return watchEvent
+}
@@ -0,0 +1,317 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/flate"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+
+	pb "control_grpc/gen/proto"
+	"github.com/klauspost/compress/zstd"
+)
+
+// archiveEntry is a file, folder or symlink selected for a folder archive.
+type archiveEntry struct {
+	path    string // absolute path on disk
+	relPath string // slash-separated path inside the archive
+	info    os.FileInfo
+}
+
+// folderFilter applies FileRequest include/exclude globs to paths relative to the
+// archived folder.
+type folderFilter struct {
+	include []string
+	exclude []string
+}
+
+func newFolderFilter(include, exclude []string) (*folderFilter, error) {
+	f := &folderFilter{}
+	for _, list := range []struct {
+		in  []string
+		out *[]string
+	}{{include, &f.include}, {exclude, &f.exclude}} {
+		for _, p := range list.in {
+			p = strings.Trim(filepath.ToSlash(strings.TrimSpace(p)), "/")
+			if p == "" {
+				continue
+			}
+			if _, err := path.Match(p, ""); err != nil {
+				return nil, fmt.Errorf("invalid pattern %q: %w", p, err)
+			}
+			*list.out = append(*list.out, p)
+		}
+	}
+	return f, nil
+}
+
+func matchGlob(pattern, relPath string) bool {
+	if !strings.Contains(pattern, "/") {
+		relPath = path.Base(relPath)
+	}
+	matched, _ := path.Match(pattern, relPath)
+	return matched
+}
+
+// excluded reports whether relPath matches an exclude pattern. Callers check every
+// folder on the way down, so excluding a folder name skips its whole subtree.
+func (f *folderFilter) excluded(relPath string) bool {
+	for _, p := range f.exclude {
+		if matchGlob(p, relPath) {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *folderFilter) included(relPath string) bool {
+	if len(f.include) == 0 {
+		return true
+	}
+	for _, p := range f.include {
+		if matchGlob(p, relPath) {
+			return true
+		}
+	}
+	return false
+}
+
+// collectArchiveEntries walks root once up front so the total size is known before
+// streaming starts. Unreadable entries and those hidden by the host's file policy are
+// skipped. Folders are only listed on their own when no include patterns are set;
+// otherwise they appear implicitly through the files they contain.
+func collectArchiveEntries(ctx context.Context, root string, filter *folderFilter, policy *filePolicy) ([]archiveEntry, int64, error) {
+	var entries []archiveEntry
+	var totalSize int64
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		if err != nil {
+			log.Printf("Error accessing path '%s' during walk: %v. Skipping.", p, err)
+			if d != nil && d.IsDir() && p != root {
+				return fs.SkipDir
+			}
+			if p == root {
+				return err
+			}
+			return nil
+		}
+		if p == root {
+			return nil
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			log.Printf("Error getting relative path for '%s' (base '%s'): %v. Skipping.", p, root, err)
+			return nil
+		}
+		rel = filepath.ToSlash(rel)
+
+		if filter.excluded(rel) || !policy.visible(p) {
+			if d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			log.Printf("Could not stat '%s': %v. Skipping.", p, err)
+			return nil
+		}
+
+		switch {
+		case d.IsDir():
+			if len(filter.include) == 0 {
+				entries = append(entries, archiveEntry{path: p, relPath: rel, info: info})
+			}
+		case info.Mode().IsRegular(), info.Mode()&os.ModeSymlink != 0:
+			if filter.included(rel) {
+				entries = append(entries, archiveEntry{path: p, relPath: rel, info: info})
+				if info.Mode().IsRegular() {
+					totalSize += info.Size()
+				}
+			}
+		default:
+			log.Printf("Skipping special file '%s' in archive.", p)
+		}
+		return nil
+	})
+	return entries, totalSize, err
+}
+
+// archiveWriter writes entries in one of the supported archive formats.
+type archiveWriter interface {
+	// WriteEntry adds an entry. content is only read for regular files.
+	WriteEntry(entry archiveEntry, linkTarget string, content io.Reader) error
+	Close() error
+}
+
+func newArchiveWriter(format pb.ArchiveFormat, level int32, w io.Writer) (archiveWriter, error) {
+	if level < 0 || level > 9 {
+		return nil, fmt.Errorf("compression level %d out of range 0-9", level)
+	}
+	switch format {
+	case pb.ArchiveFormat_ARCHIVE_ZIP:
+		zw := zip.NewWriter(w)
+		flateLevel := flate.DefaultCompression
+		if level > 0 {
+			flateLevel = int(level)
+		}
+		zw.RegisterCompressor(zip.Deflate, func(out io.Writer) (io.WriteCloser, error) {
+			return flate.NewWriter(out, flateLevel)
+		})
+		return &zipArchiveWriter{zw: zw}, nil
+	case pb.ArchiveFormat_ARCHIVE_TAR_GZ:
+		gzipLevel := gzip.DefaultCompression
+		if level > 0 {
+			gzipLevel = int(level)
+		}
+		gz, err := gzip.NewWriterLevel(w, gzipLevel)
+		if err != nil {
+			return nil, err
+		}
+		return &tarArchiveWriter{tw: tar.NewWriter(gz), compressor: gz}, nil
+	case pb.ArchiveFormat_ARCHIVE_TAR_ZST:
+		zstdLevel := zstd.SpeedDefault
+		if level > 0 {
+			zstdLevel = zstd.EncoderLevelFromZstd(int(level))
+		}
+		zw, err := zstd.NewWriter(w, zstd.WithEncoderLevel(zstdLevel))
+		if err != nil {
+			return nil, err
+		}
+		return &tarArchiveWriter{tw: tar.NewWriter(zw), compressor: zw}, nil
+	default:
+		return nil, fmt.Errorf("unsupported archive format %v", format)
+	}
+}
+
+type zipArchiveWriter struct {
+	zw *zip.Writer
+}
+
+func (a *zipArchiveWriter) WriteEntry(entry archiveEntry, linkTarget string, content io.Reader) error {
+	header, err := zip.FileInfoHeader(entry.info)
+	if err != nil {
+		return err
+	}
+	header.Name = entry.relPath
+	switch {
+	case entry.info.IsDir():
+		header.Name += "/"
+		header.Method = zip.Store
+	case entry.info.Mode()&os.ModeSymlink != 0:
+		// Info-ZIP convention: symlink entries store the link target as their content.
+		header.Method = zip.Store
+		content = strings.NewReader(linkTarget)
+	default:
+		header.Method = zip.Deflate
+	}
+	entryWriter, err := a.zw.CreateHeader(header)
+	if err != nil {
+		return err
+	}
+	if content != nil && !entry.info.IsDir() {
+		_, err = io.Copy(entryWriter, content)
+	}
+	return err
+}
+
+func (a *zipArchiveWriter) Close() error {
+	return a.zw.Close()
+}
+
+type tarArchiveWriter struct {
+	tw         *tar.Writer
+	compressor io.WriteCloser
+}
+
+func (a *tarArchiveWriter) WriteEntry(entry archiveEntry, linkTarget string, content io.Reader) error {
+	header, err := tar.FileInfoHeader(entry.info, linkTarget)
+	if err != nil {
+		return err
+	}
+	header.Name = entry.relPath
+	if entry.info.IsDir() {
+		header.Name += "/"
+	}
+	if err := a.tw.WriteHeader(header); err != nil {
+		return err
+	}
+	if content != nil && entry.info.Mode().IsRegular() {
+		// Never write more than the header promised, even if the file grew meanwhile.
+		_, err = io.Copy(a.tw, io.LimitReader(content, header.Size))
+	}
+	return err
+}
+
+func (a *tarArchiveWriter) Close() error {
+	if err := a.tw.Close(); err != nil {
+		a.compressor.Close()
+		return err
+	}
+	return a.compressor.Close()
+}
+
+// countingReader adds the number of bytes read to a shared progress counter.
+type countingReader struct {
+	r     io.Reader
+	count *atomic.Int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.count.Add(int64(n))
+	return n, err
+}
+
+// writeFolderArchive writes entries to aw, adding source bytes read to progress.
+// Files that can no longer be opened because of permissions are skipped.
+func writeFolderArchive(ctx context.Context, aw archiveWriter, entries []archiveEntry, progress *atomic.Int64) error {
+	for _, entry := range entries {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		var linkTarget string
+		var content io.Reader
+		var file *os.File
+		switch {
+		case entry.info.Mode()&os.ModeSymlink != 0:
+			target, err := os.Readlink(entry.path)
+			if err != nil {
+				log.Printf("Error reading symlink '%s': %v. Skipping.", entry.path, err)
+				continue
+			}
+			linkTarget = target
+		case entry.info.Mode().IsRegular():
+			f, err := os.Open(entry.path)
+			if err != nil {
+				log.Printf("Error opening file '%s' for archiving: %v. Skipping.", entry.path, err)
+				if os.IsPermission(err) || os.IsNotExist(err) {
+					continue
+				}
+				return err
+			}
+			file = f
+			content = &countingReader{r: f, count: progress}
+		}
+
+		err := aw.WriteEntry(entry, linkTarget, content)
+		if file != nil {
+			file.Close()
+		}
+		if err != nil {
+			return fmt.Errorf("archiving '%s': %w", entry.path, err)
+		}
+	}
+	return aw.Close()
+}
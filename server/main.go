@@ -19,6 +19,7 @@ import (
 	"time"
 
 	pb "control_grpc/gen/proto"
+	"control_grpc/server/screen"
 
 	"fyne.io/fyne/v2"
 	"fyne.io/fyne/v2/app"
@@ -57,6 +58,9 @@ type server struct {
 	allowKeyboardControl  bool
 	allowFileSystemAccess bool
 	allowTerminalAccess   bool
+	filePolicy            *filePolicy
+	terminals             *terminalRegistry
+	feeds                 *feedRegistry
 }
 
 var (
@@ -65,12 +69,19 @@ var (
 	allowKeyboardControlFlag  = flag.Bool("allowKeyboardControl", true, "Allow client to control keyboard")
 	allowFileSystemAccessFlag = flag.Bool("allowFileSystemAccess", true, "Allow client to access file system")
 	allowTerminalAccessFlag   = flag.Bool("allowTerminalAccess", true, "Allow client to access terminal")
+	terminalGraceFlag         = flag.Duration("terminalGrace", 10*time.Minute, "How long a terminal session keeps running after its client disconnects, so it can be reattached. 0 ends it immediately.")
+	terminalRecordDirFlag     = flag.String("terminalRecordDir", "", "Record every terminal session, including what is typed, to an asciicast (asciinema) file in this folder. Empty disables recording.")
+	terminalShellsFlag        = flag.String("terminalShells", "", "Comma separated shells clients may start in the terminal, by ID or as id=path, e.g. pwsh,cmd,wsl or bash,zsh,tools=/usr/local/bin/toolsh. The first is the default. Empty offers every known shell found on this host.")
+	fileRootsFlag             = flag.String("fileRoots", "", "Folders shared with clients, separated by the OS path list separator (';' on Windows, ':' elsewhere). Append =ro for read-only, e.g. /srv/data:/var/log=ro. Empty shares every drive.")
+	fileDenyFlag              = flag.String("fileDeny", "", "Comma separated name or path patterns clients may never access, e.g. .ssh,*.key,id_*")
+	fileReadOnlyFlag          = flag.Bool("fileReadOnly", false, "Reject all file system changes (uploads, edits, moves and deletes)")
 	enableRelay               = flag.Bool("relay", false, "Enable relay mode to connect through a relay server")
 	relayServerAddr           = flag.String("relayServer", "localhost:34000", "Address of the relay server's control port (IP:PORT)")
 	hostIDFlag                = flag.String("hostID", "auto", "Unique ID for this host. 'auto' for random generation.")
 	sessionPasswordFlag       = flag.String("sessionPassword", "", "HASHED password to protect this host session when using relay (optional).")
 	localRelaxedAuthFlag      = flag.Bool("localRelaxedAuth", false, "Enable relaxed client certificate authentication for direct local connections.")
 	headlessFlag              = flag.Bool("headless", false, "Run the server without any GUI.")
+	captureSourceFlag         = flag.String("captureSource", "auto", "Screen capture backend: auto, gdigrab (Windows), x11grab (X11 and Xvfb, uses DISPLAY), pipewiregrab (Wayland) or kmsgrab (Linux console, needs root)")
 
 	fyneApp                   fyne.App
 	fyneWindow                fyne.Window
@@ -185,6 +196,7 @@ func min(a, b int) int {
 func main() {
 	flag.Parse()
 	log.SetFlags(log.LstdFlags | log.Lshortfile)
+	screen.PreferredSource = *captureSourceFlag
 
 	initialHostID := *hostIDFlag
 	if strings.ToLower(initialHostID) == "auto" || initialHostID == "" {
@@ -194,12 +206,25 @@ func main() {
 		log.Printf("INFO: Using provided initial Host ID: %s", initialHostID)
 	}
 
+	terminalShells, err := parseTerminalShells(*terminalShellsFlag)
+	if err != nil {
+		log.Fatalf("FATAL: Invalid terminal shell list: %v", err)
+	}
+
+	if *terminalRecordDirFlag != "" {
+		if err := os.MkdirAll(*terminalRecordDirFlag, 0700); err != nil {
+			log.Fatalf("FATAL: Cannot create terminal recording folder: %v", err)
+		}
+	}
+
 	s := &server{
 		sessionPasswordHash:   *sessionPasswordFlag,
 		allowMouseControl:     *allowMouseControlFlag,
 		allowKeyboardControl:  *allowKeyboardControlFlag,
 		allowFileSystemAccess: *allowFileSystemAccessFlag,
 		allowTerminalAccess:   *allowTerminalAccessFlag,
+		terminals:             newTerminalRegistry(*terminalGraceFlag, terminalShells, *terminalRecordDirFlag),
+		feeds:                 newFeedRegistry(),
 	}
 	if s.sessionPasswordHash != "" {
 		log.Printf("INFO: Session password protection is ENABLED.")
@@ -211,6 +236,23 @@ func main() {
 	log.Printf("INFO: Permission - Keyboard Control: %t", s.allowKeyboardControl)
 	log.Printf("INFO: Permission - File System Access: %t", s.allowFileSystemAccess)
 	log.Printf("INFO: Permission - Terminal Access: %t", s.allowTerminalAccess)
+	log.Printf("INFO: Terminal sessions survive client disconnects for %v", *terminalGraceFlag)
+	for i, shell := range terminalShells {
+		log.Printf("INFO: Terminal shell %q: %s %s (default: %t)", shell.id, shell.path, strings.Join(shell.args, " "), i == 0)
+	}
+	if *terminalRecordDirFlag != "" {
+		log.Printf("INFO: Terminal sessions are recorded to %s", *terminalRecordDirFlag)
+	}
+	if len(terminalShells) == 0 {
+		log.Printf("WARN: No terminal shells are available. Terminal sessions cannot be started.")
+	}
+
+	filePolicy, err := newFilePolicy(*fileRootsFlag, *fileDenyFlag, *fileReadOnlyFlag)
+	if err != nil {
+		log.Fatalf("FATAL: Invalid file access policy: %v", err)
+	}
+	s.filePolicy = filePolicy
+	log.Printf("INFO: File access policy - Shared folders: %s", filePolicy)
 
 	if *localRelaxedAuthFlag {
 		log.Printf("INFO: Relaxed local client authentication is ENABLED.")
@@ -249,6 +291,8 @@ func main() {
 		grpc.Creds(tlsCredentials),
 		grpc.MaxSendMsgSize(1024 * 1024 * 10),
 		grpc.MaxRecvMsgSize(1024 * 1024 * 10),
+		grpc.ChainUnaryInterceptor(s.fileAccessUnaryInterceptor, s.terminalAccessUnaryInterceptor),
+		grpc.ChainStreamInterceptor(s.fileAccessStreamInterceptor, s.terminalAccessStreamInterceptor),
 	}
 	// log.Println("WARN: TLS is temporarily disabled for server for compilation purposes.")
 
@@ -675,7 +719,7 @@ func loadTLSCredentialsFromEmbed(relaxedAuthEnabled bool) (credentials.Transport
 }
 
 func (s *server) Ping(ctx context.Context, req *pb.PingRequest) (*pb.PingResponse, error) {
-	return &pb.PingResponse{ClientTimestampNano: req.GetClientTimestampNano()}, nil
+	return &pb.PingResponse{ClientTimestampNano: req.GetClientTimestampNano(), ServerTimestampNano: time.Now().UnixNano()}, nil
 }
 
 func (s *server) GetSessionInfo(ctx context.Context, req *pb.GetSessionInfoRequest) (*pb.SessionInfoResponse, error) {
@@ -688,6 +732,7 @@ func (s *server) GetSessionInfo(ctx context.Context, req *pb.GetSessionInfoReque
 			AllowFileSystemAccess: s.allowFileSystemAccess,
 			AllowTerminalAccess:   s.allowTerminalAccess,
 		},
+		TerminalShells: s.terminals.shellInfos(),
 	}, nil
 }
 
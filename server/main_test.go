@@ -167,3 +167,57 @@ func TestKeyboardLogging(t *testing.T) {
 	}
 	logBuffer.Reset()
 }
+
+func TestDetectTextEncoding(t *testing.T) {
+	testCases := []struct {
+		name      string
+		data      []byte
+		truncated bool
+		expected  pb.TextEncoding
+	}{
+		{"ASCII", []byte("key = value\n"), false, pb.TextEncoding_ENCODING_UTF8},
+		{"Empty", []byte{}, false, pb.TextEncoding_ENCODING_UTF8},
+		{"UTF8", []byte("привет\n"), false, pb.TextEncoding_ENCODING_UTF8},
+		{"UTF8CutMidRune", []byte("привет")[:5], true, pb.TextEncoding_ENCODING_UTF8},
+		{"UTF8CutMidRuneAtEOF", []byte("привет")[:5], false, pb.TextEncoding_ENCODING_LATIN1},
+		{"UTF8BOM", []byte("\xEF\xBB\xBFtext"), false, pb.TextEncoding_ENCODING_UTF8_BOM},
+		{"UTF16LE", []byte{0xFF, 0xFE, 'a', 0, 'b', 0}, false, pb.TextEncoding_ENCODING_UTF16_LE},
+		{"UTF16BE", []byte{0xFE, 0xFF, 0, 'a', 0, 'b'}, false, pb.TextEncoding_ENCODING_UTF16_BE},
+		{"Latin1", []byte("caf\xe9 cr\xe8me\n"), false, pb.TextEncoding_ENCODING_LATIN1},
+		{"NULBytes", []byte("\x7fELF\x02\x01\x01\x00\x00"), false, pb.TextEncoding_ENCODING_BINARY},
+		{"ControlBytes", []byte{0x89, 0x01, 0x02, 0x03, 0x04, 0x05, 'a'}, false, pb.TextEncoding_ENCODING_BINARY},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			result := detectTextEncoding(tc.data, tc.data, tc.truncated)
+			if result != tc.expected {
+				t.Errorf("detectTextEncoding(%q, truncated=%v): expected %v, got %v", tc.data, tc.truncated, tc.expected, result)
+			}
+		})
+	}
+}
+
+func TestAppendScrollback(t *testing.T) {
+	testCases := []struct {
+		name       string
+		scrollback string
+		chunk      string
+		limit      int
+		expected   string
+	}{
+		{"BelowLimit", "abc", "def", 10, "abcdef"},
+		{"AtLimit", "abcde", "fghij", 10, "abcdefghij"},
+		{"TrimToLineStart", "line1\nline2\n", "line3\n", 14, "line2\nline3\n"},
+		{"TrimWithoutNewline", "abcdef", "ghij", 6, "efghij"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			result := appendScrollback([]byte(tc.scrollback), []byte(tc.chunk), tc.limit)
+			if string(result) != tc.expected {
+				t.Errorf("appendScrollback(%q, %q, %d): expected %q, got %q", tc.scrollback, tc.chunk, tc.limit, tc.expected, result)
+			}
+		})
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"io"
+	"runtime"
+	"strings"
+)
+
+const (
+	defaultTerminalCols = 120
+	defaultTerminalRows = 30
+)
+
+// ptySession is an interactive shell attached to a pseudo-terminal. Reads return what
+// the shell prints and writes are typed into the terminal. startPTY creates one using
+// the platform's backend: winpty on Windows, /dev/ptmx on Linux and macOS.
+type ptySession interface {
+	io.Reader
+	io.Writer
+	// Resize changes the terminal size in character cells.
+	Resize(cols, rows int) error
+	// Close ends the shell and releases the terminal. It is safe to call more than once
+	// and unblocks a pending Read.
+	Close() error
+	// Shell is the program running in the terminal, for logging.
+	Shell() string
+}
+
+// ptyOptions configure a new terminal session. Zero values select the platform defaults.
+type ptyOptions struct {
+	shell string   // path of the shell program
+	args  []string // arguments after the program name
+	dir   string   // working directory
+	env   []string // KEY=VALUE pairs added to the server's environment
+	cols  int
+	rows  int
+}
+
+// setEnv replaces or appends a variable in an os.Environ style list. Names are case
+// insensitive on Windows.
+func setEnv(env []string, key, value string) []string {
+	result := make([]string, 0, len(env)+1)
+	for _, kv := range env {
+		if !envKeyIs(kv, key) {
+			result = append(result, kv)
+		}
+	}
+	return append(result, key+"="+value)
+}
+
+// setEnvDefault adds a variable only when env does not already define it.
+func setEnvDefault(env []string, key, value string) []string {
+	for _, kv := range env {
+		if envKeyIs(kv, key) {
+			return env
+		}
+	}
+	return append(env, key+"="+value)
+}
+
+// mergeEnv applies the KEY=VALUE pairs in extra over env.
+func mergeEnv(env, extra []string) []string {
+	for _, kv := range extra {
+		if key, value, ok := strings.Cut(kv, "="); ok {
+			env = setEnv(env, key, value)
+		}
+	}
+	return env
+}
+
+func envKeyIs(kv, key string) bool {
+	name, _, ok := strings.Cut(kv, "=")
+	if !ok {
+		return false
+	}
+	if runtime.GOOS == "windows" {
+		return strings.EqualFold(name, key)
+	}
+	return name == key
+}
@@ -0,0 +1,19 @@
+//go:build !windows && !linux && !darwin
+
+package main
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// ptyEnterKey is sent after each command line typed by the client.
+const ptyEnterKey = "\r"
+
+func startPTY(opts ptyOptions) (ptySession, error) {
+	return nil, fmt.Errorf("terminal sessions are not supported on %s", runtime.GOOS)
+}
+
+func knownShells() []shellCandidate {
+	return nil
+}
@@ -0,0 +1,250 @@
+//go:build linux || darwin
+
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// ptyEnterKey is sent after each command line typed by the client, like the Return key
+// in a terminal emulator.
+const ptyEnterKey = "\r"
+
+// shellExitTimeout is how long Close waits for the shell to hang up before killing it.
+const shellExitTimeout = 3 * time.Second
+
+type unixPTY struct {
+	master    *os.File
+	cmd       *exec.Cmd
+	shell     string
+	exited    chan struct{} // closed once the shell has been reaped
+	closeOnce sync.Once
+}
+
+// startPTY runs the current user's login shell on a new pseudo-terminal.
+func startPTY(opts ptyOptions) (ptySession, error) {
+	shell := opts.shell
+	if shell == "" {
+		shell = loginShell()
+	}
+	dir := opts.dir
+	if dir == "" {
+		dir = defaultUnixTerminalDir()
+	}
+	env := setEnv(os.Environ(), "TERM", "xterm-256color")
+	if u, err := user.Current(); err == nil {
+		env = setEnvDefault(env, "HOME", u.HomeDir)
+		env = setEnvDefault(env, "USER", u.Username)
+		env = setEnvDefault(env, "LOGNAME", u.Username)
+	}
+	env = setEnvDefault(env, "SHELL", shell)
+	env = mergeEnv(env, opts.env)
+	cols, rows := opts.cols, opts.rows
+	if cols <= 0 || rows <= 0 {
+		cols, rows = defaultTerminalCols, defaultTerminalRows
+	}
+
+	master, slaveName, err := openPTY()
+	if err != nil {
+		return nil, err
+	}
+	slave, err := os.OpenFile(slaveName, os.O_RDWR|unix.O_NOCTTY, 0)
+	if err != nil {
+		master.Close()
+		return nil, fmt.Errorf("failed to open %s: %w", slaveName, err)
+	}
+	// The child keeps its own copies of the slave descriptor.
+	defer slave.Close()
+
+	p := &unixPTY{master: master, shell: shell, exited: make(chan struct{})}
+	if err := p.Resize(cols, rows); err != nil {
+		master.Close()
+		return nil, err
+	}
+
+	// A leading dash in argv[0] asks the shell to behave as a login shell and read the
+	// user's profile.
+	cmd := &exec.Cmd{
+		Path:   shell,
+		Args:   append([]string{"-" + filepath.Base(shell)}, opts.args...),
+		Dir:    dir,
+		Env:    env,
+		Stdin:  slave,
+		Stdout: slave,
+		Stderr: slave,
+		// Start a new session with the slave as its controlling terminal so job
+		// control and Ctrl+C reach the shell's foreground process group.
+		SysProcAttr: &syscall.SysProcAttr{Setsid: true, Setctty: true, Ctty: 0},
+	}
+	log.Printf("TerminalService: Starting login shell %s in directory: %s (%s)", shell, dir, slaveName)
+	if err := cmd.Start(); err != nil {
+		master.Close()
+		return nil, fmt.Errorf("failed to start shell %s: %w", shell, err)
+	}
+	p.cmd = cmd
+	go func() {
+		err := cmd.Wait()
+		log.Printf("TerminalService: Shell %s (pid %d) exited: %v", shell, cmd.Process.Pid, err)
+		close(p.exited)
+	}()
+	return p, nil
+}
+
+// openPTY opens a new pseudo-terminal master and returns it with the path of its slave.
+func openPTY() (*os.File, string, error) {
+	master, err := os.OpenFile("/dev/ptmx", os.O_RDWR|unix.O_NOCTTY|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to open /dev/ptmx: %w", err)
+	}
+	conn, err := master.SyscallConn()
+	if err != nil {
+		master.Close()
+		return nil, "", err
+	}
+	var slaveName string
+	var ptyErr error
+	if err := conn.Control(func(fd uintptr) {
+		slaveName, ptyErr = unlockPTY(int(fd))
+	}); err != nil {
+		ptyErr = err
+	}
+	if ptyErr != nil {
+		master.Close()
+		return nil, "", fmt.Errorf("failed to set up pseudo-terminal: %w", ptyErr)
+	}
+	return master, slaveName, nil
+}
+
+// knownShells lists the shells offered to clients when -terminalShells is empty. The
+// first one found is the default.
+func knownShells() []shellCandidate {
+	return []shellCandidate{
+		{id: "login", name: "Login shell", paths: []string{loginShell()}},
+		{id: "bash", name: "Bash", paths: []string{"bash"}},
+		{id: "zsh", name: "Zsh", paths: []string{"zsh"}},
+		{id: "fish", name: "fish", paths: []string{"fish"}},
+		{id: "sh", name: "POSIX sh", paths: []string{"sh"}},
+		{id: "pwsh", name: "PowerShell", paths: []string{"pwsh"}, args: []string{"-NoLogo"}},
+	}
+}
+
+// loginShell returns the current user's shell from the password database, then $SHELL,
+// then /bin/sh.
+func loginShell() string {
+	candidates := []string{passwdShell(os.Getuid()), os.Getenv("SHELL"), "/bin/sh"}
+	for _, shell := range candidates {
+		if shell == "" || !filepath.IsAbs(shell) {
+			continue
+		}
+		if info, err := os.Stat(shell); err == nil && !info.IsDir() && info.Mode()&0111 != 0 {
+			return shell
+		}
+	}
+	return "/bin/sh"
+}
+
+// passwdShell looks up uid in /etc/passwd. macOS keeps regular accounts in Directory
+// Services instead, so there this usually falls through to $SHELL.
+func passwdShell(uid int) string {
+	file, err := os.Open("/etc/passwd")
+	if err != nil {
+		return ""
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Split(scanner.Text(), ":")
+		if len(fields) < 7 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if fields[2] == strconv.Itoa(uid) {
+			return fields[6]
+		}
+	}
+	return ""
+}
+
+func defaultUnixTerminalDir() string {
+	if home, err := os.UserHomeDir(); err == nil {
+		if info, err := os.Stat(home); err == nil && info.IsDir() {
+			return home
+		}
+	}
+	if cwd, err := os.Getwd(); err == nil {
+		return cwd
+	}
+	return "/"
+}
+
+func (p *unixPTY) Read(b []byte) (int, error) {
+	n, err := p.master.Read(b)
+	// Once every slave descriptor is closed the master reports EIO rather than EOF.
+	if err != nil && (errors.Is(err, syscall.EIO) || errors.Is(err, os.ErrClosed)) {
+		err = io.EOF
+	}
+	return n, err
+}
+
+func (p *unixPTY) Write(b []byte) (int, error) {
+	return p.master.Write(b)
+}
+
+func (p *unixPTY) Resize(cols, rows int) error {
+	if cols <= 0 || rows <= 0 || cols > 0xFFFF || rows > 0xFFFF {
+		return fmt.Errorf("invalid terminal size %dx%d", cols, rows)
+	}
+	conn, err := p.master.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var ioctlErr error
+	if err := conn.Control(func(fd uintptr) {
+		ioctlErr = unix.IoctlSetWinsize(int(fd), unix.TIOCSWINSZ, &unix.Winsize{Row: uint16(rows), Col: uint16(cols)})
+	}); err != nil {
+		return err
+	}
+	if ioctlErr != nil {
+		return fmt.Errorf("failed to resize terminal: %w", ioctlErr)
+	}
+	return nil
+}
+
+// Close hangs up the shell's session, as closing a terminal window would, and kills it
+// if it does not exit in time.
+func (p *unixPTY) Close() error {
+	p.closeOnce.Do(func() {
+		if p.cmd != nil && p.cmd.Process != nil {
+			_ = syscall.Kill(-p.cmd.Process.Pid, syscall.SIGHUP)
+		}
+		p.master.Close()
+		if p.cmd == nil {
+			return
+		}
+		select {
+		case <-p.exited:
+		case <-time.After(shellExitTimeout):
+			log.Printf("TerminalService: Shell %s did not exit after SIGHUP, killing it.", p.shell)
+			_ = syscall.Kill(-p.cmd.Process.Pid, syscall.SIGKILL)
+		}
+	})
+	return nil
+}
+
+func (p *unixPTY) Shell() string {
+	return p.shell
+}
@@ -15,15 +15,42 @@ import (
 	"control_grpc/server/screen"
 )
 
-func (s *server) GetFeed(stream pb.RemoteControlService_GetFeedServer) error {
+func (s *server) GetFeed(feedStream pb.RemoteControlService_GetFeedServer) error {
+	// The screen feed and the cursor sender share the stream.
+	stream := &syncFeedStream{RemoteControlService_GetFeedServer: feedStream}
 	serverWidth, serverHeight := robotgo.GetScreenSize()
 	log.Printf("Server screen dimensions: %dx%d", serverWidth, serverHeight)
 
-	var capture *screen.ScreenCapture
-	var err error
+	reqMsgInit, err := stream.Recv()
+	if err != nil {
+		if err == io.EOF {
+			log.Println("Client closed stream before init.")
+			return nil
+		}
+		log.Printf("Failed to receive initial message: %v", err)
+		return status.Errorf(codes.InvalidArgument, "Failed to receive initial message: %v", err)
+	}
+	log.Printf("Received init message from client: Width=%d, Height=%d, Display=%d, RemoteCursor=%t", reqMsgInit.GetClientWidth(), reqMsgInit.GetClientHeight(), reqMsgInit.GetDisplayIndex(), reqMsgInit.GetRemoteCursor())
+
+	display, region := newFeedDisplay(int(reqMsgInit.GetDisplayIndex()))
+	video := newAdaptiveVideo(reqMsgInit.GetVideoSettings(), time.Now())
+
+	var capture feedCapture
+	var videoCapture *screen.ScreenCapture
+	var tileCapture *screen.TileCapture
 	videoCaptureActive := false
 
-	capture, err = screen.NewScreenCapture()
+	if tileFormat := reqMsgInit.GetTileFormat(); tileFormat != "" {
+		tileCapture, err = screen.NewTileCapture(region, video.settings(), tileFormat)
+		if err == nil {
+			capture = tileCapture
+		}
+	} else {
+		videoCapture, err = screen.NewScreenCapture(region, video.settings(), feedCodecs(reqMsgInit.GetCodecs()), !reqMsgInit.GetRemoteCursor())
+		if err == nil {
+			capture = videoCapture
+		}
+	}
 	if err != nil {
 		log.Printf("Error initializing screen capture: %v", err)
 		errMsg := fmt.Sprintf("Failed to initialize screen capture: %v", err)
@@ -39,23 +66,17 @@ func (s *server) GetFeed(stream pb.RemoteControlService_GetFeedServer) error {
 		videoCaptureActive = true
 		defer capture.Close()
 	}
-
-	reqMsgInit, err := stream.Recv()
-	if err != nil {
-		if err == io.EOF {
-			log.Println("Client closed stream before init.")
-			return nil
+	var feedID string
+	if capture != nil {
+		if feedID, err = s.feeds.add(capture); err != nil {
+			log.Printf("Failed to register the feed for keyframe requests: %v", err)
+		} else {
+			defer s.feeds.remove(feedID)
 		}
-		log.Printf("Failed to receive initial message: %v", err)
-		return status.Errorf(codes.InvalidArgument, "Failed to receive initial message: %v", err)
 	}
-	log.Printf("Received init message from client: Width=%d, Height=%d", reqMsgInit.GetClientWidth(), reqMsgInit.GetClientHeight())
-
-	scaleX, scaleY := getScaleFactors(serverWidth, serverHeight, reqMsgInit)
-	log.Printf("Calculated scale factors: ScaleX=%.2f, ScaleY=%.2f", scaleX, scaleY)
 
 	inputEvents := make(chan *pb.FeedRequest, 120)
-	go handleInputEvents(s, inputEvents, scaleX, scaleY)
+	go handleInputEvents(s, inputEvents, display, video, capture)
 
 	errChan := make(chan error, 1)
 	go func() {
@@ -65,7 +86,12 @@ func (s *server) GetFeed(stream pb.RemoteControlService_GetFeedServer) error {
 	if videoCaptureActive && capture != nil {
 		log.Println("Starting screen feed sender goroutine.")
 		go func() {
-			feedErr := sendScreenFeed(stream, capture)
+			var feedErr error
+			if tileCapture != nil {
+				feedErr = sendTileFeed(stream, tileCapture, feedID, display, video)
+			} else {
+				feedErr = sendScreenFeed(stream, videoCapture, feedID, display, video)
+			}
 			if feedErr != nil {
 				log.Printf("sendScreenFeed goroutine exited with error: %v", feedErr)
 			} else {
@@ -76,6 +102,14 @@ func (s *server) GetFeed(stream pb.RemoteControlService_GetFeedServer) error {
 		log.Println("Video capture is not active; not starting screen feed sender.")
 	}
 
+	if videoCaptureActive && reqMsgInit.GetRemoteCursor() {
+		go func() {
+			if err := sendCursor(stream, display); err != nil {
+				log.Printf("sendCursor goroutine exited with error: %v", err)
+			}
+		}()
+	}
+
 	receiveErr := <-errChan
 	close(inputEvents) // Close inputEvents to stop handleInputEvents and related goroutines
 	log.Printf("GetFeed: receiveInputEvents goroutine finished with error: %v", receiveErr)
@@ -99,16 +133,6 @@ func (s *server) GetFeed(stream pb.RemoteControlService_GetFeedServer) error {
 	return nil
 }
 
-func getScaleFactors(serverWidth, serverHeight int, reqMsgInit *pb.FeedRequest) (float32, float32) {
-	if reqMsgInit.GetClientWidth() == 0 || reqMsgInit.GetClientHeight() == 0 {
-		log.Println("Client width or height is zero, using 1.0 for scale factors.")
-		return 1.0, 1.0
-	}
-	scaleX := float32(serverWidth) / float32(reqMsgInit.GetClientWidth())
-	scaleY := float32(serverHeight) / float32(reqMsgInit.GetClientHeight())
-	return scaleX, scaleY
-}
-
 func mapFyneKeyToRobotGo(fyneKeyName string) (key string, isSpecial bool) {
 	switch fyneKeyName {
 	case "Return", "Enter":
@@ -204,7 +228,7 @@ func mapFyneKeyToRobotGo(fyneKeyName string) (key string, isSpecial bool) {
 	}
 }
 
-func handleInputEvents(s *server, inputEvents chan *pb.FeedRequest, scaleX, scaleY float32) {
+func handleInputEvents(s *server, inputEvents chan *pb.FeedRequest, display *feedDisplay, video *adaptiveVideo, capture feedCapture) {
 	log.Println("Input event handler goroutine started.")
 	defer log.Println("Input event handler goroutine stopped.")
 
@@ -238,8 +262,7 @@ func handleInputEvents(s *server, inputEvents chan *pb.FeedRequest, scaleX, scal
 							continue
 						}
 
-						serverX := int(float32(point.X) * scaleX)
-						serverY := int(float32(point.Y) * scaleY)
+						serverX, serverY := display.toDesktop(float32(point.X), float32(point.Y), reqMsg.GetClientWidth(), reqMsg.GetClientHeight())
 						robotgo.Move(serverX, serverY)
 
 					}
@@ -248,8 +271,7 @@ func handleInputEvents(s *server, inputEvents chan *pb.FeedRequest, scaleX, scal
 				}
 			} else {
 
-				serverX := int(float32(reqMsg.GetMouseX()) * scaleX)
-				serverY := int(float32(reqMsg.GetMouseY()) * scaleY)
+				serverX, serverY := display.toDesktop(float32(reqMsg.GetMouseX()), float32(reqMsg.GetMouseY()), reqMsg.GetClientWidth(), reqMsg.GetClientHeight())
 				robotgo.Move(serverX, serverY)
 
 				if eventType == "down" {
@@ -282,6 +304,27 @@ func handleInputEvents(s *server, inputEvents chan *pb.FeedRequest, scaleX, scal
 		case "keyboard_event":
 			log.Printf("DEBUG: [handleInputEvents] Forwarding to processKeyboardInput. Type: '%s', KeyName: '%s', KeyChar: '%s'", reqMsg.GetKeyboardEventType(), reqMsg.GetKeyName(), reqMsg.GetKeyCharStr())
 			processKeyboardInput(reqMsg)
+		case "select_display":
+			region, changed := display.set(int(reqMsg.GetDisplayIndex()))
+			if changed && capture != nil {
+				if err := capture.SetRegion(region); err != nil {
+					log.Printf("Failed to switch screen capture to display %d: %v", reqMsg.GetDisplayIndex(), err)
+				}
+			}
+		case "video_settings":
+			settings, changed := video.request(reqMsg.GetVideoSettings(), time.Now())
+			if changed && capture != nil {
+				if err := capture.SetVideoSettings(settings); err != nil {
+					log.Printf("Failed to apply video settings requested by the client: %v", err)
+				}
+			}
+		case "video_stats":
+			settings, changed := video.observe(reqMsg.GetVideoStats(), time.Now())
+			if changed && capture != nil {
+				if err := capture.SetVideoSettings(settings); err != nil {
+					log.Printf("Failed to apply adapted video settings: %v", err)
+				}
+			}
 		default:
 			log.Printf("Unknown input event message type: %s", reqMsg.Message)
 		}
@@ -387,51 +430,125 @@ func receiveInputEvents(stream pb.RemoteControlService_GetFeedServer, inputEvent
 	}
 }
 
-func sendScreenFeed(stream pb.RemoteControlService_GetFeedServer, capture *screen.ScreenCapture) error {
+// sendScreenFeed sends every picture the encoder produces as soon as it is complete,
+// one access unit per message.
+func sendScreenFeed(stream pb.RemoteControlService_GetFeedServer, capture *screen.ScreenCapture, feedID string, display *feedDisplay, video *adaptiveVideo) error {
 	log.Println("Screen feed sender goroutine started.")
 	defer log.Println("Screen feed sender goroutine stopped.")
 
-	frameBuffer := make([]byte, 2*1024*1024)
-	ticker := time.NewTicker(time.Second / 30)
-	defer ticker.Stop()
+	var frameCounter int32 = 0
+	for {
+		unit, err := capture.NextAccessUnit(stream.Context())
+		if err != nil {
+			if err == io.EOF {
+				log.Println("Screen capture source reported EOF.")
+				return status.Errorf(codes.Internal, "Screen capture source EOF")
+			}
+			log.Printf("Stream context done (client likely disconnected): %v", err)
+			return nil
+		}
+
+		err = stream.Send(&pb.FeedResponse{
+			Data:             unit.Data,
+			FrameNumber:      frameCounter,
+			Timestamp:        time.Now().UnixNano(),
+			ContentType:      unit.Format.Codec.ContentType(),
+			Codec:            string(unit.Format.Codec),
+			HwAccel:          capture.Encoder(),
+			Display:          display.takeChange(),
+			VideoSettings:    video.takeChange(),
+			Width:            int32(unit.Format.Width),
+			Height:           int32(unit.Format.Height),
+			CaptureWidth:     int32(unit.Format.CaptureWidth),
+			CaptureHeight:    int32(unit.Format.CaptureHeight),
+			FeedId:           feedID,
+			Keyframe:         unit.Keyframe,
+			CaptureTimestamp: unit.Time.UnixNano(),
+		})
+		if err != nil {
+			s, ok := status.FromError(err)
+			if ok && (s.Code() == codes.Canceled || s.Code() == codes.Unavailable) {
+				log.Printf("Client disconnected or stream unavailable during send: %v", err)
+				return nil
+			}
+			log.Printf("Error sending frame to client: %v", err)
+			return status.Errorf(codes.Internal, "Failed to send frame: %v", err)
+		}
+		frameCounter++
+	}
+}
+
+// tileMessageBytes bounds the tile data in one message, well within the 4 MB gRPC
+// clients receive by default. A whole frame of a large, busy screen is several times
+// that.
+const tileMessageBytes = 2 << 20
+
+// sendTileFeed sends the tiles of the screen that changed, splitting frames whose tiles
+// do not fit in one message across several.
+func sendTileFeed(stream pb.RemoteControlService_GetFeedServer, capture *screen.TileCapture, feedID string, display *feedDisplay, video *adaptiveVideo) error {
+	log.Println("Tile feed sender goroutine started.")
+	defer log.Println("Tile feed sender goroutine stopped.")
 
 	var frameCounter int32 = 0
 	for {
-		select {
-		case <-ticker.C:
-			n, err := capture.ReadFrame(frameBuffer)
-			if err != nil {
-				if err == io.EOF {
-					log.Println("Screen capture source reported EOF.")
-					return status.Errorf(codes.Internal, "Screen capture source EOF")
-				}
-				log.Printf("Error reading frame from screen capture: %v", err)
-				continue
+		frame, err := capture.NextFrame(stream.Context())
+		if err != nil {
+			if err == io.EOF {
+				log.Println("Tile capture closed.")
+				return nil
 			}
-			if n == 0 {
-				continue
+			if stream.Context().Err() != nil {
+				log.Printf("Stream context done (client likely disconnected): %v", err)
+				return nil
 			}
+			log.Printf("Error capturing tiles: %v", err)
+			return status.Errorf(codes.Internal, "Failed to capture tiles: %v", err)
+		}
 
-			err = stream.Send(&pb.FeedResponse{
-				Data:        frameBuffer[:n],
-				FrameNumber: frameCounter,
-				Timestamp:   time.Now().UnixNano(),
-				ContentType: "video/mp2t",
-				HwAccel:     screen.Accel,
-			})
-			if err != nil {
-				s, ok := status.FromError(err)
-				if ok && (s.Code() == codes.Canceled || s.Code() == codes.Unavailable) {
-					log.Printf("Client disconnected or stream unavailable during send: %v", err)
-					return nil
-				}
-				log.Printf("Error sending frame to client: %v", err)
-				return status.Errorf(codes.Internal, "Failed to send frame: %v", err)
+		if err := sendTileFrame(stream, capture, feedID, display, video, frameCounter, frame); err != nil {
+			s, ok := status.FromError(err)
+			if ok && (s.Code() == codes.Canceled || s.Code() == codes.Unavailable) {
+				log.Printf("Client disconnected or stream unavailable during send: %v", err)
+				return nil
 			}
-			frameCounter++
-		case <-stream.Context().Done():
-			log.Printf("Stream context done (client likely disconnected): %v", stream.Context().Err())
-			return nil
+			log.Printf("Error sending tiles to client: %v", err)
+			return status.Errorf(codes.Internal, "Failed to send tiles: %v", err)
 		}
+		frameCounter++
 	}
 }
+
+// sendTileFrame sends a frame's tiles in as many messages as tileMessageBytes needs,
+// each carrying the frame's details, with more_tiles set on all but the last.
+func sendTileFrame(stream pb.RemoteControlService_GetFeedServer, capture *screen.TileCapture, feedID string, display *feedDisplay, video *adaptiveVideo, frameNumber int32, frame screen.TileFrame) error {
+	parts := screen.SplitTiles(frame.Tiles, tileMessageBytes)
+	if len(parts) > 1 {
+		log.Printf("Sending tile frame %d in %d messages.", frameNumber, len(parts))
+	}
+	for i, part := range parts {
+		tiles := make([]*pb.Tile, len(part))
+		for j, t := range part {
+			tiles[j] = &pb.Tile{X: int32(t.X), Y: int32(t.Y), Width: int32(t.Width), Height: int32(t.Height), Data: t.Data}
+		}
+		err := stream.Send(&pb.FeedResponse{
+			FrameNumber:      frameNumber,
+			Timestamp:        time.Now().UnixNano(),
+			ContentType:      "image/" + capture.TileFormat(),
+			Display:          display.takeChange(),
+			VideoSettings:    video.takeChange(),
+			Width:            int32(frame.Format.Width),
+			Height:           int32(frame.Format.Height),
+			CaptureWidth:     int32(frame.Format.CaptureWidth),
+			CaptureHeight:    int32(frame.Format.CaptureHeight),
+			FeedId:           feedID,
+			Keyframe:         frame.Full,
+			CaptureTimestamp: frame.Time.UnixNano(),
+			Tiles:            tiles,
+			MoreTiles:        i < len(parts)-1,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,153 @@
+package screen
+
+import (
+	"bytes"
+	"time"
+)
+
+// AccessUnit is one encoded picture. For H.264 and HEVC it is the NAL units that decode
+// to it, each with its Annex-B start code; for VP9 a frame or superframe and for AV1 a
+// temporal unit of OBUs, without the IVF framing.
+type AccessUnit struct {
+	Data     []byte
+	Keyframe bool      // holds an IDR picture, which decodes without earlier ones
+	Time     time.Time // when the host read the picture's first bytes from the encoder
+	Format   Format    // of the stream the picture belongs to
+
+	gen uint64 // the ffmpeg process that produced it
+}
+
+var startCode = []byte{0, 0, 1}
+
+// H.264 NAL unit types that matter for finding picture boundaries (ITU-T H.264 table 7-1).
+const (
+	nalSlice     = 1
+	nalSliceIDR  = 5
+	nalSEI       = 6
+	nalSPS       = 7
+	nalPPS       = 8
+	nalAUD       = 9
+	nalPrefixMin = 14 // 14 to 18 may only come before a picture's first slice
+	nalPrefixMax = 18
+)
+
+// HEVC NAL unit types (ITU-T H.265 table 7-1). Types up to 31 are slices, of which 16
+// to 23 start a picture that decodes on its own.
+const (
+	hevcVCLMax     = 31
+	hevcIRAPMin    = 16
+	hevcIRAPMax    = 23
+	hevcVPS        = 32
+	hevcAUD        = 35
+	hevcPrefixSEI  = 39
+	hevcReserved41 = 41 // 41 to 44 and 48 to 55 may only come before a picture's slices
+	hevcReserved44 = 44
+	hevcUnspec48   = 48
+	hevcUnspec55   = 55
+)
+
+// annexBSplitter splits an H.264 or HEVC Annex-B byte stream into access units. A
+// picture is only known to be complete when the next one starts, so the caller flushes
+// it when the encoder goes quiet instead of waiting a frame.
+type annexBSplitter struct {
+	hevc     bool
+	buf      []byte // bytes not returned yet, starting with the current access unit
+	scanned  int    // buf[:scanned] has been searched for start codes
+	hasVCL   bool   // the current access unit has a slice
+	keyframe bool
+	started  time.Time
+}
+
+// write adds stream bytes read at now and returns the access units they complete.
+func (p *annexBSplitter) write(data []byte, now time.Time) []AccessUnit {
+	if len(p.buf) == 0 {
+		p.started = now
+	}
+	p.buf = append(p.buf, data...)
+
+	var units []AccessUnit
+	for {
+		i := bytes.Index(p.buf[p.scanned:], startCode)
+		if i < 0 {
+			// Keep the last bytes, they may be the start of a start code.
+			p.scanned = max(p.scanned, len(p.buf)-len(startCode)+1)
+			return units
+		}
+		code := p.scanned + i
+		nal := code + len(startCode)
+		header := 1
+		if p.hevc {
+			header = 2
+		}
+		if nal+header+1 > len(p.buf) {
+			// Slices need the byte after the header to tell whether they begin a picture.
+			p.scanned = code
+			return units
+		}
+		start := code
+		if code > 0 && p.buf[code-1] == 0 {
+			start-- // four byte start code
+		}
+
+		var vcl, beginsPicture, keyframe bool
+		if p.hevc {
+			vcl, beginsPicture, keyframe = classifyHEVC(p.buf[nal:])
+		} else {
+			vcl, beginsPicture, keyframe = classifyH264(p.buf[nal:])
+		}
+		if p.hasVCL && beginsPicture && start > 0 {
+			units = append(units, p.take(start, now))
+			nal -= start
+		}
+		if vcl {
+			p.hasVCL = true
+		}
+		if keyframe {
+			p.keyframe = true
+		}
+		p.scanned = nal
+	}
+}
+
+// classifyH264 reports whether an H.264 NAL unit is a slice, whether it begins a new
+// picture, and whether it is part of an IDR picture.
+func classifyH264(nal []byte) (vcl, beginsPicture, keyframe bool) {
+	nalType := nal[0] & 0x1f
+	vcl = nalType == nalSlice || nalType == nalSliceIDR
+	// A slice header starts with first_mb_in_slice as an Exp-Golomb number, whose
+	// first bit is 1 only for 0, the picture's first slice.
+	firstSlice := vcl && nal[1]&0x80 != 0
+	beginsPicture = firstSlice || nalType == nalAUD || nalType == nalSPS || nalType == nalPPS ||
+		nalType == nalSEI || (nalType >= nalPrefixMin && nalType <= nalPrefixMax)
+	return vcl, beginsPicture, nalType == nalSliceIDR
+}
+
+// classifyHEVC is classifyH264 for HEVC, whose NAL unit header is two bytes.
+func classifyHEVC(nal []byte) (vcl, beginsPicture, keyframe bool) {
+	nalType := (nal[0] >> 1) & 0x3f
+	vcl = nalType <= hevcVCLMax
+	// A slice segment header starts with first_slice_segment_in_pic_flag.
+	firstSlice := vcl && nal[2]&0x80 != 0
+	beginsPicture = firstSlice || (nalType >= hevcVPS && nalType <= hevcAUD) || nalType == hevcPrefixSEI ||
+		(nalType >= hevcReserved41 && nalType <= hevcReserved44) || (nalType >= hevcUnspec48 && nalType <= hevcUnspec55)
+	return vcl, beginsPicture, nalType >= hevcIRAPMin && nalType <= hevcIRAPMax
+}
+
+// flush returns the pending bytes as an access unit when they hold a picture.
+func (p *annexBSplitter) flush(now time.Time) (AccessUnit, bool) {
+	if !p.hasVCL {
+		return AccessUnit{}, false
+	}
+	return p.take(len(p.buf), now), true
+}
+
+// take returns buf[:n] as an access unit and starts the next one.
+func (p *annexBSplitter) take(n int, now time.Time) AccessUnit {
+	unit := AccessUnit{Data: bytes.Clone(p.buf[:n]), Keyframe: p.keyframe, Time: p.started}
+	p.buf = p.buf[n:]
+	p.scanned = 0
+	p.hasVCL = false
+	p.keyframe = false
+	p.started = now
+	return unit
+}
@@ -0,0 +1,185 @@
+package screen
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// PreferredSource names the capture source to use, or "auto" to pick the first one
+// available on this host. It is set from the server's -captureSource flag.
+var PreferredSource = "auto"
+
+// Region is the part of the desktop to capture, in desktop pixels. A zero size means
+// the whole desktop.
+type Region struct {
+	X, Y, Width, Height int
+}
+
+func (r Region) empty() bool {
+	return r.Width <= 0 || r.Height <= 0
+}
+
+func (r Region) cropFilter() string {
+	return fmt.Sprintf("crop=%d:%d:%d:%d", r.Width, r.Height, r.X, r.Y)
+}
+
+// CaptureSource is a way for ffmpeg to grab the screen on this host.
+type CaptureSource interface {
+	// Name is the ffmpeg input device, as accepted by -captureSource.
+	Name() string
+	// Available reports whether the source can work on this host with this ffmpeg.
+	Available(caps FFmpegCapabilities) bool
+	// InputArgs are the ffmpeg arguments that open the screen. drawMouse is false when
+	// the client draws the cursor itself; sources that cannot leave it out ignore it.
+	InputArgs(region Region, framerate int, drawMouse bool) []string
+	// Filters turn the captured frames into software frames of the region, ahead of
+	// scaling. Sources that cannot capture a region crop here.
+	Filters(region Region) []string
+}
+
+// captureSources lists every backend in the order "auto" tries them.
+func captureSources() []CaptureSource {
+	return []CaptureSource{
+		gdigrabSource{},
+		// On Wayland, x11grab only sees X11 windows through XWayland, so PipeWire
+		// comes first there.
+		pipewiregrabSource{},
+		x11grabSource{},
+		kmsgrabSource{},
+	}
+}
+
+// SelectCaptureSource returns the source called name, or the first available one for
+// "auto" or an empty name.
+func SelectCaptureSource(name string, caps FFmpegCapabilities) (CaptureSource, error) {
+	sources := captureSources()
+	if name == "" || name == "auto" {
+		for _, source := range sources {
+			if source.Available(caps) {
+				return source, nil
+			}
+		}
+		return nil, fmt.Errorf("no screen capture source is available on %s. Check that ffmpeg supports gdigrab, x11grab, kmsgrab or pipewiregrab and that a display is running", runtime.GOOS)
+	}
+	names := make([]string, len(sources))
+	for i, source := range sources {
+		if source.Name() == name {
+			return source, nil
+		}
+		names[i] = source.Name()
+	}
+	return nil, fmt.Errorf("unknown capture source %q, expected auto or one of %s", name, strings.Join(names, ", "))
+}
+
+// gdigrabSource captures the Windows desktop through GDI.
+type gdigrabSource struct{}
+
+func (gdigrabSource) Name() string { return "gdigrab" }
+
+func (gdigrabSource) Available(caps FFmpegCapabilities) bool {
+	return runtime.GOOS == "windows" && caps.HasDevice("gdigrab")
+}
+
+func (gdigrabSource) InputArgs(region Region, framerate int, drawMouse bool) []string {
+	args := []string{"-f", "gdigrab", "-framerate", fmt.Sprint(framerate)}
+	if !drawMouse {
+		args = append(args, "-draw_mouse", "0")
+	}
+	if !region.empty() {
+		args = append(args,
+			"-offset_x", fmt.Sprint(region.X),
+			"-offset_y", fmt.Sprint(region.Y),
+			"-video_size", fmt.Sprintf("%dx%d", region.Width, region.Height),
+		)
+	}
+	return append(args, "-i", "desktop")
+}
+
+func (gdigrabSource) Filters(Region) []string { return nil }
+
+// x11grabSource captures an X11 display, including Xvfb and XWayland, named by DISPLAY.
+type x11grabSource struct{}
+
+func (x11grabSource) Name() string { return "x11grab" }
+
+func (x11grabSource) Available(caps FFmpegCapabilities) bool {
+	return runtime.GOOS != "windows" && os.Getenv("DISPLAY") != "" && caps.HasDevice("x11grab")
+}
+
+func (x11grabSource) InputArgs(region Region, framerate int, drawMouse bool) []string {
+	display := os.Getenv("DISPLAY")
+	if display == "" {
+		display = ":0"
+	}
+	args := []string{"-f", "x11grab", "-framerate", fmt.Sprint(framerate)}
+	if !drawMouse {
+		args = append(args, "-draw_mouse", "0")
+	}
+	if !region.empty() {
+		args = append(args, "-video_size", fmt.Sprintf("%dx%d", region.Width, region.Height))
+		display = fmt.Sprintf("%s+%d,%d", display, region.X, region.Y)
+	}
+	return append(args, "-i", display)
+}
+
+func (x11grabSource) Filters(Region) []string { return nil }
+
+// kmsgrabSource reads the framebuffer through the Linux kernel mode setting API, which
+// works without a display server but needs CAP_SYS_ADMIN. "auto" only picks it when
+// running as root.
+type kmsgrabSource struct{}
+
+func (kmsgrabSource) Name() string { return "kmsgrab" }
+
+func (kmsgrabSource) Available(caps FFmpegCapabilities) bool {
+	return runtime.GOOS == "linux" && os.Geteuid() == 0 && kmsDevice() != "" &&
+		caps.HasDevice("kmsgrab") && caps.HasFilter("hwmap") && caps.HasFilter("hwdownload")
+}
+
+// InputArgs ignores drawMouse, as the cursor is on its own plane that kmsgrab never reads.
+func (kmsgrabSource) InputArgs(region Region, framerate int, drawMouse bool) []string {
+	device := kmsDevice()
+	if device == "" {
+		device = "/dev/dri/card0"
+	}
+	return []string{"-device", device, "-f", "kmsgrab", "-framerate", fmt.Sprint(framerate), "-i", "-"}
+}
+
+func (kmsgrabSource) Filters(region Region) []string {
+	// Frames arrive as DRM buffers; VAAPI maps them so they can be copied to memory.
+	filters := []string{"hwmap=derive_device=vaapi", "hwdownload", "format=bgr0"}
+	if !region.empty() {
+		filters = append(filters, region.cropFilter())
+	}
+	return filters
+}
+
+// kmsDevice returns the first DRM card device, or "" if there is none.
+func kmsDevice() string {
+	cards, _ := filepath.Glob("/dev/dri/card*")
+	if len(cards) == 0 {
+		return ""
+	}
+	return cards[0]
+}
+
+// pipewiregrabSource captures a Wayland session through the screen cast portal, which
+// asks the user at the host which screen to share. The region is ignored as the portal
+// chooses the screen.
+type pipewiregrabSource struct{}
+
+func (pipewiregrabSource) Name() string { return "pipewiregrab" }
+
+func (pipewiregrabSource) Available(caps FFmpegCapabilities) bool {
+	wayland := os.Getenv("WAYLAND_DISPLAY") != "" || os.Getenv("XDG_SESSION_TYPE") == "wayland"
+	return runtime.GOOS == "linux" && wayland && caps.HasDevice("lavfi") && caps.HasFilter("pipewiregrab")
+}
+
+func (pipewiregrabSource) InputArgs(region Region, framerate int, drawMouse bool) []string {
+	return []string{"-f", "lavfi", "-i", fmt.Sprintf("pipewiregrab=framerate=%d:enable_dmabuf=0", framerate)}
+}
+
+func (pipewiregrabSource) Filters(Region) []string { return nil }
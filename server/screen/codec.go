@@ -0,0 +1,55 @@
+package screen
+
+import "time"
+
+// Codec is a video compression format, named as clients advertise it.
+type Codec string
+
+const (
+	CodecH264 Codec = "h264"
+	CodecHEVC Codec = "hevc"
+	CodecVP9  Codec = "vp9"
+	CodecAV1  Codec = "av1"
+)
+
+// ParseCodec returns the codec with a name, and false for codecs the host does not know.
+func ParseCodec(name string) (Codec, bool) {
+	switch c := Codec(name); c {
+	case CodecH264, CodecHEVC, CodecVP9, CodecAV1:
+		return c, true
+	}
+	return "", false
+}
+
+// ContentType is the MIME type of the codec's access units.
+func (c Codec) ContentType() string {
+	return "video/" + string(c)
+}
+
+// muxer is the ffmpeg output format that frames the codec's pictures. H.264 and HEVC
+// are written as raw Annex-B streams, the others in IVF.
+func (c Codec) muxer() string {
+	switch c {
+	case CodecH264, CodecHEVC:
+		return string(c)
+	}
+	return "ivf"
+}
+
+// accessUnitSplitter cuts an encoder's output into access units.
+type accessUnitSplitter interface {
+	// write adds output read at now and returns the access units it completes.
+	write(data []byte, now time.Time) []AccessUnit
+	// flush returns a pending access unit that may be complete.
+	flush(now time.Time) (AccessUnit, bool)
+}
+
+func newSplitter(c Codec) accessUnitSplitter {
+	switch c {
+	case CodecH264:
+		return &annexBSplitter{}
+	case CodecHEVC:
+		return &annexBSplitter{hevc: true}
+	}
+	return &ivfSplitter{codec: c}
+}
@@ -0,0 +1,64 @@
+package screen
+
+import (
+	"fmt"
+
+	"github.com/kbinani/screenshot"
+)
+
+// AllDisplays selects the region spanning every display.
+const AllDisplays = -1
+
+// Display is one of the host's monitors.
+type Display struct {
+	Index   int
+	Primary bool // the display at the desktop origin
+	Region
+}
+
+// Displays lists the active displays. It is empty when they cannot be enumerated, such
+// as on a Wayland or console session without an X server.
+func Displays() []Display {
+	n := screenshot.NumActiveDisplays()
+	displays := make([]Display, 0, n)
+	for i := 0; i < n; i++ {
+		bounds := screenshot.GetDisplayBounds(i)
+		displays = append(displays, Display{
+			Index:   i,
+			Primary: bounds.Min.X == 0 && bounds.Min.Y == 0,
+			Region:  Region{X: bounds.Min.X, Y: bounds.Min.Y, Width: bounds.Dx(), Height: bounds.Dy()},
+		})
+	}
+	return displays
+}
+
+// DisplayRegion returns the region of the display with the given index, or of all
+// displays for AllDisplays. Without enumerable displays it returns an empty region,
+// which captures the whole desktop.
+func DisplayRegion(index int) (Region, error) {
+	displays := Displays()
+	if len(displays) == 0 && (index == 0 || index == AllDisplays) {
+		return Region{}, nil
+	}
+	if index == AllDisplays {
+		return spanningRegion(displays), nil
+	}
+	if index < 0 || index >= len(displays) {
+		return Region{}, fmt.Errorf("display %d does not exist, the host has %d", index, len(displays))
+	}
+	return displays[index].Region, nil
+}
+
+// spanningRegion returns the bounding box of the displays.
+func spanningRegion(displays []Display) Region {
+	if len(displays) == 0 {
+		return Region{}
+	}
+	minX, minY := displays[0].X, displays[0].Y
+	maxX, maxY := minX+displays[0].Width, minY+displays[0].Height
+	for _, d := range displays[1:] {
+		minX, minY = min(minX, d.X), min(minY, d.Y)
+		maxX, maxY = max(maxX, d.X+d.Width), max(maxY, d.Y+d.Height)
+	}
+	return Region{X: minX, Y: minY, Width: maxX - minX, Height: maxY - minY}
+}
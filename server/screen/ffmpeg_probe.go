@@ -0,0 +1,479 @@
+package screen
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"log"
+	"os/exec"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const ffmpegProbeTimeout = 15 * time.Second
+
+// FFmpegCapabilities is what the installed ffmpeg was built with, from its -encoders,
+// -devices and -filters listings.
+type FFmpegCapabilities struct {
+	encoders map[string]bool
+	devices  map[string]bool // input devices such as gdigrab and x11grab
+	filters  map[string]bool
+}
+
+func (c FFmpegCapabilities) HasEncoder(name string) bool { return c.encoders[name] }
+func (c FFmpegCapabilities) HasDevice(name string) bool  { return c.devices[name] }
+func (c FFmpegCapabilities) HasFilter(name string) bool  { return c.filters[name] }
+
+var (
+	probeOnce  sync.Once
+	probedCaps FFmpegCapabilities
+	probeErr   error
+)
+
+// probeFFmpeg lists the installed ffmpeg's capabilities, once per process.
+func probeFFmpeg() (FFmpegCapabilities, error) {
+	probeOnce.Do(func() {
+		var encoders, devices, filters string
+		if encoders, probeErr = runFFmpeg("-encoders"); probeErr != nil {
+			probeErr = fmt.Errorf("ffmpeg is not usable: %w", probeErr)
+			return
+		}
+		if devices, probeErr = runFFmpeg("-devices"); probeErr != nil {
+			return
+		}
+		if filters, probeErr = runFFmpeg("-filters"); probeErr != nil {
+			return
+		}
+		probedCaps = FFmpegCapabilities{
+			encoders: parseFFmpegEncoders(encoders),
+			devices:  parseFFmpegDevices(devices),
+			filters:  parseFFmpegFilters(filters),
+		}
+		log.Printf("Screen capture: ffmpeg has %d encoders, %d devices and %d filters", len(probedCaps.encoders), len(probedCaps.devices), len(probedCaps.filters))
+	})
+	return probedCaps, probeErr
+}
+
+func runFFmpeg(args ...string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), ffmpegProbeTimeout)
+	defer cancel()
+	out, err := exec.CommandContext(ctx, "ffmpeg", append([]string{"-hide_banner"}, args...)...).Output()
+	return string(out), err
+}
+
+// parseFFmpegEncoders reads `ffmpeg -encoders`: a legend, a dashed line, then one
+// "V....D name description" line per encoder.
+func parseFFmpegEncoders(out string) map[string]bool {
+	return parseFFmpegListing(out, func(fields []string) (string, bool) {
+		return fields[1], len(fields[0]) == 6
+	})
+}
+
+// parseFFmpegDevices reads `ffmpeg -devices`, keeping the devices that can be used as
+// input ("D" in the flags).
+func parseFFmpegDevices(out string) map[string]bool {
+	return parseFFmpegListing(out, func(fields []string) (string, bool) {
+		return fields[1], strings.Contains(fields[0], "D")
+	})
+}
+
+// parseFFmpegFilters reads `ffmpeg -filters`, whose lines are "flags name in->out
+// description". It has no dashed line, so lines are recognised by the arrow.
+func parseFFmpegFilters(out string) map[string]bool {
+	names := make(map[string]bool)
+	scanner := bufio.NewScanner(strings.NewReader(out))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 3 && strings.Contains(fields[2], "->") {
+			names[fields[1]] = true
+		}
+	}
+	return names
+}
+
+func parseFFmpegListing(out string, entry func(fields []string) (string, bool)) map[string]bool {
+	names := make(map[string]bool)
+	listing := false
+	scanner := bufio.NewScanner(strings.NewReader(out))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "--") {
+			listing = true
+			continue
+		}
+		fields := strings.Fields(line)
+		if !listing || len(fields) < 2 {
+			continue
+		}
+		if name, ok := entry(fields); ok {
+			// Devices may list several comma separated names.
+			for _, n := range strings.Split(name, ",") {
+				names[n] = true
+			}
+		}
+	}
+	return names
+}
+
+// encoderProfile is an encoder and the options that keep its latency low.
+type encoderProfile struct {
+	name  string
+	codec Codec
+	// globalArgs come before the input, such as the hardware device to encode on.
+	globalArgs []string
+	// pixelFormat is what frames are converted to, and upload then moves them to the
+	// encoder's hardware.
+	pixelFormat string
+	upload      string
+	args        []string
+	// averageBitrateOnly is set for encoders whose rate control ignores -maxrate and
+	// -bufsize.
+	averageBitrateOnly bool
+}
+
+// bitrateArgs are the rate control options for a target bit rate. Peaks may reach 5/3
+// of the target, with two seconds' worth of buffer.
+func (p encoderProfile) bitrateArgs(kbps int) []string {
+	args := []string{"-b:v", fmt.Sprintf("%dk", kbps)}
+	if p.averageBitrateOnly {
+		return args
+	}
+	return append(args, "-maxrate", fmt.Sprintf("%dk", kbps*5/3), "-bufsize", fmt.Sprintf("%dk", kbps*2))
+}
+
+var libx264Profile = encoderProfile{
+	name:        "libx264",
+	codec:       CodecH264,
+	pixelFormat: "yuv420p",
+	args: []string{
+		"-preset", "ultrafast",
+		"-tune", "zerolatency",
+	},
+}
+
+// softwareEncoderProfiles are used when no hardware encoder suits the client, cheapest
+// first: only libx264 reliably keeps up with a large desktop on a modest CPU.
+var softwareEncoderProfiles = []encoderProfile{
+	libx264Profile,
+	{
+		name:        "libvpx-vp9",
+		codec:       CodecVP9,
+		pixelFormat: "yuv420p",
+		args: []string{
+			"-deadline", "realtime",
+			"-cpu-used", "8",
+			"-row-mt", "1",
+			"-lag-in-frames", "0",
+			"-error-resilient", "1",
+		},
+	},
+	{
+		name:        "libsvtav1",
+		codec:       CodecAV1,
+		pixelFormat: "yuv420p",
+		args: []string{
+			"-preset", "12",
+			"-svtav1-params", "pred-struct=1:lookahead=0",
+		},
+		averageBitrateOnly: true,
+	},
+	{
+		name:        "libx265",
+		codec:       CodecHEVC,
+		pixelFormat: "yuv420p",
+		args: []string{
+			"-preset", "ultrafast",
+			"-tune", "zerolatency",
+			"-x265-params", "log-level=error",
+		},
+	},
+	{
+		name:        "libaom-av1",
+		codec:       CodecAV1,
+		pixelFormat: "yuv420p",
+		args: []string{
+			"-usage", "realtime",
+			"-cpu-used", "8",
+			"-row-mt", "1",
+			"-lag-in-frames", "0",
+		},
+		averageBitrateOnly: true,
+	},
+}
+
+var hardwareEncoderProfiles = map[string]encoderProfile{
+	"h264_nvenc": {
+		name:        "h264_nvenc",
+		codec:       CodecH264,
+		pixelFormat: "yuv420p",
+		args: []string{
+			"-preset", "ll",
+			"-profile:v", "high",
+			"-rc", "vbr_hq",
+			"-multipass", "0",
+			"-delay", "0",
+			"-zerolatency", "1",
+			"-rc-lookahead", "0",
+			"-forced-idr", "1",
+			"-strict", "2",
+		},
+	},
+	"h264_amf": {
+		name:        "h264_amf",
+		codec:       CodecH264,
+		pixelFormat: "yuv420p",
+		args: []string{
+			"-usage", "ultralowlatency",
+			"-quality", "speed",
+			"-profile:v", "high",
+			"-rc", "cbr",
+		},
+		averageBitrateOnly: true,
+	},
+	"h264_qsv": {
+		name:        "h264_qsv",
+		codec:       CodecH264,
+		pixelFormat: "nv12",
+		args: []string{
+			"-preset", "veryfast",
+			"-profile:v", "high",
+			"-look_ahead", "0",
+			"-async_depth", "1",
+		},
+	},
+	"h264_vaapi": {
+		name:        "h264_vaapi",
+		codec:       CodecH264,
+		globalArgs:  []string{"-vaapi_device", "/dev/dri/renderD128"},
+		pixelFormat: "nv12",
+		upload:      "hwupload",
+		args: []string{
+			"-profile:v", "high",
+			"-bf", "0",
+		},
+	},
+	"h264_videotoolbox": {
+		name:        "h264_videotoolbox",
+		codec:       CodecH264,
+		pixelFormat: "yuv420p",
+		args: []string{
+			"-realtime", "1",
+			"-profile:v", "high",
+		},
+		averageBitrateOnly: true,
+	},
+	"hevc_nvenc": {
+		name:        "hevc_nvenc",
+		codec:       CodecHEVC,
+		pixelFormat: "yuv420p",
+		args: []string{
+			"-preset", "p1",
+			"-tune", "ull",
+			"-rc", "vbr",
+			"-delay", "0",
+			"-zerolatency", "1",
+			"-rc-lookahead", "0",
+			"-forced-idr", "1",
+		},
+	},
+	"hevc_amf": {
+		name:        "hevc_amf",
+		codec:       CodecHEVC,
+		pixelFormat: "yuv420p",
+		args: []string{
+			"-usage", "ultralowlatency",
+			"-quality", "speed",
+			"-rc", "cbr",
+		},
+		averageBitrateOnly: true,
+	},
+	"hevc_qsv": {
+		name:        "hevc_qsv",
+		codec:       CodecHEVC,
+		pixelFormat: "nv12",
+		args: []string{
+			"-preset", "veryfast",
+			"-look_ahead", "0",
+			"-async_depth", "1",
+		},
+	},
+	"hevc_vaapi": {
+		name:        "hevc_vaapi",
+		codec:       CodecHEVC,
+		globalArgs:  []string{"-vaapi_device", "/dev/dri/renderD128"},
+		pixelFormat: "nv12",
+		upload:      "hwupload",
+		args:        []string{"-bf", "0"},
+	},
+	"hevc_videotoolbox": {
+		name:               "hevc_videotoolbox",
+		codec:              CodecHEVC,
+		pixelFormat:        "yuv420p",
+		args:               []string{"-realtime", "1"},
+		averageBitrateOnly: true,
+	},
+	"vp9_qsv": {
+		name:        "vp9_qsv",
+		codec:       CodecVP9,
+		pixelFormat: "nv12",
+		args: []string{
+			"-preset", "veryfast",
+			"-async_depth", "1",
+		},
+	},
+	"vp9_vaapi": {
+		name:        "vp9_vaapi",
+		codec:       CodecVP9,
+		globalArgs:  []string{"-vaapi_device", "/dev/dri/renderD128"},
+		pixelFormat: "nv12",
+		upload:      "hwupload",
+	},
+	"av1_nvenc": {
+		name:        "av1_nvenc",
+		codec:       CodecAV1,
+		pixelFormat: "yuv420p",
+		args: []string{
+			"-preset", "p1",
+			"-tune", "ull",
+			"-rc", "vbr",
+			"-delay", "0",
+			"-zerolatency", "1",
+			"-rc-lookahead", "0",
+			"-forced-idr", "1",
+		},
+	},
+	"av1_amf": {
+		name:        "av1_amf",
+		codec:       CodecAV1,
+		pixelFormat: "yuv420p",
+		args: []string{
+			"-usage", "ultralowlatency",
+			"-quality", "speed",
+			"-rc", "cbr",
+		},
+		averageBitrateOnly: true,
+	},
+	"av1_qsv": {
+		name:        "av1_qsv",
+		codec:       CodecAV1,
+		pixelFormat: "nv12",
+		args: []string{
+			"-preset", "veryfast",
+			"-async_depth", "1",
+		},
+	},
+	"av1_vaapi": {
+		name:        "av1_vaapi",
+		codec:       CodecAV1,
+		globalArgs:  []string{"-vaapi_device", "/dev/dri/renderD128"},
+		pixelFormat: "nv12",
+		upload:      "hwupload",
+	},
+}
+
+// hardwareEncoderCandidates are tried in order before the software encoders. Newer
+// codecs come first, as they need less bandwidth for the same picture and cost the
+// GPU no more.
+func hardwareEncoderCandidates() []string {
+	switch runtime.GOOS {
+	case "windows":
+		return []string{"av1_nvenc", "av1_amf", "av1_qsv", "hevc_nvenc", "hevc_amf", "hevc_qsv", "vp9_qsv", "h264_nvenc", "h264_amf", "h264_qsv"}
+	case "linux":
+		return []string{"av1_nvenc", "av1_vaapi", "av1_qsv", "hevc_nvenc", "hevc_vaapi", "hevc_qsv", "vp9_vaapi", "vp9_qsv", "h264_nvenc", "h264_vaapi", "h264_qsv"}
+	case "darwin":
+		return []string{"hevc_videotoolbox", "h264_videotoolbox"}
+	}
+	return nil
+}
+
+// encoderCandidates are the encoders ffmpeg was built with, in the order they are
+// preferred.
+func encoderCandidates(caps FFmpegCapabilities) []encoderProfile {
+	var candidates []encoderProfile
+	for _, name := range hardwareEncoderCandidates() {
+		if caps.HasEncoder(name) {
+			candidates = append(candidates, hardwareEncoderProfiles[name])
+		}
+	}
+	for _, profile := range softwareEncoderProfiles {
+		if caps.HasEncoder(profile.name) {
+			candidates = append(candidates, profile)
+		}
+	}
+	return candidates
+}
+
+// pickEncoder returns the first candidate producing one of the codecs a client can
+// decode that usable accepts.
+func pickEncoder(candidates []encoderProfile, codecs []Codec, usable func(encoderProfile) bool) (encoderProfile, bool) {
+	for _, profile := range candidates {
+		if slices.Contains(codecs, profile.codec) && usable(profile) {
+			return profile, true
+		}
+	}
+	return encoderProfile{}, false
+}
+
+// detectEncoder picks the encoder for a client that can decode codecs. Each is first
+// made to encode a test clip, once per process, as being listed by ffmpeg -encoders
+// only means support was compiled in, not that this host's GPU has it.
+func detectEncoder(caps FFmpegCapabilities, codecs []Codec) (encoderProfile, error) {
+	profile, ok := pickEncoder(encoderCandidates(caps), codecs, encoderUsable)
+	if !ok {
+		return encoderProfile{}, fmt.Errorf("no encoder on this host produces any of the codecs %v", codecs)
+	}
+	log.Printf("Screen capture: Using encoder %s for codecs %v", profile.name, codecs)
+	return profile, nil
+}
+
+var (
+	encoderTestsMu sync.Mutex
+	encoderTests   = map[string]error{}
+)
+
+func encoderUsable(profile encoderProfile) bool {
+	encoderTestsMu.Lock()
+	defer encoderTestsMu.Unlock()
+	err, tested := encoderTests[profile.name]
+	if !tested {
+		err = testEncoder(profile)
+		encoderTests[profile.name] = err
+		if err != nil {
+			log.Printf("Screen capture: Encoder %s is not usable on this host: %v", profile.name, err)
+		}
+	}
+	return err == nil
+}
+
+func testEncoder(profile encoderProfile) error {
+	args := append([]string{"-loglevel", "error"}, profile.globalArgs...)
+	args = append(args, "-f", "lavfi", "-i", "color=c=black:s=320x240:r=30", "-frames:v", "3", "-vf", profile.filters())
+	args = append(args, "-c:v", profile.name)
+	args = append(args, profile.args...)
+	args = append(args, profile.bitrateArgs(DefaultVideoSettings.BitrateKbps)...)
+	args = append(args, "-f", "null", "-")
+	out, err := runFFmpegCombined(args...)
+	if err != nil {
+		return fmt.Errorf("%w: %s", err, strings.TrimSpace(out))
+	}
+	return nil
+}
+
+func runFFmpegCombined(args ...string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), ffmpegProbeTimeout)
+	defer cancel()
+	out, err := exec.CommandContext(ctx, "ffmpeg", append([]string{"-hide_banner"}, args...)...).CombinedOutput()
+	return string(out), err
+}
+
+// filters converts frames for the encoder.
+func (p encoderProfile) filters() string {
+	f := "format=" + p.pixelFormat
+	if p.upload != "" {
+		f += "," + p.upload
+	}
+	return f
+}
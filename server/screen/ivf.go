@@ -0,0 +1,115 @@
+package screen
+
+import (
+	"bytes"
+	"encoding/binary"
+	"time"
+)
+
+const (
+	ivfFileHeaderSize  = 32
+	ivfFrameHeaderSize = 12 // frame size, little endian, and a 64 bit timestamp
+)
+
+// AV1 OBU types (AV1 specification section 6.2.2).
+const (
+	obuFrameHeader = 3
+	obuFrame       = 6
+)
+
+// ivfSplitter reads the IVF stream ffmpeg writes for VP9 and AV1, in which every frame
+// comes with its size, so a picture is complete as soon as its bytes are.
+type ivfSplitter struct {
+	codec        Codec
+	buf          []byte
+	headerParsed bool
+	started      time.Time
+}
+
+func (p *ivfSplitter) write(data []byte, now time.Time) []AccessUnit {
+	if len(p.buf) == 0 {
+		p.started = now
+	}
+	p.buf = append(p.buf, data...)
+
+	var units []AccessUnit
+	for {
+		if !p.headerParsed {
+			if len(p.buf) < ivfFileHeaderSize {
+				return units
+			}
+			p.buf = p.buf[ivfFileHeaderSize:]
+			p.headerParsed = true
+		}
+		if len(p.buf) < ivfFrameHeaderSize {
+			return units
+		}
+		size := int(binary.LittleEndian.Uint32(p.buf))
+		end := ivfFrameHeaderSize + size
+		if len(p.buf) < end {
+			return units
+		}
+		frame := bytes.Clone(p.buf[ivfFrameHeaderSize:end])
+		keyframe := vp9Keyframe(frame)
+		if p.codec == CodecAV1 {
+			keyframe = av1Keyframe(frame)
+		}
+		units = append(units, AccessUnit{Data: frame, Keyframe: keyframe, Time: p.started})
+		p.buf = p.buf[end:]
+		p.started = now
+	}
+}
+
+// flush returns nothing, as frames are returned as soon as they are complete.
+func (p *ivfSplitter) flush(now time.Time) (AccessUnit, bool) {
+	return AccessUnit{}, false
+}
+
+// vp9Keyframe reads the start of a VP9 frame's uncompressed header: frame_marker,
+// the profile, show_existing_frame and frame_type, which is 0 for a keyframe. A
+// superframe starts with its first frame, so it is read the same way.
+func vp9Keyframe(frame []byte) bool {
+	if len(frame) == 0 || frame[0]>>6 != 2 {
+		return false
+	}
+	bit := func(i int) byte { return frame[0] >> (7 - i) & 1 }
+	pos := 4
+	if bit(2) == 1 && bit(3) == 1 {
+		pos++ // profile 3 has a reserved bit
+	}
+	return bit(pos) == 0 && bit(pos+1) == 0
+}
+
+// av1Keyframe reports whether a temporal unit has a frame header of a key frame that
+// is decoded rather than shown again, assuming the sequence does not use reduced still
+// picture headers, which is only for images.
+func av1Keyframe(tu []byte) bool {
+	for len(tu) > 0 {
+		header := tu[0]
+		obuType := header >> 3 & 0xf
+		i := 1
+		if header&0x04 != 0 {
+			i++ // extension header
+		}
+		size := len(tu) - i
+		if header&0x02 != 0 {
+			v, n := binary.Uvarint(tu[min(i, len(tu)):])
+			if n <= 0 {
+				return false
+			}
+			size = int(v)
+			i += n
+		}
+		if i > len(tu) || size > len(tu)-i {
+			return false
+		}
+		payload := tu[i : i+size]
+		if (obuType == obuFrameHeader || obuType == obuFrame) && len(payload) > 0 {
+			// show_existing_frame, then frame_type, where 0 is KEY_FRAME.
+			showExisting := payload[0]>>7 == 1
+			return !showExisting && payload[0]>>5&0x3 == 0
+		}
+		tu = tu[i+size:]
+	}
+	return false
+}
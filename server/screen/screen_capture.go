@@ -2,19 +2,66 @@ package screen
 
 import (
 	"bytes"
-	"errors"
+	"context"
 	"fmt"
 	"io"
 	"log"
+	"math"
 	"os/exec"
 	"strings"
 	"sync"
 	"time"
-
-	"github.com/StackExchange/wmi"
-	"github.com/kbinani/screenshot"
 )
 
+// VideoSettings are the size, frame rate and bit rate the screen is encoded at.
+type VideoSettings struct {
+	// Width and Height bound the video size. The captured region keeps its aspect
+	// ratio and is only ever scaled down to fit.
+	Width, Height int
+	// Scale shrinks the fitted size further, from 0 to 1.
+	Scale       float64
+	Framerate   int
+	BitrateKbps int
+}
+
+// DefaultVideoSettings are used for clients that do not ask for any.
+var DefaultVideoSettings = VideoSettings{Width: 1920, Height: 1080, Scale: 1, Framerate: 30, BitrateKbps: 3000}
+
+func (v VideoSettings) String() string {
+	return fmt.Sprintf("up to %dx%d scaled by %.2f at %d fps, %d kbps", v.Width, v.Height, v.Scale, v.Framerate, v.BitrateKbps)
+}
+
+// Format describes the stream NextAccessUnit returns.
+type Format struct {
+	Codec Codec
+	// Width and Height are the size of the encoded frames.
+	Width, Height int
+	// CaptureWidth and CaptureHeight are the size of the captured region in desktop
+	// pixels, or 0 when it is unknown and the whole desktop is captured.
+	CaptureWidth, CaptureHeight int
+}
+
+// videoSize returns the size to encode region at: the largest that fits within the
+// settings without changing the aspect ratio or scaling up, then scaled. Sizes are
+// even, as yuv420p requires. An unknown region is stretched to the bounds, at most
+// the default size.
+func videoSize(region Region, settings VideoSettings) (int, int) {
+	scale := settings.Scale
+	if scale <= 0 || scale > 1 {
+		scale = 1
+	}
+	if region.empty() {
+		width, height := min(settings.Width, DefaultVideoSettings.Width), min(settings.Height, DefaultVideoSettings.Height)
+		return evenSize(float64(width) * scale), evenSize(float64(height) * scale)
+	}
+	fit := min(1, float64(settings.Width)/float64(region.Width), float64(settings.Height)/float64(region.Height))
+	return evenSize(math.Round(float64(region.Width) * fit * scale)), evenSize(math.Round(float64(region.Height) * fit * scale))
+}
+
+func evenSize(v float64) int {
+	return max(int(v)&^1, 2)
+}
+
 type ScreenCapture struct {
 	cmd       *exec.Cmd
 	output    io.ReadCloser
@@ -22,24 +69,69 @@ type ScreenCapture struct {
 	restartCh chan struct{}
 	mu        sync.Mutex
 	running   bool
+	source    CaptureSource
+	encoder   encoderProfile
+	drawMouse bool
+	region    Region
+	settings  VideoSettings
+	format    Format
+
+	// units carries the pictures of every ffmpeg process started, tagged with gen so
+	// that those of a replaced process are dropped.
+	units    chan AccessUnit
+	gen      uint64
+	done     chan struct{}
+	doneOnce sync.Once
+	// lastKeyframe is when the latest keyframe was read, lastKeyframeRequest when
+	// RequestKeyframe last restarted the encoder.
+	lastKeyframe        time.Time
+	lastKeyframeRequest time.Time
 }
 
-type Win32_VideoController struct {
-	Name string
-}
-
-var Accel string
+const (
+	// keyframeInterval is how often the encoder makes a keyframe by itself.
+	keyframeInterval = 2 * time.Second
+	// keyframeRestartInterval is the least time between two encoder restarts made for
+	// RequestKeyframe. Restarting drops the pictures ffmpeg has queued and costs its
+	// startup time, so a client asking repeatedly must wait for natural keyframes.
+	keyframeRestartInterval = 5 * time.Second
+	// accessUnitIdleFlush is how long the encoder must be quiet for the picture read so
+	// far to be treated as complete.
+	accessUnitIdleFlush = 2 * time.Millisecond
+)
 
-func NewScreenCapture() (*ScreenCapture, error) {
+// NewScreenCapture starts capturing region of the desktop, with the best encoder of one
+// of codecs, which a client can decode. An empty region captures the whole desktop, and
+// no codecs means H.264. drawMouse is false when the client draws the cursor itself.
+func NewScreenCapture(region Region, settings VideoSettings, codecs []Codec, drawMouse bool) (*ScreenCapture, error) {
+	caps, err := probeFFmpeg()
+	if err != nil {
+		return nil, err
+	}
+	source, err := SelectCaptureSource(PreferredSource, caps)
+	if err != nil {
+		return nil, err
+	}
+	if !source.Available(caps) {
+		log.Printf("Screen capture: WARN: Capture source %s may not work on this host, using it as requested.", source.Name())
+	}
+	if len(codecs) == 0 {
+		codecs = []Codec{CodecH264}
+	}
+	encoder, err := detectEncoder(caps, codecs)
+	if err != nil {
+		return nil, err
+	}
 	sc := &ScreenCapture{
 		restartCh: make(chan struct{}, 1),
 		running:   true,
-	}
-
-	var err error
-	Accel, err = detectEncoder()
-	if err != nil {
-		log.Printf("Error detecting encoder: %v. Will use fallback in start().", err)
+		source:    source,
+		encoder:   encoder,
+		drawMouse: drawMouse,
+		region:    region,
+		settings:  settings,
+		units:     make(chan AccessUnit, 8),
+		done:      make(chan struct{}),
 	}
 
 	if err := sc.start(); err != nil {
@@ -50,113 +142,52 @@ func NewScreenCapture() (*ScreenCapture, error) {
 	return sc, nil
 }
 
-func (sc *ScreenCapture) start() error {
-	sc.mu.Lock()
-	defer sc.mu.Unlock()
-
-	currentEncoder := Accel
-	if currentEncoder == "" {
-		var detectErr error
-		currentEncoder, detectErr = detectEncoder()
-		if detectErr != nil {
-			log.Printf("Screen capture start: Error detecting encoder: %v. Fallback to libx264.", detectErr)
-			currentEncoder = "libx264"
-		}
-	}
-	log.Printf("Screen capture: Attempting to use encoder: %s", currentEncoder)
-
-	primaryDisplayIndex := 0
-	bounds := screenshot.GetDisplayBounds(primaryDisplayIndex)
-
-	captureWidth := bounds.Dx()
-	captureHeight := bounds.Dy()
-	captureOffsetX := bounds.Min.X
-	captureOffsetY := bounds.Min.Y
-
-	if captureWidth <= 0 || captureHeight <= 0 {
-		log.Printf("Screen capture: Invalid primary display dimensions (%dx%d). Capturing entire desktop and scaling.", captureWidth, captureHeight)
-
-	}
-	log.Printf("Screen capture: Primary display detected as %dx%d at offset (%d,%d)", captureWidth, captureHeight, captureOffsetX, captureOffsetY)
-
-	args := []string{
-		"-f", "gdigrab",
-		"-framerate", "30",
-		"-i", "desktop",
-		"-an",
-	}
-
-	vfString := ""
-	if captureWidth > 0 && captureHeight > 0 {
-		vfString = fmt.Sprintf("crop=%d:%d:%d:%d,scale=1920:1080,format=yuv420p",
-			captureWidth, captureHeight, captureOffsetX, captureOffsetY)
-	} else {
-		vfString = "scale=1920:1080,format=yuv420p"
+// buildFFmpegArgs assembles the capture command: encoder device, screen input, filters
+// down to the video size, encoder options and the encoded stream on stdout, in the
+// codec's muxer.
+func buildFFmpegArgs(source CaptureSource, region Region, settings VideoSettings, encoder encoderProfile, drawMouse bool) []string {
+	args := append([]string{}, encoder.globalArgs...)
+	args = append(args, source.InputArgs(region, settings.Framerate, drawMouse)...)
+	args = append(args, "-an")
+
+	width, height := videoSize(region, settings)
+	filters := source.Filters(region)
+	if width != region.Width || height != region.Height {
+		filters = append(filters, fmt.Sprintf("scale=%d:%d", width, height))
 	}
-	args = append(args, "-vf", vfString)
-
-	args = append(args,
-		"-c:v", currentEncoder,
-		"-g", "60",
+	filters = append(filters, encoder.filters())
+	args = append(args, "-vf", strings.Join(filters, ","))
+
+	gop := int(keyframeInterval.Seconds()) * settings.Framerate
+	args = append(args, "-c:v", encoder.name, "-g", fmt.Sprint(gop))
+	args = append(args, encoder.args...)
+	args = append(args, encoder.bitrateArgs(settings.BitrateKbps)...)
+	return append(args,
 		"-flags", "+low_delay",
 		"-fflags", "nobuffer",
-		"-f", "mpegts",
+		"-f", encoder.codec.muxer(),
 		"-flush_packets", "1",
 		"pipe:1",
 	)
+}
 
-	switch {
-	case strings.Contains(currentEncoder, "nvenc"):
-		args = append(args,
-			"-preset", "ll",
-			"-profile:v", "high",
-			"-rc", "vbr_hq",
-			"-b:v", "3M",
-			"-maxrate", "5M",
-			"-bufsize", "6M",
-			"-multipass", "0",
-			"-delay", "0",
-			"-zerolatency", "1",
-			"-rc-lookahead", "0",
-			"-forced-idr", "1",
-			"-strict", "2",
-		)
-
-	case strings.Contains(currentEncoder, "amf"):
-		args = append(args,
-			"-usage", "ultralowlatency",
-			"-quality", "speed",
-			"-profile:v", "high",
-			"-rc", "cbr",
-			"-b:v", "3M",
-		)
-
-	case strings.Contains(currentEncoder, "qsv"):
-		args = append(args,
-			"-preset", "veryfast",
-			"-profile:v", "high",
-			"-look_ahead", "0",
-			"-async_depth", "1",
-			"-b:v", "3M",
-			"-maxrate", "5M",
-		)
-
-	case strings.Contains(currentEncoder, "libx264"):
-		args = append(args,
-			"-preset", "ultrafast",
-			"-tune", "zerolatency",
-
-			"-b:v", "3M", "-maxrate", "4M", "-bufsize", "6M",
-		)
-	default:
-		log.Printf("Screen capture: Encoder '%s' not specifically handled, using generic libx264 settings.", currentEncoder)
-		args = replaceOrAddArg(args, "-c:v", "libx264")
-		args = append(args,
-			"-preset", "ultrafast",
-			"-tune", "zerolatency",
-			"-b:v", "3M", "-maxrate", "4M", "-bufsize", "6M",
-		)
+func (sc *ScreenCapture) start() error {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	return sc.startLocked()
+}
+
+func (sc *ScreenCapture) startLocked() error {
+	region := sc.region
+	if region.empty() {
+		log.Printf("Screen capture: Display size unknown. Capturing the entire desktop and scaling.")
+	} else {
+		log.Printf("Screen capture: Capturing %dx%d at offset (%d,%d)", region.Width, region.Height, region.X, region.Y)
 	}
+	width, height := videoSize(region, sc.settings)
+	sc.format = Format{Codec: sc.encoder.codec, Width: width, Height: height, CaptureWidth: region.Width, CaptureHeight: region.Height}
+	log.Printf("Screen capture: Capturing with %s, encoding with %s at %dx%d (%v)", sc.source.Name(), sc.encoder.name, width, height, sc.settings)
+	args := buildFFmpegArgs(sc.source, region, sc.settings, sc.encoder, sc.drawMouse)
 
 	log.Printf("Screen capture: Starting FFmpeg with args: %v", args)
 
@@ -181,13 +212,20 @@ func (sc *ScreenCapture) start() error {
 		return startErr
 	}
 
-	log.Printf("Screen capture started with %s encoder (PID: %d)", currentEncoder, sc.cmd.Process.Pid)
+	log.Printf("Screen capture started with %s encoder (PID: %d)", sc.encoder.name, sc.cmd.Process.Pid)
 
+	sc.gen++
+	go sc.pump(sc.output, sc.gen, sc.format)
+
+	cmd := sc.cmd
 	go func() {
-		waitErr := sc.cmd.Wait()
+		waitErr := cmd.Wait()
 		sc.mu.Lock()
-		if sc.running {
-			log.Printf("Screen capture: FFmpeg process (PID: %d) exited while capture was expected to be running. Error: %v. Stderr: %s", sc.cmd.Process.Pid, waitErr, sc.stderr.String())
+		if sc.cmd != cmd {
+			// SetRegion or SetVideoSettings replaced this process.
+			log.Printf("Screen capture: FFmpeg process (PID: %d) for the previous region exited.", cmd.Process.Pid)
+		} else if sc.running {
+			log.Printf("Screen capture: FFmpeg process (PID: %d) exited while capture was expected to be running. Error: %v. Stderr: %s", cmd.Process.Pid, waitErr, sc.stderr.String())
 			if sc.output != nil {
 				sc.output.Close()
 				sc.output = nil
@@ -198,7 +236,7 @@ func (sc *ScreenCapture) start() error {
 				log.Println("Screen capture: restartCh is full or monitor not ready, restart signal might be missed.")
 			}
 		} else {
-			log.Printf("Screen capture: FFmpeg process (PID: %d) exited (expected due to Close call or failed restart). Error (if any): %v. Stderr: %s", sc.cmd.Process.Pid, waitErr, sc.stderr.String())
+			log.Printf("Screen capture: FFmpeg process (PID: %d) exited (expected due to Close call or failed restart). Error (if any): %v. Stderr: %s", cmd.Process.Pid, waitErr, sc.stderr.String())
 		}
 		sc.mu.Unlock()
 	}()
@@ -206,62 +244,51 @@ func (sc *ScreenCapture) start() error {
 	return nil
 }
 
-func replaceOrAddArg(args []string, argToSet string, valueToSet string) []string {
-	found := false
-	for i, arg := range args {
-		if arg == argToSet {
-			if i+1 < len(args) {
-				args[i+1] = valueToSet
-				found = true
-				break
-			} else {
-				args = append(args, valueToSet)
-				found = true
-				break
-			}
-		}
+// SetRegion switches the capture to another region of the desktop, such as another
+// display, by restarting ffmpeg.
+func (sc *ScreenCapture) SetRegion(region Region) error {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	if !sc.running {
+		return io.ErrClosedPipe
 	}
-	if !found {
-		args = append(args, argToSet, valueToSet)
+	if region == sc.region {
+		return nil
 	}
-	return args
+	log.Printf("Screen capture: Switching region from %+v to %+v", sc.region, region)
+	sc.region = region
+	return sc.restartLocked()
 }
 
-func detectEncoder() (string, error) {
-	var controllers []Win32_VideoController
-	query := "SELECT Name FROM Win32_VideoController"
-	done := make(chan error, 1)
-	go func() {
-		done <- wmi.Query(query, &controllers)
-	}()
-
-	select {
-	case err := <-done:
-		if err != nil {
-			log.Printf("WMI query error: %v", err)
-			return "libx264", errors.New("WMI query failed, fallback to software encoder")
-		}
-		if len(controllers) == 0 {
-			return "libx264", errors.New("no video controllers found via WMI, fallback to software encoder")
-		}
-	case <-time.After(5 * time.Second):
-		log.Println("WMI query timed out, fallback to software encoder")
-		return "libx264", errors.New("WMI query timed out, fallback to software encoder")
+// SetVideoSettings re-encodes at another size, frame rate or bit rate by restarting
+// ffmpeg, as not every encoder can change them on the fly.
+func (sc *ScreenCapture) SetVideoSettings(settings VideoSettings) error {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	if !sc.running {
+		return io.ErrClosedPipe
+	}
+	if settings == sc.settings {
+		return nil
 	}
+	log.Printf("Screen capture: Changing video from %v to %v", sc.settings, settings)
+	sc.settings = settings
+	return sc.restartLocked()
+}
 
-	gpuVendor := strings.ToLower(controllers[0].Name)
-	log.Printf("Detected GPU: %s", controllers[0].Name)
-	switch {
-	case strings.Contains(gpuVendor, "amd"):
-		return "h264_amf", nil
-	case strings.Contains(gpuVendor, "nvidia"):
-		return "h264_nvenc", nil
-	case strings.Contains(gpuVendor, "intel"):
-		return "h264_qsv", nil
-	default:
-		log.Printf("Unknown GPU vendor: %s, fallback to software encoder", gpuVendor)
-		return "libx264", nil
+// restartLocked replaces the ffmpeg process with one using the current settings. The
+// next NextAccessUnit returns its pictures.
+func (sc *ScreenCapture) restartLocked() error {
+	sc.cleanupInternal()
+	if err := sc.startLocked(); err != nil {
+		// Let the monitor retry, as it would after a crash.
+		select {
+		case sc.restartCh <- struct{}{}:
+		default:
+		}
+		return err
 	}
+	return nil
 }
 
 func (sc *ScreenCapture) monitor() {
@@ -295,6 +322,7 @@ func (sc *ScreenCapture) monitor() {
 					sc.mu.Lock()
 					sc.running = false
 					sc.mu.Unlock()
+					sc.stop()
 					return
 				}
 			} else {
@@ -313,24 +341,128 @@ func (sc *ScreenCapture) monitor() {
 	}
 }
 
-func (sc *ScreenCapture) ReadFrame(buffer []byte) (int, error) {
+// Encoder returns the name of the ffmpeg encoder in use.
+func (sc *ScreenCapture) Encoder() string {
+	return sc.encoder.name
+}
+
+// Format returns the size of the video being captured. It changes with SetRegion and
+// SetVideoSettings.
+func (sc *ScreenCapture) Format() Format {
 	sc.mu.Lock()
-	if sc.output == nil {
-		sc.mu.Unlock()
-		log.Println("Screen capture: ReadFrame called but output pipe is nil. Returning EOF.")
-		return 0, io.EOF
+	defer sc.mu.Unlock()
+	return sc.format
+}
+
+// pump splits the output of one ffmpeg process into access units for NextAccessUnit.
+func (sc *ScreenCapture) pump(output io.Reader, gen uint64, format Format) {
+	chunks := make(chan []byte)
+	go func() {
+		defer close(chunks)
+		buf := make([]byte, 64*1024)
+		for {
+			n, err := output.Read(buf)
+			if n > 0 {
+				chunks <- bytes.Clone(buf[:n])
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	deliver := func(unit AccessUnit) bool {
+		unit.gen, unit.Format = gen, format
+		select {
+		case sc.units <- unit:
+			return true
+		case <-sc.done:
+			return false
+		}
 	}
-	currentOutput := sc.output
-	sc.mu.Unlock()
+	splitter := newSplitter(format.Codec)
+	idle := time.NewTimer(accessUnitIdleFlush)
+	defer idle.Stop()
+	for {
+		select {
+		case chunk, ok := <-chunks:
+			if !ok {
+				if unit, ok := splitter.flush(time.Now()); ok {
+					deliver(unit)
+				}
+				return
+			}
+			for _, unit := range splitter.write(chunk, time.Now()) {
+				if !deliver(unit) {
+					return
+				}
+			}
+			idle.Reset(accessUnitIdleFlush)
+		case <-idle.C:
+			if unit, ok := splitter.flush(time.Now()); ok && !deliver(unit) {
+				return
+			}
+		case <-sc.done:
+			return
+		}
+	}
+}
 
-	n, err := currentOutput.Read(buffer)
+// NextAccessUnit returns the next encoded picture. It returns io.EOF once the capture
+// is closed or could not be restarted.
+func (sc *ScreenCapture) NextAccessUnit(ctx context.Context) (AccessUnit, error) {
+	for {
+		select {
+		case unit := <-sc.units:
+			sc.mu.Lock()
+			current := unit.gen == sc.gen
+			if current && unit.Keyframe {
+				sc.lastKeyframe = unit.Time
+			}
+			sc.mu.Unlock()
+			if current {
+				return unit, nil
+			}
+		case <-sc.done:
+			return AccessUnit{}, io.EOF
+		case <-ctx.Done():
+			return AccessUnit{}, ctx.Err()
+		}
+	}
+}
 
-	if err != nil {
+// RequestKeyframe has the encoder start over from a keyframe, for a client that
+// restarted its decoder. ffmpeg cannot be asked for one mid-stream, so the encoder is
+// restarted when keyframeRestartNeeded allows it. It reports whether a keyframe is
+// coming, which one always is within keyframeInterval.
+func (sc *ScreenCapture) RequestKeyframe() bool {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	if !sc.running {
+		return false
+	}
+	now := time.Now()
+	if !keyframeRestartNeeded(now, sc.lastKeyframe, sc.lastKeyframeRequest) {
+		return true
+	}
+	log.Println("Screen capture: Restarting the encoder for a keyframe.")
+	sc.lastKeyframeRequest = now
+	return sc.restartLocked() == nil
+}
 
-		log.Printf("Screen capture: Error reading frame: %v (read %d bytes)", err, n)
-		return n, io.EOF
+// keyframeRestartNeeded reports whether a keyframe request at now should restart the
+// encoder: not within keyframeRestartInterval of the last restart, and not when the
+// encoder's own next keyframe is less than half an interval away.
+func keyframeRestartNeeded(now, lastKeyframe, lastRestart time.Time) bool {
+	if now.Sub(lastRestart) < keyframeRestartInterval {
+		return false
 	}
-	return n, nil
+	due := keyframeInterval - now.Sub(lastKeyframe)
+	return due < 0 || due >= keyframeInterval/2
+}
+
+func (sc *ScreenCapture) stop() {
+	sc.doneOnce.Do(func() { close(sc.done) })
 }
 
 func (sc *ScreenCapture) cleanupInternal() {
@@ -372,5 +504,6 @@ func (sc *ScreenCapture) Close() {
 
 	sc.cleanupInternal()
 	sc.mu.Unlock()
+	sc.stop()
 	log.Println("Screen capture: Resources cleaned up after Close call.")
 }
@@ -0,0 +1,428 @@
+package screen
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"math/rand"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+const ffmpegEncodersOutput = `Encoders:
+ V..... = Video
+ A..... = Audio
+ S..... = Subtitle
+ .F.... = Frame-level multithreading
+ ..S... = Slice-level multithreading
+ ...X.. = Codec is experimental
+ ....B. = Supports draw_horiz_band
+ .....D = Supports direct rendering method 1
+ ------
+ V....D libx264              libx264 H.264 / AVC / MPEG-4 AVC / MPEG-4 part 10 (codec h264)
+ V....D h264_nvenc           NVIDIA NVENC H.264 encoder (codec h264)
+ V..... h264_vaapi           H.264/AVC (VAAPI) (codec h264)
+ A....D aac                  AAC (Advanced Audio Coding)
+`
+
+const ffmpegDevicesOutput = `Devices:
+ D. = Demuxing supported
+ .E = Muxing supported
+ ---
+ DE alsa            ALSA audio output
+ D  kmsgrab         KMS screen capture
+ D  lavfi           Libavfilter virtual input device
+  E sdl,sdl2        SDL2 output device
+ D  x11grab         X11 screen capture, using XCB
+`
+
+const ffmpegFiltersOutput = `Filters:
+  T.. = Timeline support
+  .S. = Slice threading
+  ..C = Command support
+  A = Audio input/output
+  V = Video input/output
+  N = Dynamic number and/or type of input/output
+  | = Source or sink filter
+ TSC crop              V->V       Crop the input video.
+ ... hwdownload        V->V       Download a hardware frame to a normal frame
+ ... hwmap             V->V       Map hardware frames
+ ... pipewiregrab      |->V       Capture screen or window using PipeWire.
+`
+
+func TestParseFFmpegListings(t *testing.T) {
+	encoders := parseFFmpegEncoders(ffmpegEncodersOutput)
+	for _, name := range []string{"libx264", "h264_nvenc", "h264_vaapi", "aac"} {
+		if !encoders[name] {
+			t.Errorf("encoder %s not found", name)
+		}
+	}
+	if encoders["V....."] || encoders["="] {
+		t.Errorf("legend lines were parsed as encoders: %v", encoders)
+	}
+
+	devices := parseFFmpegDevices(ffmpegDevicesOutput)
+	for _, name := range []string{"alsa", "kmsgrab", "lavfi", "x11grab"} {
+		if !devices[name] {
+			t.Errorf("input device %s not found", name)
+		}
+	}
+	if devices["sdl"] || devices["sdl2"] {
+		t.Errorf("output-only device was listed as an input: %v", devices)
+	}
+
+	filters := parseFFmpegFilters(ffmpegFiltersOutput)
+	for _, name := range []string{"crop", "hwdownload", "hwmap", "pipewiregrab"} {
+		if !filters[name] {
+			t.Errorf("filter %s not found", name)
+		}
+	}
+	if len(filters) != 4 {
+		t.Errorf("expected 4 filters, got %v", filters)
+	}
+}
+
+func TestSelectCaptureSource(t *testing.T) {
+	caps := FFmpegCapabilities{devices: parseFFmpegDevices(ffmpegDevicesOutput)}
+	if source, err := SelectCaptureSource("x11grab", caps); err != nil || source.Name() != "x11grab" {
+		t.Errorf("x11grab: got %v, %v", source, err)
+	}
+	if _, err := SelectCaptureSource("vnc", caps); err == nil {
+		t.Error("expected an error for an unknown source")
+	}
+	if _, err := SelectCaptureSource("auto", FFmpegCapabilities{}); err == nil {
+		t.Error("expected an error when ffmpeg supports no capture device")
+	}
+}
+
+func TestBuildFFmpegArgs(t *testing.T) {
+	t.Setenv("DISPLAY", ":99")
+	region := Region{X: 10, Y: 20, Width: 1280, Height: 720}
+	args := buildFFmpegArgs(x11grabSource{}, region, DefaultVideoSettings, libx264Profile, true)
+	joined := strings.Join(args, " ")
+
+	if !strings.Contains(joined, "-f x11grab -framerate 30 -video_size 1280x720 -i :99+10,20") {
+		t.Errorf("x11grab input not found in %q", joined)
+	}
+	if !strings.Contains(joined, "-vf format=yuv420p ") {
+		t.Errorf("a region that fits should not be scaled: %q", joined)
+	}
+	if !strings.Contains(joined, "-f h264 ") {
+		t.Errorf("raw H.264 output not found in %q", joined)
+	}
+	// Options after the output are ignored by ffmpeg, so the encoder's must come first.
+	if args[len(args)-1] != "pipe:1" {
+		t.Errorf("output is not the last argument: %q", joined)
+	}
+	if i, j := slices.Index(args, "-tune"), slices.Index(args, "pipe:1"); i < 0 || i > j {
+		t.Errorf("encoder options are not before the output: %q", joined)
+	}
+	if !strings.Contains(joined, "-g 60 ") || !strings.Contains(joined, "-b:v 3000k -maxrate 5000k -bufsize 6000k") {
+		t.Errorf("rate control not found in %q", joined)
+	}
+
+	low := VideoSettings{Width: 960, Height: 540, Framerate: 15, BitrateKbps: 800}
+	joined = strings.Join(buildFFmpegArgs(x11grabSource{}, region, low, hardwareEncoderProfiles["h264_amf"], true), " ")
+	if !strings.Contains(joined, "-framerate 15 ") || !strings.Contains(joined, "scale=960:540,") || !strings.Contains(joined, "-g 30 ") {
+		t.Errorf("video settings not applied in %q", joined)
+	}
+	if !strings.Contains(joined, "-b:v 800k") || strings.Contains(joined, "-maxrate") {
+		t.Errorf("AMF should only get an average bit rate: %q", joined)
+	}
+
+	vaapi := hardwareEncoderProfiles["h264_vaapi"]
+	args = buildFFmpegArgs(kmsgrabSource{}, region, DefaultVideoSettings, vaapi, true)
+	joined = strings.Join(args, " ")
+	if !strings.HasPrefix(joined, "-vaapi_device /dev/dri/renderD128 -device ") {
+		t.Errorf("VAAPI device must precede the input: %q", joined)
+	}
+	if !strings.Contains(joined, "-vf hwmap=derive_device=vaapi,hwdownload,format=bgr0,crop=1280:720:10:20,format=nv12,hwupload") {
+		t.Errorf("kmsgrab filters not found in %q", joined)
+	}
+
+	args = buildFFmpegArgs(gdigrabSource{}, Region{}, DefaultVideoSettings, libx264Profile, true)
+	if joined = strings.Join(args, " "); !strings.Contains(joined, "-f gdigrab -framerate 30 -i desktop") {
+		t.Errorf("gdigrab should capture the whole desktop without a region: %q", joined)
+	}
+	if !strings.Contains(joined, "-vf scale=1920:1080,format=yuv420p") {
+		t.Errorf("a desktop of unknown size should be scaled to the default size: %q", joined)
+	}
+	if strings.Contains(joined, "-draw_mouse") {
+		t.Errorf("the cursor should be drawn unless the client draws it: %q", joined)
+	}
+
+	joined = strings.Join(buildFFmpegArgs(gdigrabSource{}, Region{}, DefaultVideoSettings, libx264Profile, false), " ")
+	if !strings.Contains(joined, "-f gdigrab -framerate 30 -draw_mouse 0 -i desktop") {
+		t.Errorf("gdigrab should leave the cursor out for a client that draws it: %q", joined)
+	}
+}
+
+func TestSpanningRegion(t *testing.T) {
+	displays := []Display{
+		{Index: 0, Primary: true, Region: Region{X: 0, Y: 0, Width: 1920, Height: 1080}},
+		{Index: 1, Region: Region{X: -1280, Y: 200, Width: 1280, Height: 1024}},
+		{Index: 2, Region: Region{X: 1920, Y: -300, Width: 2560, Height: 1440}},
+	}
+	want := Region{X: -1280, Y: -300, Width: 5760, Height: 1524}
+	if got := spanningRegion(displays); got != want {
+		t.Errorf("spanningRegion = %+v, want %+v", got, want)
+	}
+	if got := spanningRegion(nil); !got.empty() {
+		t.Errorf("spanningRegion of no displays = %+v, want an empty region", got)
+	}
+}
+
+func TestVideoSize(t *testing.T) {
+	native := VideoSettings{Width: 7680, Height: 4320, Scale: 1}
+	tests := []struct {
+		name          string
+		region        Region
+		settings      VideoSettings
+		width, height int
+	}{
+		{"4K native", Region{Width: 3840, Height: 2160}, native, 3840, 2160},
+		{"4K into 1080p", Region{Width: 3840, Height: 2160}, DefaultVideoSettings, 1920, 1080},
+		{"ultrawide into 1080p", Region{Width: 3440, Height: 1440}, DefaultVideoSettings, 1920, 804},
+		{"portrait into 1080p", Region{Width: 1080, Height: 1920}, DefaultVideoSettings, 608, 1080},
+		{"small display is not scaled up", Region{Width: 1366, Height: 768}, DefaultVideoSettings, 1366, 768},
+		{"halved", Region{Width: 2560, Height: 1440}, VideoSettings{Width: 7680, Height: 4320, Scale: 0.5}, 1280, 720},
+		{"unknown desktop", Region{}, native, 1920, 1080},
+	}
+	for _, tt := range tests {
+		if w, h := videoSize(tt.region, tt.settings); w != tt.width || h != tt.height {
+			t.Errorf("%s: got %dx%d, want %dx%d", tt.name, w, h, tt.width, tt.height)
+		}
+	}
+}
+
+func TestAnnexBSplitter(t *testing.T) {
+	keyframe := []byte{
+		0, 0, 0, 1, 0x67, 0x42, 0x00, 0x1f, // SPS
+		0, 0, 0, 1, 0x68, 0xce, 0x3c, 0x80, // PPS
+		0, 0, 1, 0x65, 0x88, 0x84, 0x00, // IDR, first slice
+		0, 0, 1, 0x65, 0x40, 0x22, 0x11, // IDR, second slice
+	}
+	first := []byte{0, 0, 0, 1, 0x41, 0x9a, 0x02, 0x03}
+	second := []byte{0, 0, 0, 1, 0x41, 0x9a, 0x05, 0x06}
+	stream := slices.Concat(keyframe, first, second)
+	want := []AccessUnit{{Data: keyframe, Keyframe: true}, {Data: first}, {Data: second}}
+
+	start := time.Now()
+	for _, chunkSize := range []int{1, 5, len(stream)} {
+		var p annexBSplitter
+		var got []AccessUnit
+		for i := 0; i < len(stream); i += chunkSize {
+			got = append(got, p.write(stream[i:min(i+chunkSize, len(stream))], start.Add(time.Duration(i)))...)
+		}
+		if len(got) != 2 {
+			t.Fatalf("chunks of %d: got %d access units before the flush, want 2", chunkSize, len(got))
+		}
+		last, ok := p.flush(start)
+		if !ok {
+			t.Fatalf("chunks of %d: flush returned nothing", chunkSize)
+		}
+		got = append(got, last)
+		for i, unit := range got {
+			if !bytes.Equal(unit.Data, want[i].Data) || unit.Keyframe != want[i].Keyframe {
+				t.Errorf("chunks of %d: access unit %d = % x (keyframe %t), want % x (keyframe %t)", chunkSize, i, unit.Data, unit.Keyframe, want[i].Data, want[i].Keyframe)
+			}
+		}
+		if !got[0].Time.Equal(start) {
+			t.Errorf("chunks of %d: first access unit read at %v, want the time of its first bytes", chunkSize, got[0].Time.Sub(start))
+		}
+		if _, ok := p.flush(start); ok {
+			t.Errorf("chunks of %d: flush without a pending picture returned one", chunkSize)
+		}
+	}
+}
+
+func TestHEVCAccessUnits(t *testing.T) {
+	keyframe := []byte{
+		0, 0, 0, 1, 0x40, 0x01, 0x0c, // VPS
+		0, 0, 0, 1, 0x42, 0x01, 0x01, // SPS
+		0, 0, 0, 1, 0x44, 0x01, 0xc1, // PPS
+		0, 0, 1, 0x26, 0x01, 0xaf, 0x10, // IDR_W_RADL, first slice segment
+	}
+	inter := []byte{0, 0, 0, 1, 0x02, 0x01, 0xd0, 0x20} // TRAIL_R, first slice segment
+	var p annexBSplitter
+	p.hevc = true
+	units := p.write(slices.Concat(keyframe, inter), time.Now())
+	last, ok := p.flush(time.Now())
+	if len(units) != 1 || !ok {
+		t.Fatalf("got %d access units and a flushed one (%t), want 1 and one", len(units), ok)
+	}
+	if !bytes.Equal(units[0].Data, keyframe) || !units[0].Keyframe || !bytes.Equal(last.Data, inter) || last.Keyframe {
+		t.Errorf("got % x (keyframe %t) and % x (keyframe %t)", units[0].Data, units[0].Keyframe, last.Data, last.Keyframe)
+	}
+}
+
+func TestIVFSplitter(t *testing.T) {
+	ivf := func(frames ...[]byte) []byte {
+		stream := make([]byte, ivfFileHeaderSize)
+		copy(stream, "DKIF")
+		for i, f := range frames {
+			header := make([]byte, ivfFrameHeaderSize)
+			binary.LittleEndian.PutUint32(header, uint32(len(f)))
+			binary.LittleEndian.PutUint64(header[4:], uint64(i))
+			stream = append(append(stream, header...), f...)
+		}
+		return stream
+	}
+	tests := []struct {
+		codec     Codec
+		frames    [][]byte
+		keyframes []bool
+	}{
+		// frame_marker, profile 0, show_existing_frame and frame_type.
+		{CodecVP9, [][]byte{{0x82, 0x49, 0x83}, {0x86, 0x00}}, []bool{true, false}},
+		// A temporal delimiter, then a frame OBU with a size field.
+		{CodecAV1, [][]byte{{0x12, 0x00, 0x32, 0x02, 0x10, 0x00}, {0x12, 0x00, 0x32, 0x02, 0x30, 0x00}}, []bool{true, false}},
+	}
+	for _, tt := range tests {
+		stream := ivf(tt.frames...)
+		for _, chunkSize := range []int{1, 7, len(stream)} {
+			p := newSplitter(tt.codec)
+			var got []AccessUnit
+			for i := 0; i < len(stream); i += chunkSize {
+				got = append(got, p.write(stream[i:min(i+chunkSize, len(stream))], time.Now())...)
+			}
+			if len(got) != len(tt.frames) {
+				t.Fatalf("%s in chunks of %d: got %d frames, want %d", tt.codec, chunkSize, len(got), len(tt.frames))
+			}
+			for i, unit := range got {
+				if !bytes.Equal(unit.Data, tt.frames[i]) || unit.Keyframe != tt.keyframes[i] {
+					t.Errorf("%s in chunks of %d: frame %d = % x (keyframe %t), want % x (keyframe %t)", tt.codec, chunkSize, i, unit.Data, unit.Keyframe, tt.frames[i], tt.keyframes[i])
+				}
+			}
+		}
+	}
+}
+
+func TestPickEncoder(t *testing.T) {
+	candidates := []encoderProfile{hardwareEncoderProfiles["hevc_nvenc"], hardwareEncoderProfiles["h264_nvenc"]}
+	candidates = append(candidates, softwareEncoderProfiles...)
+	usable := func(p encoderProfile) bool { return p.name != "hevc_nvenc" }
+
+	tests := []struct {
+		codecs []Codec
+		want   string
+	}{
+		{[]Codec{CodecHEVC, CodecH264}, "h264_nvenc"},
+		{[]Codec{CodecVP9}, "libvpx-vp9"},
+		{[]Codec{CodecHEVC}, "libx265"},
+		{[]Codec{CodecAV1, CodecVP9}, "libvpx-vp9"},
+	}
+	for _, tt := range tests {
+		if got, ok := pickEncoder(candidates, tt.codecs, usable); !ok || got.name != tt.want {
+			t.Errorf("%v: got %q (%t), want %q", tt.codecs, got.name, ok, tt.want)
+		}
+	}
+	if got, ok := pickEncoder(candidates[:2], []Codec{CodecAV1}, usable); ok {
+		t.Errorf("no AV1 encoder: got %q", got.name)
+	}
+}
+
+func TestDiffTiles(t *testing.T) {
+	prev := image.NewRGBA(image.Rect(0, 0, 150, 100))
+	if got := diffTiles(nil, prev, 64); len(got) != 6 || got[5] != image.Rect(128, 64, 150, 100) {
+		t.Fatalf("without a previous frame: got %v, want 6 tiles ending with the clipped corner", got)
+	}
+
+	cur := image.NewRGBA(prev.Bounds())
+	if got := diffTiles(prev, cur, 64); len(got) != 0 {
+		t.Errorf("identical frames: got %v, want no tiles", got)
+	}
+	cur.Set(70, 10, color.RGBA{R: 255, A: 255})
+	cur.Set(149, 99, color.RGBA{G: 255, A: 255})
+	want := []image.Rectangle{image.Rect(64, 0, 128, 64), image.Rect(128, 64, 150, 100)}
+	if got := diffTiles(prev, cur, 64); !slices.Equal(got, want) {
+		t.Errorf("two changed pixels: got %v, want %v", got, want)
+	}
+
+	// A capture that does not start at the origin is compared by position in the frame.
+	moved := image.NewRGBA(image.Rect(-150, 0, 0, 100))
+	if got := diffTiles(prev, moved, 64); len(got) != 0 {
+		t.Errorf("offset identical frame: got %v, want no tiles", got)
+	}
+	if got := diffTiles(prev, image.NewRGBA(image.Rect(0, 0, 100, 100)), 64); len(got) != 4 {
+		t.Errorf("resized frame: got %d tiles, want all 4", len(got))
+	}
+}
+
+func TestSplitTiles(t *testing.T) {
+	// A whole frame of a busy 1080p screen is more than a client receives in a message.
+	img := image.NewRGBA(image.Rect(0, 0, 1920, 1080))
+	rand.New(rand.NewSource(1)).Read(img.Pix)
+	var tiles []Tile
+	total := 0
+	for _, r := range diffTiles(nil, img, TileSize) {
+		data, err := encodeTile(img.SubImage(r), TilePNG)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tiles = append(tiles, Tile{X: r.Min.X, Y: r.Min.Y, Width: r.Dx(), Height: r.Dy(), Data: data})
+		total += len(data)
+	}
+	if total <= 4<<20 {
+		t.Fatalf("the test frame is only %d bytes", total)
+	}
+
+	const budget = 1 << 20
+	parts := SplitTiles(tiles, budget)
+	if len(parts) < total/budget {
+		t.Errorf("got %d parts for %d bytes, want at least %d", len(parts), total, total/budget)
+	}
+	var rejoined []Tile
+	for i, part := range parts {
+		size := 0
+		for _, tile := range part {
+			size += len(tile.Data)
+		}
+		if size > budget || len(part) == 0 {
+			t.Errorf("part %d: %d tiles of %d bytes, want 1 or more within %d", i, len(part), size, budget)
+		}
+		rejoined = append(rejoined, part...)
+	}
+	if !slices.EqualFunc(rejoined, tiles, func(a, b Tile) bool { return a.X == b.X && a.Y == b.Y }) {
+		t.Errorf("the parts do not hold the %d tiles in order", len(tiles))
+	}
+
+	big := Tile{Data: make([]byte, 3)}
+	small := Tile{Data: make([]byte, 1)}
+	if got := SplitTiles([]Tile{small, big, small}, 2); len(got) != 3 || len(got[1]) != 1 {
+		t.Errorf("a tile over the budget: got %d parts, want it alone in the middle one", len(got))
+	}
+	if got := SplitTiles([]Tile{small, small}, 2); len(got) != 1 {
+		t.Errorf("tiles within the budget: got %d parts, want 1", len(got))
+	}
+	if got := SplitTiles(nil, 2); len(got) != 1 || len(got[0]) != 0 {
+		t.Errorf("no tiles: got %v, want one empty part", got)
+	}
+}
+
+func TestKeyframeRestartNeeded(t *testing.T) {
+	now := time.Now()
+	longAgo := now.Add(-time.Minute)
+	testCases := []struct {
+		name                      string
+		lastKeyframe, lastRestart time.Time
+		want                      bool
+	}{
+		{"FirstRequest", time.Time{}, time.Time{}, true},
+		{"KeyframeJustSent", now.Add(-100 * time.Millisecond), longAgo, true},
+		{"KeyframeHalfAnIntervalAway", now.Add(-keyframeInterval / 2), longAgo, true},
+		{"KeyframeDueShortly", now.Add(-keyframeInterval + 400*time.Millisecond), longAgo, false},
+		{"KeyframeOverdue", now.Add(-3 * keyframeInterval), longAgo, true},
+		{"JustRestarted", longAgo, now.Add(-time.Second), false},
+		{"RestartedWithinTheInterval", longAgo, now.Add(-keyframeRestartInterval + time.Millisecond), false},
+		{"RestartedBeforeTheInterval", longAgo, now.Add(-keyframeRestartInterval), true},
+	}
+	for _, tc := range testCases {
+		if got := keyframeRestartNeeded(now, tc.lastKeyframe, tc.lastRestart); got != tc.want {
+			t.Errorf("%s: got %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,243 @@
+package screen
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/kbinani/screenshot"
+)
+
+const (
+	// TileSize is the width and height of the squares the screen is compared in.
+	TileSize = 64
+	// maxTileFramerate bounds how often the screen is compared, as capturing and
+	// diffing in Go costs far more CPU than an encoder does.
+	maxTileFramerate = 15
+	tileJPEGQuality  = 80
+)
+
+// Tile formats.
+const (
+	TileJPEG = "jpeg"
+	TilePNG  = "png"
+)
+
+// Tile is an encoded part of the screen, positioned in the frame.
+type Tile struct {
+	X, Y, Width, Height int
+	Data                []byte
+}
+
+// TileFrame is the tiles that changed since the previous frame.
+type TileFrame struct {
+	Format Format
+	Tiles  []Tile
+	// Full is set when the tiles cover the whole frame, which the client can start from.
+	Full bool
+	// Time is when the screen was captured.
+	Time time.Time
+}
+
+// TileCapture captures the screen in process, without ffmpeg, and sends only the
+// tiles that changed, as JPEG or PNG. It suits desktop work where little moves, and
+// hosts where ffmpeg is not installed. Frames keep the display's native size.
+type TileCapture struct {
+	mu        sync.Mutex
+	region    Region
+	format    string
+	framerate int
+	prev      *image.RGBA // the previous frame, nil to send the next one whole
+	ticker    *time.Ticker
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// NewTileCapture starts comparing region of the desktop at the settings' frame rate,
+// sending tiles in format. An empty region captures the first display.
+func NewTileCapture(region Region, settings VideoSettings, format string) (*TileCapture, error) {
+	if format != TileJPEG && format != TilePNG {
+		return nil, fmt.Errorf("unknown tile format %q, expected %s or %s", format, TileJPEG, TilePNG)
+	}
+	if screenshot.NumActiveDisplays() == 0 {
+		return nil, errors.New("no displays can be captured in process on this host")
+	}
+	tc := &TileCapture{
+		region: region,
+		format: format,
+		done:   make(chan struct{}),
+	}
+	tc.framerate = tileFramerate(settings)
+	tc.ticker = time.NewTicker(time.Second / time.Duration(tc.framerate))
+	log.Printf("Screen capture: Capturing %s tiles at up to %d fps", format, tc.framerate)
+	return tc, nil
+}
+
+func tileFramerate(settings VideoSettings) int {
+	return min(max(settings.Framerate, 1), maxTileFramerate)
+}
+
+// NextFrame waits for the screen to change and returns the changed tiles. It returns
+// io.EOF once the capture is closed.
+func (tc *TileCapture) NextFrame(ctx context.Context) (TileFrame, error) {
+	for {
+		select {
+		case <-tc.ticker.C:
+		case <-tc.done:
+			return TileFrame{}, io.EOF
+		case <-ctx.Done():
+			return TileFrame{}, ctx.Err()
+		}
+
+		tc.mu.Lock()
+		region, prev := tc.region, tc.prev
+		tc.mu.Unlock()
+		if region.empty() {
+			bounds := screenshot.GetDisplayBounds(0)
+			region = Region{X: bounds.Min.X, Y: bounds.Min.Y, Width: bounds.Dx(), Height: bounds.Dy()}
+		}
+		now := time.Now()
+		img, err := screenshot.CaptureRect(image.Rect(region.X, region.Y, region.X+region.Width, region.Y+region.Height))
+		if err != nil {
+			log.Printf("Screen capture: Failed to capture tiles: %v", err)
+			continue
+		}
+
+		changed := diffTiles(prev, img, TileSize)
+		tc.mu.Lock()
+		if tc.prev != prev {
+			// SetRegion or RequestKeyframe asked for a whole frame meanwhile.
+			tc.mu.Unlock()
+			continue
+		}
+		tc.prev = img
+		tc.mu.Unlock()
+		if len(changed) == 0 {
+			continue
+		}
+
+		frame := TileFrame{
+			Format: Format{Width: img.Bounds().Dx(), Height: img.Bounds().Dy(), CaptureWidth: region.Width, CaptureHeight: region.Height},
+			Full:   prev == nil,
+			Time:   now,
+			Tiles:  make([]Tile, 0, len(changed)),
+		}
+		for _, r := range changed {
+			data, err := encodeTile(img.SubImage(r), tc.format)
+			if err != nil {
+				return TileFrame{}, err
+			}
+			origin := r.Min.Sub(img.Bounds().Min)
+			frame.Tiles = append(frame.Tiles, Tile{X: origin.X, Y: origin.Y, Width: r.Dx(), Height: r.Dy(), Data: data})
+		}
+		return frame, nil
+	}
+}
+
+// diffTiles returns the tiles of cur that differ from prev, or all of them when there
+// is no previous frame of the same size.
+func diffTiles(prev, cur *image.RGBA, size int) []image.Rectangle {
+	bounds := cur.Bounds()
+	full := prev == nil || prev.Bounds().Size() != bounds.Size()
+	var changed []image.Rectangle
+	for y := bounds.Min.Y; y < bounds.Max.Y; y += size {
+		for x := bounds.Min.X; x < bounds.Max.X; x += size {
+			tile := image.Rect(x, y, x+size, y+size).Intersect(bounds)
+			if full || !tileEqual(prev, cur, tile) {
+				changed = append(changed, tile)
+			}
+		}
+	}
+	return changed
+}
+
+// SplitTiles groups tiles, in order, into parts whose data adds up to at most budget
+// bytes, so that a large frame can be sent in several messages. A tile larger than the
+// budget gets a part of its own.
+func SplitTiles(tiles []Tile, budget int) [][]Tile {
+	var parts [][]Tile
+	start, size := 0, 0
+	for i, t := range tiles {
+		if i > start && size+len(t.Data) > budget {
+			parts = append(parts, tiles[start:i])
+			start, size = i, 0
+		}
+		size += len(t.Data)
+	}
+	return append(parts, tiles[start:])
+}
+
+// tileEqual compares a tile of cur with the same place in prev, row by row.
+func tileEqual(prev, cur *image.RGBA, tile image.Rectangle) bool {
+	offset := prev.Bounds().Min.Sub(cur.Bounds().Min)
+	rowBytes := tile.Dx() * 4
+	for y := tile.Min.Y; y < tile.Max.Y; y++ {
+		c := cur.PixOffset(tile.Min.X, y)
+		p := prev.PixOffset(tile.Min.X+offset.X, y+offset.Y)
+		if !bytes.Equal(cur.Pix[c:c+rowBytes], prev.Pix[p:p+rowBytes]) {
+			return false
+		}
+	}
+	return true
+}
+
+func encodeTile(img image.Image, format string) ([]byte, error) {
+	var buf bytes.Buffer
+	var err error
+	if format == TilePNG {
+		err = (&png.Encoder{CompressionLevel: png.BestSpeed}).Encode(&buf, img)
+	} else {
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: tileJPEGQuality})
+	}
+	return buf.Bytes(), err
+}
+
+// TileFormat returns the format tiles are encoded in.
+func (tc *TileCapture) TileFormat() string {
+	return tc.format
+}
+
+// SetRegion switches to another region of the desktop, starting with a whole frame.
+func (tc *TileCapture) SetRegion(region Region) error {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	tc.region = region
+	tc.prev = nil
+	return nil
+}
+
+// SetVideoSettings applies the settings' frame rate. Tiles are always at the display's
+// native size.
+func (tc *TileCapture) SetVideoSettings(settings VideoSettings) error {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	if framerate := tileFramerate(settings); framerate != tc.framerate {
+		log.Printf("Screen capture: Comparing tiles at %d fps", framerate)
+		tc.framerate = framerate
+		tc.ticker.Reset(time.Second / time.Duration(framerate))
+	}
+	return nil
+}
+
+// RequestKeyframe has the next frame sent whole, for a client that lost tiles.
+func (tc *TileCapture) RequestKeyframe() bool {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	tc.prev = nil
+	return true
+}
+
+func (tc *TileCapture) Close() {
+	tc.closeOnce.Do(func() {
+		tc.ticker.Stop()
+		close(tc.done)
+	})
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	pb "control_grpc/gen/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// execWaitDelay is how long Exec waits for the output pipes to close after the program
+// has exited or been killed, in case it left children holding them open.
+const execWaitDelay = 2 * time.Second
+
+// Exec runs one program without a PTY, streaming stdout and stderr separately and
+// finishing with its exit status.
+func (s *server) Exec(req *pb.ExecRequest, stream pb.TerminalService_ExecServer) error {
+	return runExec(stream.Context(), req, stream.Send)
+}
+
+func runExec(ctx context.Context, req *pb.ExecRequest, send func(*pb.ExecResponse) error) error {
+	argv := req.GetArgv()
+	if len(argv) == 0 || argv[0] == "" {
+		return status.Errorf(codes.InvalidArgument, "No command given.")
+	}
+	if req.GetTimeoutMs() < 0 {
+		return status.Errorf(codes.InvalidArgument, "Invalid timeout: %d ms", req.GetTimeoutMs())
+	}
+	dir, err := terminalWorkingDirectory(req.GetWorkingDirectory())
+	if err != nil {
+		return err
+	}
+	env, err := terminalEnvironment(req.GetEnvironment())
+	if err != nil {
+		return err
+	}
+
+	timeout := time.Duration(req.GetTimeoutMs()) * time.Millisecond
+	var runCtx context.Context
+	var cancel context.CancelFunc
+	if timeout > 0 {
+		runCtx, cancel = context.WithTimeout(ctx, timeout)
+	} else {
+		runCtx, cancel = context.WithCancel(ctx)
+	}
+	defer cancel()
+
+	// The output writers are called from the goroutines exec starts for each pipe, and
+	// a stream may only be sent on from one goroutine at a time.
+	var sendMu sync.Mutex
+	cmd := exec.CommandContext(runCtx, argv[0], argv[1:]...)
+	cmd.Dir = dir
+	cmd.Env = mergeEnv(os.Environ(), env)
+	cmd.Stdin = bytes.NewReader(req.GetStdin())
+	cmd.Stdout = &execOutputWriter{mu: &sendMu, send: send, outputType: pb.TerminalResponse_STDOUT}
+	cmd.Stderr = &execOutputWriter{mu: &sendMu, send: send, outputType: pb.TerminalResponse_STDERR}
+	cmd.WaitDelay = execWaitDelay
+
+	log.Printf("TerminalService: Exec %q in %q (timeout %v)", strings.Join(argv, " "), dir, timeout)
+	start := time.Now()
+	err = cmd.Run()
+	if cmd.ProcessState == nil {
+		log.Printf("TerminalService: Exec failed to start %q: %v", argv[0], err)
+		if errors.Is(err, exec.ErrNotFound) {
+			return status.Errorf(codes.NotFound, "Command not found: %s", argv[0])
+		}
+		return status.Errorf(codes.FailedPrecondition, "Failed to start command: %v", err)
+	}
+
+	resp := &pb.ExecResponse{Exited: true, ExitCode: int32(cmd.ProcessState.ExitCode())}
+	var exitErr *exec.ExitError
+	switch {
+	case errors.Is(runCtx.Err(), context.DeadlineExceeded):
+		resp.TimedOut = true
+		resp.ErrorMessage = "Command timed out after " + timeout.String()
+	case ctx.Err() != nil:
+		log.Printf("TerminalService: Exec %q cancelled by the client after %v.", argv[0], time.Since(start).Round(time.Millisecond))
+		return ctx.Err()
+	case err != nil && !errors.As(err, &exitErr):
+		resp.ErrorMessage = err.Error()
+	}
+	log.Printf("TerminalService: Exec %q finished in %v with exit code %d.", argv[0], time.Since(start).Round(time.Millisecond), resp.ExitCode)
+
+	sendMu.Lock()
+	defer sendMu.Unlock()
+	return send(resp)
+}
+
+type execOutputWriter struct {
+	mu         *sync.Mutex
+	send       func(*pb.ExecResponse) error
+	outputType pb.TerminalResponse_OutputType
+}
+
+func (w *execOutputWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err := w.send(&pb.ExecResponse{OutputType: w.outputType, Data: p}); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"runtime"
+	"testing"
+
+	pb "control_grpc/gen/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestTerminalAccessInterceptor(t *testing.T) {
+	s := &server{allowTerminalAccess: false}
+	called := false
+	handler := func(ctx context.Context, req any) (any, error) {
+		called = true
+		return nil, nil
+	}
+
+	info := &grpc.UnaryServerInfo{FullMethod: "/control_grpc.TerminalService/ListSessions"}
+	if _, err := s.terminalAccessUnaryInterceptor(context.Background(), nil, info, handler); status.Code(err) != codes.PermissionDenied || called {
+		t.Errorf("ListSessions with terminal access disabled: expected PermissionDenied without calling the handler, got %v (called %t)", err, called)
+	}
+	streamInfo := &grpc.StreamServerInfo{FullMethod: "/control_grpc.TerminalService/Exec"}
+	streamHandler := func(srv any, ss grpc.ServerStream) error {
+		called = true
+		return nil
+	}
+	if err := s.terminalAccessStreamInterceptor(nil, nil, streamInfo, streamHandler); status.Code(err) != codes.PermissionDenied || called {
+		t.Errorf("Exec with terminal access disabled: expected PermissionDenied without calling the handler, got %v (called %t)", err, called)
+	}
+
+	info.FullMethod = "/control_grpc.SessionService/GetSessionInfo"
+	if _, err := s.terminalAccessUnaryInterceptor(context.Background(), nil, info, handler); err != nil || !called {
+		t.Errorf("GetSessionInfo: expected other services to pass, got %v (called %t)", err, called)
+	}
+}
+
+func TestRunExec(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses sh")
+	}
+	run := func(req *pb.ExecRequest) (stdout, stderr string, last *pb.ExecResponse, err error) {
+		err = runExec(context.Background(), req, func(resp *pb.ExecResponse) error {
+			switch {
+			case resp.GetExited():
+				last = resp
+			case resp.GetOutputType() == pb.TerminalResponse_STDERR:
+				stderr += string(resp.GetData())
+			default:
+				stdout += string(resp.GetData())
+			}
+			return nil
+		})
+		return
+	}
+
+	stdout, stderr, last, err := run(&pb.ExecRequest{
+		Argv:        []string{"sh", "-c", `read line; echo "$line $GREETING"; echo oops >&2; exit 3`},
+		Environment: map[string]string{"GREETING": "world"},
+		Stdin:       []byte("hello\n"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stdout != "hello world\n" || stderr != "oops\n" {
+		t.Errorf("got stdout %q, stderr %q", stdout, stderr)
+	}
+	if last == nil || last.GetExitCode() != 3 || last.GetTimedOut() {
+		t.Errorf("expected exit code 3, got %v", last)
+	}
+
+	_, _, last, err = run(&pb.ExecRequest{Argv: []string{"sleep", "5"}, TimeoutMs: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if last == nil || !last.GetTimedOut() || last.GetExitCode() != -1 {
+		t.Errorf("expected a timeout, got %v", last)
+	}
+
+	if _, _, _, err := run(&pb.ExecRequest{Argv: []string{"no-such-command-for-exec-test"}}); status.Code(err) != codes.NotFound {
+		t.Errorf("expected NotFound for a missing program, got %v", err)
+	}
+	if _, _, _, err := run(&pb.ExecRequest{}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("expected InvalidArgument without argv, got %v", err)
+	}
+}
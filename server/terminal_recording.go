@@ -0,0 +1,263 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	pb "control_grpc/gen/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	terminalRecordingExt = ".cast"
+	// terminalRecordingChunkSize is how much of a recording GetRecording sends per message.
+	terminalRecordingChunkSize = 64 * 1024
+)
+
+// asciicastHeader is the first line of an asciicast v2 file.
+type asciicastHeader struct {
+	Version   int               `json:"version"`
+	Width     int               `json:"width"`
+	Height    int               `json:"height"`
+	Timestamp int64             `json:"timestamp"`
+	Title     string            `json:"title,omitempty"`
+	Env       map[string]string `json:"env,omitempty"`
+}
+
+// terminalRecorder writes a terminal session to an asciicast v2 file, which asciinema
+// and the client's player can replay: a JSON header line, then one
+// [seconds, code, data] line per event. Output is "o", input "i", a resize "r" and a
+// marker "m". A nil recorder records nothing.
+type terminalRecorder struct {
+	mu    sync.Mutex
+	file  *os.File
+	start time.Time
+	// pending holds an incomplete UTF-8 sequence at the end of the last chunk of each
+	// event code, as event data must be valid UTF-8.
+	pending map[string][]byte
+	failed  bool
+}
+
+func newTerminalRecorder(path string, header asciicastHeader, start time.Time) (*terminalRecorder, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return nil, err
+	}
+	line, err := json.Marshal(header)
+	if err == nil {
+		_, err = file.Write(append(line, '\n'))
+	}
+	if err != nil {
+		file.Close()
+		os.Remove(path)
+		return nil, err
+	}
+	return &terminalRecorder{file: file, start: start, pending: make(map[string][]byte)}, nil
+}
+
+func (r *terminalRecorder) output(data []byte) { r.event("o", data) }
+func (r *terminalRecorder) input(data []byte)  { r.event("i", data) }
+
+func (r *terminalRecorder) resize(cols, rows int) {
+	r.event("r", []byte(fmt.Sprintf("%dx%d", cols, rows)))
+}
+
+func (r *terminalRecorder) marker(label string) {
+	r.event("m", []byte(label))
+}
+
+func (r *terminalRecorder) event(code string, data []byte) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.file == nil || r.failed {
+		return
+	}
+	if pending := r.pending[code]; len(pending) > 0 {
+		data = append(pending, data...)
+	}
+	keep := incompleteUTF8Suffix(data)
+	r.pending[code] = append([]byte(nil), data[len(data)-keep:]...)
+	if data = data[:len(data)-keep]; len(data) > 0 {
+		r.writeEventLocked(code, data)
+	}
+}
+
+func (r *terminalRecorder) writeEventLocked(code string, data []byte) {
+	elapsed := math.Round(time.Since(r.start).Seconds()*1e6) / 1e6
+	var line bytes.Buffer
+	enc := json.NewEncoder(&line)
+	enc.SetEscapeHTML(false)
+	// Invalid UTF-8 is written as U+FFFD.
+	if err := enc.Encode([]any{elapsed, code, string(data)}); err != nil {
+		return
+	}
+	if _, err := r.file.Write(line.Bytes()); err != nil {
+		log.Printf("TerminalService: Error writing recording %s, recording stopped: %v", r.file.Name(), err)
+		r.failed = true
+	}
+}
+
+// close writes any pending partial characters and closes the file.
+func (r *terminalRecorder) close() {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.file == nil {
+		return
+	}
+	if !r.failed {
+		for _, code := range []string{"o", "i"} {
+			if pending := r.pending[code]; len(pending) > 0 {
+				r.writeEventLocked(code, pending)
+			}
+		}
+	}
+	if err := r.file.Close(); err != nil {
+		log.Printf("TerminalService: Error closing recording %s: %v", r.file.Name(), err)
+	}
+	r.file = nil
+}
+
+// incompleteUTF8Suffix returns the length of a UTF-8 sequence cut off at the end of b.
+func incompleteUTF8Suffix(b []byte) int {
+	for i := 1; i <= 3 && i <= len(b); i++ {
+		c := b[len(b)-i]
+		if c&0xC0 == 0x80 {
+			continue // continuation byte
+		}
+		need := 1
+		switch {
+		case c >= 0xF0:
+			need = 4
+		case c >= 0xE0:
+			need = 3
+		case c >= 0xC0:
+			need = 2
+		}
+		if need > i {
+			return i
+		}
+		return 0
+	}
+	return 0
+}
+
+// terminalRecordingPath returns the path of a recording a client asked for by name,
+// which must be a file directly inside the recording directory.
+func (r *terminalRegistry) terminalRecordingPath(name string) (string, error) {
+	if r.recordDir == "" {
+		return "", status.Errorf(codes.FailedPrecondition, "Terminal session recording is disabled on this host.")
+	}
+	if name == "" || name != filepath.Base(name) || !strings.HasSuffix(name, terminalRecordingExt) || strings.ContainsAny(name, `/\`) {
+		return "", status.Errorf(codes.InvalidArgument, "Invalid recording name: %q", name)
+	}
+	return filepath.Join(r.recordDir, name), nil
+}
+
+func (s *server) ListRecordings(ctx context.Context, req *pb.ListTerminalRecordingsRequest) (*pb.ListTerminalRecordingsResponse, error) {
+	dir := s.terminals.recordDir
+	if dir == "" {
+		return nil, status.Errorf(codes.FailedPrecondition, "Terminal session recording is disabled on this host.")
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		log.Printf("ListRecordings: Error reading %s: %v", dir, err)
+		return nil, status.Errorf(codes.Internal, "Failed to read recording directory: %v", err)
+	}
+
+	active := s.terminals.activeRecordings()
+	var recordings []*pb.TerminalRecordingInfo
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), terminalRecordingExt) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		recording := &pb.TerminalRecordingInfo{
+			Name:         entry.Name(),
+			Size:         info.Size(),
+			ModifiedTime: info.ModTime().UnixNano(),
+			Active:       active[entry.Name()],
+		}
+		if header, err := readAsciicastHeader(filepath.Join(dir, entry.Name())); err == nil {
+			recording.Title = header.Title
+			recording.StartTime = time.Unix(header.Timestamp, 0).UnixNano()
+			recording.Cols = uint32(header.Width)
+			recording.Rows = uint32(header.Height)
+			recording.Shell = header.Env["SHELL"]
+		}
+		recordings = append(recordings, recording)
+	}
+	sort.Slice(recordings, func(i, j int) bool { return recordings[i].ModifiedTime > recordings[j].ModifiedTime })
+	log.Printf("ListRecordings request received: %d recording(s)", len(recordings))
+	return &pb.ListTerminalRecordingsResponse{Recordings: recordings}, nil
+}
+
+func readAsciicastHeader(path string) (asciicastHeader, error) {
+	var header asciicastHeader
+	file, err := os.Open(path)
+	if err != nil {
+		return header, err
+	}
+	defer file.Close()
+	line, err := bufio.NewReader(file).ReadBytes('\n')
+	if err != nil && err != io.EOF {
+		return header, err
+	}
+	err = json.Unmarshal(line, &header)
+	return header, err
+}
+
+// GetRecording streams a recording's file. A recording still being written is sent up
+// to its current end.
+func (s *server) GetRecording(req *pb.GetTerminalRecordingRequest, stream pb.TerminalService_GetRecordingServer) error {
+	path, err := s.terminals.terminalRecordingPath(req.GetName())
+	if err != nil {
+		return err
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return status.Errorf(codes.NotFound, "Recording not found: %s", req.GetName())
+		}
+		return status.Errorf(codes.Internal, "Failed to open recording: %v", err)
+	}
+	defer file.Close()
+
+	log.Printf("GetRecording request received: %s", req.GetName())
+	buf := make([]byte, terminalRecordingChunkSize)
+	for {
+		n, err := file.Read(buf)
+		if n > 0 {
+			if err := stream.Send(&pb.TerminalRecordingChunk{Data: buf[:n]}); err != nil {
+				return err
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return status.Errorf(codes.Internal, "Failed to read recording: %v", err)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestTerminalRecorder(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "session"+terminalRecordingExt)
+	rec, err := newTerminalRecorder(path, asciicastHeader{Version: 2, Width: 80, Height: 24, Title: "test"}, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// "é" is split across two chunks and must not be written as two broken characters.
+	rec.output([]byte("h\xc3"))
+	rec.output([]byte("\xa9llo <b>\r\n"))
+	rec.input([]byte("ls\r"))
+	rec.resize(100, 40)
+	rec.marker("client detached")
+	rec.close()
+	rec.output([]byte("after close"))
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	if !scanner.Scan() {
+		t.Fatal("recording has no header")
+	}
+	var header asciicastHeader
+	if err := json.Unmarshal(scanner.Bytes(), &header); err != nil || header.Version != 2 || header.Width != 80 || header.Title != "test" {
+		t.Fatalf("unexpected header %s (%v)", scanner.Text(), err)
+	}
+
+	want := [][2]string{{"o", "h"}, {"o", "éllo <b>\r\n"}, {"i", "ls\r"}, {"r", "100x40"}, {"m", "client detached"}}
+	var got [][2]string
+	for scanner.Scan() {
+		var event []any
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil || len(event) != 3 {
+			t.Fatalf("invalid event line %s (%v)", scanner.Text(), err)
+		}
+		if _, ok := event[0].(float64); !ok {
+			t.Errorf("event time is not a number: %s", scanner.Text())
+		}
+		code, _ := event[1].(string)
+		data, _ := event[2].(string)
+		got = append(got, [2]string{code, data})
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got events %q, want %q", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("event %d: got %q, want %q", i, got[i], want[i])
+		}
+	}
+}
+
+func TestTerminalRecordingPath(t *testing.T) {
+	r := newTerminalRegistry(0, nil, "")
+	if _, err := r.terminalRecordingPath("a.cast"); status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("with recording disabled: expected FailedPrecondition, got %v", err)
+	}
+
+	r.recordDir = t.TempDir()
+	for _, name := range []string{"", "../a.cast", "sub/a.cast", `sub\a.cast`, "a.txt", ".."} {
+		if _, err := r.terminalRecordingPath(name); status.Code(err) != codes.InvalidArgument {
+			t.Errorf("%q: expected InvalidArgument, got %v", name, err)
+		}
+	}
+	if path, err := r.terminalRecordingPath("20240101-120000-abcd.cast"); err != nil || filepath.Dir(path) != r.recordDir {
+		t.Errorf("valid name: got %q, %v", path, err)
+	}
+}
@@ -1,292 +1,202 @@
 package main
 
 import (
-	_ "embed"
+	"context"
 	"fmt"
 	"io"
 	"log"
-	"os"
-	"os/exec"
-	"path/filepath"
-	"regexp"
-	"runtime"
 	"strings"
-	"sync"
-	"unicode/utf8"
 
 	pb "control_grpc/gen/proto"
-	"github.com/iamacarpet/go-winpty"
+	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
 	"google.golang.org/grpc/status"
 )
 
-//go:embed winpty.dll
-var winptyDllEmbed []byte
+const terminalServicePrefix = "/control_grpc.TerminalService/"
 
-//go:embed winpty-agent.exe
-var winptyAgentEmbed []byte
-
-var (
-	winptyInitOnce sync.Once
-	winptyInitErr  error
-)
-
-func extractFileToPath(outputPath string, data []byte, perm os.FileMode) error {
-	log.Printf("INFO: Ensuring presence of %s by writing/overwriting...", outputPath)
-	err := os.WriteFile(outputPath, data, perm)
-	if err != nil {
-		return fmt.Errorf("failed to write embedded file to %s: %w", outputPath, err)
+// terminalAccessUnaryInterceptor and terminalAccessStreamInterceptor reject every
+// TerminalService call when the host has disabled terminal access.
+func (s *server) terminalAccessUnaryInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	if err := s.checkTerminalEnabled(info.FullMethod); err != nil {
+		return nil, err
 	}
-	log.Printf("INFO: Successfully wrote/updated %s (%d bytes).", outputPath, len(data))
-	return nil
+	return handler(ctx, req)
 }
 
-func ensureWinptyBinariesAreExtracted() error {
-	winptyInitOnce.Do(func() {
-		log.Println("INFO: Performing one-time extraction check for WinPTY binaries...")
-		exePath, err := os.Executable()
-		if err != nil {
-			winptyInitErr = fmt.Errorf("failed to get executable path: %w", err)
-			return
-		}
-		exeDir := filepath.Dir(exePath)
-		log.Printf("INFO: Current executable directory for WinPTY extraction: %s", exeDir)
-
-		dllPath := filepath.Join(exeDir, "winpty.dll")
-		agentPath := filepath.Join(exeDir, "winpty-agent.exe")
-
-		if len(winptyDllEmbed) == 0 {
-			log.Println("WARN: Embedded winpty.dll data is empty. Cannot extract.")
-		} else {
-			if err := extractFileToPath(dllPath, winptyDllEmbed, 0644); err != nil {
-				winptyInitErr = fmt.Errorf("failed to extract winpty.dll: %w", err)
-				return
-			}
-		}
-
-		if len(winptyAgentEmbed) == 0 {
-			log.Println("WARN: Embedded winpty-agent.exe data is empty. Cannot extract.")
-		} else {
-			if err := extractFileToPath(agentPath, winptyAgentEmbed, 0755); err != nil {
-				winptyInitErr = fmt.Errorf("failed to extract winpty-agent.exe: %w", err)
-				return
-			}
-		}
-		log.Println("WARN: winpty-agent.exe embedding and extraction is temporarily disabled.")
-		if winptyInitErr == nil {
-			log.Println("INFO: WinPTY binaries successfully checked/extracted.")
-		}
-	})
-	return winptyInitErr
+func (s *server) terminalAccessStreamInterceptor(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if err := s.checkTerminalEnabled(info.FullMethod); err != nil {
+		return err
+	}
+	return handler(srv, ss)
 }
 
-var ansiEscapePattern = regexp.MustCompile(`(\x1b\[\??[0-9;]*[a-zA-Z])|(\x1b\][^\a]*\a)|\x07`)
-
-func stripANSI(str string) string {
-	return ansiEscapePattern.ReplaceAllString(str, "")
+func (s *server) checkTerminalEnabled(method string) error {
+	if !strings.HasPrefix(method, terminalServicePrefix) || s.allowTerminalAccess {
+		return nil
+	}
+	log.Printf("%s request rejected: terminal access is disabled by the host.", strings.TrimPrefix(method, terminalServicePrefix))
+	return status.Errorf(codes.PermissionDenied, "Terminal access has been disabled by the host.")
 }
 
+// CommandStream attaches a client stream to a terminal session. The first request
+// chooses the session: its session_id reattaches to a running one, otherwise a new one
+// is started. Closing the stream detaches; stop_session ends the shell.
 func (s *server) CommandStream(stream pb.TerminalService_CommandStreamServer) error {
-	log.Println("TerminalService (WinPTY): Client connected to CommandStream.")
-
-	if err := ensureWinptyBinariesAreExtracted(); err != nil {
-		log.Printf("TerminalService (WinPTY): Critical error ensuring WinPTY binaries: %v", err)
-		return status.Errorf(codes.FailedPrecondition, "failed to prepare WinPTY environment: %v", err)
-	}
+	log.Println("TerminalService: Client connected to CommandStream.")
 
 	ctx := stream.Context()
 
-	initialCwd, err := os.Getwd()
+	first, err := stream.Recv()
 	if err != nil {
-		homeDir, homeErr := os.UserHomeDir()
-		if homeErr != nil {
-			log.Printf("TerminalService (WinPTY): Error getting CWD (%v) and home dir (%v). Using OS default.", err, homeErr)
-			if runtime.GOOS == "windows" {
-				initialCwd = "C:\\"
-			} else {
-				initialCwd = "/"
-			}
-		} else {
-			initialCwd = homeDir
+		if err == io.EOF {
+			return nil
 		}
+		return err
 	}
-	log.Printf("TerminalService (WinPTY): Initializing WinPTY session in directory: %s", initialCwd)
 
-	var shellCmdArgs []string
-	var shellPath string
-
-	if runtime.GOOS == "windows" {
-		psPath, errPs := exec.LookPath("powershell.exe")
-		if errPs == nil {
-			shellPath = psPath
-			shellCmdArgs = []string{"-NoProfile"}
-			log.Printf("TerminalService (WinPTY): Using PowerShell at %s with args: %v", shellPath, shellCmdArgs)
-		} else {
-			cmdPath, errCmd := exec.LookPath("cmd.exe")
-			if errCmd == nil {
-				shellPath = cmdPath
-				shellCmdArgs = []string{}
-				log.Printf("TerminalService (WinPTY): PowerShell not found, falling back to CMD at %s", shellPath)
-			} else {
-				log.Printf("TerminalService (WinPTY): Error - Neither PowerShell nor CMD found. PowerShell err: %v, CMD err: %v", errPs, errCmd)
-				return status.Errorf(codes.FailedPrecondition, "no suitable shell found on Windows server (PowerShell or CMD)")
-			}
-		}
+	var session *terminalSession
+	if id := first.GetSessionId(); id != "" {
+		session, err = s.terminals.get(id)
 	} else {
-		log.Printf("TerminalService (WinPTY): Error - go-winpty is intended for Windows. Current OS: %s", runtime.GOOS)
-		return status.Errorf(codes.FailedPrecondition, "go-winpty is for Windows only, server OS is %s", runtime.GOOS)
-	}
-
-	var fullCmdLineBuilder strings.Builder
-	fullCmdLineBuilder.WriteString(shellPath)
-	for _, arg := range shellCmdArgs {
-		fullCmdLineBuilder.WriteString(" ")
-		if strings.Contains(arg, " ") {
-			fullCmdLineBuilder.WriteString("\"")
-			fullCmdLineBuilder.WriteString(arg)
-			fullCmdLineBuilder.WriteString("\"")
-		} else {
-			fullCmdLineBuilder.WriteString(arg)
-		}
+		session, err = s.terminals.create(first)
 	}
-	fullCmdLine := fullCmdLineBuilder.String()
-
-	log.Printf("TerminalService (WinPTY): Preparing to start WinPTY with command line: '%s'", fullCmdLine)
-
-	ptyOptions := &winpty.Options{
-		Command: fullCmdLine,
-		Dir:     initialCwd,
-		Env:     os.Environ(),
-		Flags: winpty.WINPTY_SPAWN_FLAG_AUTO_SHUTDOWN |
-			winpty.WINPTY_FLAG_ALLOW_CURPROC_DESKTOP_CREATION,
-		InitialCols: 120,
-		InitialRows: 30,
+	if err != nil {
+		return err
 	}
-
-	pty, err := winpty.OpenWithOptions(*ptyOptions)
+	attachment, replay, err := session.attach(s.terminals)
 	if err != nil {
-		log.Printf("TerminalService (WinPTY): Error starting WinPTY with command '%s': %v. Ensure winpty.dll and winpty-agent.exe are accessible in the executable's directory.", fullCmdLine, err)
-		return status.Errorf(codes.Internal, "failed to start WinPTY: %v. Check server logs for extraction details.", err)
+		return err
 	}
-	log.Printf("TerminalService (WinPTY): WinPTY started successfully for shell: %s", shellPath)
-
+	log.Printf("TerminalService: Client attached to session %s (%q), replaying %d bytes.", session.id, session.name, len(replay))
+	client := "unknown address"
+	if p, ok := peer.FromContext(ctx); ok {
+		client = p.Addr.String()
+	}
+	session.recorder.marker("client attached from " + client)
 	defer func() {
-		log.Println("TerminalService (WinPTY): Cleaning up WinPTY session...")
-		if pty != nil {
-			pty.StdIn.Close()
-			pty.Close()
-			log.Println("TerminalService (WinPTY): pty.Close() called.")
-		}
-		log.Println("TerminalService (WinPTY): WinPTY session cleanup complete.")
+		session.detach(s.terminals, attachment)
+		session.recorder.marker("client detached from " + client)
 	}()
 
-	var ptyReadWg sync.WaitGroup
-	ptyReadWg.Add(1)
+	if err := stream.Send(&pb.TerminalResponse{
+		SessionId:  session.id,
+		OutputType: pb.TerminalResponse_SYSTEM_MESSAGE,
+		Session:    session.info(),
+	}); err != nil {
+		return err
+	}
+	if len(replay) > 0 {
+		if err := stream.Send(&pb.TerminalResponse{
+			SessionId:  session.id,
+			OutputType: pb.TerminalResponse_STDOUT,
+			Output:     replay,
+		}); err != nil {
+			return err
+		}
+	}
 
+	// Requests are applied by a separate goroutine so the stream is only ever sent on
+	// from this one.
+	inputErrs := make(chan error, 1)
 	go func() {
-		defer ptyReadWg.Done()
-		log.Println("TerminalService (WinPTY): PTY stdout read goroutine started.")
-		buf := make([]byte, 8192)
+		req := first
+		if len(first.GetInput()) == 0 && first.GetResize() == nil && first.GetCommand() == "" && !first.GetStopSession() {
+			// The first request only selected the session.
+			req = nil
+		}
 		for {
-			select {
-			case <-ctx.Done():
-				log.Printf("TerminalService (WinPTY): PTY stdout read goroutine: stream context done: %v. Exiting.", ctx.Err())
-				return
-			default:
-			}
-
-			n, readErr := pty.StdOut.Read(buf)
-			if n > 0 {
-				outputData := buf[:n]
-				var outputToSend string
-
-				if utf8.Valid(outputData) {
-					rawString := string(outputData)
-					outputToSend = stripANSI(rawString)
-				} else {
-					outputToSend = "\n"
-					log.Printf("TerminalService (WinPTY): Invalid UTF-8 detected in PTY output chunk. Sending newline. Original (hex): %x", outputData)
-				}
-
-				if sendErr := stream.Send(&pb.TerminalResponse{
-					OutputType:   pb.TerminalResponse_STDOUT,
-					OutputLine:   outputToSend,
-					CommandEnded: false,
-				}); sendErr != nil {
-					log.Printf("TerminalService (WinPTY): Error sending PTY stdout to client: %v. Exiting read goroutine.", sendErr)
+			if req != nil {
+				if err := applyTerminalRequest(session, req); err != nil {
+					inputErrs <- err
 					return
 				}
 			}
-
-			if readErr != nil {
-				finalMsg := "--- PTY session ended (stdout) ---"
-				if readErr == io.EOF {
-					log.Println("TerminalService (WinPTY): EOF reading from PTY stdout. Shell process likely exited.")
-				} else {
-					if ctx.Err() == nil {
-						log.Printf("TerminalService (WinPTY): Error reading from PTY stdout: %v", readErr)
-						finalMsg = fmt.Sprintf("--- PTY read error: %v ---", readErr)
-					} else {
-						log.Printf("TerminalService (WinPTY): PTY stdout read error after context cancellation: %v", readErr)
-						finalMsg = fmt.Sprintf("--- PTY session ended (context done, stdout read err: %v) ---", ctx.Err())
-					}
-				}
-				_ = stream.Send(&pb.TerminalResponse{OutputLine: finalMsg, CommandEnded: true})
+			next, err := stream.Recv()
+			if err != nil {
+				inputErrs <- err
 				return
 			}
+			req = next
 		}
 	}()
 
 	for {
 		select {
-		case <-ctx.Done():
-			log.Printf("TerminalService (WinPTY): Main loop: stream context done: %v. Waiting for PTY read goroutine to finish.", ctx.Err())
-			ptyReadWg.Wait()
-			log.Println("TerminalService (WinPTY): Main loop: PTY read goroutine finished. Exiting CommandStream.")
-			return ctx.Err()
-		default:
-		}
-
-		req, err := stream.Recv()
-		if err != nil {
+		case chunk, ok := <-attachment.output:
+			if !ok {
+				// CommandEnded tells the client the session is gone, rather than only
+				// detached from this stream.
+				log.Printf("TerminalService: Stream for session %s finished: %s", session.id, attachment.reason)
+				return stream.Send(&pb.TerminalResponse{
+					SessionId:    session.id,
+					OutputType:   pb.TerminalResponse_SYSTEM_MESSAGE,
+					OutputLine:   attachment.reason,
+					CommandEnded: attachment.ended,
+				})
+			}
+			if err := stream.Send(&pb.TerminalResponse{
+				SessionId:  session.id,
+				OutputType: pb.TerminalResponse_STDOUT,
+				Output:     chunk,
+			}); err != nil {
+				log.Printf("TerminalService: Error sending output of session %s: %v", session.id, err)
+				return err
+			}
+		case err := <-inputErrs:
 			if err == io.EOF {
-				log.Println("TerminalService (WinPTY): Client closed send stream (EOF). PTY session will continue until explicitly closed or shell exits.")
-			} else {
-				st, ok := status.FromError(err)
-				if ok && (st.Code() == codes.Canceled || st.Code() == codes.Unavailable) {
-					log.Printf("TerminalService (WinPTY): Client disconnected or stream unavailable: %v", err)
-				} else {
-					log.Printf("TerminalService (WinPTY): Error receiving input from client: %v", err)
-				}
+				log.Printf("TerminalService: Client stopped sending to session %s (EOF). Output stream remains active.", session.id)
+				inputErrs = nil
+				continue
 			}
-
-			if err != io.EOF {
-				log.Printf("TerminalService (WinPTY): Non-EOF error on Recv: %v. Terminating session.", err)
-				ptyReadWg.Wait()
+			st, ok := status.FromError(err)
+			if ok && (st.Code() == codes.Canceled || st.Code() == codes.Unavailable) {
+				log.Printf("TerminalService: Client of session %s disconnected: %v", session.id, err)
 				return err
 			}
-
-			log.Println("TerminalService (WinPTY): Client stopped sending (EOF on Recv). PTY output stream remains active.")
-			<-ctx.Done()
-			log.Println("TerminalService (WinPTY): Context cancelled after client Recv EOF. Terminating session.")
-			ptyReadWg.Wait()
-			return ctx.Err()
-		}
-
-		inputFromClient := req.GetCommand()
-		inputBytes := []byte(inputFromClient + "\r\n")
-
-		if _, writeErr := pty.StdIn.Write(inputBytes); writeErr != nil {
-			log.Printf("TerminalService (WinPTY): Error writing to PTY stdin: %v", writeErr)
+			log.Printf("TerminalService: Error on session %s: %v", session.id, err)
 			_ = stream.Send(&pb.TerminalResponse{
+				SessionId:    session.id,
 				OutputType:   pb.TerminalResponse_ERROR_MESSAGE,
-				OutputLine:   fmt.Sprintf("--- Error writing to PTY: %v ---", writeErr),
+				OutputLine:   fmt.Sprintf("--- Error: %v ---", err),
 				CommandEnded: true,
 			})
-			ptyReadWg.Wait()
-			return status.Errorf(codes.Internal, "failed to write to PTY stdin: %v", writeErr)
+			return err
+		case <-ctx.Done():
+			log.Printf("TerminalService: Stream for session %s done: %v. Detaching.", session.id, ctx.Err())
+			return ctx.Err()
+		}
+	}
+}
+
+// applyTerminalRequest resizes, types into or stops a session as the request asks.
+func applyTerminalRequest(session *terminalSession, req *pb.TerminalRequest) error {
+	if req.GetStopSession() {
+		session.stop()
+		return nil
+	}
+	if size := req.GetResize(); size != nil {
+		if err := session.resize(int(size.GetCols()), int(size.GetRows())); err != nil {
+			log.Printf("TerminalService: Error resizing session %s to %dx%d: %v", session.id, size.GetCols(), size.GetRows(), err)
 		}
 	}
+
+	var input []byte
+	switch {
+	case len(req.GetInput()) > 0:
+		input = req.GetInput()
+	case req.GetResize() == nil:
+		input = []byte(req.GetCommand() + ptyEnterKey)
+	}
+	if len(input) == 0 {
+		return nil
+	}
+	if _, err := session.pty.Write(input); err != nil {
+		return status.Errorf(codes.Internal, "failed to write to PTY stdin: %v", err)
+	}
+	session.recorder.input(input)
+	return nil
 }
 
 func firstNBytes(data []byte, n int) []byte {
@@ -0,0 +1,368 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	pb "control_grpc/gen/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// terminalScrollbackSize bounds the output kept per session for replay on reattach.
+	terminalScrollbackSize = 256 * 1024
+	// terminalOutputQueue is how many output chunks may wait for a slow client before
+	// it is detached. It can reattach and catch up from the scrollback.
+	terminalOutputQueue = 256
+)
+
+// terminalSession is a PTY owned by the host rather than by a client stream. Clients
+// attach to it, one at a time, and it keeps running while none is attached until the
+// registry's grace period expires.
+type terminalSession struct {
+	id      string
+	name    string
+	pty     ptySession
+	created time.Time
+	exited  chan struct{} // closed when the shell's output has ended
+	// recorder writes the session to recordingName in the registry's recordDir, when
+	// recording is enabled.
+	recorder      *terminalRecorder
+	recordingName string
+
+	mu         sync.Mutex
+	scrollback []byte
+	cols, rows int
+	attachment *terminalAttachment
+	detachedAt time.Time
+	graceTimer *time.Timer
+}
+
+// terminalAttachment is a client stream's view of a session's output.
+type terminalAttachment struct {
+	output chan []byte
+	// reason says why output was closed and ended whether it was because the shell
+	// exited. Both are set before the close.
+	reason string
+	ended  bool
+}
+
+type terminalRegistry struct {
+	mu       sync.Mutex
+	sessions map[string]*terminalSession
+	grace    time.Duration
+	shells   []terminalShell // offered to clients, the first is the default
+	// recordDir is where sessions are recorded as asciicast files. Empty disables
+	// recording.
+	recordDir string
+	created   int
+}
+
+func newTerminalRegistry(grace time.Duration, shells []terminalShell, recordDir string) *terminalRegistry {
+	return &terminalRegistry{sessions: make(map[string]*terminalSession), grace: grace, shells: shells, recordDir: recordDir}
+}
+
+func newTerminalSessionID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// create starts a new session with the shell, directory, environment and size asked for
+// in a client's first request.
+func (r *terminalRegistry) create(req *pb.TerminalRequest) (*terminalSession, error) {
+	shell, err := r.shell(req.GetShell())
+	if err != nil {
+		return nil, err
+	}
+	dir, err := terminalWorkingDirectory(req.GetWorkingDirectory())
+	if err != nil {
+		return nil, err
+	}
+	env, err := terminalEnvironment(req.GetEnvironment())
+	if err != nil {
+		return nil, err
+	}
+	id, err := newTerminalSessionID()
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "Failed to generate session ID: %v", err)
+	}
+	cols, rows := int(req.GetResize().GetCols()), int(req.GetResize().GetRows())
+	if cols <= 0 || rows <= 0 {
+		cols, rows = defaultTerminalCols, defaultTerminalRows
+	}
+	pty, err := startPTY(ptyOptions{shell: shell.path, args: shell.args, dir: dir, env: env, cols: cols, rows: rows})
+	if err != nil {
+		log.Printf("TerminalService: Error starting terminal session: %v", err)
+		return nil, status.Errorf(codes.FailedPrecondition, "Failed to start terminal session: %v", err)
+	}
+
+	r.mu.Lock()
+	r.created++
+	number := r.created
+	r.mu.Unlock()
+	name := req.GetSessionName()
+	if name == "" {
+		name = fmt.Sprintf("%s %d", filepath.Base(pty.Shell()), number)
+	}
+	session := &terminalSession{
+		id:      id,
+		name:    name,
+		pty:     pty,
+		created: time.Now(),
+		exited:  make(chan struct{}),
+		cols:    cols,
+		rows:    rows,
+	}
+	if r.recordDir != "" {
+		// A session that cannot be recorded is not started, so none goes unrecorded.
+		session.recordingName = session.created.Format("20060102-150405") + "-" + id + terminalRecordingExt
+		header := asciicastHeader{
+			Version:   2,
+			Width:     cols,
+			Height:    rows,
+			Timestamp: session.created.Unix(),
+			Title:     name,
+			Env:       map[string]string{"SHELL": pty.Shell(), "TERM": "xterm-256color"},
+		}
+		session.recorder, err = newTerminalRecorder(filepath.Join(r.recordDir, session.recordingName), header, session.created)
+		if err != nil {
+			pty.Close()
+			log.Printf("TerminalService: Error creating recording for session %s: %v", id, err)
+			return nil, status.Errorf(codes.FailedPrecondition, "Failed to start session recording: %v", err)
+		}
+	}
+
+	r.mu.Lock()
+	r.sessions[id] = session
+	r.mu.Unlock()
+
+	log.Printf("TerminalService: Started session %s (%q) running %s at %dx%d in %q with %d extra environment variable(s)", id, name, pty.Shell(), cols, rows, dir, len(env))
+	go session.pump(r)
+	return session, nil
+}
+
+func (r *terminalRegistry) get(id string) (*terminalSession, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	session, ok := r.sessions[id]
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "Terminal session %s not found. It may have ended.", id)
+	}
+	return session, nil
+}
+
+func (r *terminalRegistry) remove(session *terminalSession) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.sessions[session.id] == session {
+		delete(r.sessions, session.id)
+	}
+}
+
+func (r *terminalRegistry) list() []*pb.TerminalSessionInfo {
+	r.mu.Lock()
+	sessions := make([]*terminalSession, 0, len(r.sessions))
+	for _, session := range r.sessions {
+		sessions = append(sessions, session)
+	}
+	r.mu.Unlock()
+
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].created.Before(sessions[j].created) })
+	infos := make([]*pb.TerminalSessionInfo, len(sessions))
+	for i, session := range sessions {
+		infos[i] = session.info()
+	}
+	return infos
+}
+
+// activeRecordings returns the names of the recordings still being written.
+func (r *terminalRegistry) activeRecordings() map[string]bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	active := make(map[string]bool)
+	for _, session := range r.sessions {
+		if session.recordingName != "" {
+			active[session.recordingName] = true
+		}
+	}
+	return active
+}
+
+func (s *server) ListSessions(ctx context.Context, req *pb.ListTerminalSessionsRequest) (*pb.ListTerminalSessionsResponse, error) {
+	sessions := s.terminals.list()
+	log.Printf("ListSessions request received: %d terminal session(s)", len(sessions))
+	return &pb.ListTerminalSessionsResponse{Sessions: sessions}, nil
+}
+
+func (t *terminalSession) info() *pb.TerminalSessionInfo {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	info := &pb.TerminalSessionInfo{
+		SessionId:   t.id,
+		Name:        t.name,
+		Shell:       t.pty.Shell(),
+		CreatedTime: t.created.UnixNano(),
+		Attached:    t.attachment != nil,
+		Cols:        uint32(t.cols),
+		Rows:        uint32(t.rows),
+	}
+	if !t.detachedAt.IsZero() {
+		info.DetachedTime = t.detachedAt.UnixNano()
+	}
+	return info
+}
+
+// pump copies the shell's output into the scrollback and to the attached client until
+// the shell exits, then removes the session.
+func (t *terminalSession) pump(r *terminalRegistry) {
+	buf := make([]byte, 8192)
+	for {
+		n, err := t.pty.Read(buf)
+		if n > 0 {
+			chunk := append([]byte(nil), buf[:n]...)
+			t.recorder.output(chunk)
+			t.mu.Lock()
+			t.scrollback = appendScrollback(t.scrollback, chunk, terminalScrollbackSize)
+			if a := t.attachment; a != nil {
+				select {
+				case a.output <- chunk:
+				default:
+					log.Printf("TerminalService: Client of session %s is not keeping up with output. Detaching it.", t.id)
+					t.detachLocked(a, "--- Detached: client could not keep up with output. Reattach to continue. ---")
+					t.startGraceLocked(r)
+				}
+			}
+			t.mu.Unlock()
+		}
+		if err != nil {
+			log.Printf("TerminalService: Session %s output ended: %v", t.id, err)
+			t.mu.Lock()
+			if t.graceTimer != nil {
+				t.graceTimer.Stop()
+			}
+			if a := t.attachment; a != nil {
+				t.attachment = nil
+				a.reason = "--- PTY session ended ---"
+				a.ended = true
+				close(a.output)
+			}
+			t.mu.Unlock()
+			close(t.exited)
+			r.remove(t)
+			t.pty.Close()
+			t.recorder.close()
+			return
+		}
+	}
+}
+
+// appendScrollback appends chunk to scrollback, dropping the oldest output beyond
+// limit. The kept output starts at a line boundary when one is near, so the replay does
+// not begin in the middle of an escape sequence.
+func appendScrollback(scrollback, chunk []byte, limit int) []byte {
+	scrollback = append(scrollback, chunk...)
+	if len(scrollback) <= limit {
+		return scrollback
+	}
+	trimmed := scrollback[len(scrollback)-limit:]
+	if i := bytes.IndexByte(trimmed, '\n'); i >= 0 && i < 4096 {
+		trimmed = trimmed[i+1:]
+	}
+	return append(make([]byte, 0, limit), trimmed...)
+}
+
+// attach makes a client stream the session's only consumer, detaching any previous one,
+// and returns the scrollback to replay.
+func (t *terminalSession) attach(r *terminalRegistry) (*terminalAttachment, []byte, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	select {
+	case <-t.exited:
+		return nil, nil, status.Errorf(codes.NotFound, "Terminal session %s has ended.", t.id)
+	default:
+	}
+	if t.attachment != nil {
+		log.Printf("TerminalService: Session %s is being attached by another client. Detaching the previous one.", t.id)
+		t.detachLocked(t.attachment, "--- Detached: session was attached by another client. ---")
+	}
+	if t.graceTimer != nil {
+		t.graceTimer.Stop()
+		t.graceTimer = nil
+	}
+	a := &terminalAttachment{output: make(chan []byte, terminalOutputQueue)}
+	t.attachment = a
+	return a, append([]byte(nil), t.scrollback...), nil
+}
+
+// detach ends a client's attachment, if it is still the current one.
+func (t *terminalSession) detach(r *terminalRegistry, a *terminalAttachment) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.attachment == a {
+		t.detachLocked(a, "")
+		t.startGraceLocked(r)
+	}
+}
+
+func (t *terminalSession) detachLocked(a *terminalAttachment, reason string) {
+	t.attachment = nil
+	a.reason = reason
+	close(a.output)
+	t.detachedAt = time.Now()
+}
+
+// startGraceLocked ends the detached session unless a client reattaches within the
+// registry's grace period.
+func (t *terminalSession) startGraceLocked(r *terminalRegistry) {
+	select {
+	case <-t.exited:
+		return
+	default:
+	}
+	if r.grace <= 0 {
+		log.Printf("TerminalService: Session %s detached. Ending it, no grace period is configured.", t.id)
+		go t.pty.Close()
+		return
+	}
+	log.Printf("TerminalService: Session %s detached. It will end in %v unless reattached.", t.id, r.grace)
+	detachedAt := t.detachedAt
+	t.graceTimer = time.AfterFunc(r.grace, func() {
+		t.mu.Lock()
+		// A client may have reattached and detached again since this timer was set.
+		expired := t.attachment == nil && t.detachedAt.Equal(detachedAt)
+		t.mu.Unlock()
+		if expired {
+			log.Printf("TerminalService: Session %s was not reattached within %v. Ending it.", t.id, r.grace)
+			t.pty.Close()
+		}
+	})
+}
+
+func (t *terminalSession) resize(cols, rows int) error {
+	if err := t.pty.Resize(cols, rows); err != nil {
+		return err
+	}
+	t.recorder.resize(cols, rows)
+	t.mu.Lock()
+	t.cols, t.rows = cols, rows
+	t.mu.Unlock()
+	return nil
+}
+
+// stop ends the shell. The session is removed once its output has drained.
+func (t *terminalSession) stop() {
+	log.Printf("TerminalService: Stopping session %s at the client's request.", t.id)
+	t.pty.Close()
+}
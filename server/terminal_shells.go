@@ -0,0 +1,174 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	pb "control_grpc/gen/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// terminalShell is a shell the host lets clients start in a terminal session.
+type terminalShell struct {
+	id   string
+	name string
+	path string
+	args []string
+}
+
+// shellCandidate is a well-known shell and the places to look for it: program names
+// searched in PATH or absolute paths, in order.
+type shellCandidate struct {
+	id    string
+	name  string
+	paths []string
+	args  []string
+}
+
+func (c shellCandidate) find() (string, bool) {
+	for _, p := range c.paths {
+		if p == "" {
+			continue
+		}
+		if path, err := exec.LookPath(p); err == nil {
+			return path, true
+		}
+	}
+	return "", false
+}
+
+// parseTerminalShells resolves the -terminalShells flag: a comma separated list of shell
+// IDs from knownShells, or id=path entries for other programs. An empty spec offers
+// every known shell found on this host. The first shell is the default.
+func parseTerminalShells(spec string) ([]terminalShell, error) {
+	candidates := knownShells()
+	var shells []terminalShell
+	if strings.TrimSpace(spec) == "" {
+		for _, c := range candidates {
+			if path, ok := c.find(); ok {
+				shells = append(shells, terminalShell{id: c.id, name: c.name, path: path, args: c.args})
+			}
+		}
+		return shells, nil
+	}
+
+	seen := make(map[string]bool)
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if id, program, ok := strings.Cut(entry, "="); ok {
+			id, program = strings.TrimSpace(id), strings.TrimSpace(program)
+			if id == "" || program == "" {
+				return nil, fmt.Errorf("invalid shell entry %q, expected id=path", entry)
+			}
+			path, err := exec.LookPath(program)
+			if err != nil {
+				return nil, fmt.Errorf("shell %q: %w", id, err)
+			}
+			if seen[id] {
+				return nil, fmt.Errorf("shell %q is listed more than once", id)
+			}
+			seen[id] = true
+			shells = append(shells, terminalShell{id: id, name: filepath.Base(path), path: path})
+			continue
+		}
+
+		var candidate *shellCandidate
+		for i := range candidates {
+			if candidates[i].id == entry {
+				candidate = &candidates[i]
+				break
+			}
+		}
+		if candidate == nil {
+			ids := make([]string, len(candidates))
+			for i, c := range candidates {
+				ids[i] = c.id
+			}
+			return nil, fmt.Errorf("unknown shell %q, expected one of [%s] or id=path", entry, strings.Join(ids, ", "))
+		}
+		if seen[entry] {
+			return nil, fmt.Errorf("shell %q is listed more than once", entry)
+		}
+		seen[entry] = true
+		path, ok := candidate.find()
+		if !ok {
+			log.Printf("WARN: Shell %q is not installed on this host. It will not be offered.", entry)
+			continue
+		}
+		shells = append(shells, terminalShell{id: candidate.id, name: candidate.name, path: path, args: candidate.args})
+	}
+	return shells, nil
+}
+
+// shell returns the shell with the given ID, or the default one for an empty ID.
+func (r *terminalRegistry) shell(id string) (terminalShell, error) {
+	if len(r.shells) == 0 {
+		return terminalShell{}, status.Errorf(codes.FailedPrecondition, "No terminal shells are available on this host.")
+	}
+	if id == "" {
+		return r.shells[0], nil
+	}
+	for _, shell := range r.shells {
+		if shell.id == id {
+			return shell, nil
+		}
+	}
+	return terminalShell{}, status.Errorf(codes.InvalidArgument, "Shell %q is not offered by this host.", id)
+}
+
+func (r *terminalRegistry) shellInfos() []*pb.TerminalShell {
+	infos := make([]*pb.TerminalShell, len(r.shells))
+	for i, shell := range r.shells {
+		infos[i] = &pb.TerminalShell{Id: shell.id, Name: shell.name, Path: shell.path, IsDefault: i == 0}
+	}
+	return infos
+}
+
+// terminalWorkingDirectory checks a directory requested by the client. An empty one
+// keeps the platform default.
+func terminalWorkingDirectory(dir string) (string, error) {
+	if dir == "" {
+		return "", nil
+	}
+	if !filepath.IsAbs(dir) {
+		return "", status.Errorf(codes.InvalidArgument, "Working directory must be an absolute path: %s", dir)
+	}
+	dir = filepath.Clean(dir)
+	info, err := os.Stat(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", status.Errorf(codes.NotFound, "Working directory not found: %s", dir)
+		}
+		return "", status.Errorf(codes.Internal, "Failed to access working directory: %v", err)
+	}
+	if !info.IsDir() {
+		return "", status.Errorf(codes.InvalidArgument, "Working directory is not a directory: %s", dir)
+	}
+	return dir, nil
+}
+
+// terminalEnvironment turns the variables requested by the client into KEY=VALUE pairs,
+// sorted by name so sessions start the same way each time.
+func terminalEnvironment(vars map[string]string) ([]string, error) {
+	env := make([]string, 0, len(vars))
+	for key, value := range vars {
+		if key == "" || strings.ContainsAny(key, "=\x00") {
+			return nil, status.Errorf(codes.InvalidArgument, "Invalid environment variable name: %q", key)
+		}
+		if strings.ContainsRune(value, 0) {
+			return nil, status.Errorf(codes.InvalidArgument, "Environment variable %s contains a NUL character.", key)
+		}
+		env = append(env, key+"="+value)
+	}
+	sort.Strings(env)
+	return env, nil
+}